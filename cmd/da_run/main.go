@@ -3,15 +3,16 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/hkanpak21/lattigostats/pkg/he"
 	"github.com/hkanpak21/lattigostats/pkg/jobs"
+	"github.com/hkanpak21/lattigostats/pkg/manifest"
 	"github.com/hkanpak21/lattigostats/pkg/ops/approx"
 	"github.com/hkanpak21/lattigostats/pkg/ops/categorical"
 	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
@@ -21,6 +22,7 @@ import (
 	"github.com/hkanpak21/lattigostats/pkg/storage"
 	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
 func main() {
@@ -28,11 +30,29 @@ func main() {
 	tablePath := flag.String("table", "", "Path to encrypted table directory")
 	keysPath := flag.String("keys", "", "Path to evaluation keys directory")
 	outputPath := flag.String("output", "./result", "Output directory for result")
-	profile := flag.String("profile", "A", "Parameter profile")
+	profile := flag.String("profile", "A2", "Parameter profile")
+	profileFile := flag.String("profile-file", "", "Path to a custom profile JSON/YAML file (overrides the table profile)")
+	insecure := flag.Bool("insecure", false, "Allow test-only and insecure profiles (never for real data)")
+	planOnly := flag.Bool("plan", false, "Print the depth/cost plan for the job and exit without touching ciphertexts")
+	memoryMB := flag.Int64("memory", 512, "Memory budget in MiB for blocks prefetched ahead of the computation")
+	workers := flag.Int("workers", 1, "Number of goroutines blocks are evaluated on, each with its own evaluator sharing the keys")
+	keyringDir := flag.String("keyring", "", "Directory of trusted owner public keys (*.pub); the table's signed manifest must verify against it")
+	allowUnsigned := flag.Bool("allow-unsigned", false, "Run on a table without verifying its signature (tests only; without it, -keyring is required)")
+	tracePath := flag.String("trace", "", "Write a JSON trace of every HE operation's level, scale, noise and values (needs -trace-sk)")
+	traceSK := flag.String("trace-sk", "", "Secret key the trace decrypts with; test and staging only (needs -trace-unsafe)")
+	traceUnsafe := flag.Bool("trace-unsafe", false, "Confirm that -trace may decrypt every intermediate result, which reveals the data")
 	flag.Parse()
 
-	if *jobPath == "" || *tablePath == "" || *keysPath == "" {
-		fmt.Fprintln(os.Stderr, "Usage: da_run -job <job.json> -table <table_dir> -keys <keys_dir>")
+	if *jobPath == "" || *tablePath == "" || (*keysPath == "" && !*planOnly) {
+		fmt.Fprintln(os.Stderr, "Usage: da_run -job <job.json> -table <table_dir> -keys <keys_dir> [-plan]")
+		os.Exit(1)
+	}
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "-workers must be at least 1")
+		os.Exit(1)
+	}
+	if *keyringDir == "" && !*allowUnsigned && !*planOnly {
+		fmt.Fprintln(os.Stderr, "Refusing to run on an unverified table: pass -keyring with the trusted owner keys, or -allow-unsigned for tests")
 		os.Exit(1)
 	}
 
@@ -45,38 +65,76 @@ func main() {
 		os.Exit(1)
 	}
 
-	metaPath := filepath.Join(*tablePath, "metadata.json")
-	meta, err := schema.LoadMetadataFromFile(metaPath)
+	// Verify the owner's signature before reading anything else from the table
+	var signed *manifest.Signed
+	var signedManifest *manifest.Manifest
+	if *keyringDir != "" {
+		keyring, err := manifest.LoadKeyring(*keyringDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load keyring: %v\n", err)
+			os.Exit(1)
+		}
+		signed, signedManifest, err = keyring.VerifyTable(store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Table verification failed: %v\n", err)
+			os.Exit(1)
+		}
+	} else if store.Has(storage.EntryKey{Kind: storage.KindFile, Column: manifest.File}) {
+		fmt.Println("Warning: table is signed but no -keyring was given; its signature is not verified")
+	} else if !*planOnly {
+		fmt.Println("Warning: table is not signed (-allow-unsigned); its contents are not verified")
+	}
+
+	meta, err := store.LoadMetadata()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load metadata: %v\n", err)
 		os.Exit(1)
 	}
 	fmt.Printf("Table: %s (%d rows, %d blocks)\n", meta.Schema.Name, meta.RowCount, meta.BlockCount)
+	var provenance *jobs.Provenance
+	if signedManifest != nil {
+		if err := signedManifest.CheckOwner(meta.DataOwnerID); err != nil {
+			fmt.Fprintf(os.Stderr, "Table verification failed: %v\n", err)
+			os.Exit(1)
+		}
+		provenance = &jobs.Provenance{Table: signedManifest.Table, Signer: signed.Signer, Manifest: signed.Digest()}
+		for _, src := range signedManifest.Sources {
+			provenance.Sources = append(provenance.Sources, src.Signer)
+		}
+		fmt.Printf("Verified signature of %s on %d entries\n", signed.Signer, len(signedManifest.Entries))
+	}
 
-	// Use profile from metadata if not explicitly overridden (or match logic)
-	// Actually, we should trust the metadata profile as the data is encrypted with it.
-	// Use profile from metadata if not explicitly overridden (or match logic)
-	detectedProfile := meta.ParamsHash
-	if *profile != "A" && *profile != string(detectedProfile) {
-		fmt.Printf("Warning: Flag profile %s differs from table profile %s. Using table profile.\n", *profile, detectedProfile)
+	// The table records the profile it was encrypted with; -profile-file overrides
+	// it for custom profiles, and the parameter hash must match either way.
+	tableProfile := meta.Profile
+	if tableProfile == "" {
+		// Tables written before hash binding stored the profile letter in ParamsHash
+		tableProfile = meta.ParamsHash
+		fmt.Printf("Warning: table has no params hash (legacy format); trusting profile %s\n", tableProfile)
+	}
+	if *profile != "A2" && *profile != tableProfile {
+		fmt.Printf("Warning: Flag profile %s differs from table profile %s. Using table profile.\n", *profile, tableProfile)
 	}
-	fmt.Printf("Using Profile: %s\n", detectedProfile)
 
 	// Load parameters
-	var prof *params.Profile
-	switch string(detectedProfile) {
-	case "A":
-		prof, err = params.NewProfileA()
-	case "B":
-		prof, err = params.NewProfileB()
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown profile in metadata: %s\n", detectedProfile)
-		os.Exit(1)
-	}
+	policy := params.Policy{AllowInsecure: *insecure}
+	prof, err := params.ResolveProfile(tableProfile, *profileFile, policy)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create parameters: %v\n", err)
 		os.Exit(1)
 	}
+	if err := prof.Validate(policy); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to run: %v\n", err)
+		os.Exit(1)
+	}
+	if meta.Profile != "" {
+		if err := prof.CheckHash(meta.ParamsHash, "table "+meta.Schema.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "Table check failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Using Profile: %s (params hash %s)\n", prof.Type, prof.ParamsHash[:16])
+	store.ParamsHash = prof.ParamsHash
 	p := prof.Params
 
 	// Load job spec
@@ -87,12 +145,117 @@ func main() {
 	}
 	fmt.Printf("Job: %s (%s)\n", job.ID, job.Operation)
 
-	// Load keys based on profile
-	var evk rlwe.EvaluationKeySet
-	var btp *bootstrapping.Evaluator
+	// Conditions may name categories by their dictionary labels
+	if err := job.ResolveLabels(
+		func(column, label string) (int, error) {
+			col := meta.Schema.GetColumn(column)
+			if col == nil {
+				return 0, fmt.Errorf("column %s not found", column)
+			}
+			return col.Code(label)
+		},
+		func(column string, code int) string {
+			col := meta.Schema.GetColumn(column)
+			if col == nil || len(col.Dictionary()) == 0 {
+				return ""
+			}
+			return col.Label(code)
+		},
+	); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve job conditions: %v\n", err)
+		os.Exit(1)
+	}
+	for _, c := range job.Conditions {
+		if c.Label != "" {
+			fmt.Printf("  Condition: %s (code %d)\n", c, c.Value)
+		}
+	}
+	if err := checkCodes(meta, job); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid job: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Check the job's depth against the level budget before loading anything
+	target := jobs.PlanTarget{
+		Profile:    prof,
+		BlockCount: meta.BlockCount,
+		Categories: make(map[string]int),
+		LookupDEZ:  job.Operation == jobs.OpLookup && !hasLookupBMVs(store, meta, job),
+		InputDepth: meta.RetractionDepth(),
+		Levels:     meta.Levels,
+	}
+	for _, col := range meta.Schema.Columns {
+		target.Categories[col.Name] = col.Categories()
+	}
+	if col := meta.Schema.GetColumn(job.LookupColumn); col != nil {
+		target.LookupBound = col.LookupBound()
+	}
+	plan, err := jobs.EstimateJob(job, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to plan job: %v\n", err)
+		os.Exit(1)
+	}
+	if *planOnly {
+		fmt.Print(plan)
+		return
+	}
+	if !plan.Fits {
+		fmt.Fprint(os.Stderr, plan)
+		fmt.Fprintf(os.Stderr, "Refusing to run: job %s needs depth %d but profile %s has %d levels left on this table\n",
+			job.ID, plan.Depth, prof.Type, plan.MaxLevel)
+		os.Exit(1)
+	}
+
+	if err := prof.CheckKeyDir(*keysPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Key check failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Load the evaluation keys of the profile's parameters
+	fmt.Println("Loading evaluation keys...")
+	rlkPath := filepath.Join(*keysPath, "relin.key")
+	rlkData, err := os.ReadFile(rlkPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read relin key: %v\n", err)
+		os.Exit(1)
+	}
+	rlk := new(rlwe.RelinearizationKey)
+	if err := rlk.UnmarshalBinary(rlkData); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse relin key: %v\n", err)
+		os.Exit(1)
+	}
+
+	galksDir := filepath.Join(*keysPath, "galois")
+	galksEntries, err := os.ReadDir(galksDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read Galois keys directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	var galks []*rlwe.GaloisKey
+	for _, entry := range galksEntries {
+		if entry.IsDir() {
+			continue
+		}
+		gkPath := filepath.Join(galksDir, entry.Name())
+		gkData, err := os.ReadFile(gkPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read Galois key %s: %v\n", entry.Name(), err)
+			os.Exit(1)
+		}
+		gk := new(rlwe.GaloisKey)
+		if err := gk.UnmarshalBinary(gkData); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to parse Galois key %s: %v\n", entry.Name(), err)
+			os.Exit(1)
+		}
+		galks = append(galks, gk)
+	}
+	evk := rlwe.NewMemEvaluationKeySet(rlk, galks...)
 
+	// Bootstrapping profiles also load the keys of the bootstrapping parameters
+	var btp *bootstrapping.Evaluator
 	if prof.BootstrapEnabled {
-		// Profile B: Load bootstrapping keys bundle
+		// Bootstrapping: Load bootstrapping keys bundle
 		fmt.Println("Loading bootstrapping keys...")
 		bkPath := filepath.Join(*keysPath, "bootstrapping.key")
 		bkData, err := os.ReadFile(bkPath)
@@ -108,13 +271,7 @@ func main() {
 
 		// Initialize bootstrapper
 		fmt.Println("Initializing bootstrapper...")
-		logN := p.LogN()
-		btpParamsLiteral := bootstrapping.ParametersLiteral{
-			LogN: &logN,
-			LogP: []int{61, 61, 61, 61},
-			Xs:   p.Xs(),
-		}
-		btpParams, err := bootstrapping.NewParametersFromLiteral(p, btpParamsLiteral)
+		btpParams, err := prof.BootstrappingParameters()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to create bootstrapping params: %v\n", err)
 			os.Exit(1)
@@ -125,49 +282,6 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Failed to create bootstrapper: %v\n", err)
 			os.Exit(1)
 		}
-		evk = btpEvk
-
-	} else {
-		// Profile A: Load standard evaluation keys
-		fmt.Println("Loading evaluation keys...")
-		rlkPath := filepath.Join(*keysPath, "relin.key")
-		rlkData, err := os.ReadFile(rlkPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read relin key: %v\n", err)
-			os.Exit(1)
-		}
-		rlk := new(rlwe.RelinearizationKey)
-		if err := rlk.UnmarshalBinary(rlkData); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse relin key: %v\n", err)
-			os.Exit(1)
-		}
-
-		galksDir := filepath.Join(*keysPath, "galois")
-		galksEntries, err := os.ReadDir(galksDir)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read Galois keys directory: %v\n", err)
-			os.Exit(1)
-		}
-
-		var galks []*rlwe.GaloisKey
-		for _, entry := range galksEntries {
-			if entry.IsDir() {
-				continue
-			}
-			gkPath := filepath.Join(galksDir, entry.Name())
-			gkData, err := os.ReadFile(gkPath)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to read Galois key %s: %v\n", entry.Name(), err)
-				os.Exit(1)
-			}
-			gk := new(rlwe.GaloisKey)
-			if err := gk.UnmarshalBinary(gkData); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to parse Galois key %s: %v\n", entry.Name(), err)
-				os.Exit(1)
-			}
-			galks = append(galks, gk)
-		}
-		evk = rlwe.NewMemEvaluationKeySet(rlk, galks...)
 	}
 
 	// Create evaluator
@@ -176,28 +290,51 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to create evaluator: %v\n", err)
 		os.Exit(1)
 	}
+	eval.SetWorkers(*workers)
+	var tracer *he.Tracer
+	if *tracePath != "" {
+		if tracer, err = newTracer(p, *traceSK, *traceUnsafe); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot trace: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("WARNING: tracing decrypts every intermediate result with the secret key")
+		eval.SetTracer(tracer)
+	}
 
 	// Execute job
 	fmt.Println("Executing job...")
 	var result *rlwe.Ciphertext
 
+	budget := *memoryMB << 20
+	endJob := eval.Span(string(job.Operation))
 	switch job.Operation {
 	case jobs.OpMean, jobs.OpVariance, jobs.OpStdev:
-		result, err = runNumericOp(eval, store, meta, job)
+		result, err = runNumericOp(eval, store, meta, job, budget)
 	case jobs.OpCorr:
-		result, err = runCorrelation(eval, store, meta, job)
+		result, err = runCorrelation(eval, store, meta, job, budget)
 	case jobs.OpBc, jobs.OpBa, jobs.OpBv:
-		result, err = runBinOp(eval, store, meta, job)
+		result, err = runBinOp(eval, store, meta, job, budget)
 	case jobs.OpLBc:
-		result, err = runLBc(eval, store, meta, job)
+		result, err = runLBc(eval, store, meta, job, budget)
 	case jobs.OpPercentile:
-		result, err = runPercentile(eval, store, meta, job)
+		result, err = runPercentile(eval, store, meta, job, budget)
 	case jobs.OpLookup:
 		result, err = runLookup(eval, store, meta, job)
 	default:
 		fmt.Fprintf(os.Stderr, "Operation %s not yet implemented\n", job.Operation)
 		os.Exit(1)
 	}
+	endJob()
+
+	// The trace is written before checking the job's error, to show where it
+	// went wrong
+	if tracer != nil {
+		if err := writeTrace(tracer, *tracePath); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote trace of %d operations to %s\n", len(tracer.Records()), *tracePath)
+	}
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Job execution failed: %v\n", err)
@@ -211,16 +348,61 @@ func main() {
 	}
 
 	resultPath := filepath.Join(*outputPath, "result.ct")
-	if err := storage.SaveCiphertext(resultPath, result); err != nil {
+	if err := storage.SaveCiphertext(resultPath, result, prof.ParamsHash, storage.Content{
+		EntryKey: storage.EntryKey{Kind: storage.KindResult},
+		JobID:    job.ID,
+	}); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to save result: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Results over normalized columns are mapped back to original units by ddia decrypt
+	var transform *jobs.ResultTransform
+	if col := job.NumericColumn(); col != "" {
+		if t, ok := meta.Transforms[col]; ok {
+			transform = job.ResultTransform(t.Scale, t.Offset)
+			if transform == nil {
+				fmt.Printf("Note: %s is stored normalized; result values are (x - %g) / %g\n", col, t.Offset, t.Scale)
+			}
+		}
+	}
+
+	// Means of dates and timestamps are rendered as times by ddia decrypt
+	var timeEncoding *schema.TimeEncoding
+	if job.Operation == jobs.OpMean || job.Operation == jobs.OpBa {
+		if col := meta.Schema.GetColumn(job.NumericColumn()); col != nil {
+			if e, ok := col.TimeEncoding(); ok {
+				timeEncoding = &e
+			}
+		}
+	}
+
+	// LBc results are bit fields that ddia decrypt unpacks into a contingency table
+	var lbc *jobs.LBcLayout
+	if job.Operation == jobs.OpLBc {
+		config := categorical.DefaultLBcConfig()
+		lbc = &jobs.LBcLayout{
+			Column:      job.InputColumns[0],
+			Categories:  len(meta.Schema.GetColumn(job.InputColumns[0]).Codes()),
+			Masks:       len(job.InputColumns) - 1,
+			Delta:       config.Delta,
+			DeltaOffset: config.DeltaOffset,
+			LambdaBig:   config.LambdaBig,
+		}
+	}
+
 	// Save job result metadata
 	jobResult := &jobs.JobResult{
 		JobID:      job.ID,
 		Operation:  string(job.Operation),
 		ResultPath: resultPath,
+		ParamsHash: prof.ParamsHash,
+		Transform:  transform,
+		Conditions: job.Conditions,
+		Labels:     resultLabels(meta, job),
+		Time:       timeEncoding,
+		LBc:        lbc,
+		Provenance: provenance,
 		Metadata: map[string]interface{}{
 			"execution_time": time.Since(startTime).String(),
 			"level":          result.Level(),
@@ -228,9 +410,10 @@ func main() {
 	}
 
 	resultMetaPath := filepath.Join(*outputPath, "result.json")
-	f, _ := os.Create(resultMetaPath)
-	json.NewEncoder(f).Encode(jobResult)
-	f.Close()
+	if err := jobs.SaveJobResult(resultMetaPath, jobResult); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save result metadata: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Print stats
 	stats := eval.Stats()
@@ -240,80 +423,91 @@ func main() {
 	fmt.Printf("Result saved to: %s\n", resultPath)
 }
 
-func runNumericOp(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec) (*rlwe.Ciphertext, error) {
-	colName := job.InputColumns[0]
+// openStream returns a stream over the table's blocks that prefetches as
+// many blocks as fit in budget bytes. With several workers the budget also
+// holds the batch of blocks being evaluated, one per worker, so the workers
+// are capped at the blocks that fit.
+func openStream(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, keys storage.BlockKeys, budget int64) (*storage.Prefetcher, error) {
+	depth, err := store.PrefetchDepth(keys, budget)
+	if err != nil {
+		return nil, err
+	}
+	if workers := eval.Workers(); workers > depth {
+		fmt.Printf("  Memory budget holds %d blocks; evaluating on %d of %d workers\n", depth, depth, workers)
+		eval.SetWorkers(depth)
+	}
+	if eval.Workers() > 1 {
+		depth = max(depth-eval.Workers(), 1)
+		fmt.Printf("  Streaming %d blocks on %d workers, prefetching up to %d ahead\n", meta.BlockCount, eval.Workers(), min(depth, meta.BlockCount))
+	} else {
+		fmt.Printf("  Streaming %d blocks, prefetching up to %d ahead\n", meta.BlockCount, min(depth, meta.BlockCount))
+	}
+	return store.NewPrefetcher(keys, meta.BlockCount, depth), nil
+}
 
-	// Load data blocks
-	fmt.Printf("  Loading %d blocks for column %s...\n", meta.BlockCount, colName)
-	xBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	vBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
+func blockKey(column string, block int) storage.EntryKey {
+	return storage.EntryKey{Kind: storage.KindBlock, Column: column, Block: block}
+}
 
-	for b := 0; b < meta.BlockCount; b++ {
-		var err error
-		xBlocks[b], err = store.LoadBlock(colName, b)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load block %d: %w", b, err)
-		}
-		vBlocks[b], err = store.LoadValidity(colName, b)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load validity %d: %w", b, err)
-		}
+func validityKey(column string, block int) storage.EntryKey {
+	return storage.EntryKey{Kind: storage.KindValidity, Column: column, Block: block}
+}
+
+func runNumericOp(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec, budget int64) (*rlwe.Ciphertext, error) {
+	colName := job.InputColumns[0]
+
+	stream, err := openStream(eval, store, meta, func(b int) []storage.EntryKey {
+		return []storage.EntryKey{blockKey(colName, b), validityKey(colName, b)}
+	}, budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocks of column %s: %w", colName, err)
 	}
+	defer stream.Close()
 
 	numOp := numeric.NewNumericOp(eval)
+	if _, ok := meta.Transforms[colName]; ok {
+		numOp.InvSqrtConfig = numeric.NormalizedINVSQRTConfig()
+	}
 
 	switch job.Operation {
 	case jobs.OpMean:
 		fmt.Println("  Computing mean...")
-		return numOp.Mean(xBlocks, vBlocks)
+		return numOp.StreamMean(stream)
 	case jobs.OpVariance:
 		fmt.Println("  Computing variance...")
-		return numOp.Variance(xBlocks, vBlocks)
+		return numOp.StreamVariance(stream)
 	case jobs.OpStdev:
 		fmt.Println("  Computing standard deviation...")
-		return numOp.Stdev(xBlocks, vBlocks)
+		return numOp.StreamStdev(stream)
 	default:
 		return nil, fmt.Errorf("unknown numeric operation: %s", job.Operation)
 	}
 }
 
-func runCorrelation(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec) (*rlwe.Ciphertext, error) {
+func runCorrelation(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec, budget int64) (*rlwe.Ciphertext, error) {
 	xCol := job.InputColumns[0]
 	yCol := job.InputColumns[1]
 
-	fmt.Printf("  Loading blocks for columns %s and %s...\n", xCol, yCol)
-	xBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	yBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	vxBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	vyBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-
-	for b := 0; b < meta.BlockCount; b++ {
-		var err error
-		xBlocks[b], err = store.LoadBlock(xCol, b)
-		if err != nil {
-			return nil, err
-		}
-		yBlocks[b], err = store.LoadBlock(yCol, b)
-		if err != nil {
-			return nil, err
-		}
-		vxBlocks[b], err = store.LoadValidity(xCol, b)
-		if err != nil {
-			return nil, err
-		}
-		vyBlocks[b], err = store.LoadValidity(yCol, b)
-		if err != nil {
-			return nil, err
-		}
+	stream, err := openStream(eval, store, meta, func(b int) []storage.EntryKey {
+		return []storage.EntryKey{blockKey(xCol, b), blockKey(yCol, b), validityKey(xCol, b), validityKey(yCol, b)}
+	}, budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocks of columns %s and %s: %w", xCol, yCol, err)
 	}
+	defer stream.Close()
 
 	numOp := numeric.NewNumericOp(eval)
+	_, xNorm := meta.Transforms[xCol]
+	_, yNorm := meta.Transforms[yCol]
+	if xNorm && yNorm {
+		numOp.InvSqrtConfig = numeric.NormalizedINVSQRTConfig()
+	}
 	fmt.Println("  Computing correlation...")
-	return numOp.Correlation(xBlocks, yBlocks, vxBlocks, vyBlocks)
+	return numOp.StreamCorrelation(stream)
 }
 
-func runBinOp(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec) (*rlwe.Ciphertext, error) {
-	// Load validity for target column (or first condition column)
+func runBinOp(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec, budget int64) (*rlwe.Ciphertext, error) {
+	// Mask with the validity of the target column (or first condition column)
 	var validityCol string
 	if job.TargetColumn != "" {
 		validityCol = job.TargetColumn
@@ -323,21 +517,6 @@ func runBinOp(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableM
 		return nil, fmt.Errorf("no column specified for bin operation")
 	}
 
-	vBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	for b := 0; b < meta.BlockCount; b++ {
-		var err error
-		vBlocks[b], err = store.LoadValidity(validityCol, b)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load validity: %w", err)
-		}
-	}
-
-	// Create BMV store adapter
-	bmvStore := &bmvStoreAdapter{
-		store:      store,
-		blockCount: meta.BlockCount,
-	}
-
 	// Convert conditions
 	conditions := make([]categorical.Condition, len(job.Conditions))
 	for i, c := range job.Conditions {
@@ -347,96 +526,78 @@ func runBinOp(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableM
 		}
 	}
 
+	// Every block streams as [target, validity, bmv_1, ..., bmv_k]; bin-count has no target
+	withTarget := job.Operation != jobs.OpBc
+	stream, err := openStream(eval, store, meta, func(b int) []storage.EntryKey {
+		var keys []storage.EntryKey
+		if withTarget {
+			keys = append(keys, blockKey(job.TargetColumn, b))
+		}
+		keys = append(keys, validityKey(validityCol, b))
+		for _, c := range conditions {
+			keys = append(keys, storage.EntryKey{Kind: storage.KindBMV, Column: c.ColumnName, Value: c.Value, Block: b})
+		}
+		return keys
+	}, budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocks: %w", err)
+	}
+	defer stream.Close()
+
 	catOp := categorical.NewCategoricalOp(eval)
 
 	switch job.Operation {
 	case jobs.OpBc:
 		fmt.Println("  Computing bin-count...")
-		return catOp.Bc(vBlocks, conditions, bmvStore)
+		return catOp.StreamBc(stream, conditions)
 
 	case jobs.OpBa:
 		fmt.Printf("  Computing bin-average for %s...\n", job.TargetColumn)
-		targetBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-		for b := 0; b < meta.BlockCount; b++ {
-			var err error
-			targetBlocks[b], err = store.LoadBlock(job.TargetColumn, b)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return catOp.Ba(targetBlocks, vBlocks, conditions, bmvStore)
+		return catOp.StreamBa(stream, conditions)
 
 	case jobs.OpBv:
 		fmt.Printf("  Computing bin-variance for %s...\n", job.TargetColumn)
-		targetBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-		for b := 0; b < meta.BlockCount; b++ {
-			var err error
-			targetBlocks[b], err = store.LoadBlock(job.TargetColumn, b)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return catOp.Bv(targetBlocks, vBlocks, conditions, bmvStore)
+		return catOp.StreamBv(stream, conditions)
 
 	default:
 		return nil, fmt.Errorf("unknown bin operation: %s", job.Operation)
 	}
 }
 
-// bmvStoreAdapter adapts storage.TableStore to categorical.BMVStore
-type bmvStoreAdapter struct {
-	store      *storage.TableStore
-	blockCount int
-}
-
-func (a *bmvStoreAdapter) GetBMV(columnName string, value int, blockIndex int) (*rlwe.Ciphertext, error) {
-	return a.store.LoadBMV(columnName, value, blockIndex)
-}
-
-func (a *bmvStoreAdapter) BlockCount() int {
-	return a.blockCount
-}
-
 // runLBc runs Large-Bin-Count computation
-func runLBc(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec) (*rlwe.Ciphertext, error) {
+func runLBc(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec, budget int64) (*rlwe.Ciphertext, error) {
 	if len(job.InputColumns) < 1 {
 		return nil, fmt.Errorf("LBc requires at least one input column")
 	}
 
 	primaryCol := job.InputColumns[0]
 	otherCols := job.InputColumns[1:]
+	if meta.Schema.GetColumn(primaryCol) == nil {
+		return nil, fmt.Errorf("column %s not found", primaryCol)
+	}
 
 	fmt.Printf("  Computing LBc with primary=%s, others=%v...\n", primaryCol, otherCols)
 
-	// Load validity blocks
-	vBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	for b := 0; b < meta.BlockCount; b++ {
-		var err error
-		vBlocks[b], err = store.LoadValidity(primaryCol, b)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load validity: %w", err)
+	// Every block streams as [pbmv, validity, bbmv_1, ..., bbmv_m]
+	stream, err := openStream(eval, store, meta, func(b int) []storage.EntryKey {
+		keys := []storage.EntryKey{
+			{Kind: storage.KindPBMV, Column: primaryCol, Block: b},
+			validityKey(primaryCol, b),
 		}
-	}
-
-	// Create PBMV store adapter
-	pbmvStore := &pbmvStoreAdapter{
-		store:      store,
-		blockCount: meta.BlockCount,
-	}
-
-	// Create BBMV store adapters for other columns
-	bbmvStores := make(map[string]categorical.BBMVStore)
-	for _, col := range otherCols {
-		bbmvStores[col] = &bbmvStoreAdapter{
-			store:      store,
-			blockCount: meta.BlockCount,
+		for _, col := range otherCols {
+			keys = append(keys, storage.EntryKey{Kind: storage.KindBBMV, Column: col, Block: b})
 		}
+		return keys
+	}, budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocks: %w", err)
 	}
+	defer stream.Close()
 
 	config := categorical.DefaultLBcConfig()
 	lbcComputer := categorical.NewLBcComputer(eval, config)
 
-	lbcResult, err := lbcComputer.ComputeLBc(primaryCol, pbmvStore, otherCols, bbmvStores, vBlocks)
+	lbcResult, err := lbcComputer.StreamLBc(stream, len(otherCols), meta.BlockCount)
 	if err != nil {
 		return nil, err
 	}
@@ -450,7 +611,7 @@ func runLBc(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMet
 }
 
 // runPercentile runs k-percentile computation
-func runPercentile(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec) (*rlwe.Ciphertext, error) {
+func runPercentile(eval *he.Evaluator, store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec, budget int64) (*rlwe.Ciphertext, error) {
 	if len(job.InputColumns) < 1 {
 		return nil, fmt.Errorf("percentile requires an input column")
 	}
@@ -466,30 +627,27 @@ func runPercentile(eval *he.Evaluator, store *storage.TableStore, meta *schema.T
 
 	fmt.Printf("  Computing %.0f-th percentile for %s...\n", job.K, colName)
 
-	// Load validity blocks
-	vBlocks := make([]*rlwe.Ciphertext, meta.BlockCount)
-	for b := 0; b < meta.BlockCount; b++ {
-		var err error
-		vBlocks[b], err = store.LoadValidity(colName, b)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load validity: %w", err)
+	// Every block streams as [bmv_1, ..., bmv_S, validity], the BMVs in rank order
+	codes := col.Codes()
+	stream, err := openStream(eval, store, meta, func(b int) []storage.EntryKey {
+		keys := make([]storage.EntryKey, 0, len(codes)+1)
+		for _, code := range codes {
+			keys = append(keys, storage.EntryKey{Kind: storage.KindBMV, Column: colName, Value: code, Block: b})
 		}
+		return append(keys, validityKey(colName, b))
+	}, budget)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocks of column %s: %w", colName, err)
 	}
-
-	// Create BMV store for ordinal
-	bmvStore := &ordinalBMVStoreAdapter{
-		store:      store,
-		colName:    colName,
-		blockCount: meta.BlockCount,
-	}
+	defer stream.Close()
 
 	ordOp := ordinal.NewOrdinalOp(eval)
 	config := ordinal.PercentileConfig{
 		K:          float64(job.K),
-		Categories: col.CategoryCount,
+		Categories: len(codes),
 	}
 
-	return ordOp.Percentile(vBlocks, bmvStore, config)
+	return ordOp.StreamPercentile(stream, config)
 }
 
 // runLookup runs table lookup (equality check + selection)
@@ -505,21 +663,11 @@ func runLookup(eval *he.Evaluator, store *storage.TableStore, meta *schema.Table
 
 	fmt.Printf("  Looking up %s where %s=%d...\n", job.TargetColumn, job.LookupColumn, job.LookupValue)
 
-	// Optimization: check if BMV exists for this value
-	// If it does, we can use it directly instead of expensive approximation
-	hasBMV := true
-	for b := 0; b < meta.BlockCount; b++ {
-		bmvPath := filepath.Join(store.BasePath, "bmvs", fmt.Sprintf("%s_v%d_%d.bin", job.LookupColumn, job.LookupValue, b))
-		if _, err := os.Stat(bmvPath); os.IsNotExist(err) {
-			hasBMV = false
-			break
-		}
-	}
-
-	var result *rlwe.Ciphertext
-	if hasBMV {
+	// Optimization: if BMVs exist for this value, use them directly
+	// instead of the expensive approximation
+	if hasLookupBMVs(store, meta, job) {
 		fmt.Println("  Optimization: using pre-computed BMV for lookup")
-		for b := 0; b < meta.BlockCount; b++ {
+		return eval.MapSum(meta.BlockCount, func(w he.Backend, b int) (*rlwe.Ciphertext, error) {
 			bmv, err := store.LoadBMV(job.LookupColumn, job.LookupValue, b)
 			if err != nil {
 				return nil, err
@@ -528,89 +676,155 @@ func runLookup(eval *he.Evaluator, store *storage.TableStore, meta *schema.Table
 			if err != nil {
 				return nil, err
 			}
-			masked, err := eval.Mul(bmv, target)
-			if err != nil {
-				return nil, err
-			}
-			masked, err = eval.Rescale(masked)
+			masked, err := w.Mul(bmv, target)
 			if err != nil {
 				return nil, err
 			}
+			return w.Rescale(masked)
+		})
+	}
 
-			if result == nil {
-				result = masked
-			} else {
-				eval.AddInPlace(result, masked)
-			}
+	fmt.Println("  Warning: BMV not found. Falling back to expensive DISCRETEEQUALZERO approximation...")
+	dezConfig := approx.DefaultDEZConfig(lookupCol.LookupBound())
+	return eval.MapSum(meta.BlockCount, func(w he.Backend, b int) (*rlwe.Ciphertext, error) {
+		catBlock, err := store.LoadBlock(job.LookupColumn, b)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		fmt.Println("  Warning: BMV not found. Falling back to expensive DISCRETEEQUALZERO approximation...")
-		approxOp := approx.NewApproxOp(eval)
-		dezConfig := approx.DefaultDEZConfig(lookupCol.CategoryCount)
-
-		for b := 0; b < meta.BlockCount; b++ {
-			catBlock, _ := store.LoadBlock(job.LookupColumn, b)
-			targetBlock, _ := store.LoadBlock(job.TargetColumn, b)
-
-			catMinus, _ := eval.AddConst(catBlock, complex(float64(-job.LookupValue), 0))
-			eq, err := approxOp.DISCRETEEQUALZERO(catMinus, dezConfig)
-			if err != nil {
-				return nil, err
-			}
-
-			masked, _ := eval.Mul(eq, targetBlock)
-			masked, _ = eval.Rescale(masked)
-
-			if result == nil {
-				result = masked
-			} else {
-				eval.AddInPlace(result, masked)
-			}
+		targetBlock, err := store.LoadBlock(job.TargetColumn, b)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	return result, nil
-}
 
-// pbmvStoreAdapter adapts storage to PBMV store
-type pbmvStoreAdapter struct {
-	store      *storage.TableStore
-	blockCount int
-}
+		catMinus, err := w.AddConst(catBlock, complex(float64(-job.LookupValue), 0))
+		if err != nil {
+			return nil, err
+		}
+		eq, err := approx.NewApproxOp(w).DISCRETEEQUALZERO(catMinus, dezConfig)
+		if err != nil {
+			return nil, err
+		}
 
-func (a *pbmvStoreAdapter) GetPBMV(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
-	return a.store.LoadPBMV(columnName, blockIndex)
+		masked, err := w.Mul(eq, targetBlock)
+		if err != nil {
+			return nil, err
+		}
+		return w.Rescale(masked)
+	})
 }
 
-func (a *pbmvStoreAdapter) BlockCount() int {
-	return a.blockCount
+// checkCodes rejects conditions and lookups on codes outside their column's
+// domain, which would otherwise silently select no rows, and percentiles of
+// date and timestamp columns, which have no BMVs
+func checkCodes(meta *schema.TableMetadata, job *jobs.JobSpec) error {
+	check := func(column string, code int) error {
+		col := meta.Schema.GetColumn(column)
+		if col == nil {
+			return nil
+		}
+		if col.IsTemporal() {
+			return fmt.Errorf("%s column %s has no BMVs; use a derived column such as %s_%s", col.Type, column, column, schema.PartMonth)
+		}
+		if !col.IsCoded() {
+			return nil
+		}
+		if !col.InDomain(code) {
+			return fmt.Errorf("code %d of column %s is outside its domain [%d, %d]", code, column, col.Base(), col.Base()+col.Categories()-1)
+		}
+		return nil
+	}
+	for _, c := range job.Conditions {
+		if err := check(c.Column, c.Value); err != nil {
+			return err
+		}
+	}
+	if job.Operation == jobs.OpLookup {
+		return check(job.LookupColumn, job.LookupValue)
+	}
+	if job.Operation == jobs.OpPercentile && len(job.InputColumns) > 0 {
+		// Percentiles need a BMV per bucket, which only the derived calendar parts have
+		if col := meta.Schema.GetColumn(job.InputColumns[0]); col != nil && col.IsTemporal() {
+			return fmt.Errorf("percentiles of %s column %s are not supported; use a derived column such as %s_%s, whose bucket ddia decrypt shows as its label",
+				col.Type, col.Name, col.Name, schema.PartMonth)
+		}
+	}
+	return nil
 }
 
-// bbmvStoreAdapter adapts storage to BBMV store
-type bbmvStoreAdapter struct {
-	store      *storage.TableStore
-	blockCount int
-}
+// resultLabels returns the category dictionaries of the categorical columns a job's result refers to
+func resultLabels(meta *schema.TableMetadata, job *jobs.JobSpec) map[string][]string {
+	columns := append([]string{}, job.InputColumns...)
+	for _, c := range job.Conditions {
+		columns = append(columns, c.Column)
+	}
+	if job.LookupColumn != "" {
+		columns = append(columns, job.LookupColumn)
+	}
 
-func (a *bbmvStoreAdapter) GetBBMV(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
-	return a.store.LoadBBMV(columnName, blockIndex)
-}
+	dicts := meta.Dictionaries()
+	labels := make(map[string][]string)
+	for _, name := range columns {
+		if dict, ok := dicts[name]; ok {
+			labels[name] = dict
+		}
+	}
 
-func (a *bbmvStoreAdapter) BlockCount() int {
-	return a.blockCount
+	// Percentile results are 1-based ranks; name them by their codes when
+	// those do not start at 1, e.g. the years of a derived year column
+	if job.Operation == jobs.OpPercentile {
+		col := meta.Schema.GetColumn(job.InputColumns[0])
+		if _, ok := labels[col.Name]; !ok && col.Base() != schema.DefaultCodeBase {
+			for _, code := range col.Codes() {
+				labels[col.Name] = append(labels[col.Name], strconv.Itoa(code))
+			}
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
 }
 
-// ordinalBMVStoreAdapter adapts storage to ordinal BMV store
-type ordinalBMVStoreAdapter struct {
-	store      *storage.TableStore
-	colName    string
-	blockCount int
+// hasLookupBMVs reports whether the table has BMVs for the looked-up value in every block
+func hasLookupBMVs(store *storage.TableStore, meta *schema.TableMetadata, job *jobs.JobSpec) bool {
+	for b := 0; b < meta.BlockCount; b++ {
+		if !store.HasBMV(job.LookupColumn, job.LookupValue, b) {
+			return false
+		}
+	}
+	return true
 }
 
-func (a *ordinalBMVStoreAdapter) GetBMV(value int, blockIndex int) (*rlwe.Ciphertext, error) {
-	return a.store.LoadBMV(a.colName, value, blockIndex)
+// newTracer loads the secret key for a trace; the oracle defeats the
+// encryption, so it must be asked for with -trace-unsafe. That flag does not
+// allow insecure profiles, so staging runs trace on the secure ones.
+func newTracer(p ckks.Parameters, skPath string, unsafe bool) (*he.Tracer, error) {
+	if skPath == "" {
+		return nil, fmt.Errorf("-trace needs the secret key (-trace-sk)")
+	}
+	if !unsafe {
+		return nil, fmt.Errorf("the trace decrypts every intermediate result; pass -trace-unsafe to confirm this is a test or staging run")
+	}
+	skData, err := os.ReadFile(skPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+	sk := new(rlwe.SecretKey)
+	if err := sk.UnmarshalBinary(skData); err != nil {
+		return nil, fmt.Errorf("failed to parse secret key: %w", err)
+	}
+	return he.NewTracer(p, sk, he.DefaultTraceSlots), nil
 }
 
-func (a *ordinalBMVStoreAdapter) BlockCount() int {
-	return a.blockCount
+// writeTrace writes a tracer's records as JSON
+func writeTrace(tracer *he.Tracer, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create trace: %w", err)
+	}
+	if err := tracer.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
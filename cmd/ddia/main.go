@@ -6,13 +6,18 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/hkanpak21/lattigostats/pkg/jobs"
+	"github.com/hkanpak21/lattigostats/pkg/ops/categorical"
 	"github.com/hkanpak21/lattigostats/pkg/params"
 	"github.com/hkanpak21/lattigostats/pkg/privacy"
 	"github.com/hkanpak21/lattigostats/pkg/storage"
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
@@ -50,28 +55,54 @@ func printUsage() {
 }
 
 func runKeygen(cmd *flag.FlagSet, args []string) {
-	profile := cmd.String("profile", "A", "Parameter profile (A or B)")
+	profile := cmd.String("profile", "A2", "Parameter profile (A2, B2, R, or T and TB for tests; A and B are deprecated)")
+	profileFile := cmd.String("profile-file", "", "Path to a custom profile JSON/YAML file (overrides -profile)")
 	outputDir := cmd.String("output", "./keys", "Output directory for keys")
+	minSecurity := cmd.Float64("min-security", params.DefaultMinSecurityBits, "Minimum estimated bit-security to accept")
+	insecure := cmd.Bool("insecure", false, "Allow test-only and insecure profiles (never for real data)")
+	seed := cmd.String("seed", "", "Seed for deterministic key generation (requires -insecure)")
 	cmd.Parse(args)
 
 	// Get parameters
-	var prof *params.Profile
-	var err error
-	switch *profile {
-	case "A":
-		prof, err = params.NewProfileA()
-	case "B":
-		prof, err = params.NewProfileB()
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown profile: %s\n", *profile)
+	policy := params.Policy{MinBits: *minSecurity, AllowInsecure: *insecure}
+	if *seed != "" && !*insecure {
+		fmt.Fprintln(os.Stderr, "-seed makes the keys predictable and requires -insecure")
 		os.Exit(1)
 	}
+	prof, err := params.ResolveProfile(*profile, *profileFile, policy)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create parameters: %v\n", err)
 		os.Exit(1)
 	}
+	if err := prof.Validate(policy); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to generate keys: %v\n", err)
+		os.Exit(1)
+	}
+	est, err := prof.Security()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to estimate security: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Security: %s\n", est)
+	for _, note := range est.Notes {
+		fmt.Printf("  note: %s\n", note)
+	}
 	p := prof.Params
 
+	var kgen params.KeyGenerator = rlwe.NewKeyGenerator(p)
+	if *seed != "" {
+		// Bootstrapping keys are generated by Lattigo from crypto/rand
+		if prof.BootstrapEnabled {
+			fmt.Fprintf(os.Stderr, "-seed is not supported for bootstrapping profile %s\n", prof.Type)
+			os.Exit(1)
+		}
+		if kgen, err = params.NewSeededKeyGenerator(p, *seed); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to seed key generation: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("WARNING: deterministic key generation; these keys are for tests only")
+	}
+
 	// Create output directory
 	if err := os.MkdirAll(*outputDir, 0700); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create output directory: %v\n", err)
@@ -80,7 +111,6 @@ func runKeygen(cmd *flag.FlagSet, args []string) {
 
 	// Generate keys
 	fmt.Println("Generating secret key...")
-	kgen := rlwe.NewKeyGenerator(p)
 	sk := kgen.GenSecretKeyNew()
 
 	fmt.Println("Generating public key...")
@@ -116,20 +146,37 @@ func runKeygen(cmd *flag.FlagSet, args []string) {
 	}
 	fmt.Printf("Relinearization key saved to: %s\n", rlkPath)
 
-	// Generate Galois keys (and bootstrapping keys for Profile B)
+	// Galois keys for the rotations of inner sums, on every profile
+	fmt.Println("Generating Galois keys for rotations...")
+	slots := p.MaxSlots()
+	// Sorted so that a seeded key set is written in the same order every run
+	galEls := rlwe.GaloisElementsForInnerSum(p, 1, slots)
+	sort.Slice(galEls, func(i, j int) bool { return galEls[i] < galEls[j] })
+	galks := kgen.GenGaloisKeysNew(galEls, sk)
+
+	// Save Galois keys individually
+	galksDir := filepath.Join(*outputDir, "galois")
+	if err := os.MkdirAll(galksDir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create galois directory: %v\n", err)
+		os.Exit(1)
+	}
+	for i, gk := range galks {
+		gkPath := filepath.Join(galksDir, fmt.Sprintf("galois_%d.key", i))
+		if err := saveKey(gkPath, gk); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save Galois key %d: %v\n", i, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Galois keys saved to: %s\n", galksDir)
+
+	// Bootstrapping keys are for the bootstrapping parameters and do not
+	// replace the keys above
 	if prof.BootstrapEnabled {
 		fmt.Println("Generating Bootstrapping keys (this may take a while)...")
 		fmt.Println("WARNING: This operation is memory-intensive and may take several minutes.")
 
 		// Create bootstrapping parameters
-		logN := p.LogN()
-		btpParamsLiteral := bootstrapping.ParametersLiteral{
-			LogN: &logN,
-			LogP: []int{61, 61, 61, 61},
-			Xs:   p.Xs(),
-		}
-
-		btpParams, err := bootstrapping.NewParametersFromLiteral(p, btpParamsLiteral)
+		btpParams, err := prof.BootstrappingParameters()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to create bootstrapping params: %v\n", err)
 			os.Exit(1)
@@ -154,44 +201,16 @@ func runKeygen(cmd *flag.FlagSet, args []string) {
 			os.Exit(1)
 		}
 		fmt.Printf("Bootstrapping keys saved to: %s\n", bkPath)
-
-	} else {
-		// Profile A: Generate standard Galois keys for rotations
-		fmt.Println("Generating Galois keys for rotations...")
-		slots := p.MaxSlots()
-		galks := kgen.GenGaloisKeysNew(rlwe.GaloisElementsForInnerSum(p, 1, slots), sk)
-
-		// Save Galois keys individually
-		galksDir := filepath.Join(*outputDir, "galois")
-		if err := os.MkdirAll(galksDir, 0700); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create galois directory: %v\n", err)
-			os.Exit(1)
-		}
-		for i, gk := range galks {
-			gkPath := filepath.Join(galksDir, fmt.Sprintf("galois_%d.key", i))
-			if err := saveKey(gkPath, gk); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to save Galois key %d: %v\n", i, err)
-				os.Exit(1)
-			}
-		}
-		fmt.Printf("Galois keys saved to: %s\n", galksDir)
 	}
 
-	// Save parameters metadata
-	meta := map[string]interface{}{
-		"profile":   *profile,
-		"log_n":     p.LogN(),
-		"log_scale": p.LogDefaultScale(),
-		"slots":     p.MaxSlots(),
-	}
+	// Save parameters metadata (binds the key set to the parameter hash)
 	metaPath := filepath.Join(*outputDir, "params.json")
-	f, err := os.Create(metaPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create params file: %v\n", err)
+	keyInfo := prof.KeyInfo()
+	keyInfo.Seeded = *seed != ""
+	if err := params.SaveKeyInfo(metaPath, keyInfo); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save params file: %v\n", err)
 		os.Exit(1)
 	}
-	json.NewEncoder(f).Encode(meta)
-	f.Close()
 	fmt.Printf("Parameters saved to: %s\n", metaPath)
 
 	fmt.Println("\nKey generation complete!")
@@ -209,7 +228,10 @@ func runDecrypt(cmd *flag.FlagSet, args []string) {
 	skPath := cmd.String("sk", "", "Path to secret key")
 	ctPath := cmd.String("ct", "", "Path to ciphertext")
 	outputPath := cmd.String("output", "", "Output path for plaintext")
-	paramsProfile := cmd.String("profile", "A", "Parameter profile")
+	paramsProfile := cmd.String("profile", "", "Parameter profile (default: detected from the ciphertext header)")
+	profileFile := cmd.String("profile-file", "", "Path to a custom profile JSON/YAML file (overrides -profile)")
+	insecure := cmd.Bool("insecure", false, "Allow test-only and insecure profiles (never for real data)")
+	policyPath := cmd.String("policy", "", "Path to the privacy policy applied to LBc contingency tables")
 	cmd.Parse(args)
 
 	if *skPath == "" || *ctPath == "" {
@@ -217,24 +239,87 @@ func runDecrypt(cmd *flag.FlagSet, args []string) {
 		os.Exit(1)
 	}
 
-	// Load parameters
+	// Load ciphertext first: its header names the parameters it was created with
+	ct, header, err := storage.LoadCiphertext(*ctPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load ciphertext: %v\n", err)
+		os.Exit(1)
+	}
+	resultMetaPath := filepath.Join(filepath.Dir(*ctPath), "result.json")
+	var jobResult *jobs.JobResult
+	if _, err := os.Stat(resultMetaPath); err == nil {
+		jobResult, err = jobs.LoadJobResult(resultMetaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load result metadata: %v\n", err)
+			os.Exit(1)
+		}
+		if header != nil && header.Content.Kind == storage.KindResult && header.Content.JobID != jobResult.JobID {
+			fmt.Fprintf(os.Stderr, "Result check failed: ciphertext holds the result of job %s, but result.json describes job %s\n",
+				header.Content.JobID, jobResult.JobID)
+			os.Exit(1)
+		}
+	}
+	if jobResult != nil {
+		if prov := jobResult.Provenance; prov != nil {
+			fmt.Printf("Computed from table %s signed by %s (manifest %s)\n", prov.Table, prov.Signer, prov.Manifest[:16])
+			if len(prov.Sources) > 0 {
+				fmt.Printf("  merged from tables signed by %s\n", strings.Join(prov.Sources, ", "))
+			}
+		} else {
+			fmt.Println("Warning: result was computed from a table whose signature was not verified")
+		}
+	}
+	if header != nil {
+		fmt.Printf("Ciphertext: %s (level %d, log-scale %.1f, %d slots)\n",
+			header.Content, header.Level, header.LogScale, header.Slots)
+	} else {
+		fmt.Println("Warning: ciphertext has no header (legacy format)")
+	}
+
+	// Load parameters: as given, else the built-in profile matching the header
+	policy := params.Policy{AllowInsecure: *insecure}
 	var prof *params.Profile
-	var err error
-	switch *paramsProfile {
-	case "A":
-		prof, err = params.NewProfileA()
-	case "B":
-		prof, err = params.NewProfileB()
+	switch {
+	case *paramsProfile != "" || *profileFile != "":
+		prof, err = params.ResolveProfile(*paramsProfile, *profileFile, policy)
+	case header != nil && header.ParamsHash != "":
+		prof, err = params.DetectProfile(header.ParamsHash)
+		if err == nil {
+			fmt.Printf("Detected profile %s from the ciphertext header\n", prof.Type)
+		}
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown profile: %s\n", *paramsProfile)
-		os.Exit(1)
+		prof, err = params.NewProfile(params.ProfileA)
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create parameters: %v\n", err)
 		os.Exit(1)
 	}
+	if err := prof.Validate(policy); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to decrypt: %v\n", err)
+		os.Exit(1)
+	}
 	p := prof.Params
 
+	// Check the key set, ciphertext and result against the profile before decrypting
+	if err := prof.CheckKeyDir(filepath.Dir(*skPath)); err != nil {
+		fmt.Fprintf(os.Stderr, "Key check failed: %v\n", err)
+		os.Exit(1)
+	}
+	if header != nil && header.ParamsHash != "" {
+		if err := prof.CheckHash(header.ParamsHash, "ciphertext "+*ctPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Ciphertext check failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if jobResult != nil && jobResult.ParamsHash == "" {
+		fmt.Printf("Warning: result %s has no params hash (legacy format); trusting profile %s\n", jobResult.JobID, prof.Type)
+	} else if jobResult != nil {
+		if err := prof.CheckHash(jobResult.ParamsHash, "result "+jobResult.JobID); err != nil {
+			fmt.Fprintf(os.Stderr, "Result check failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Load secret key
 	skData, err := os.ReadFile(*skPath)
 	if err != nil {
@@ -247,25 +332,37 @@ func runDecrypt(cmd *flag.FlagSet, args []string) {
 		os.Exit(1)
 	}
 
-	// Load ciphertext using storage package (handles length prefix)
-	ct, err := storage.LoadCiphertext(*ctPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load ciphertext: %v\n", err)
-		os.Exit(1)
-	}
-
 	// Decrypt
 	decryptor := rlwe.NewDecryptor(p, sk)
 	encoder := ckks.NewEncoder(p)
 
 	pt := decryptor.DecryptNew(ct)
-	values := make([]complex128, p.MaxSlots())
-	encoder.Decode(pt, values)
+	realValues := make([]float64, p.MaxSlots())
+	encoder.Decode(pt, realValues)
 
-	// Output
-	realValues := make([]float64, len(values))
-	for i, v := range values {
-		realValues[i] = real(v)
+	// Map results over normalized columns back to original units
+	if jobResult != nil && jobResult.Transform != nil {
+		for i := range realValues {
+			realValues[i] = jobResult.Transform.Apply(realValues[i])
+		}
+		fmt.Printf("Mapped result to original units (%g * z + %g)\n", jobResult.Transform.Scale, jobResult.Transform.Offset)
+	}
+	if jobResult != nil {
+		printLabels(jobResult, realValues)
+	}
+	if jobResult != nil && jobResult.LBc != nil {
+		if err := printContingencyTable(jobResult, realValues, *policyPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to unpack LBc result: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if jobResult != nil && jobResult.Time != nil {
+		when, err := jobResult.Time.Format(realValues[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to render result as a time: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Result as time: %s (%.2f %ss since %s)\n", when, realValues[0], jobResult.Time.Unit, jobResult.Time.Epoch)
 	}
 
 	if *outputPath != "" {
@@ -286,6 +383,84 @@ func runDecrypt(cmd *flag.FlagSet, args []string) {
 	}
 }
 
+// printLabels describes a decrypted result in terms of category labels
+func printLabels(result *jobs.JobResult, values []float64) {
+	if len(result.Conditions) > 0 {
+		conds := make([]string, len(result.Conditions))
+		for i, c := range result.Conditions {
+			conds[i] = c.String()
+		}
+		fmt.Printf("Result for %s\n", strings.Join(conds, " AND "))
+	}
+
+	switch jobs.Operation(result.Operation) {
+	case jobs.OpPercentile:
+		for col, labels := range result.Labels {
+			bucket := int(math.Round(values[0]))
+			label := strconv.Itoa(bucket)
+			if bucket >= 1 && bucket <= len(labels) {
+				label = labels[bucket-1]
+			}
+			fmt.Printf("Percentile bucket of %s: %d (%s)\n", col, bucket, label)
+		}
+	case jobs.OpLBc:
+		columns := make([]string, 0, len(result.Labels))
+		for col := range result.Labels {
+			columns = append(columns, col)
+		}
+		sort.Strings(columns)
+		for _, col := range columns {
+			labels := result.Labels[col]
+			legend := make([]string, len(labels))
+			for i, l := range labels {
+				legend[i] = fmt.Sprintf("%d=%s", i+1, l)
+			}
+			fmt.Printf("Categories of %s: %s\n", col, strings.Join(legend, ", "))
+		}
+	}
+}
+
+// printContingencyTable unpacks the counts of an LBc result and prints the
+// contingency table the privacy policy releases, keyed by category labels
+func printContingencyTable(result *jobs.JobResult, values []float64, policyPath string) error {
+	policy := privacy.DefaultPolicy()
+	if policyPath != "" {
+		var err error
+		if policy, err = privacy.LoadPolicy(policyPath); err != nil {
+			return err
+		}
+	}
+
+	layout := result.LBc
+	counts := categorical.DecodeLBc(values, layout.Categories, layout.Masks, categorical.LBcConfig{
+		Delta:       layout.Delta,
+		DeltaOffset: layout.DeltaOffset,
+		LambdaBig:   layout.LambdaBig,
+	})
+	processed, err := privacy.NewLBcPostProcessor(policy).WithLabels(result.Labels).ProcessDecryptedChunks(
+		[][]float64{counts}, []string{layout.Column}, []int{layout.Categories}, result.JobID)
+	if err != nil {
+		return err
+	}
+
+	if violations := processed.Inspection.Violations; len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Printf("Privacy violation (%s): %s\n", v.Rule, v.Message)
+		}
+		return fmt.Errorf("contingency table of %s withheld by the privacy policy", layout.Column)
+	}
+	table, ok := processed.Inspection.TransformedValue.(*privacy.ContingencyTable)
+	if !ok {
+		return fmt.Errorf("privacy inspection released no contingency table")
+	}
+	output, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode contingency table: %w", err)
+	}
+	fmt.Printf("Contingency table of %s (%d cells suppressed):\n%s\n", layout.Column, processed.Suppressions, output)
+	return nil
+}
+
 func runInspect(cmd *flag.FlagSet, args []string) {
 	inputPath := cmd.String("input", "", "Path to decrypted values JSON")
 	policyPath := cmd.String("policy", "", "Path to privacy policy JSON")
@@ -43,7 +43,7 @@ func runDemo() error {
 	fmt.Println("│ Step 1: Setting up CKKS Parameters                             │")
 	fmt.Println("└─────────────────────────────────────────────────────────────────┘")
 
-	profile, err := params.NewProfileA()
+	profile, err := params.NewProfileA2()
 	if err != nil {
 		return fmt.Errorf("failed to create profile: %w", err)
 	}
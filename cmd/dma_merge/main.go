@@ -10,17 +10,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 
+	"github.com/hkanpak21/lattigostats/pkg/manifest"
 	"github.com/hkanpak21/lattigostats/pkg/schema"
 	"github.com/hkanpak21/lattigostats/pkg/storage"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 )
 
 func main() {
-	inputsFlag := flag.String("inputs", "", "Comma-separated list of encrypted table directories")
-	outputDir := flag.String("output", "./merged", "Output directory for merged table")
+	inputsFlag := flag.String("inputs", "", "Comma-separated list of encrypted tables (directories, .lstc files or s3:// paths)")
+	outputDir := flag.String("output", "./merged", "Output directory for merged table, or a container file ending in .lstc")
 	macKeyPath := flag.String("mac-key", "", "Path to MAC key file (for token verification)")
 	tokensFlag := flag.String("tokens", "", "Comma-separated list of token files (one per input)")
+	keyringDir := flag.String("keyring", "", "Directory of trusted owner public keys (*.pub); every input must be signed by its owner")
+	signKeyPath := flag.String("sign-key", "", "Path to the DMA's signing key; signs the merged table (requires -keyring)")
+	allowUnsigned := flag.Bool("allow-unsigned", false, "Merge inputs without verifying their signatures (tests only; without it, -keyring is required)")
 	flag.Parse()
 
 	if *inputsFlag == "" {
@@ -28,23 +32,48 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Parse input directories
-	inputs := filepath.SplitList(*inputsFlag)
-	if len(inputs) == 0 {
-		// Try comma-separated
-		inputs = splitComma(*inputsFlag)
-	}
+	// Parse input tables; s3:// paths contain colons, so only commas separate them
+	inputs := splitComma(*inputsFlag)
 
 	if len(inputs) < 1 {
 		fmt.Fprintln(os.Stderr, "At least one input directory required")
 		os.Exit(1)
 	}
 
+	if *keyringDir == "" && !*allowUnsigned {
+		fmt.Fprintln(os.Stderr, "Refusing to merge unverified tables: pass -keyring with the trusted owner keys, or -allow-unsigned for tests")
+		os.Exit(1)
+	}
+	var keyring manifest.Keyring
+	if *keyringDir != "" {
+		var err error
+		keyring, err = manifest.LoadKeyring(*keyringDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load keyring: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var signKey *manifest.PrivateKey
+	if *signKeyPath != "" {
+		if keyring == nil {
+			fmt.Fprintln(os.Stderr, "-sign-key requires -keyring: only verified inputs can be re-signed")
+			os.Exit(1)
+		}
+		var err error
+		signKey, err = manifest.LoadPrivateKey(*signKeyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load signing key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Printf("Merging %d tables...\n", len(inputs))
 
 	// Load metadata from all inputs
 	var allMeta []*schema.TableMetadata
 	var allStores []*storage.TableStore
+	var sources []manifest.Signed
+	lowestLevels := make(map[storage.EntryKind]int) // Per kind, over all inputs
 
 	for i, inputPath := range inputs {
 		store, err := storage.OpenTableStore(inputPath)
@@ -54,13 +83,55 @@ func main() {
 		}
 		allStores = append(allStores, store)
 
-		metaPath := filepath.Join(inputPath, "metadata.json")
-		meta, err := schema.LoadMetadataFromFile(metaPath)
+		// Verify the owner's signature before reading anything else from the table
+		var signedManifest *manifest.Manifest
+		if keyring != nil {
+			var signed *manifest.Signed
+			signed, signedManifest, err = keyring.VerifyTable(store)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Verification of table %d (%s) failed: %v\n", i, inputPath, err)
+				os.Exit(1)
+			}
+			sources = append(sources, *signed)
+		} else {
+			fmt.Printf("  Warning: table %d (%s) is not verified (-allow-unsigned)\n", i, inputPath)
+		}
+
+		meta, err := store.LoadMetadata()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to load metadata for table %d: %v\n", i, err)
 			os.Exit(1)
 		}
 		allMeta = append(allMeta, meta)
+		if signedManifest != nil {
+			if err := signedManifest.CheckOwner(meta.DataOwnerID); err != nil {
+				fmt.Fprintf(os.Stderr, "Verification of table %d (%s) failed: %v\n", i, inputPath, err)
+				os.Exit(1)
+			}
+			fmt.Printf("  Table %d: verified signature of %s\n", i, signedManifest.Owner)
+		}
+		if meta.Profile != "" {
+			store.ParamsHash = meta.ParamsHash
+		}
+
+		// Refuse incomplete or damaged inputs before writing any of the output
+		report, err := store.VerifyTable(meta, storage.VerifyOptions{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to check table %d (%s): %v\n", i, inputPath, err)
+			os.Exit(1)
+		}
+		if !report.OK() {
+			for _, p := range report.Problems {
+				fmt.Fprintf(os.Stderr, "  %s\n", p)
+			}
+			fmt.Fprintf(os.Stderr, "Table %d (%s) failed verification: %s\n", i, inputPath, report)
+			os.Exit(1)
+		}
+		for kind, level := range report.LowestLevels {
+			if lowest, ok := lowestLevels[kind]; !ok || level < lowest {
+				lowestLevels[kind] = level
+			}
+		}
 
 		fmt.Printf("  Table %d: %s (%d rows, %d columns)\n",
 			i, meta.Schema.Name, meta.RowCount, len(meta.Schema.Columns))
@@ -71,7 +142,8 @@ func main() {
 		ref := allMeta[0]
 		for i := 1; i < len(allMeta); i++ {
 			if allMeta[i].ParamsHash != ref.ParamsHash {
-				fmt.Fprintf(os.Stderr, "Parameter mismatch between table 0 and %d\n", i)
+				fmt.Fprintf(os.Stderr, "Parameter mismatch between table 0 (params hash %s) and %d (params hash %s)\n",
+					ref.ParamsHash, i, allMeta[i].ParamsHash)
 				os.Exit(1)
 			}
 			if allMeta[i].Slots != ref.Slots {
@@ -82,11 +154,12 @@ func main() {
 	}
 
 	// Create output
-	mergedStore, err := storage.NewTableStore(*outputDir)
+	mergedStore, err := storage.CreateTableStore(*outputDir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create output: %v\n", err)
 		os.Exit(1)
 	}
+	mergedStore.ParamsHash = allStores[0].ParamsHash
 
 	// Simple merge: concatenate all columns from all tables
 	// In a real implementation, this would join by protected identifiers
@@ -106,14 +179,13 @@ func main() {
 	for i, meta := range allMeta {
 		for _, col := range meta.Schema.Columns {
 			uniqueName := fmt.Sprintf("%s_%s", meta.DataOwnerID, col.Name)
-			mergedSchema.Columns = append(mergedSchema.Columns, schema.Column{
-				Name:          uniqueName,
-				Type:          col.Type,
-				CategoryCount: col.CategoryCount,
-				MinValue:      col.MinValue,
-				MaxValue:      col.MaxValue,
-				Description:   fmt.Sprintf("From %s: %s", meta.DataOwnerID, col.Description),
-			})
+			merged := col
+			merged.Name = uniqueName
+			merged.Description = fmt.Sprintf("From %s: %s", meta.DataOwnerID, col.Description)
+			if col.DerivedFrom != "" {
+				merged.DerivedFrom = fmt.Sprintf("%s_%s", meta.DataOwnerID, col.DerivedFrom)
+			}
+			mergedSchema.Columns = append(mergedSchema.Columns, merged)
 			colSources[uniqueName] = columnSource{storeIdx: i, colName: col.Name}
 		}
 	}
@@ -156,14 +228,31 @@ func main() {
 
 		// Save join masks for DA to apply
 		for i := 0; i < len(inputs); i++ {
-			maskPath := filepath.Join(*outputDir, fmt.Sprintf("join_mask_%d.json", i))
-			if err := SaveJoinMask(maskPath, joinMasks[i], slots); err != nil {
+			maskName := fmt.Sprintf("join_mask_%d.json", i)
+			if err := SaveJoinMask(mergedStore, maskName, joinMasks[i], slots); err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to save join mask %d: %v\n", i, err)
 				os.Exit(1)
 			}
 		}
 	}
 
+	// Inputs may sit at different levels after retractions or trimming; the
+	// merged table holds each kind at the lowest level of any input
+	levels := schema.EntryLevels{
+		Block:    lowestLevels[storage.KindBlock],
+		Validity: lowestLevels[storage.KindValidity],
+		BMV:      lowestLevels[storage.KindValidity],
+	}
+	if level, ok := lowestLevels[storage.KindBMV]; ok {
+		levels.BMV = level
+	}
+	drop := func(ct *rlwe.Ciphertext, level int) *rlwe.Ciphertext {
+		if ct.Level() > level {
+			ct.Resize(ct.Degree(), level)
+		}
+		return ct
+	}
+
 	fmt.Printf("\nMerging into table with %d columns, %d rows\n", len(mergedSchema.Columns), rowCount)
 
 	// Copy blocks
@@ -179,7 +268,7 @@ func main() {
 				fmt.Fprintf(os.Stderr, "Failed to load block: %v\n", err)
 				os.Exit(1)
 			}
-			if err := mergedStore.SaveBlock(newColName, b, ct); err != nil {
+			if err := mergedStore.SaveBlock(newColName, b, drop(ct, levels.Block)); err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to save block: %v\n", err)
 				os.Exit(1)
 			}
@@ -187,10 +276,10 @@ func main() {
 			// Copy validity
 			ctVal, err := srcStore.LoadValidity(src.colName, b)
 			if err != nil {
-				// Validity might not exist, skip
-				continue
+				fmt.Fprintf(os.Stderr, "Failed to load validity: %v\n", err)
+				os.Exit(1)
 			}
-			if err := mergedStore.SaveValidity(newColName, b, ctVal); err != nil {
+			if err := mergedStore.SaveValidity(newColName, b, drop(ctVal, levels.Validity)); err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to save validity: %v\n", err)
 				os.Exit(1)
 			}
@@ -198,14 +287,15 @@ func main() {
 
 		// Copy BMVs for categorical columns
 		srcCol := allMeta[src.storeIdx].Schema.GetColumn(src.colName)
-		if srcCol != nil && (srcCol.Type == schema.Categorical || srcCol.Type == schema.Ordinal) {
-			for v := 1; v <= srcCol.CategoryCount; v++ {
+		if srcCol != nil && srcCol.IsCoded() {
+			for _, v := range srcCol.Codes() {
 				for b := 0; b < srcMeta.BlockCount; b++ {
 					ct, err := srcStore.LoadBMV(src.colName, v, b)
 					if err != nil {
-						continue
+						fmt.Fprintf(os.Stderr, "Failed to load BMV: %v\n", err)
+						os.Exit(1)
 					}
-					if err := mergedStore.SaveBMV(newColName, v, b, ct); err != nil {
+					if err := mergedStore.SaveBMV(newColName, v, b, drop(ct, levels.BMV)); err != nil {
 						fmt.Fprintf(os.Stderr, "Failed to save BMV: %v\n", err)
 						os.Exit(1)
 					}
@@ -227,12 +317,28 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to create metadata: %v\n", err)
 		os.Exit(1)
 	}
+	mergedMeta.Profile = allMeta[0].Profile
+	mergedMeta.Levels = &levels
+	if signKey != nil {
+		// The signer of a merged table is its owner
+		mergedMeta.DataOwnerID = signKey.ID
+	}
 
-	metaPath := filepath.Join(*outputDir, "metadata.json")
-	if err := mergedMeta.SaveToFile(metaPath); err != nil {
+	if err := mergedStore.SaveMetadata(mergedMeta); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to save metadata: %v\n", err)
 		os.Exit(1)
 	}
+	if signKey != nil {
+		if _, err := manifest.SignTable(mergedStore, signKey, mergedSchema.Name, sources); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to sign merged table: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Signed merged manifest as %s over %d source signatures\n", signKey.ID, len(sources))
+	}
+	if err := mergedStore.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to finish merged table: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Load MAC key if provided (for future identifier matching)
 	if *macKeyPath != "" {
@@ -373,8 +479,8 @@ type JoinMaskBlocks struct {
 	Slots  int         `json:"slots"`
 }
 
-// SaveJoinMask saves join mask blocks to a JSON file
-func SaveJoinMask(path string, mask []float64, slots int) error {
+// SaveJoinMask saves join mask blocks as a JSON file of the merged table
+func SaveJoinMask(store *storage.TableStore, name string, mask []float64, slots int) error {
 	numBlocks := (len(mask) + slots - 1) / slots
 	blocks := make([][]float64, numBlocks)
 
@@ -394,27 +500,21 @@ func SaveJoinMask(path string, mask []float64, slots int) error {
 		Slots:  slots,
 	}
 
-	f, err := os.Create(path)
+	data, err := json.MarshalIndent(jmb, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	encoder := json.NewEncoder(f)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(jmb)
+	return store.SaveFile(name, append(data, '\n'))
 }
 
-// LoadJoinMask loads join mask blocks from a JSON file
-func LoadJoinMask(path string) (*JoinMaskBlocks, error) {
-	f, err := os.Open(path)
+// LoadJoinMask loads join mask blocks saved in a table by SaveJoinMask
+func LoadJoinMask(store *storage.TableStore, name string) (*JoinMaskBlocks, error) {
+	data, err := store.LoadFile(name)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
 	var jmb JoinMaskBlocks
-	if err := json.NewDecoder(f).Decode(&jmb); err != nil {
+	if err := json.Unmarshal(data, &jmb); err != nil {
 		return nil, err
 	}
 	return &jmb, nil
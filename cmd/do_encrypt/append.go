@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// runAppend encrypts new rows into an existing table as a new generation
+func runAppend(cmd *flag.FlagSet, args []string) {
+	dataPath := cmd.String("data", "", "Path to CSV file of the rows to append")
+	tablePath := cmd.String("table", "", "Encrypted table to append to (directory or s3:// URL)")
+	pkPath := cmd.String("pk", "", "Path to public key")
+	profileFile := cmd.String("profile-file", "", "Path to the custom profile JSON/YAML file the table was encrypted with")
+	insecure := cmd.Bool("insecure", false, "Allow test-only and insecure profiles (never for real data)")
+	strict := cmd.Bool("strict", false, "Abort if any categorical cell has no code in its column's domain")
+	signKeyPath := cmd.String("sign-key", "", "Path to the owner's signing key; required to append to a signed table")
+	cmd.Parse(args)
+
+	if *dataPath == "" || *tablePath == "" || *pkPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: do_encrypt append -data <csv> -table <dir> -pk <public_key> [-sign-key <key>]")
+		os.Exit(1)
+	}
+
+	table, err := openOwnedTable(*tablePath, *profileFile, *insecure, *signKeyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot append to %s: %v\n", *tablePath, err)
+		os.Exit(1)
+	}
+	meta, prof := table.meta, table.prof
+
+	pk, err := loadPublicKey(prof, *pkPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load public key: %v\n", err)
+		os.Exit(1)
+	}
+	data, colIndex, err := readCSV(*dataPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load data: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The new rows are encoded with the table's schema as stored: its
+	// dictionaries, year ranges and bounds are fixed, so new labels and
+	// years count as invalid cells, and values outside the bounds are
+	// rejected as at encryption
+	tableSchema := meta.Schema
+	tableSchema.Columns = append([]schema.Column(nil), meta.Schema.Columns...)
+	for _, col := range tableSchema.Columns {
+		if col.DerivedFrom != "" {
+			continue
+		}
+		if _, ok := colIndex[col.Name]; !ok {
+			fmt.Fprintf(os.Stderr, "Column %s not found in data\n", col.Name)
+			os.Exit(1)
+		}
+	}
+	for _, col := range tableSchema.Columns {
+		if col.DerivedFrom == "" {
+			continue
+		}
+		src := tableSchema.GetColumn(col.DerivedFrom)
+		times, _, _, err := parseTimes(src, data, colIndex[src.Name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Column %s: %v\n", src.Name, err)
+			os.Exit(1)
+		}
+		addDerivedCells(&col, times, data, colIndex)
+	}
+
+	codes, quality, err := resolveCodes(tableSchema.Columns, data, colIndex, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if violations := reportQuality(tableSchema.Columns, quality); violations > 0 {
+		if *strict {
+			fmt.Fprintf(os.Stderr, "Refusing to append: %d categorical cells have no valid code (-strict)\n", violations)
+			os.Exit(1)
+		}
+		fmt.Printf("WARNING: %d categorical cells have no valid code and are encrypted as invalid\n", violations)
+	}
+
+	gen := meta.Generation + 1
+	writer := newBlockWriter(table.store, prof.Params, rlwe.NewEncryptor(prof.Params, pk), meta.RowCount, len(data), gen, meta.StoredLevels(prof.MaxLevel()), meta.BlockGeneration)
+	first, end := writer.blocks()
+	fmt.Printf("Appending %d rows to %d as generation %d (blocks %d..%d)\n", len(data), meta.RowCount, gen, first, end-1)
+	if _, err := encryptColumns(writer, tableSchema.Columns, data, colIndex, codes); err != nil {
+		fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Commit: nothing refers to the new ciphertexts until the metadata does
+	var blocks []int
+	for b := first; b < end; b++ {
+		blocks = append(blocks, b)
+	}
+	updated := table.nextGeneration(end, blocks)
+	updated.RowCount = meta.RowCount + len(data)
+	updated.Quality = mergeQuality(meta.Quality, quality)
+	updated.Appends = append(append([]schema.AppendRecord(nil), meta.Appends...), schema.AppendRecord{
+		Generation: gen,
+		FirstRow:   meta.RowCount,
+		Rows:       len(data),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := table.commit(updated); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nAppend complete! %s now has %d rows in %d blocks\n", meta.Schema.Name, updated.RowCount, updated.BlockCount)
+}
+
+// mergeQuality adds the data quality of appended rows to a table's
+func mergeQuality(table, appended map[string]schema.ColumnQuality) map[string]schema.ColumnQuality {
+	if len(appended) == 0 {
+		return table
+	}
+	merged := make(map[string]schema.ColumnQuality)
+	for name, q := range table {
+		merged[name] = q
+	}
+	for name, q := range appended {
+		m := merged[name]
+		m.Merge(q)
+		merged[name] = m
+	}
+	return merged
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+	"github.com/hkanpak21/lattigostats/pkg/storage"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// blockWriter encrypts per-row vectors covering table rows
+// [firstRow, firstRow+rows) into the blocks holding them, at generation gen.
+// A block that already holds earlier rows is extended rather than
+// re-encrypted: its unused slots are zero, so the new rows are encrypted into
+// an otherwise zero plaintext with the public key and added to the
+// ciphertext of the block's previous generation.
+// New blocks are encrypted at the level of their kind in levels.
+type blockWriter struct {
+	store     *storage.TableStore
+	params    ckks.Parameters
+	encoder   *ckks.Encoder
+	encryptor params.Encryptor
+	evaluator *ckks.Evaluator
+	slots     int
+	firstRow  int
+	rows      int
+	gen       int
+	levels    schema.EntryLevels
+	prevGen   func(block int) int
+}
+
+// newBlockWriter creates a writer for rows new rows starting at firstRow;
+// prevGen returns the generation of the stored blocks being extended
+func newBlockWriter(store *storage.TableStore, p ckks.Parameters, encryptor params.Encryptor, firstRow, rows, gen int, levels schema.EntryLevels, prevGen func(block int) int) *blockWriter {
+	return &blockWriter{
+		store:     store,
+		params:    p,
+		encoder:   ckks.NewEncoder(p),
+		encryptor: encryptor,
+		evaluator: ckks.NewEvaluator(p, nil),
+		slots:     p.MaxSlots(),
+		firstRow:  firstRow,
+		rows:      rows,
+		gen:       gen,
+		levels:    levels,
+		prevGen:   prevGen,
+	}
+}
+
+// blocks returns the range [first, end) of the blocks holding the new rows
+func (w *blockWriter) blocks() (first, end int) {
+	return w.firstRow / w.slots, (w.firstRow + w.rows + w.slots - 1) / w.slots
+}
+
+// write encrypts values, one per new row, into every block holding the new
+// rows and saves each block under all keys; keys whose kinds share a level
+// share a ciphertext, and blocks with earlier rows are extended from the
+// previous generation of each key
+func (w *blockWriter) write(values []float64, keys ...storage.EntryKey) error {
+	first, end := w.blocks()
+	for b := first; b < end; b++ {
+		start := b * w.slots
+		lo := max(start, w.firstRow)
+		hi := min(start+w.slots, w.firstRow+w.rows)
+		vec := make([]float64, w.slots)
+		copy(vec[lo-start:], values[lo-w.firstRow:hi-w.firstRow])
+
+		fresh := make(map[int]*rlwe.Ciphertext)
+		for _, key := range keys {
+			var ct *rlwe.Ciphertext
+			var err error
+			if lo > start {
+				prev := key
+				prev.Block, prev.Gen = b, w.prevGen(b)
+				ct, err = w.extend(prev, vec)
+			} else if level := w.level(key.Kind); fresh[level] != nil {
+				ct = fresh[level]
+			} else {
+				ct, err = w.encrypt(vec, level, rlwe.NewScale(w.params.DefaultScale()))
+				fresh[level] = ct
+			}
+			if err != nil {
+				return fmt.Errorf("block %d of %s: %w", b, key, err)
+			}
+			key.Block, key.Gen = b, w.gen
+			if err := w.store.SaveEntry(key, ct); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// level returns the level new ciphertexts of a kind are encrypted at
+func (w *blockWriter) level(kind storage.EntryKind) int {
+	switch kind {
+	case storage.KindBlock:
+		return w.levels.Block
+	case storage.KindValidity:
+		return w.levels.Validity
+	}
+	return w.levels.BMV
+}
+
+// encrypt encodes and encrypts a vector at the given level and scale
+func (w *blockWriter) encrypt(vec []float64, level int, scale rlwe.Scale) (*rlwe.Ciphertext, error) {
+	pt := ckks.NewPlaintext(w.params, level)
+	pt.Scale = scale
+	if err := w.encoder.Encode(vec, pt); err != nil {
+		return nil, fmt.Errorf("encoding failed: %w", err)
+	}
+	ct, err := w.encryptor.EncryptNew(pt)
+	if err != nil {
+		return nil, fmt.Errorf("encryption failed: %w", err)
+	}
+	return ct, nil
+}
+
+// extend adds vec, zero in the slots of earlier rows, to a stored block
+func (w *blockWriter) extend(prev storage.EntryKey, vec []float64) (*rlwe.Ciphertext, error) {
+	old, err := w.store.LoadEntry(prev)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := w.encrypt(vec, old.Level(), old.Scale)
+	if err != nil {
+		return nil, err
+	}
+	return w.evaluator.AddNew(old, delta)
+}
+
+// mask multiplies a stored block by a 0/1 plaintext and re-randomizes the
+// product with an encryption of zero, so that the mask cannot be recovered by
+// comparing the result with the block it supersedes. The plaintext is encoded
+// at the scale of the prime the rescale drops, so the block keeps its scale
+// and loses one level.
+func (w *blockWriter) mask(prev storage.EntryKey, keep []float64) (*rlwe.Ciphertext, error) {
+	old, err := w.store.LoadEntry(prev)
+	if err != nil {
+		return nil, err
+	}
+	level := old.Level()
+	if level == 0 {
+		return nil, fmt.Errorf("%s has no level left; encrypt the table again", prev)
+	}
+	pt := ckks.NewPlaintext(w.params, level)
+	pt.Scale = rlwe.NewScale(w.params.Q()[level])
+	if err := w.encoder.Encode(keep, pt); err != nil {
+		return nil, fmt.Errorf("encoding failed: %w", err)
+	}
+	masked, err := w.evaluator.MulNew(old, pt)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.evaluator.Rescale(masked, masked); err != nil {
+		return nil, err
+	}
+	zero, err := w.encrypt(make([]float64, w.slots), masked.Level(), masked.Scale)
+	if err != nil {
+		return nil, err
+	}
+	return w.evaluator.AddNew(masked, zero)
+}
+
+// columnEntries returns the ciphertext entries a column has in every block:
+// its values, its validity and the BMVs of its codes
+func columnEntries(col *schema.Column) []storage.EntryKey {
+	keys := []storage.EntryKey{
+		{Kind: storage.KindBlock, Column: col.Name},
+		{Kind: storage.KindValidity, Column: col.Name},
+	}
+	if col.IsCoded() {
+		for _, code := range col.Codes() {
+			keys = append(keys, storage.EntryKey{Kind: storage.KindBMV, Column: col.Name, Value: code})
+		}
+	}
+	return keys
+}
+
+// encryptColumns encrypts the new rows of every column: its values, its
+// validity and, for categorical/ordinal columns, one BMV per code. It returns
+// the normalization of each bounded numerical column.
+func encryptColumns(w *blockWriter, columns []schema.Column, data [][]string, colIndex map[string]int, codes map[string][]int) (map[string]schema.Transform, error) {
+	// Bounded numerical columns are encrypted normalized to [0, 1] so that sums
+	// of squares stay far below the CKKS scale
+	transforms := make(map[string]schema.Transform)
+	for i := range columns {
+		col := &columns[i]
+		fmt.Printf("  Encrypting column: %s (%s)\n", col.Name, col.Type)
+		transform, normalize := col.Transform()
+		if normalize {
+			fmt.Printf("    Normalizing from [%g, %g] to [0, 1]\n", col.MinValue, col.MaxValue)
+			transforms[col.Name] = transform
+		}
+
+		values, validity, err := columnVectors(col, data, colIndex[col.Name], codes[col.Name], transform, normalize)
+		if err != nil {
+			return nil, err
+		}
+		keys := []storage.EntryKey{{Kind: storage.KindBlock, Column: col.Name}}
+		if col.Type == schema.Boolean {
+			// A boolean block is already the BMV of true
+			keys = append(keys, storage.EntryKey{Kind: storage.KindBMV, Column: col.Name, Value: 1})
+		}
+		if err := w.write(values, keys...); err != nil {
+			return nil, err
+		}
+		if err := w.write(validity, storage.EntryKey{Kind: storage.KindValidity, Column: col.Name}); err != nil {
+			return nil, err
+		}
+
+		if !col.IsCoded() {
+			continue
+		}
+		fmt.Printf("    Generating BMVs for codes %d..%d\n", col.Base(), col.Base()+col.CategoryCount-1)
+		for _, catVal := range col.Codes() {
+			if col.Type == schema.Boolean && catVal == 1 {
+				continue
+			}
+			bmv := make([]float64, len(data))
+			for r, code := range codes[col.Name] {
+				if code == catVal {
+					bmv[r] = 1
+				}
+			}
+			if err := w.write(bmv, storage.EntryKey{Kind: storage.KindBMV, Column: col.Name, Value: catVal}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return transforms, nil
+}
+
+// columnVectors returns the encoded values and validity of a column's cells;
+// coded columns take their values from colCodes
+func columnVectors(col *schema.Column, data [][]string, idx int, colCodes []int, transform schema.Transform, normalize bool) (values, validity []float64, err error) {
+	values = make([]float64, len(data))
+	validity = make([]float64, len(data))
+	for r, row := range data {
+		cell := row[idx]
+		switch {
+		case colCodes != nil:
+			if colCodes[r] != noCode {
+				validity[r] = 1
+				values[r] = float64(colCodes[r])
+			}
+		case col.IsMissing(cell):
+		default:
+			var v float64
+			if col.IsTemporal() {
+				v, err = col.TimeValue(cell)
+			} else {
+				v, err = strconv.ParseFloat(cell, 64)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid value at row %d, col %s: %s", r, col.Name, cell)
+			}
+			if normalize {
+				if v, err = transform.Normalize(v); err != nil {
+					return nil, nil, fmt.Errorf("encoding error at row %d, col %s: %w", r, col.Name, err)
+				}
+			}
+			validity[r] = 1
+			values[r] = v
+		}
+	}
+	return values, validity, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// runInferSchema proposes a schema for a CSV file, to be reviewed before encryption
+func runInferSchema(cmd *flag.FlagSet, args []string) {
+	defaults := schema.DefaultInferOptions()
+	dataPath := cmd.String("data", "", "Path to CSV data file")
+	outputPath := cmd.String("output", "", "Path to write the proposed schema JSON (default stdout)")
+	name := cmd.String("name", defaults.Name, "Table name")
+	maxCategories := cmd.Int("max-categories", defaults.MaxCategories, "Columns with at most this many distinct values are categorical")
+	maxRows := cmd.Int("rows", 0, "Number of rows to scan (0 scans the whole file)")
+	cmd.Parse(args)
+
+	if *dataPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: do_encrypt infer-schema -data <csv> [-output <json>]")
+		os.Exit(1)
+	}
+
+	dataFile, err := os.Open(*dataPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open data: %v\n", err)
+		os.Exit(1)
+	}
+	defer dataFile.Close()
+
+	tableSchema, report, err := schema.InferSchema(dataFile, schema.InferOptions{
+		Name:          *name,
+		MaxCategories: *maxCategories,
+		MaxRows:       *maxRows,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to infer schema: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The summary goes to stderr so that stdout can be redirected to a file
+	fmt.Fprintf(os.Stderr, "Scanned %d rows\n", report.Rows)
+	for _, obs := range report.Columns {
+		typ := string(obs.Type)
+		if typ == "" {
+			typ = "skipped"
+		}
+		fmt.Fprintf(os.Stderr, "  %-20s %-12s %d values, %d missing, %d distinct\n", obs.Name, typ, obs.Values, obs.Missing, obs.Distinct)
+	}
+	for _, w := range report.Warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+	}
+
+	data, err := json.MarshalIndent(tableSchema, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode schema: %v\n", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+	if *outputPath == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*outputPath, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write schema: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Proposed schema written to %s; review it before encrypting\n", *outputPath)
+}
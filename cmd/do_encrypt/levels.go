@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hkanpak21/lattigostats/pkg/jobs"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// tableLevels returns the levels to encrypt each kind of ciphertext at:
+// the profile's top level, lowered to what the operations in opsSpec need
+// and then set per kind by levelsSpec. It returns nil if every kind stays
+// at the top level.
+func tableLevels(prof *params.Profile, tableSchema *schema.TableSchema, blockCount int, opsSpec, levelsSpec string) (*schema.EntryLevels, error) {
+	top := prof.MaxLevel()
+	levels := schema.EntryLevels{Block: top, Validity: top, BMV: top}
+	if opsSpec != "" {
+		specs, categories, err := levelJobs(opsSpec, tableSchema)
+		if err != nil {
+			return nil, err
+		}
+		levels, err = jobs.TableLevels(specs, jobs.PlanTarget{
+			Profile:    prof,
+			BlockCount: blockCount,
+			Categories: map[string]int{"column": categories},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if levelsSpec != "" {
+		for _, item := range strings.Split(levelsSpec, ",") {
+			kind, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			level, err := strconv.Atoi(value)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("invalid level %q: expected kind=level", item)
+			}
+			switch kind {
+			case "block":
+				levels.Block = level
+			case "validity":
+				levels.Validity = level
+			case "bmv":
+				levels.BMV = level
+			default:
+				return nil, fmt.Errorf("unknown ciphertext kind %q: expected block, validity or bmv", kind)
+			}
+		}
+	}
+	if levels.Lowest() < 0 || max(levels.Block, levels.Validity, levels.BMV) > top {
+		return nil, fmt.Errorf("levels %+v are outside 0..%d of profile %s", levels, top, prof.Type)
+	}
+	if levels == (schema.EntryLevels{Block: top, Validity: top, BMV: top}) {
+		return nil, nil
+	}
+	return &levels, nil
+}
+
+// levelJobs returns the most demanding job of each operation in spec, a
+// comma-separated list of operations such as "mean,bc:3,lbc:2": bc, ba and
+// bv take their number of conditions, lbc its number of columns. Percentiles
+// are planned over the ordinal column with the most categories, which it
+// also returns.
+func levelJobs(spec string, tableSchema *schema.TableSchema) ([]*jobs.JobSpec, int, error) {
+	categories := 0
+	for _, col := range tableSchema.Columns {
+		if col.Type == schema.Ordinal {
+			categories = max(categories, col.CategoryCount)
+		}
+	}
+
+	var specs []*jobs.JobSpec
+	for _, item := range strings.Split(spec, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(item), ":")
+		n := 1
+		if hasArg {
+			var err error
+			if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+				return nil, 0, fmt.Errorf("invalid operation %q: expected op or op:count", item)
+			}
+		}
+		job := &jobs.JobSpec{ID: item, Operation: jobs.Operation(name), Table: tableSchema.Name}
+		switch job.Operation {
+		case jobs.OpMean, jobs.OpVariance, jobs.OpStdev, jobs.OpPercentile:
+			job.InputColumns = []string{"column"}
+			job.K = 50
+		case jobs.OpCorr:
+			job.InputColumns = []string{"column", "column"}
+		case jobs.OpBc, jobs.OpBa, jobs.OpBv:
+			if job.Operation != jobs.OpBc {
+				job.TargetColumn = "column"
+			}
+			for i := 0; i < n; i++ {
+				job.Conditions = append(job.Conditions, jobs.Condition{Column: "column"})
+			}
+		case jobs.OpLBc:
+			job.InputColumns = make([]string, max(n, 2))
+			for i := range job.InputColumns {
+				job.InputColumns[i] = "column"
+			}
+		case jobs.OpLookup:
+			job.LookupColumn, job.TargetColumn = "column", "column"
+		}
+		if job.Operation == jobs.OpPercentile && categories == 0 {
+			return nil, 0, fmt.Errorf("operation percentile needs an ordinal column")
+		}
+		specs = append(specs, job)
+	}
+	return specs, categories, nil
+}
@@ -3,52 +3,85 @@
 package main
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"strconv"
 
+	"github.com/hkanpak21/lattigostats/pkg/manifest"
 	"github.com/hkanpak21/lattigostats/pkg/params"
 	"github.com/hkanpak21/lattigostats/pkg/schema"
 	"github.com/hkanpak21/lattigostats/pkg/storage"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
-	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "infer-schema" {
+		runInferSchema(flag.NewFlagSet("infer-schema", flag.ExitOnError), os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "append" {
+		runAppend(flag.NewFlagSet("append", flag.ExitOnError), os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retract" {
+		runRetract(flag.NewFlagSet("retract", flag.ExitOnError), os.Args[2:])
+		return
+	}
+
 	dataPath := flag.String("data", "", "Path to CSV data file")
 	schemaPath := flag.String("schema", "", "Path to schema JSON file")
 	pkPath := flag.String("pk", "", "Path to public key")
-	outputDir := flag.String("output", "./encrypted", "Output directory")
-	profile := flag.String("profile", "A", "Parameter profile (A or B)")
+	outputDir := flag.String("output", "./encrypted", "Output directory, or a single container file if it ends in .lstc")
+	profile := flag.String("profile", "A2", "Parameter profile (A2, B2, R, or T and TB for tests; A and B are deprecated)")
+	profileFile := flag.String("profile-file", "", "Path to a custom profile JSON/YAML file (overrides -profile)")
 	ownerID := flag.String("owner", "owner1", "Data owner ID")
+	insecure := flag.Bool("insecure", false, "Allow test-only and insecure profiles (never for real data)")
+	seed := flag.String("seed", "", "Seed for deterministic encryption (requires -insecure)")
+	strict := flag.Bool("strict", false, "Abort if any categorical cell has no code in its column's domain")
+	signKeyPath := flag.String("sign-key", "", "Path to the owner's signing key; signs a manifest of the table")
+	levelsFor := flag.String("levels-for", "", "Encrypt at the lowest levels the listed operations need, e.g. bc:3,ba:2,mean")
+	levelsFlag := flag.String("levels", "", "Levels per kind of ciphertext, e.g. block=4,validity=3,bmv=3 (overrides -levels-for)")
 	flag.Parse()
 
 	if *dataPath == "" || *schemaPath == "" || *pkPath == "" {
 		fmt.Fprintln(os.Stderr, "Usage: do_encrypt -data <csv> -schema <json> -pk <public_key>")
+		fmt.Fprintln(os.Stderr, "       do_encrypt append -data <csv> -table <dir> -pk <public_key>")
+		fmt.Fprintln(os.Stderr, "       do_encrypt retract -table <dir> -pk <public_key> -rows <list>")
+		fmt.Fprintln(os.Stderr, "       do_encrypt infer-schema -data <csv> [-output <json>]")
 		os.Exit(1)
 	}
 
 	// Load parameters
-	var prof *params.Profile
-	var err error
-	switch *profile {
-	case "A":
-		prof, err = params.NewProfileA()
-	case "B":
-		prof, err = params.NewProfileB()
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown profile: %s\n", *profile)
-		os.Exit(1)
-	}
+	policy := params.Policy{AllowInsecure: *insecure}
+	prof, err := params.ResolveProfile(*profile, *profileFile, policy)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create parameters: %v\n", err)
 		os.Exit(1)
 	}
+	if err := prof.Validate(policy); err != nil {
+		fmt.Fprintf(os.Stderr, "Refusing to encrypt: %v\n", err)
+		os.Exit(1)
+	}
 	p := prof.Params
 
+	var signKey *manifest.PrivateKey
+	if *signKeyPath != "" {
+		signKey, err = manifest.LoadPrivateKey(*signKeyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load signing key: %v\n", err)
+			os.Exit(1)
+		}
+		if signKey.ID != *ownerID {
+			fmt.Fprintf(os.Stderr, "Signing key belongs to %q, not to owner %q\n", signKey.ID, *ownerID)
+			os.Exit(1)
+		}
+	}
+	if *seed != "" && !*insecure {
+		fmt.Fprintln(os.Stderr, "-seed makes the ciphertexts predictable and requires -insecure")
+		os.Exit(1)
+	}
+
 	// Load schema
 	schemaFile, err := os.Open(*schemaPath)
 	if err != nil {
@@ -64,47 +97,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Load public key
-	pkData, err := os.ReadFile(*pkPath)
+	pk, err := loadPublicKey(prof, *pkPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read public key: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to load public key: %v\n", err)
 		os.Exit(1)
 	}
-	pk := new(rlwe.PublicKey)
-	if err := pk.UnmarshalBinary(pkData); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to parse public key: %v\n", err)
-		os.Exit(1)
+	var encryptor params.Encryptor = rlwe.NewEncryptor(p, pk)
+	if *seed != "" {
+		if encryptor, err = params.NewSeededEncryptor(p, pk, *seed); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to seed encryption: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("WARNING: deterministic encryption; this table is for tests only")
 	}
 
-	// Load CSV data
-	dataFile, err := os.Open(*dataPath)
+	data, colIndex, err := readCSV(*dataPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to open data: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to load data: %v\n", err)
 		os.Exit(1)
 	}
-	reader := csv.NewReader(dataFile)
-	records, err := reader.ReadAll()
-	dataFile.Close()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to read CSV: %v\n", err)
-		os.Exit(1)
-	}
-
-	if len(records) < 2 {
-		fmt.Fprintln(os.Stderr, "CSV must have header and at least one row")
-		os.Exit(1)
-	}
-
-	header := records[0]
-	data := records[1:]
 	rowCount := len(data)
 
-	// Map column names to indices
-	colIndex := make(map[string]int)
-	for i, name := range header {
-		colIndex[name] = i
-	}
-
 	// Validate schema columns exist
 	for _, col := range tableSchema.Columns {
 		if _, ok := colIndex[col.Name]; !ok {
@@ -113,144 +126,89 @@ func main() {
 		}
 	}
 
-	// Create output directory
-	store, err := storage.NewTableStore(*outputDir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create table store: %v\n", err)
+	// Append the derived calendar-part columns of date/timestamp columns
+	for _, col := range tableSchema.Columns {
+		if !col.IsTemporal() || len(col.Derive) == 0 {
+			continue
+		}
+		times, minYear, maxYear, err := parseTimes(&col, data, colIndex[col.Name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Column %s: %v\n", col.Name, err)
+			os.Exit(1)
+		}
+		for _, part := range col.Derive {
+			derived := col.DerivedColumn(part, minYear, maxYear)
+			addDerivedCells(&derived, times, data, colIndex)
+			tableSchema.Columns = append(tableSchema.Columns, derived)
+			fmt.Printf("  Derived ordinal column %s from %s\n", derived.Name, col.Name)
+		}
+	}
+	if err := tableSchema.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid schema: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Setup encryption
-	encryptor := rlwe.NewEncryptor(p, pk)
-	encoder := ckks.NewEncoder(p)
-	slots := p.MaxSlots()
-	scale := rlwe.NewScale(p.DefaultScale())
-	level := p.MaxLevel()
-
-	// Calculate blocks
-	blockCount := (rowCount + slots - 1) / slots
-
-	fmt.Printf("Encrypting %d rows in %d blocks (slots=%d)\n", rowCount, blockCount, slots)
-
-	// Encrypt each column
-	for _, col := range tableSchema.Columns {
-		fmt.Printf("  Encrypting column: %s (%s)\n", col.Name, col.Type)
-		idx := colIndex[col.Name]
-
-		for b := 0; b < blockCount; b++ {
-			startRow := b * slots
-			endRow := startRow + slots
-			if endRow > rowCount {
-				endRow = rowCount
-			}
-
-			// Extract values for this block
-			values := make([]complex128, slots)
-			validity := make([]complex128, slots)
-
-			for i := startRow; i < endRow; i++ {
-				slotIdx := i - startRow
-				cellValue := data[i][idx]
-
-				if cellValue == "" || cellValue == "NA" || cellValue == "null" {
-					validity[slotIdx] = 0
-					values[slotIdx] = 0
-				} else {
-					validity[slotIdx] = 1
-					v, err := strconv.ParseFloat(cellValue, 64)
-					if err != nil {
-						// For categorical, try int
-						iv, err2 := strconv.Atoi(cellValue)
-						if err2 != nil {
-							fmt.Fprintf(os.Stderr, "Invalid value at row %d, col %s: %s\n", i, col.Name, cellValue)
-							os.Exit(1)
-						}
-						v = float64(iv)
-					}
-					values[slotIdx] = complex(v, 0)
-				}
-			}
-
-			// Encrypt values
-			pt := ckks.NewPlaintext(p, level)
-			pt.Scale = scale
-			encoder.Encode(values, pt)
-			ct, err := encryptor.EncryptNew(pt)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
-				os.Exit(1)
-			}
-
-			if err := store.SaveBlock(col.Name, b, ct); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to save block: %v\n", err)
-				os.Exit(1)
-			}
-
-			// Encrypt validity
-			ptVal := ckks.NewPlaintext(p, level)
-			ptVal.Scale = scale
-			encoder.Encode(validity, ptVal)
-			ctVal, err := encryptor.EncryptNew(ptVal)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Validity encryption failed: %v\n", err)
-				os.Exit(1)
-			}
-
-			if err := store.SaveValidity(col.Name, b, ctVal); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to save validity: %v\n", err)
-				os.Exit(1)
-			}
+	// Dates and timestamps without declared bounds are normalized over the
+	// observed range, which is recorded in the schema for later appends
+	for i := range tableSchema.Columns {
+		col := &tableSchema.Columns[i]
+		if !col.IsTemporal() || col.HasBounds() {
+			continue
+		}
+		if err := boundTimes(col, data, colIndex[col.Name]); err != nil {
+			fmt.Fprintf(os.Stderr, "Column %s: %v\n", col.Name, err)
+			os.Exit(1)
 		}
+		e, _ := col.TimeEncoding()
+		fmt.Printf("  Bounded %s to its observed range of %ss since %s: [%g, %g]\n", col.Name, e.Unit, e.Epoch, col.MinValue, col.MaxValue)
+	}
 
-		// Generate BMVs for categorical/ordinal columns
-		if col.Type == schema.Categorical || col.Type == schema.Ordinal {
-			fmt.Printf("    Generating BMVs for %d categories\n", col.CategoryCount)
-			idx := colIndex[col.Name]
+	// Resolve categorical cells to codes, building a category dictionary from
+	// the data for categorical columns whose values are labels. Cells without a
+	// valid code are encrypted as invalid so they count in no category.
+	codes, quality, err := resolveCodes(tableSchema.Columns, data, colIndex, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if violations := reportQuality(tableSchema.Columns, quality); violations > 0 {
+		if *strict {
+			fmt.Fprintf(os.Stderr, "Refusing to encrypt: %d categorical cells have no valid code (-strict)\n", violations)
+			os.Exit(1)
+		}
+		fmt.Printf("WARNING: %d categorical cells have no valid code and are encrypted as invalid\n", violations)
+	}
 
-			for catVal := 0; catVal < col.CategoryCount; catVal++ {
-				for b := 0; b < blockCount; b++ {
-					startRow := b * slots
-					endRow := startRow + slots
-					if endRow > rowCount {
-						endRow = rowCount
-					}
+	slots := p.MaxSlots()
+	blockCount := (rowCount + slots - 1) / slots
 
-					bmv := make([]complex128, slots)
-					for i := startRow; i < endRow; i++ {
-						slotIdx := i - startRow
-						cellValue := data[i][idx]
-						if cellValue != "" && cellValue != "NA" && cellValue != "null" {
-							iv, _ := strconv.Atoi(cellValue)
-							if iv == catVal {
-								bmv[slotIdx] = 1
-							}
-						}
-					}
+	// Ciphertexts are encrypted at the top level unless trimmed to what the
+	// table's jobs need; each level dropped saves one prime of every ciphertext
+	levels, err := tableLevels(prof, &tableSchema, blockCount, *levelsFor, *levelsFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid levels: %v\n", err)
+		os.Exit(1)
+	}
 
-					pt := ckks.NewPlaintext(p, level)
-					pt.Scale = scale
-					encoder.Encode(bmv, pt)
-					ct, err := encryptor.EncryptNew(pt)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "BMV encryption failed: %v\n", err)
-						os.Exit(1)
-					}
+	// Create output directory
+	store, err := storage.CreateTableStore(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create table store: %v\n", err)
+		os.Exit(1)
+	}
+	store.ParamsHash = prof.ParamsHash
 
-					if err := store.SaveBMV(col.Name, catVal, b, ct); err != nil {
-						fmt.Fprintf(os.Stderr, "Failed to save BMV: %v\n", err)
-						os.Exit(1)
-					}
-				}
-			}
-		}
+	fmt.Printf("Encrypting %d rows in %d blocks (slots=%d)\n", rowCount, blockCount, slots)
+	if levels != nil {
+		fmt.Printf("Trimming ciphertexts of profile %s (top level %d) to block=%d, validity=%d, bmv=%d\n",
+			prof.Type, prof.MaxLevel(), levels.Block, levels.Validity, levels.BMV)
 	}
 
-	// Save metadata
 	meta, err := schema.NewTableMetadata(
 		tableSchema,
 		rowCount,
 		slots,
-		*profile,
+		prof.ParamsHash,
 		int(p.LogDefaultScale()),
 		*ownerID,
 	)
@@ -258,10 +216,40 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to create metadata: %v\n", err)
 		os.Exit(1)
 	}
+	meta.Profile = string(prof.Type)
+	meta.Levels = levels
+
+	writer := newBlockWriter(store, p, encryptor, 0, rowCount, 0, meta.StoredLevels(prof.MaxLevel()), nil)
+	transforms, err := encryptColumns(writer, tableSchema.Columns, data, colIndex, codes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Encryption failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Save metadata
+	if len(transforms) > 0 {
+		meta.Transforms = transforms
+	}
+	if len(quality) > 0 {
+		meta.Quality = quality
+	}
 
-	metaPath := store.BasePath + "/metadata.json"
-	if err := meta.SaveToFile(metaPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to save metadata: %v\n", err)
+	if err := store.SaveMetadata(meta); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if signKey != nil {
+		signed, err := manifest.SignTable(store, signKey, tableSchema.Name, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to sign table: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Signed manifest as %s\n", signed.Signer)
+	} else {
+		fmt.Println("WARNING: table is not signed; pass -sign-key so that consumers can verify it")
+	}
+	if err := store.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to finish table: %v\n", err)
 		os.Exit(1)
 	}
 
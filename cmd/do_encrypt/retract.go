@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// runRetract withdraws rows from a table without re-encrypting it: their
+// slots are zeroed in every ciphertext of their blocks, which are written as
+// a new generation
+func runRetract(cmd *flag.FlagSet, args []string) {
+	tablePath := cmd.String("table", "", "Encrypted table to withdraw rows from (directory or s3:// URL)")
+	pkPath := cmd.String("pk", "", "Path to public key")
+	rowList := cmd.String("rows", "", "Rows to withdraw by 0-based position in the table, e.g. 3,17,200-210")
+	rowsFile := cmd.String("rows-file", "", "File of rows to withdraw, one position or range per line")
+	profileFile := cmd.String("profile-file", "", "Path to the custom profile JSON/YAML file the table was encrypted with")
+	insecure := cmd.Bool("insecure", false, "Allow test-only and insecure profiles (never for real data)")
+	signKeyPath := cmd.String("sign-key", "", "Path to the owner's signing key; required to update a signed table")
+	cmd.Parse(args)
+
+	if *tablePath == "" || *pkPath == "" || (*rowList == "") == (*rowsFile == "") {
+		fmt.Fprintln(os.Stderr, "Usage: do_encrypt retract -table <dir> -pk <public_key> (-rows <list> | -rows-file <file>) [-sign-key <key>]")
+		os.Exit(1)
+	}
+
+	table, err := openOwnedTable(*tablePath, *profileFile, *insecure, *signKeyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot retract rows of %s: %v\n", *tablePath, err)
+		os.Exit(1)
+	}
+	meta, prof := table.meta, table.prof
+
+	spec := *rowList
+	if *rowsFile != "" {
+		data, err := os.ReadFile(*rowsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read rows: %v\n", err)
+			os.Exit(1)
+		}
+		spec = strings.Join(strings.Fields(string(data)), ",")
+	}
+	rows, err := parseRows(spec, meta.RowCount)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid rows: %v\n", err)
+		os.Exit(1)
+	}
+
+	pk, err := loadPublicKey(prof, *pkPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load public key: %v\n", err)
+		os.Exit(1)
+	}
+
+	updated, err := table.retract(rlwe.NewEncryptor(prof.Params, pk), rows)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nRetraction complete! Jobs on %s now exclude the %d rows and have %d fewer levels\n",
+		meta.Schema.Name, len(rows), updated.RetractionDepth())
+	left := updated.StoredLevels(prof.MaxLevel()).Lowest() - updated.RetractionDepth()
+	fmt.Printf("Retractions are not compacted: the most retracted block has %d levels left for jobs and later retractions\n", left)
+	fmt.Println("Encrypt the table again once jobs need more")
+	fmt.Println("Earlier generations still hold the rows; delete them once no job reads them")
+}
+
+// retract zeroes the slots of rows, sorted and distinct, in every ciphertext
+// of their blocks, writes the masked blocks as the next generation and
+// commits it. It returns the committed metadata.
+func (t *ownedTable) retract(encryptor params.Encryptor, rows []int) (*schema.TableMetadata, error) {
+	meta, prof := t.meta, t.prof
+
+	// One mask per block, zero at the slots of the withdrawn rows
+	masks := make(map[int][]float64)
+	var blocks []int
+	for _, r := range rows {
+		b := r / meta.Slots
+		if masks[b] == nil {
+			masks[b] = make([]float64, meta.Slots)
+			for i := range masks[b] {
+				masks[b][i] = 1
+			}
+			blocks = append(blocks, b)
+		}
+		masks[b][r%meta.Slots] = 0
+	}
+
+	// Masking costs a level, which trimmed or retracted blocks may not have
+	for _, b := range blocks {
+		if meta.StoredLevels(prof.MaxLevel()).Lowest()-meta.BlockRetractions(b) < 1 {
+			return nil, fmt.Errorf("block %d has no level left for a retraction; encrypt the table again", b)
+		}
+	}
+
+	gen := meta.Generation + 1
+	writer := newBlockWriter(t.store, prof.Params, encryptor, 0, 0, gen, meta.StoredLevels(prof.MaxLevel()), meta.BlockGeneration)
+	fmt.Printf("Retracting %d rows in %d blocks as generation %d\n", len(rows), len(blocks), gen)
+	for _, b := range blocks {
+		for i := range meta.Schema.Columns {
+			for _, key := range columnEntries(&meta.Schema.Columns[i]) {
+				prev := key
+				prev.Block, prev.Gen = b, meta.BlockGeneration(b)
+				ct, err := writer.mask(prev, masks[b])
+				if err != nil {
+					return nil, fmt.Errorf("failed to mask block %d: %w", b, err)
+				}
+				key.Block, key.Gen = b, gen
+				if err := t.store.SaveEntry(key, ct); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	updated := t.nextGeneration(meta.BlockCount, blocks)
+	updated.Retractions = append(append([]schema.RetractionRecord(nil), meta.Retractions...), schema.RetractionRecord{
+		Generation: gen,
+		Rows:       len(rows),
+		Blocks:     blocks,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+	})
+	if err := t.commit(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// parseRows parses a comma-separated list of row positions and ranges into
+// sorted distinct positions below rowCount
+func parseRows(spec string, rowCount int) ([]int, error) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a row position", part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("%q is not a row range", part)
+			}
+		}
+		if first < 0 || last >= rowCount {
+			return nil, fmt.Errorf("%s is outside the table's rows 0..%d", part, rowCount-1)
+		}
+		for r := first; r <= last; r++ {
+			seen[r] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("no rows given")
+	}
+	rows := make([]int, 0, len(seen))
+	for r := range seen {
+		rows = append(rows, r)
+	}
+	sort.Ints(rows)
+	return rows, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// noCode marks a categorical cell that is missing or has no valid code;
+// code bases are non-negative so it never collides with a real code
+const noCode = -1
+
+// loadPublicKey reads the public key of a key directory made for prof
+func loadPublicKey(prof *params.Profile, path string) (*rlwe.PublicKey, error) {
+	if err := prof.CheckKeyDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("key check failed: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	pk := new(rlwe.PublicKey)
+	if err := pk.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return pk, nil
+}
+
+// readCSV reads a CSV file with a header and at least one row, returning the
+// rows and the index of each column name
+func readCSV(path string) ([][]string, map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open data: %w", err)
+	}
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, nil, fmt.Errorf("CSV must have header and at least one row")
+	}
+	colIndex := make(map[string]int)
+	for i, name := range records[0] {
+		colIndex[name] = i
+	}
+	return records[1:], colIndex, nil
+}
+
+// parseTimes parses the cells of a date/timestamp column, leaving missing
+// cells zero, and returns the range of their years
+func parseTimes(col *schema.Column, data [][]string, idx int) (times []time.Time, minYear, maxYear int, err error) {
+	times = make([]time.Time, len(data))
+	for r, row := range data {
+		if col.IsMissing(row[idx]) {
+			continue
+		}
+		t, err := col.ParseTime(row[idx])
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid value at row %d: %w", r, err)
+		}
+		times[r] = t
+		if minYear == 0 || t.Year() < minYear {
+			minYear = t.Year()
+		}
+		if t.Year() > maxYear {
+			maxYear = t.Year()
+		}
+	}
+	return times, minYear, maxYear, nil
+}
+
+// boundTimes sets the bounds of a date/timestamp column that declares none to
+// the range of its offsets in data, so that it is encrypted normalized: raw
+// offsets, e.g. about 1.7e9 seconds since 1970, overflow sums of squares and
+// bootstrapping
+func boundTimes(col *schema.Column, data [][]string, idx int) error {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for r, row := range data {
+		if col.IsMissing(row[idx]) {
+			continue
+		}
+		v, err := col.TimeValue(row[idx])
+		if err != nil {
+			return fmt.Errorf("invalid value at row %d: %w", r, err)
+		}
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if math.IsInf(lo, 1) {
+		return nil
+	}
+	if hi == lo {
+		hi = lo + 1
+	}
+	col.MinValue, col.MaxValue = lo, hi
+	return nil
+}
+
+// addDerivedCells appends the cells of a derived column, computed from the
+// parsed times of its source column, to every row
+func addDerivedCells(derived *schema.Column, times []time.Time, data [][]string, colIndex map[string]int) {
+	colIndex[derived.Name] = len(data[0])
+	for r := range data {
+		cell := ""
+		if !times[r].IsZero() {
+			cell = derived.DerivedCell(times[r])
+		}
+		data[r] = append(data[r], cell)
+	}
+}
+
+// resolveCodes resolves the categorical cells of every coded column to codes,
+// noCode for cells without a valid one, and counts the cells of each column
+// that were missing or invalid. With learnLabels, columns without a
+// dictionary whose cells are all labels get one built from the data.
+func resolveCodes(columns []schema.Column, data [][]string, colIndex map[string]int, learnLabels bool) (map[string][]int, map[string]schema.ColumnQuality, error) {
+	codes := make(map[string][]int)
+	quality := make(map[string]schema.ColumnQuality)
+	for i := range columns {
+		col := &columns[i]
+		if !col.IsCoded() {
+			continue
+		}
+		idx := colIndex[col.Name]
+
+		// A column is taken to hold labels only if none of its cells is an
+		// integer; stray non-integers in a coded column are data-quality issues
+		if learnLabels && len(col.Labels) == 0 && col.Type != schema.Boolean {
+			var cells []string
+			integers := 0
+			for _, row := range data {
+				if col.IsMissing(row[idx]) {
+					continue
+				}
+				cells = append(cells, row[idx])
+				if _, err := strconv.Atoi(row[idx]); err == nil {
+					integers++
+				}
+			}
+			if len(cells) > 0 && integers == 0 {
+				if col.Type == schema.Ordinal {
+					return nil, nil, fmt.Errorf("ordinal column %s has non-integer values; declare its labels in order in the schema", col.Name)
+				}
+				col.Labels = schema.BuildLabels(cells)
+				if err := col.Validate(); err != nil {
+					return nil, nil, fmt.Errorf("failed to build dictionary: %w", err)
+				}
+				fmt.Printf("  Built dictionary for %s: %v\n", col.Name, col.Labels)
+			}
+		}
+		col.CategoryCount = col.Categories()
+
+		colCodes := make([]int, len(data))
+		var q schema.ColumnQuality
+		for r, row := range data {
+			colCodes[r] = noCode
+			if col.IsMissing(row[idx]) {
+				q.Missing++
+				continue
+			}
+			code, issue := col.ParseCode(row[idx])
+			if issue != schema.CellValid {
+				if q.Violations() == 0 {
+					fmt.Printf("    First invalid value of %s at row %d: %q (%s)\n", col.Name, r, row[idx], issue)
+				}
+				q.Add(issue)
+				continue
+			}
+			colCodes[r] = code
+		}
+		codes[col.Name] = colCodes
+		quality[col.Name] = q
+	}
+	return codes, quality, nil
+}
+
+// reportQuality prints the per-column data quality of categorical cells and
+// returns the number of cells without a valid code
+func reportQuality(columns []schema.Column, quality map[string]schema.ColumnQuality) int {
+	if len(quality) == 0 {
+		return 0
+	}
+	violations := 0
+	fmt.Println("Data quality of categorical columns:")
+	for _, col := range columns {
+		if q, ok := quality[col.Name]; ok {
+			fmt.Printf("  %s (codes %d..%d): %s\n", col.Name, col.Base(), col.Base()+col.CategoryCount-1, q)
+			violations += q.Violations()
+		}
+	}
+	return violations
+}
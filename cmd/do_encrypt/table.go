@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hkanpak21/lattigostats/pkg/manifest"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+	"github.com/hkanpak21/lattigostats/pkg/storage"
+)
+
+// ownedTable is an encrypted table opened by its owner for an update. An
+// update writes the blocks it changes under a new generation and commits by
+// replacing the metadata, or the manifest of a signed table, so jobs that
+// loaded the table earlier keep reading the version they started on.
+type ownedTable struct {
+	store   *storage.TableStore
+	meta    *schema.TableMetadata
+	prof    *params.Profile
+	signKey *manifest.PrivateKey
+}
+
+// openOwnedTable opens a table directory for an update. A signed table can
+// only be updated with its owner's signing key, as it is signed again, and
+// only if it still verifies against that key.
+func openOwnedTable(path, profileFile string, insecure bool, signKeyPath string) (*ownedTable, error) {
+	store, err := storage.OpenTableStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open table: %w", err)
+	}
+	if store.IsContainer() {
+		return nil, fmt.Errorf("containers are read-only; unpack the table, update the directory and pack it again")
+	}
+	var signKey *manifest.PrivateKey
+	if signKeyPath != "" {
+		signKey, err = manifest.LoadPrivateKey(signKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key: %w", err)
+		}
+	}
+	var signed *manifest.Manifest
+	if store.Has(storage.EntryKey{Kind: storage.KindFile, Column: manifest.File}) {
+		if signKey == nil {
+			return nil, fmt.Errorf("table is signed; pass the owner's -sign-key so that it can be signed again")
+		}
+		// Check the table as readers do, and every entry against its hash,
+		// before changing it, so that an update never signs tampered entries
+		keyring := manifest.Keyring{signKey.ID: signKey.Public().Key}
+		if _, signed, err = keyring.VerifyTable(store); err != nil {
+			return nil, fmt.Errorf("table does not verify against the signing key: %w", err)
+		}
+		if errs := manifest.CheckAll(store, signed); len(errs) > 0 {
+			return nil, fmt.Errorf("%d of %d entries do not match the signed manifest: %w", len(errs), len(signed.Entries), errs[0])
+		}
+	}
+	meta, err := store.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	if signKey != nil && signKey.ID != meta.DataOwnerID {
+		return nil, fmt.Errorf("signing key belongs to %q, not to owner %q", signKey.ID, meta.DataOwnerID)
+	}
+	if signed != nil {
+		if err := signed.CheckOwner(meta.DataOwnerID); err != nil {
+			return nil, err
+		}
+	}
+	if meta.Profile == "" {
+		return nil, fmt.Errorf("table predates parameter hash binding; encrypt it again")
+	}
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Kind == storage.KindFile && strings.HasPrefix(key.Column, "join_mask_") {
+			return nil, fmt.Errorf("table was merged with join masks; update its sources and merge again")
+		}
+	}
+
+	policy := params.Policy{AllowInsecure: insecure}
+	prof, err := params.ResolveProfile(meta.Profile, profileFile, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parameters: %w", err)
+	}
+	if err := prof.Validate(policy); err != nil {
+		return nil, err
+	}
+	if err := prof.CheckHash(meta.ParamsHash, "table "+meta.Schema.Name); err != nil {
+		return nil, err
+	}
+	if meta.Slots != prof.Params.MaxSlots() {
+		return nil, fmt.Errorf("table has %d slots per block but profile %s has %d", meta.Slots, prof.Type, prof.Params.MaxSlots())
+	}
+	store.ParamsHash = prof.ParamsHash
+
+	return &ownedTable{store: store, meta: meta, prof: prof, signKey: signKey}, nil
+}
+
+// nextGeneration returns a copy of the metadata at the next generation, with
+// the given blocks moved to it
+func (t *ownedTable) nextGeneration(blockCount int, blocks []int) *schema.TableMetadata {
+	updated := *t.meta
+	updated.Generation = t.meta.Generation + 1
+	updated.BlockCount = blockCount
+	updated.BlockGenerations = make([]int, blockCount)
+	for b := range updated.BlockGenerations {
+		updated.BlockGenerations[b] = t.meta.BlockGeneration(b)
+	}
+	for _, b := range blocks {
+		updated.BlockGenerations[b] = updated.Generation
+	}
+	return &updated
+}
+
+// commit saves the updated metadata, which makes the update visible to new
+// readers, and closes the table. A signed table is signed again first: its
+// manifest embeds the updated metadata, so verified readers see the update
+// as soon as the manifest is replaced and never see a manifest that does not
+// match the metadata.
+func (t *ownedTable) commit(updated *schema.TableMetadata) error {
+	if err := updated.Validate(); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if t.signKey != nil {
+		var data bytes.Buffer
+		if _, err := updated.WriteTo(&data); err != nil {
+			return err
+		}
+		t.store.SetFile(storage.MetadataFile, data.Bytes())
+		s, err := manifest.SignTable(t.store, t.signKey, updated.Schema.Name, nil)
+		if err != nil {
+			return fmt.Errorf("failed to sign table: %w", err)
+		}
+		fmt.Printf("Signed manifest as %s\n", s.Signer)
+	}
+	if err := t.store.SaveMetadata(updated); err != nil {
+		return err
+	}
+	t.meta = updated
+	if err := t.store.Close(); err != nil {
+		return fmt.Errorf("failed to finish table: %w", err)
+	}
+	return nil
+}
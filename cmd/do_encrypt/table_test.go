@@ -0,0 +1,383 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/manifest"
+	"github.com/hkanpak21/lattigostats/pkg/ops/categorical"
+	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+	"github.com/hkanpak21/lattigostats/pkg/storage"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// testTable is a signed table of a numerical column x and a categorical
+// column c on Profile T, with the keys to extend and decrypt it
+type testTable struct {
+	*ownedTable
+	sk          *rlwe.SecretKey
+	pk          *rlwe.PublicKey
+	keyring     manifest.Keyring
+	signKeyPath string
+	columns     []schema.Column
+}
+
+// testValue is the value of column x in row r
+func testValue(r int) float64 {
+	return float64(r%97) / 8
+}
+
+// testCode is the code of column c in row r
+func testCode(r int) int {
+	return r%3 + 1
+}
+
+// testColumns is the column index of the cells testRows returns
+var testColumns = map[string]int{"x": 0, "c": 1}
+
+// testRows returns the CSV cells of rows [first, first+n)
+func testRows(first, n int) [][]string {
+	data := make([][]string, n)
+	for i := range data {
+		data[i] = []string{strconv.FormatFloat(testValue(first+i), 'g', -1, 64), strconv.Itoa(testCode(first + i))}
+	}
+	return data
+}
+
+// encryptRows encrypts rows [first, first+n) with w
+func (tt *testTable) encryptRows(t *testing.T, w *blockWriter, first, n int) {
+	t.Helper()
+	data := testRows(first, n)
+	codes, _, err := resolveCodes(tt.columns, data, testColumns, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encryptColumns(w, tt.columns, data, testColumns, codes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestTable encrypts and signs rows rows into a table directory
+func newTestTable(t *testing.T, rows int) *testTable {
+	t.Helper()
+	prof, err := params.NewProfileT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := prof.Params
+	sk, pk := rlwe.NewKeyGenerator(p).GenKeyPairNew()
+
+	signKey, err := manifest.GenerateKey("owner1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir := t.TempDir()
+	signKeyPath := filepath.Join(t.TempDir(), "owner1.key")
+	if err := manifest.SavePrivateKey(signKeyPath, signKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.SavePublicKey(filepath.Join(keyDir, "owner1.pub"), signKey.Public()); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := manifest.LoadKeyring(keyDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.CreateTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.ParamsHash = prof.ParamsHash
+	tableSchema := schema.TableSchema{Name: "t", Columns: []schema.Column{
+		{Name: "x", Type: schema.Numerical},
+		{Name: "c", Type: schema.Categorical, CategoryCount: 3},
+	}}
+	meta, err := schema.NewTableMetadata(tableSchema, rows, p.MaxSlots(), prof.ParamsHash, int(p.LogDefaultScale()), "owner1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Profile = string(prof.Type)
+
+	tt := &testTable{
+		ownedTable:  &ownedTable{store: store, meta: meta, prof: prof, signKey: signKey},
+		sk:          sk,
+		pk:          pk,
+		keyring:     keyring,
+		signKeyPath: signKeyPath,
+		columns:     tableSchema.Columns,
+	}
+	tt.encryptRows(t, newBlockWriter(store, p, rlwe.NewEncryptor(p, pk), 0, rows, 0, meta.StoredLevels(prof.MaxLevel()), nil), 0, rows)
+	if err := store.SaveMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manifest.SignTable(store, signKey, "t", nil); err != nil {
+		t.Fatal(err)
+	}
+	return tt
+}
+
+// reopen opens the table for an update as do_encrypt does
+func (tt *testTable) reopen(t *testing.T) {
+	t.Helper()
+	owned, err := openOwnedTable(tt.store.BasePath, "", true, tt.signKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.ownedTable = owned
+}
+
+// open opens the table as a verifying reader does
+func (tt *testTable) open(t *testing.T) (*storage.TableStore, *schema.TableMetadata) {
+	t.Helper()
+	store, err := storage.OpenTableStore(tt.store.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.ParamsHash = tt.prof.ParamsHash
+	if _, _, err := tt.keyring.VerifyTable(store); err != nil {
+		t.Fatalf("VerifyTable failed: %v", err)
+	}
+	meta, err := store.LoadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, meta
+}
+
+// checkRows decrypts every block of column x and compares its first rows
+// slots with testValue and the rest with zero
+func (tt *testTable) checkRows(t *testing.T, store *storage.TableStore, meta *schema.TableMetadata, rows int) {
+	t.Helper()
+	p := tt.prof.Params
+	encoder, decryptor := ckks.NewEncoder(p), rlwe.NewDecryptor(p, tt.sk)
+	for b := 0; b < meta.BlockCount; b++ {
+		ct, err := store.LoadBlock("x", b)
+		if err != nil {
+			t.Fatalf("block %d: %v", b, err)
+		}
+		values := make([]float64, p.MaxSlots())
+		if err := encoder.Decode(decryptor.DecryptNew(ct), values); err != nil {
+			t.Fatal(err)
+		}
+		for i, got := range values {
+			want := 0.0
+			if r := b*p.MaxSlots() + i; r < rows {
+				want = testValue(r)
+			}
+			if math.Abs(got-want) > 1e-3 {
+				t.Fatalf("block %d slot %d: expected %g, got %g", b, i, want, got)
+			}
+		}
+	}
+}
+
+// TestAppendSignedTable appends rows that fill the partly used last block
+// and spill into a new one, and checks what verifying readers see before
+// and after the commit
+func TestAppendSignedTable(t *testing.T) {
+	prof, err := params.NewProfileT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slots := prof.Params.MaxSlots()
+	tt := newTestTable(t, slots+10)
+	tt.reopen(t)
+
+	// The new rows are encrypted under the next generation, which readers
+	// ignore until the commit
+	added := slots
+	writer := newBlockWriter(tt.store, tt.prof.Params, rlwe.NewEncryptor(tt.prof.Params, tt.pk), tt.meta.RowCount, added, 1,
+		tt.meta.StoredLevels(tt.prof.MaxLevel()), tt.meta.BlockGeneration)
+	first, end := writer.blocks()
+	if first != 1 || end != 3 {
+		t.Fatalf("appended rows should span blocks 1..2, got %d..%d", first, end-1)
+	}
+	tt.encryptRows(t, writer, tt.meta.RowCount, added)
+	before, beforeMeta := tt.open(t)
+	if beforeMeta.RowCount != slots+10 || beforeMeta.Generation != 0 {
+		t.Errorf("reader before the commit sees %d rows at generation %d", beforeMeta.RowCount, beforeMeta.Generation)
+	}
+
+	var blocks []int
+	for b := first; b < end; b++ {
+		blocks = append(blocks, b)
+	}
+	updated := tt.nextGeneration(end, blocks)
+	updated.RowCount = tt.meta.RowCount + added
+	if err := tt.commit(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	store, meta := tt.open(t)
+	if meta.RowCount != 2*slots+10 || meta.BlockCount != 3 || meta.Generation != 1 {
+		t.Fatalf("reader after the commit sees %d rows in %d blocks at generation %d", meta.RowCount, meta.BlockCount, meta.Generation)
+	}
+	if got := meta.BlockGenerations; len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 1 {
+		t.Errorf("unexpected block generations %v", got)
+	}
+	tt.checkRows(t, store, meta, meta.RowCount)
+
+	// A reader that loaded the table before the commit keeps its version
+	tt.checkRows(t, before, beforeMeta, beforeMeta.RowCount)
+}
+
+// TestRetractSignedTable withdraws rows from the first block of a signed
+// table and checks that counts, bin-counts and means over it leave them out
+// and that only the masked block lost a level
+func TestRetractSignedTable(t *testing.T) {
+	prof, err := params.NewProfileT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := prof.Params
+	slots, top := p.MaxSlots(), prof.MaxLevel()
+	rows := slots + 10
+	tt := newTestTable(t, rows)
+	tt.reopen(t)
+
+	// The withdrawn rows hold the largest value of x and one code of c each
+	retracted := []int{96, 193, 290}
+	if _, err := tt.retract(rlwe.NewEncryptor(p, tt.pk), retracted); err != nil {
+		t.Fatal(err)
+	}
+
+	store, meta := tt.open(t)
+	if meta.RowCount != rows || meta.RetractionDepth() != 1 || meta.BlockRetractions(1) != 0 {
+		t.Fatalf("unexpected metadata after the retraction: %d rows, retractions %+v", meta.RowCount, meta.Retractions)
+	}
+	for i := range tt.columns {
+		for _, key := range columnEntries(&tt.columns[i]) {
+			for b, want := range []int{top - 1, top} {
+				key.Block, key.Gen = b, meta.BlockGeneration(b)
+				ct, err := store.LoadEntry(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ct.Level() != want {
+					t.Errorf("%s is at level %d, expected %d", key, ct.Level(), want)
+				}
+			}
+		}
+	}
+
+	withdrawn := make(map[int]bool)
+	for _, r := range retracted {
+		withdrawn[r] = true
+	}
+	sum, count, bin := 0.0, 0.0, 0.0
+	for r := 0; r < rows; r++ {
+		if withdrawn[r] {
+			continue
+		}
+		sum += testValue(r)
+		count++
+		if testCode(r) == 1 {
+			bin++
+		}
+	}
+
+	kgen := rlwe.NewKeyGenerator(p)
+	evk := rlwe.NewMemEvaluationKeySet(kgen.GenRelinearizationKeyNew(tt.sk), kgen.GenGaloisKeysNew(rlwe.GaloisElementsForInnerSum(p, 1, slots), tt.sk)...)
+	evaluator, err := he.NewEvaluator(p, evk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := func(keys ...storage.EntryKey) *storage.Prefetcher {
+		s := store.NewPrefetcher(func(b int) []storage.EntryKey {
+			block := make([]storage.EntryKey, len(keys))
+			for i, key := range keys {
+				key.Block = b
+				block[i] = key
+			}
+			return block
+		}, meta.BlockCount, 1)
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	decrypt := func(ct *rlwe.Ciphertext) float64 {
+		values := make([]float64, slots)
+		if err := ckks.NewEncoder(p).Decode(rlwe.NewDecryptor(p, tt.sk).DecryptNew(ct), values); err != nil {
+			t.Fatal(err)
+		}
+		return values[0]
+	}
+
+	x := storage.EntryKey{Kind: storage.KindBlock, Column: "x"}
+	vx := storage.EntryKey{Kind: storage.KindValidity, Column: "x"}
+	vc := storage.EntryKey{Kind: storage.KindValidity, Column: "c"}
+	numOp := numeric.NewNumericOp(evaluator)
+	sumCt, err := numOp.StreamMaskedSum(stream(x, vx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	countCt, err := numOp.StreamCount(stream(vx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcCt, err := categorical.NewCategoricalOp(evaluator).StreamBc(stream(vc, storage.EntryKey{Kind: storage.KindBMV, Column: "c", Value: 1}),
+		[]categorical.Condition{{ColumnName: "c", Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Profile T has no bootstrapping for the inverse count, so the mean is
+	// taken from the decrypted masked sum and count
+	if got := decrypt(countCt); math.Abs(got-count) > 0.5 {
+		t.Errorf("count: expected %.0f, got %.2f", count, got)
+	}
+	if got := decrypt(bcCt); math.Abs(got-bin) > 0.5 {
+		t.Errorf("Bc(c=1): expected %.0f, got %.2f", bin, got)
+	}
+	if got := decrypt(sumCt) / decrypt(countCt); math.Abs(got-sum/count) > 1e-4 {
+		t.Errorf("mean: expected %.6f, got %.6f", sum/count, got)
+	}
+
+	// Putting the block back from before the retraction restores the
+	// withdrawn rows, so the table must not be updated and signed again
+	data, err := os.ReadFile(filepath.Join(tt.store.BasePath, "blocks", "x_0.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tt.store.BasePath, "blocks", "x_0.g1.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openOwnedTable(tt.store.BasePath, "", true, tt.signKeyPath); err == nil {
+		t.Error("expected a table with a replayed block to be refused")
+	}
+}
+
+// TestUpdateTamperedTable checks that a signed table whose blocks were
+// swapped cannot be opened for an update, so the update cannot sign them
+func TestUpdateTamperedTable(t *testing.T) {
+	prof, err := params.NewProfileT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := newTestTable(t, prof.Params.MaxSlots()+10)
+	if _, err := openOwnedTable(tt.store.BasePath, "", true, ""); err == nil {
+		t.Error("expected a signed table to need the signing key")
+	}
+
+	blocks := filepath.Join(tt.store.BasePath, "blocks")
+	first, second := filepath.Join(blocks, "x_0.bin"), filepath.Join(blocks, "x_1.bin")
+	if err := os.Rename(first, first+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(second, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(first+".tmp", second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openOwnedTable(tt.store.BasePath, "", true, tt.signKeyPath); err == nil {
+		t.Fatal("expected a table with swapped blocks to be refused")
+	}
+}
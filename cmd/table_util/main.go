@@ -0,0 +1,309 @@
+// Table Util - Encrypted Table Container Tool
+// This tool packs encrypted table directories into single checksummed
+// container files, unpacks them, checks tables, and signs tables.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hkanpak21/lattigostats/pkg/manifest"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/storage"
+)
+
+func main() {
+	packCmd := flag.NewFlagSet("pack", flag.ExitOnError)
+	unpackCmd := flag.NewFlagSet("unpack", flag.ExitOnError)
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	keygenCmd := flag.NewFlagSet("keygen", flag.ExitOnError)
+	signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
+
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	switch os.Args[1] {
+	case "pack":
+		runPack(packCmd, os.Args[2:])
+	case "unpack":
+		runUnpack(unpackCmd, os.Args[2:])
+	case "verify":
+		runVerify(verifyCmd, os.Args[2:])
+	case "keygen":
+		runKeygen(keygenCmd, os.Args[2:])
+	case "sign":
+		runSign(signCmd, os.Args[2:])
+	default:
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Usage: table_util <command> [options]")
+	fmt.Println("\nCommands:")
+	fmt.Println("  pack     Pack a table directory into a container file")
+	fmt.Println("  unpack   Unpack a container file into a table directory")
+	fmt.Println("  verify   Check a table's ciphertexts against its metadata, checksums and signed manifest")
+	fmt.Println("  keygen   Generate a data owner's signing key")
+	fmt.Println("  sign     Sign the manifest of an existing table")
+}
+
+func runPack(cmd *flag.FlagSet, args []string) {
+	input := cmd.String("input", "", "Table directory")
+	output := cmd.String("output", "", "Container file to create (e.g. table"+storage.ContainerExt+")")
+	cmd.Parse(args)
+
+	if *input == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "Usage: table_util pack -input <dir> -output <file>")
+		os.Exit(1)
+	}
+	n, err := storage.Pack(*input, *output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to pack table: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Packed %d entries into %s\n", n, *output)
+}
+
+func runUnpack(cmd *flag.FlagSet, args []string) {
+	input := cmd.String("input", "", "Container file")
+	output := cmd.String("output", "", "Table directory to create")
+	cmd.Parse(args)
+
+	if *input == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "Usage: table_util unpack -input <file> -output <dir>")
+		os.Exit(1)
+	}
+	n, err := storage.Unpack(*input, *output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to unpack table: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Unpacked %d entries into %s\n", n, *output)
+}
+
+func runVerify(cmd *flag.FlagSet, args []string) {
+	input := cmd.String("input", "", "Table directory, container file or s3:// URL")
+	list := cmd.Bool("list", false, "List every entry")
+	keyringDir := cmd.String("keyring", "", "Directory of trusted owner public keys (*.pub); checks the signed manifest")
+	profileFile := cmd.String("profile-file", "", "Path to the custom profile JSON/YAML file the table was encrypted with")
+	insecure := cmd.Bool("insecure", false, "Allow test-only and insecure profiles")
+	jsonOut := cmd.Bool("json", false, "Print the report of the table check as JSON")
+	cmd.Parse(args)
+
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "Usage: table_util verify -input <dir|file|s3://...> [-keyring <dir>] [-list] [-json]")
+		os.Exit(1)
+	}
+	if storage.IsContainer(*input) {
+		verifyContainer(*input, *list)
+	}
+	store, err := storage.OpenTableStore(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open table: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	if *keyringDir != "" {
+		verifyManifest(store, *keyringDir, *list)
+	}
+	checkTable(store, *profileFile, *insecure, *list, *jsonOut)
+}
+
+// checkTable checks every ciphertext a table's metadata calls for
+func checkTable(store *storage.TableStore, profileFile string, insecure, list, jsonOut bool) {
+	meta, err := store.LoadMetadata()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load metadata: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Levels are checked against the profile if it can be resolved
+	var opts storage.VerifyOptions
+	if meta.Profile != "" {
+		store.ParamsHash = meta.ParamsHash
+		prof, err := params.ResolveProfile(meta.Profile, profileFile, params.Policy{AllowInsecure: insecure})
+		if err == nil {
+			err = prof.CheckHash(meta.ParamsHash, "table "+meta.Schema.Name)
+		}
+		if err != nil {
+			fmt.Printf("Warning: %v; checking that blocks have consistent levels only\n", err)
+		} else {
+			opts.MaxLevel = prof.MaxLevel()
+		}
+	}
+
+	report, err := store.VerifyTable(meta, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to check table: %v\n", err)
+		os.Exit(1)
+	}
+	if jsonOut {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode report: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, p := range report.Problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", p)
+		}
+		if list {
+			for _, key := range report.Unreferenced {
+				fmt.Printf("  unreferenced: %s\n", key)
+			}
+		}
+		fmt.Printf("Table %s (%d rows, %d blocks, generation %d): %s\n",
+			meta.Schema.Name, meta.RowCount, meta.BlockCount, meta.Generation, report)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func verifyContainer(path string, list bool) {
+	c, err := storage.OpenContainer(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open container: %v\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	entries := c.Entries()
+	if list {
+		for _, e := range entries {
+			fmt.Printf("  %-40s %10d bytes at %d\n", e.EntryKey, e.Length, e.Offset)
+		}
+	}
+	errs := c.Verify()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d entries failed verification\n", len(errs), len(entries))
+		os.Exit(1)
+	}
+	fmt.Printf("All %d entries verified\n", len(entries))
+}
+
+func verifyManifest(store *storage.TableStore, keyringDir string, list bool) {
+	keyring, err := manifest.LoadKeyring(keyringDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load keyring: %v\n", err)
+		os.Exit(1)
+	}
+
+	signed, m, err := keyring.VerifyTable(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Signature verification failed: %v\n", err)
+		os.Exit(1)
+	}
+	meta, err := store.LoadMetadata()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load metadata: %v\n", err)
+		os.Exit(1)
+	}
+	if err := m.CheckOwner(meta.DataOwnerID); err != nil {
+		fmt.Fprintf(os.Stderr, "Signature verification failed: %v\n", err)
+		os.Exit(1)
+	}
+	if list {
+		for _, e := range m.Entries {
+			fmt.Printf("  %-40s %s\n", e.EntryKey, e.SHA256[:16])
+		}
+	}
+	errs := manifest.CheckAll(store, m)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d entries do not match the signed manifest\n", len(errs), len(m.Entries))
+		os.Exit(1)
+	}
+	fmt.Printf("Manifest of table %s signed by %s (created %s, digest %s)\n",
+		m.Table, signed.Signer, m.Created.Format(time.RFC3339), signed.Digest()[:16])
+	for _, src := range m.Sources {
+		fmt.Printf("  source manifest signed by %s, digest %s\n", src.Signer, src.Digest()[:16])
+	}
+	fmt.Printf("All %d entries match the signed manifest\n", len(m.Entries))
+}
+
+func runKeygen(cmd *flag.FlagSet, args []string) {
+	id := cmd.String("id", "", "Key owner ID: the data owner ID passed to do_encrypt -owner, or the DMA's ID")
+	output := cmd.String("output", ".", "Directory for <id>.key and <id>.pub")
+	cmd.Parse(args)
+
+	if *id == "" {
+		fmt.Fprintln(os.Stderr, "Usage: table_util keygen -id <owner> [-output <dir>]")
+		os.Exit(1)
+	}
+	key, err := manifest.GenerateKey(*id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate key: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*output, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create output directory: %v\n", err)
+		os.Exit(1)
+	}
+	keyPath := filepath.Join(*output, *id+".key")
+	pubPath := filepath.Join(*output, *id+".pub")
+	if err := manifest.SavePrivateKey(keyPath, key); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save signing key: %v\n", err)
+		os.Exit(1)
+	}
+	if err := manifest.SavePublicKey(pubPath, key.Public()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save public key: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signing key saved to: %s (keep private)\n", keyPath)
+	fmt.Printf("Public key saved to: %s (copy into the keyring of every DMA and DA)\n", pubPath)
+}
+
+func runSign(cmd *flag.FlagSet, args []string) {
+	input := cmd.String("input", "", "Table directory")
+	keyPath := cmd.String("key", "", "Path to the owner's signing key")
+	cmd.Parse(args)
+
+	if *input == "" || *keyPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: table_util sign -input <dir> -key <owner.key>")
+		os.Exit(1)
+	}
+	key, err := manifest.LoadPrivateKey(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load signing key: %v\n", err)
+		os.Exit(1)
+	}
+	store, err := storage.OpenTableStore(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open table: %v\n", err)
+		os.Exit(1)
+	}
+	if store.IsContainer() {
+		fmt.Fprintln(os.Stderr, "Containers are read-only; sign the table directory, then pack it")
+		os.Exit(1)
+	}
+	meta, err := store.LoadMetadata()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load metadata: %v\n", err)
+		os.Exit(1)
+	}
+	if key.ID != meta.DataOwnerID {
+		fmt.Fprintf(os.Stderr, "Signing key belongs to %q, but the table is owned by %q\n", key.ID, meta.DataOwnerID)
+		os.Exit(1)
+	}
+	signed, err := manifest.SignTable(store, key, meta.Schema.Name, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to sign table: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Signed manifest of %s as %s (digest %s)\n", meta.Schema.Name, signed.Signer, signed.Digest()[:16])
+}
@@ -40,7 +40,7 @@ class LattigoStats:
         ]
         return self._run_cmd(cmd)
 
-    def run_job(self, job_path, table_dir, keys_dir, output_dir="result.ct"):
+    def run_job(self, job_path, table_dir, keys_dir, output_dir="result.ct", keyring=None, allow_unsigned=False):
         cmd = [
             self.da_run_bin,
             "-job", job_path,
@@ -48,6 +48,11 @@ class LattigoStats:
             "-keys", keys_dir,
             "-output", output_dir
         ]
+        # da_run refuses tables it cannot verify unless told otherwise
+        if keyring:
+            cmd += ["-keyring", keyring]
+        if allow_unsigned:
+            cmd += ["-allow-unsigned"]
         return self._run_cmd(cmd)
 
     def decrypt(self, sk_path, ct_path, output_path, profile="B"):
@@ -0,0 +1,185 @@
+package he
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// ErrDepthExhausted is wrapped by the errors of operations that need a level
+// a ciphertext no longer has, on profiles without bootstrapping
+var ErrDepthExhausted = errors.New("depth exhausted")
+
+// minScaleFactor is the smallest scale a constant 1 is encoded at to match
+// scales, so that its rounding costs no more precision than the CKKS noise
+var minScaleFactor = rlwe.NewScale(math.Exp2(30))
+
+// depthError reports that op needs a level below 0
+func depthError(op string, level int) error {
+	return fmt.Errorf("%s at level %d: %w; use a profile with more levels or with bootstrapping", op, level, ErrDepthExhausted)
+}
+
+// scalesMatch reports whether two scales are equal up to the rounding of
+// their arithmetic
+func scalesMatch(a, b rlwe.Scale) bool {
+	return math.Abs(a.Div(b).Float64()-1) < 1e-12
+}
+
+// refresh bootstraps a ciphertext that a rescale would take below the
+// minimum level, if the profile can bootstrap
+func (e *Evaluator) refresh(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if e.CanBootstrap() && e.NeedsBootstrap(ct) {
+		return e.Bootstrap(ct)
+	}
+	return ct, nil
+}
+
+// align returns op0 and op1 at a common scale so that op can add them;
+// lattigo evaluates the sum at the lower of their levels. If one scale is an
+// integer multiple of the other, such as after a product by a constant that
+// was not rescaled, the operand with the lower scale is multiplied by the
+// integer. Otherwise the operand at the higher level is dropped to a level
+// above the other's and multiplied by a constant 1 whose scale the rescale
+// to the other's level turns into the other's scale. At equal levels this
+// costs one level of both; if that would go below the minimum level they
+// are bootstrapped first. The inputs are not modified.
+func (e *Evaluator) align(op string, op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, *rlwe.Ciphertext, error) {
+	if scalesMatch(op0.Scale, op1.Scale) {
+		return op0, op1, nil
+	}
+	if a, b, ok, err := e.alignByInteger(op0, op1); ok || err != nil {
+		return a, b, err
+	}
+
+	hi, lo, swapped := alignOrder(op0, op1)
+	level, k, factor, err := alignment(e.params, op, hi, lo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if level < e.minLevel && e.CanBootstrap() {
+		a, err := e.Bootstrap(op0)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := e.Bootstrap(op1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if scalesMatch(a.Scale, b.Scale) {
+			return a, b, nil
+		}
+		return nil, nil, fmt.Errorf("%s: bootstrapped operands have scales 2^%.4f and 2^%.4f", op, a.Scale.Log2(), b.Scale.Log2())
+	}
+
+	matched, err := e.matchScale(hi, level, k, factor, lo.Scale)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s aligning scales: %w", op, err)
+	}
+	if lo.Level() > level {
+		lo = e.evaluator.DropLevelNew(lo, lo.Level()-level)
+	}
+	if swapped {
+		return lo, matched, nil
+	}
+	return matched, lo, nil
+}
+
+// alignOrder returns the operand that is matched to the other's scale, the
+// one at the higher level or with the lower scale, first
+func alignOrder(op0, op1 *rlwe.Ciphertext) (hi, lo *rlwe.Ciphertext, swapped bool) {
+	if op1.Level() > op0.Level() || (op1.Level() == op0.Level() && op1.Scale.Cmp(op0.Scale) < 0) {
+		return op1, op0, true
+	}
+	return op0, op1, false
+}
+
+// alignment returns the level at which hi is matched to lo's scale, the
+// number of primes k hi is dropped to above it, and the scale of the
+// constant 1 whose product the rescale by those primes turns into lo's scale
+func alignment(params ckks.Parameters, op string, hi, lo *rlwe.Ciphertext) (level, k int, factor rlwe.Scale, err error) {
+	step := params.LevelsConsumedPerRescaling()
+	for k = step; ; k += step {
+		if level = min(lo.Level(), hi.Level()-k); level < 0 {
+			return 0, 0, rlwe.Scale{}, depthError(op+" aligning scales", min(hi.Level(), lo.Level()))
+		}
+		factor = lo.Scale
+		for i := 1; i <= k; i++ {
+			factor = factor.Mul(rlwe.NewScale(params.Q()[level+i]))
+		}
+		factor = factor.Div(hi.Scale)
+		if factor.Cmp(minScaleFactor) < 0 {
+			// The constant would be encoded too coarsely; consume one more
+			// prime
+			continue
+		}
+		return level, k, factor, nil
+	}
+}
+
+// integerRatio returns the operand with the lower scale, the other and the
+// ratio of their scales if it is an integer of at least 2, nil otherwise
+func integerRatio(op0, op1 *rlwe.Ciphertext) (lower, higher *rlwe.Ciphertext, swapped bool, n *big.Int) {
+	lower, higher = op0, op1
+	if op0.Scale.Cmp(op1.Scale) > 0 {
+		lower, higher, swapped = op1, op0, true
+	}
+	ratio := higher.Scale.Div(lower.Scale)
+	n, _ = new(big.Float).Add(&ratio.Value, big.NewFloat(0.5)).Int(nil)
+	if n.Cmp(big.NewInt(2)) < 0 || !scalesMatch(ratio, rlwe.NewScale(n)) {
+		return lower, higher, swapped, nil
+	}
+	return lower, higher, swapped, n
+}
+
+// alignByInteger multiplies the operand with the lower scale by the ratio of
+// the scales if it is an integer, which costs no level
+func (e *Evaluator) alignByInteger(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, *rlwe.Ciphertext, bool, error) {
+	lower, higher, swapped, n := integerRatio(op0, op1)
+	if n == nil {
+		return nil, nil, false, nil
+	}
+
+	start := time.Now()
+	scaled := lower.CopyNew()
+	if err := e.evaluator.Mul(lower, n, scaled); err != nil {
+		return nil, nil, false, fmt.Errorf("integer scale alignment failed: %w", err)
+	}
+	e.stats.mu.Lock()
+	e.stats.MulCount++
+	e.stats.MulTime += time.Since(start)
+	e.stats.mu.Unlock()
+
+	scaled.Scale = higher.Scale
+	e.trace("align scale", scaled, same, lower)
+	if swapped {
+		return higher, scaled, true, nil
+	}
+	return scaled, higher, true, nil
+}
+
+// matchScale drops ct to level+k, multiplies it by a constant 1 encoded at
+// factor and rescales it down to level, where it has the given scale
+func (e *Evaluator) matchScale(ct *rlwe.Ciphertext, level, k int, factor, scale rlwe.Scale) (*rlwe.Ciphertext, error) {
+	if ct.Level() > level+k {
+		ct = e.evaluator.DropLevelNew(ct, ct.Level()-level-k)
+	}
+	result, err := e.MulPlaintext(ct, e.EncodeConstant(1, level+k, factor))
+	if err != nil {
+		return nil, err
+	}
+	for result.Level() > level {
+		if result, err = e.Rescale(result); err != nil {
+			return nil, err
+		}
+	}
+	// Drop the rounding of the scale arithmetic, so that the operands match
+	// exactly
+	result.Scale = scale
+	return result, nil
+}
@@ -0,0 +1,52 @@
+package he
+
+import (
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Backend is what the ops packages compute with: the CKKS Evaluator, or a
+// Simulator that runs the same op code on plaintext slots
+type Backend interface {
+	// Params returns the CKKS parameters
+	Params() ckks.Parameters
+	// Stats returns the operation statistics
+	Stats() *Stats
+	// Slots returns the number of slots
+	Slots() int
+
+	NeedsBootstrap(ct *rlwe.Ciphertext) bool
+	CanBootstrap() bool
+	Bootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	MaybeBootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+
+	Add(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	AddInPlace(op0, op1 *rlwe.Ciphertext) error
+	Sub(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	Mul(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	MulConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ciphertext, error)
+	AddConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ciphertext, error)
+	Rescale(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	Rotate(ct *rlwe.Ciphertext, k int) (*rlwe.Ciphertext, error)
+	SumSlots(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	Power(ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error)
+	EvaluatePolynomial(ct *rlwe.Ciphertext, coeffs []float64) (*rlwe.Ciphertext, error)
+	EvaluateChebyshev(ct *rlwe.Ciphertext, coeffs []float64, interval Interval) (*rlwe.Ciphertext, error)
+	ZeroCiphertextLike(x *rlwe.Ciphertext) *rlwe.Ciphertext
+
+	// Workers returns the number of goroutines Parallel fans out to
+	Workers() int
+	// Parallel calls f(w, i) for every i in [0, n); w is the backend of the
+	// worker running the call
+	Parallel(n int, f func(w Backend, i int) error) error
+	SumTree(cts []*rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	MapSum(n int, f func(w Backend, i int) (*rlwe.Ciphertext, error)) (*rlwe.Ciphertext, error)
+
+	// Span labels the operations until the returned function is called
+	Span(label string) func()
+}
+
+var (
+	_ Backend = (*Evaluator)(nil)
+	_ Backend = (*Simulator)(nil)
+)
@@ -1,5 +1,6 @@
 // Package he provides a thin wrapper around Lattigo's CKKS evaluator, encoder,
-// and bootstrapper with level tracking and profiling support.
+// and bootstrapper with level tracking and profiling support, and a plaintext
+// simulator of it for tests and dry runs.
 package he
 
 import (
@@ -12,7 +13,9 @@ import (
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
-// Stats tracks HE operation statistics
+// Stats tracks HE operation statistics. It is shared by an evaluator and
+// its copies, so counts and times cover all workers; times add up the time
+// each worker spent, not wall-clock time.
 type Stats struct {
 	mu             sync.Mutex
 	MulCount       int64
@@ -52,6 +55,14 @@ type Evaluator struct {
 	bootstrapper *bootstrapping.Evaluator
 	stats        *Stats
 	minLevel     int // minimum level before bootstrap is needed
+
+	workers int          // goroutines Parallel fans out to
+	mu      sync.Mutex   // guards copies
+	copies  []*Evaluator // per-worker copies, created on first use
+
+	tracer   *Tracer  // optional: secret-key oracle recording every operation
+	spans    []string // labels of the open spans
+	spanBase string   // spans open in the evaluator a worker copy runs for
 }
 
 // NewEvaluator creates a new HE evaluator
@@ -71,6 +82,7 @@ func NewEvaluator(params ckks.Parameters, evk rlwe.EvaluationKeySet, btp *bootst
 		bootstrapper: btp,
 		stats:        &Stats{},
 		minLevel:     minLevel,
+		workers:      1,
 	}, nil
 }
 
@@ -89,7 +101,7 @@ func (e *Evaluator) Stats() *Stats {
 	return e.stats
 }
 
-// Slots returns the number of slots (N/2)
+// Slots returns the number of slots (N/2, or N on the conjugate-invariant ring)
 func (e *Evaluator) Slots() int {
 	return e.params.MaxSlots()
 }
@@ -109,14 +121,19 @@ func (e *Evaluator) CanBootstrap() bool {
 	return e.bootstrapper != nil
 }
 
-// Bootstrap performs bootstrapping on a ciphertext
+// Bootstrap performs bootstrapping on a ciphertext. The input is not
+// modified: lattigo raises the modulus of the ciphertext it bootstraps in
+// place, so it bootstraps a copy
 func (e *Evaluator) Bootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("bootstrap", ct); err != nil {
+		return nil, err
+	}
 	if e.bootstrapper == nil {
 		return nil, fmt.Errorf("bootstrapping not available")
 	}
 
 	start := time.Now()
-	result, err := e.bootstrapper.Bootstrap(ct)
+	result, err := e.bootstrapper.Bootstrap(ct.CopyNew())
 	if err != nil {
 		return nil, fmt.Errorf("bootstrap failed: %w", err)
 	}
@@ -126,6 +143,8 @@ func (e *Evaluator) Bootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	e.stats.BootstrapTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("bootstrap", result, same, ct)
+
 	return result, nil
 }
 
@@ -137,8 +156,16 @@ func (e *Evaluator) MaybeBootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error
 	return ct, nil
 }
 
-// Add adds two ciphertexts
+// Add adds two ciphertexts, aligning their scales; the sum is at the lower
+// of their levels
 func (e *Evaluator) Add(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("add", op0, op1); err != nil {
+		return nil, err
+	}
+	op0, op1, err := e.align("add", op0, op1)
+	if err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result, err := e.evaluator.AddNew(op0, op1)
 	if err != nil {
@@ -150,13 +177,25 @@ func (e *Evaluator) Add(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	e.stats.AddTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("add", result, sum, op0, op1)
 	return result, nil
 }
 
-// AddInPlace adds op1 to op0 in place
+// AddInPlace adds op1 to op0 in place, aligning their scales
 func (e *Evaluator) AddInPlace(op0, op1 *rlwe.Ciphertext) error {
+	if err := checkEncrypted("add", op0, op1); err != nil {
+		return err
+	}
+	a, op1, err := e.align("add", op0, op1)
+	if err != nil {
+		return err
+	}
+	if a != op0 {
+		*op0 = *a
+	}
+	in := e.traceInputs(op0, op1)
 	start := time.Now()
-	err := e.evaluator.Add(op0, op1, op0)
+	err = e.evaluator.Add(op0, op1, op0)
 	if err != nil {
 		return fmt.Errorf("add in place failed: %w", err)
 	}
@@ -166,11 +205,20 @@ func (e *Evaluator) AddInPlace(op0, op1 *rlwe.Ciphertext) error {
 	e.stats.AddTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("add", op0, func([][]float64) []float64 { return sum(in) })
 	return nil
 }
 
-// Sub subtracts op1 from op0
+// Sub subtracts op1 from op0, aligning their scales; the difference is at
+// the lower of their levels
 func (e *Evaluator) Sub(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("sub", op0, op1); err != nil {
+		return nil, err
+	}
+	op0, op1, err := e.align("sub", op0, op1)
+	if err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result, err := e.evaluator.SubNew(op0, op1)
 	if err != nil {
@@ -182,11 +230,24 @@ func (e *Evaluator) Sub(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	e.stats.AddTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("sub", result, difference, op0, op1)
 	return result, nil
 }
 
-// Mul multiplies two ciphertexts and relinearizes
+// Mul multiplies two ciphertexts and relinearizes; the product is at the
+// lower of their levels. Operands that the rescale of the product would take
+// below the minimum level are bootstrapped first if the profile can.
 func (e *Evaluator) Mul(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("mul", op0, op1); err != nil {
+		return nil, err
+	}
+	op0, err := e.refresh(op0)
+	if err != nil {
+		return nil, err
+	}
+	if op1, err = e.refresh(op1); err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result, err := e.evaluator.MulRelinNew(op0, op1)
 	if err != nil {
@@ -198,11 +259,19 @@ func (e *Evaluator) Mul(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	e.stats.MulTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("mul", result, product, op0, op1)
 	return result, nil
 }
 
 // MulPlaintext multiplies a ciphertext by a plaintext
 func (e *Evaluator) MulPlaintext(ct *rlwe.Ciphertext, pt *rlwe.Plaintext) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("mul plaintext", ct); err != nil {
+		return nil, err
+	}
+	ct, err := e.refresh(ct)
+	if err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result, err := e.evaluator.MulNew(ct, pt)
 	if err != nil {
@@ -214,11 +283,17 @@ func (e *Evaluator) MulPlaintext(ct *rlwe.Ciphertext, pt *rlwe.Plaintext) (*rlwe
 	e.stats.MulTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("mul plaintext", result, func(in [][]float64) []float64 {
+		return product([][]float64{in[0], e.tracer.decode(pt)})
+	}, ct)
 	return result, nil
 }
 
 // MulConst multiplies a ciphertext by a constant
 func (e *Evaluator) MulConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("mul const", ct); err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result := ct.CopyNew()
 	err := e.evaluator.Mul(ct, constant, result)
@@ -231,11 +306,15 @@ func (e *Evaluator) MulConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ci
 	e.stats.MulTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("mul const", result, mapSlots(func(x float64) float64 { return x * real(constant) }), ct)
 	return result, nil
 }
 
 // AddConst adds a constant to a ciphertext
 func (e *Evaluator) AddConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("add const", ct); err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result := ct.CopyNew()
 	err := e.evaluator.Add(ct, constant, result)
@@ -248,11 +327,18 @@ func (e *Evaluator) AddConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ci
 	e.stats.AddTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("add const", result, mapSlots(func(x float64) float64 { return x + real(constant) }), ct)
 	return result, nil
 }
 
-// Rescale rescales a ciphertext
+// Rescale rescales a ciphertext, consuming a level
 func (e *Evaluator) Rescale(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("rescale", ct); err != nil {
+		return nil, err
+	}
+	if ct.Level() < e.params.LevelsConsumedPerRescaling() {
+		return nil, depthError("rescale", ct.Level())
+	}
 	start := time.Now()
 	result := ct.CopyNew()
 	err := e.evaluator.Rescale(ct, result)
@@ -265,11 +351,15 @@ func (e *Evaluator) Rescale(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	e.stats.RescaleTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace("rescale", result, same, ct)
 	return result, nil
 }
 
 // Rotate rotates a ciphertext by k positions
 func (e *Evaluator) Rotate(ct *rlwe.Ciphertext, k int) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("rotate", ct); err != nil {
+		return nil, err
+	}
 	start := time.Now()
 	result, err := e.evaluator.RotateNew(ct, k)
 	if err != nil {
@@ -281,20 +371,26 @@ func (e *Evaluator) Rotate(ct *rlwe.Ciphertext, k int) (*rlwe.Ciphertext, error)
 	e.stats.RotateTime += time.Since(start)
 	e.stats.mu.Unlock()
 
+	e.trace(fmt.Sprintf("rotate %d", k), result, rotated(k), ct)
 	return result, nil
 }
 
 // SumSlots sums all slots into slot 0 using rotations
 func (e *Evaluator) SumSlots(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	return sumSlots(e, ct)
+}
+
+// sumSlots implements SumSlots on a backend
+func sumSlots(b Backend, ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	result := ct.CopyNew()
-	slots := e.Slots()
+	slots := b.Slots()
 
 	for rot := 1; rot < slots; rot *= 2 {
-		rotated, err := e.Rotate(result, rot)
+		rotated, err := b.Rotate(result, rot)
 		if err != nil {
 			return nil, fmt.Errorf("sum slots rotation failed: %w", err)
 		}
-		err = e.AddInPlace(result, rotated)
+		err = b.AddInPlace(result, rotated)
 		if err != nil {
 			return nil, fmt.Errorf("sum slots add failed: %w", err)
 		}
@@ -313,11 +409,10 @@ func (e *Evaluator) EncodePlaintext(values []complex128, level int, scale rlwe.S
 
 // EncodeFloats encodes a slice of float64 values into a plaintext
 func (e *Evaluator) EncodeFloats(values []float64, level int, scale rlwe.Scale) *rlwe.Plaintext {
-	cvals := make([]complex128, len(values))
-	for i, v := range values {
-		cvals[i] = complex(v, 0)
-	}
-	return e.EncodePlaintext(cvals, level, scale)
+	pt := ckks.NewPlaintext(e.params, level)
+	pt.Scale = scale
+	e.encoder.Encode(values, pt)
+	return pt
 }
 
 // EncodeConstant encodes a constant value into all slots
@@ -339,16 +434,18 @@ func (e *Evaluator) DecodePlaintext(pt *rlwe.Plaintext) []complex128 {
 
 // DecodeFloats decodes a plaintext to float64 values (real parts only)
 func (e *Evaluator) DecodeFloats(pt *rlwe.Plaintext) []float64 {
-	complex := e.DecodePlaintext(pt)
-	values := make([]float64, len(complex))
-	for i, v := range complex {
-		values[i] = real(v)
-	}
+	values := make([]float64, e.Slots())
+	e.encoder.Decode(pt, values)
 	return values
 }
 
 // Power computes ct^n using binary exponentiation
 func (e *Evaluator) Power(ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error) {
+	return binaryPower(e, ct, n)
+}
+
+// binaryPower implements Power on a backend
+func binaryPower(b Backend, ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error) {
 	if n < 1 {
 		return nil, fmt.Errorf("power must be positive")
 	}
@@ -368,16 +465,16 @@ func (e *Evaluator) Power(ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error)
 				first = false
 			} else {
 				var err error
-				result, err = e.Mul(result, base)
+				result, err = b.Mul(result, base)
 				if err != nil {
 					return nil, err
 				}
-				result, err = e.Rescale(result)
+				result, err = b.Rescale(result)
 				if err != nil {
 					return nil, err
 				}
 				// Bootstrap if needed
-				result, err = e.MaybeBootstrap(result)
+				result, err = b.MaybeBootstrap(result)
 				if err != nil {
 					return nil, err
 				}
@@ -386,16 +483,16 @@ func (e *Evaluator) Power(ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error)
 		power >>= 1
 		if power > 0 {
 			var err error
-			base, err = e.Mul(base, base)
+			base, err = b.Mul(base, base)
 			if err != nil {
 				return nil, err
 			}
-			base, err = e.Rescale(base)
+			base, err = b.Rescale(base)
 			if err != nil {
 				return nil, err
 			}
 			// Bootstrap if needed
-			base, err = e.MaybeBootstrap(base)
+			base, err = b.MaybeBootstrap(base)
 			if err != nil {
 				return nil, err
 			}
@@ -405,85 +502,13 @@ func (e *Evaluator) Power(ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error)
 	return result, nil
 }
 
-// EvaluatePolynomial evaluates a polynomial on a ciphertext using Horner's method
-// p(x) = coeffs[0] + coeffs[1]*x + coeffs[2]*x^2 + ... + coeffs[n-1]*x^(n-1)
-func (e *Evaluator) EvaluatePolynomial(ct *rlwe.Ciphertext, coeffs []float64) (*rlwe.Ciphertext, error) {
-	if len(coeffs) == 0 {
-		return nil, fmt.Errorf("coefficients cannot be empty")
-	}
-
-	n := len(coeffs)
-
-	// Degree 0: return constant ciphertext (ct * 0 + c_0)
-	if n == 1 {
-		// Create a "zero ciphertext" by multiplying ct by 0 and adding constant
-		result := ct.CopyNew()
-		if err := e.evaluator.Mul(result, complex(0, 0), result); err != nil {
-			return nil, fmt.Errorf("zero mul failed: %w", err)
-		}
-		if err := e.evaluator.Add(result, complex(coeffs[0], 0), result); err != nil {
-			return nil, fmt.Errorf("constant add failed: %w", err)
-		}
-		return result, nil
-	}
-
-	// Horner's method: p(x) = c_0 + x*(c_1 + x*(c_2 + ... + x*c_{n-1})...)
-	// Start with result = c_{n-1}
-	result := ct.CopyNew()
-	if err := e.evaluator.Mul(result, complex(0, 0), result); err != nil {
-		return nil, fmt.Errorf("init zero failed: %w", err)
-	}
-	if err := e.evaluator.Add(result, complex(coeffs[n-1], 0), result); err != nil {
-		return nil, fmt.Errorf("init constant failed: %w", err)
-	}
-
-	// Iterate: result = result * x + c_i
-	for i := n - 2; i >= 0; i-- {
-		start := time.Now()
-
-		// result = result * ct
-		tmp, err := e.evaluator.MulRelinNew(result, ct)
-		if err != nil {
-			return nil, fmt.Errorf("polynomial mul at degree %d failed: %w", i, err)
-		}
-
-		e.stats.mu.Lock()
-		e.stats.MulCount++
-		e.stats.MulTime += time.Since(start)
-		e.stats.mu.Unlock()
-
-		// Rescale
-		start = time.Now()
-		if err := e.evaluator.Rescale(tmp, tmp); err != nil {
-			return nil, fmt.Errorf("polynomial rescale at degree %d failed: %w", i, err)
-		}
-
-		e.stats.mu.Lock()
-		e.stats.RescaleCount++
-		e.stats.RescaleTime += time.Since(start)
-		e.stats.mu.Unlock()
-
-		// result = tmp + c_i
-		start = time.Now()
-		if err := e.evaluator.Add(tmp, complex(coeffs[i], 0), tmp); err != nil {
-			return nil, fmt.Errorf("polynomial add at degree %d failed: %w", i, err)
-		}
-
-		e.stats.mu.Lock()
-		e.stats.AddCount++
-		e.stats.AddTime += time.Since(start)
-		e.stats.mu.Unlock()
-
-		result = tmp
-	}
-
-	return result, nil
-}
-
 // SetEncryptor sets the encryptor for creating constant ciphertexts
 // This should be called with a public-key encryptor if you need EncryptConstantCt
 func (e *Evaluator) SetEncryptor(enc *rlwe.Encryptor) {
 	e.encryptor = enc
+	e.mu.Lock()
+	e.copies = nil // Worker copies are made again with the new encryptor
+	e.mu.Unlock()
 }
 
 // EncryptConstantCt creates a ciphertext with a constant value in all slots
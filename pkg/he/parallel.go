@@ -0,0 +1,175 @@
+package he
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/dft"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/mod1"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/polynomial"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// ShallowCopy returns an evaluator that shares e's parameters, keys and
+// stats but has its own buffers, so that it can run in another goroutine.
+// The copy has a single worker.
+func (e *Evaluator) ShallowCopy() *Evaluator {
+	c := &Evaluator{
+		params:    e.params,
+		encoder:   e.encoder.ShallowCopy(),
+		evaluator: e.evaluator.ShallowCopy(),
+		stats:     e.stats,
+		minLevel:  e.minLevel,
+		workers:   1,
+		tracer:    e.tracer,
+	}
+	if e.encryptor != nil {
+		c.encryptor = e.encryptor.ShallowCopy()
+	}
+	if e.bootstrapper != nil {
+		c.bootstrapper = copyBootstrapper(e.bootstrapper)
+	}
+	return c
+}
+
+// copyBootstrapper shallow-copies a bootstrapper. Lattigo's ShallowCopy builds
+// the DFT and Mod1 evaluators of the copy over the residual parameters instead
+// of the bootstrapping parameters, which panics on the first CoeffsToSlots, so
+// they are rebuilt as bootstrapping.NewEvaluator builds them.
+func copyBootstrapper(btp *bootstrapping.Evaluator) *bootstrapping.Evaluator {
+	c := btp.ShallowCopy()
+	params := btp.BootstrappingParameters
+	c.DFTEvaluator = dft.NewEvaluator(params, c.Evaluator)
+	c.Mod1Evaluator = mod1.NewEvaluator(c.Evaluator, polynomial.NewEvaluator(params, c.Evaluator), c.Mod1Parameters)
+	return c
+}
+
+// SetWorkers sets the number of goroutines Parallel fans out to
+func (e *Evaluator) SetWorkers(n int) {
+	e.workers = max(n, 1)
+}
+
+// Workers returns the number of goroutines Parallel fans out to
+func (e *Evaluator) Workers() int {
+	return e.workers
+}
+
+// worker returns the evaluator of worker w, a single-worker copy of e. Worker
+// 0 is a copy too: e itself would fan out again to the copies the other
+// workers are using.
+func (e *Evaluator) worker(w int) *Evaluator {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for len(e.copies) <= w {
+		e.copies = append(e.copies, e.ShallowCopy())
+	}
+	return e.copies[w]
+}
+
+// Parallel calls f(w, i) for every i in [0, n), spreading the calls over up
+// to Workers() goroutines; w is the evaluator of the goroutine running the
+// call, which has a single worker, so work that fans out again from inside f
+// runs serially on w. Parallel returns the error of the lowest i that failed.
+func (e *Evaluator) Parallel(n int, f func(w Backend, i int) error) error {
+	workers := min(e.workers, n)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := f(e, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	indices := make(chan int)
+	outer := e.spanPath()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		eval := e.worker(w)
+		eval.spanBase = outer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = f(eval, i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SumTree adds ciphertexts pairwise in a tree whose levels run in parallel.
+// The inputs are not modified; a single input is returned as is.
+func (e *Evaluator) SumTree(cts []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	return sumTree(e, cts)
+}
+
+// MapSum returns the sum of f(w, i) for every i in [0, n). The calls run in
+// batches of one per worker whose results are added in a tree to a running
+// sum, so that at most a batch of results is held at a time.
+func (e *Evaluator) MapSum(n int, f func(w Backend, i int) (*rlwe.Ciphertext, error)) (*rlwe.Ciphertext, error) {
+	return mapSum(e, n, f)
+}
+
+// sumTree implements SumTree on a backend
+func sumTree(b Backend, cts []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if len(cts) == 0 {
+		return nil, fmt.Errorf("no ciphertexts to sum")
+	}
+	for len(cts) > 1 {
+		sums := make([]*rlwe.Ciphertext, (len(cts)+1)/2)
+		err := b.Parallel(len(sums), func(w Backend, i int) error {
+			if 2*i+1 == len(cts) {
+				sums[i] = cts[2*i]
+				return nil
+			}
+			var err error
+			sums[i], err = w.Add(cts[2*i], cts[2*i+1])
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		cts = sums
+	}
+	return cts[0], nil
+}
+
+// mapSum implements MapSum on a backend
+func mapSum(b Backend, n int, f func(w Backend, i int) (*rlwe.Ciphertext, error)) (*rlwe.Ciphertext, error) {
+	var sum *rlwe.Ciphertext
+	for first := 0; first < n; first += b.Workers() {
+		batch := make([]*rlwe.Ciphertext, min(b.Workers(), n-first))
+		err := b.Parallel(len(batch), func(w Backend, i int) error {
+			var err error
+			batch[i], err = f(w, first+i)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sum != nil {
+			batch = append(batch, sum)
+		}
+		if sum, err = b.SumTree(batch); err != nil {
+			return nil, err
+		}
+	}
+	if sum == nil {
+		return nil, fmt.Errorf("no ciphertexts to sum")
+	}
+	return sum, nil
+}
@@ -0,0 +1,258 @@
+package he
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/polynomial"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+	"github.com/tuneinsight/lattigo/v6/utils/bignum"
+)
+
+// Interval is the domain [A, B] of a polynomial in the Chebyshev basis; its
+// input is mapped to [-1, 1] by (2x - A - B) / (B - A)
+type Interval struct {
+	A, B float64
+}
+
+// DefaultInterval is the domain of the Chebyshev polynomials, [-1, 1]
+var DefaultInterval = Interval{A: -1, B: 1}
+
+// changeOfBasis returns the scalar and constant that map the interval to
+// [-1, 1]
+func (i Interval) changeOfBasis() (scalar, constant float64) {
+	return 2 / (i.B - i.A), -(i.A + i.B) / (i.B - i.A)
+}
+
+// PolynomialDepth returns the levels the evaluation of a polynomial of the
+// given degree consumes, ceil(log2(degree+1))
+func PolynomialDepth(degree int) int {
+	return bits.Len(uint(max(degree, 0)))
+}
+
+// ChebyshevDepth returns the levels EvaluateChebyshev consumes for a
+// polynomial of the given degree: PolynomialDepth, and one more for mapping
+// the interval to [-1, 1] unless it only scales by an integer
+func ChebyshevDepth(degree int, interval Interval) int {
+	depth := PolynomialDepth(degree)
+	if scalar, _ := interval.changeOfBasis(); degree > 0 && scalar != math.Trunc(scalar) {
+		depth++
+	}
+	return depth
+}
+
+// EvaluatePolynomial evaluates p(x) = coeffs[0] + coeffs[1]*x + ... +
+// coeffs[n-1]*x^(n-1) on a ciphertext with the baby-step giant-step
+// algorithm, in PolynomialDepth levels. The result has the input's scale.
+func (e *Evaluator) EvaluatePolynomial(ct *rlwe.Ciphertext, coeffs []float64) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("polynomial", ct); err != nil {
+		return nil, err
+	}
+	degree, err := polynomialDegree(coeffs)
+	if err != nil {
+		return nil, err
+	}
+	if degree == 0 {
+		return constantLike(e, ct, coeffs[0])
+	}
+	input := ct
+	if ct, err = reserve(e, ct, degree, PolynomialDepth(degree)); err != nil {
+		return nil, err
+	}
+	poly := bignum.NewPolynomial(bignum.Monomial, coeffs[:degree+1], nil)
+	result, err := e.evaluatePolynomial(ct, poly)
+	if err != nil {
+		return nil, err
+	}
+	e.trace(fmt.Sprintf("polynomial of degree %d", degree), result, e.tracedPolynomial(poly), input)
+	return result, nil
+}
+
+// EvaluateChebyshev evaluates p(x) = Σ coeffs[k] T_k(y), with y the input
+// mapped from the interval to [-1, 1], on a ciphertext with the baby-step
+// giant-step algorithm, in ChebyshevDepth levels. Unlike the monomial basis,
+// the Chebyshev basis stays numerically stable at high degrees. The result
+// has the input's scale.
+func (e *Evaluator) EvaluateChebyshev(ct *rlwe.Ciphertext, coeffs []float64, interval Interval) (*rlwe.Ciphertext, error) {
+	if err := checkEncrypted("Chebyshev polynomial", ct); err != nil {
+		return nil, err
+	}
+	if !(interval.A < interval.B) {
+		return nil, fmt.Errorf("invalid Chebyshev interval [%g, %g]", interval.A, interval.B)
+	}
+	degree, err := polynomialDegree(coeffs)
+	if err != nil {
+		return nil, err
+	}
+	if degree == 0 {
+		return constantLike(e, ct, coeffs[0])
+	}
+	input := ct
+	if ct, err = reserve(e, ct, degree, ChebyshevDepth(degree, interval)); err != nil {
+		return nil, err
+	}
+	if ct, err = mapInterval(e, ct, interval); err != nil {
+		return nil, err
+	}
+	poly := bignum.NewPolynomial(bignum.Chebyshev, coeffs[:degree+1], [2]float64{interval.A, interval.B})
+	result, err := e.evaluatePolynomial(ct, poly)
+	if err != nil {
+		return nil, err
+	}
+	e.trace(fmt.Sprintf("Chebyshev polynomial of degree %d", degree), result, e.tracedPolynomial(poly), input)
+	return result, nil
+}
+
+// tracedPolynomial returns the expected slots of a polynomial's evaluation,
+// computed for the slots the tracer records only
+func (e *Evaluator) tracedPolynomial(poly bignum.Polynomial) func(in [][]float64) []float64 {
+	return func(in [][]float64) []float64 {
+		out := make([]float64, e.tracer.slots)
+		for i := range out {
+			out[i], _ = poly.Evaluate(in[0][i])[0].Float64()
+		}
+		return out
+	}
+}
+
+// polynomialDegree returns the degree of a polynomial, ignoring zero leading
+// coefficients
+func polynomialDegree(coeffs []float64) (int, error) {
+	if len(coeffs) == 0 {
+		return 0, fmt.Errorf("coefficients cannot be empty")
+	}
+	degree := len(coeffs) - 1
+	for degree > 0 && coeffs[degree] == 0 {
+		degree--
+	}
+	return degree, nil
+}
+
+// constantLike returns a ciphertext like ct with every slot set to value
+func constantLike(b Backend, ct *rlwe.Ciphertext, value float64) (*rlwe.Ciphertext, error) {
+	zero, err := b.MulConst(ct, 0)
+	if err != nil {
+		return nil, err
+	}
+	return b.AddConst(zero, complex(value, 0))
+}
+
+// reserve returns ct with depth levels left, bootstrapping it if it lacks
+// them and the profile can bootstrap
+func reserve(b Backend, ct *rlwe.Ciphertext, degree, depth int) (*rlwe.Ciphertext, error) {
+	need := depth * b.Params().LevelsConsumedPerRescaling()
+	if ct.Level() < need && b.CanBootstrap() {
+		var err error
+		if ct, err = b.Bootstrap(ct); err != nil {
+			return nil, err
+		}
+	}
+	if ct.Level() < need {
+		return nil, depthError(fmt.Sprintf("polynomial of degree %d needs %d levels", degree, need), ct.Level())
+	}
+	return ct, nil
+}
+
+// mapInterval maps ct from the interval to [-1, 1], consuming a level unless
+// the map only scales by an integer
+func mapInterval(b Backend, ct *rlwe.Ciphertext, interval Interval) (*rlwe.Ciphertext, error) {
+	scalar, constant := interval.changeOfBasis()
+	var err error
+	if scalar != 1 {
+		if ct, err = b.MulConst(ct, complex(scalar, 0)); err != nil {
+			return nil, err
+		}
+		if scalar != math.Trunc(scalar) {
+			if ct, err = b.Rescale(ct); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if constant != 0 {
+		if ct, err = b.AddConst(ct, complex(constant, 0)); err != nil {
+			return nil, err
+		}
+	}
+	return ct, nil
+}
+
+// evaluatePolynomial evaluates a polynomial with lattigo's polynomial
+// evaluator, which splits it into baby steps over the powers up to
+// sqrt(degree) and giant steps over the powers of two, and sets the scales
+// of the intermediate products so that the result has the input's scale
+func (e *Evaluator) evaluatePolynomial(ct *rlwe.Ciphertext, poly bignum.Polynomial) (*rlwe.Ciphertext, error) {
+	eval := polynomial.NewEvaluator(e.params, e.evaluator)
+	eval.Evaluator.Evaluator = countedEvaluator{Evaluator: e.evaluator, stats: e.stats}
+	result, err := eval.Evaluate(ct, poly, ct.Scale)
+	if err != nil {
+		return nil, fmt.Errorf("polynomial evaluation failed: %w", err)
+	}
+	return result, nil
+}
+
+// countedEvaluator records the operations of lattigo's polynomial evaluator
+// in the stats
+type countedEvaluator struct {
+	*ckks.Evaluator
+	stats *Stats
+}
+
+func (c countedEvaluator) count(n *int64, total *time.Duration, start time.Time) {
+	c.stats.mu.Lock()
+	*n++
+	*total += time.Since(start)
+	c.stats.mu.Unlock()
+}
+
+func (c countedEvaluator) Add(op0 *rlwe.Ciphertext, op1 rlwe.Operand, opOut *rlwe.Ciphertext) error {
+	defer c.count(&c.stats.AddCount, &c.stats.AddTime, time.Now())
+	return c.Evaluator.Add(op0, op1, opOut)
+}
+
+func (c countedEvaluator) AddNew(op0 *rlwe.Ciphertext, op1 rlwe.Operand) (*rlwe.Ciphertext, error) {
+	defer c.count(&c.stats.AddCount, &c.stats.AddTime, time.Now())
+	return c.Evaluator.AddNew(op0, op1)
+}
+
+func (c countedEvaluator) Sub(op0 *rlwe.Ciphertext, op1 rlwe.Operand, opOut *rlwe.Ciphertext) error {
+	defer c.count(&c.stats.AddCount, &c.stats.AddTime, time.Now())
+	return c.Evaluator.Sub(op0, op1, opOut)
+}
+
+func (c countedEvaluator) SubNew(op0 *rlwe.Ciphertext, op1 rlwe.Operand) (*rlwe.Ciphertext, error) {
+	defer c.count(&c.stats.AddCount, &c.stats.AddTime, time.Now())
+	return c.Evaluator.SubNew(op0, op1)
+}
+
+func (c countedEvaluator) Mul(op0 *rlwe.Ciphertext, op1 rlwe.Operand, opOut *rlwe.Ciphertext) error {
+	defer c.count(&c.stats.MulCount, &c.stats.MulTime, time.Now())
+	return c.Evaluator.Mul(op0, op1, opOut)
+}
+
+func (c countedEvaluator) MulNew(op0 *rlwe.Ciphertext, op1 rlwe.Operand) (*rlwe.Ciphertext, error) {
+	defer c.count(&c.stats.MulCount, &c.stats.MulTime, time.Now())
+	return c.Evaluator.MulNew(op0, op1)
+}
+
+func (c countedEvaluator) MulRelin(op0 *rlwe.Ciphertext, op1 rlwe.Operand, opOut *rlwe.Ciphertext) error {
+	defer c.count(&c.stats.MulCount, &c.stats.MulTime, time.Now())
+	return c.Evaluator.MulRelin(op0, op1, opOut)
+}
+
+func (c countedEvaluator) MulRelinNew(op0 *rlwe.Ciphertext, op1 rlwe.Operand) (*rlwe.Ciphertext, error) {
+	defer c.count(&c.stats.MulCount, &c.stats.MulTime, time.Now())
+	return c.Evaluator.MulRelinNew(op0, op1)
+}
+
+func (c countedEvaluator) MulThenAdd(op0 *rlwe.Ciphertext, op1 rlwe.Operand, opOut *rlwe.Ciphertext) error {
+	defer c.count(&c.stats.MulCount, &c.stats.MulTime, time.Now())
+	return c.Evaluator.MulThenAdd(op0, op1, opOut)
+}
+
+func (c countedEvaluator) Rescale(op0, op1 *rlwe.Ciphertext) error {
+	defer c.count(&c.stats.RescaleCount, &c.stats.RescaleTime, time.Now())
+	return c.Evaluator.Rescale(op0, op1)
+}
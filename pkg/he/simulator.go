@@ -0,0 +1,501 @@
+package he
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/ring"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// SimulatorConfig configures a Simulator
+type SimulatorConfig struct {
+	// Bootstrapping simulates a profile with bootstrapping: exhausted
+	// ciphertexts are refreshed to the maximum level
+	Bootstrapping bool
+	// Noise is the standard deviation of the Gaussian error that encryption,
+	// products, rotations, rescales and polynomials add to every slot; 0
+	// simulates exact arithmetic
+	Noise float64
+	// BootstrapNoise is the standard deviation of the error a bootstrap adds
+	BootstrapNoise float64
+	// Seed seeds the noise, so that a simulation can be repeated
+	Seed int64
+}
+
+// Simulator is a Backend that computes on plaintext slots, so that the ops
+// code runs in milliseconds in unit tests and in dry runs on sample data. It
+// consumes levels and sets scales as the Evaluator does, aligning operands
+// and bootstrapping exhausted ones the same way, and counts operations in
+// its Stats. It does not count the operations inside polynomials, records
+// no times, ignores the imaginary parts of constants and evaluates serially.
+//
+// Its ciphertexts are shells that the ops code, streams and CopyNew handle
+// like any other: a single polynomial with a row per level whose first row
+// holds the bits of the slots. They cannot be serialized, and an Evaluator
+// rejects them with ErrSimulatedCiphertext.
+type Simulator struct {
+	params   ckks.Parameters
+	config   SimulatorConfig
+	stats    *Stats
+	minLevel int // minimum level before bootstrap is needed
+
+	mu  sync.Mutex // guards rng
+	rng *rand.Rand
+}
+
+// ErrSimulatedCiphertext is wrapped by the errors of Evaluator operations
+// given a Simulator's ciphertext, whose slots are not encrypted
+var ErrSimulatedCiphertext = errors.New("simulated ciphertext")
+
+// checkEncrypted returns an error if any of cts is a Simulator's ciphertext:
+// a single polynomial, where encryptions have at least two
+func checkEncrypted(op string, cts ...*rlwe.Ciphertext) error {
+	for _, ct := range cts {
+		if ct != nil && len(ct.Value) < 2 {
+			return fmt.Errorf("%s: %w; only a Simulator can evaluate it", op, ErrSimulatedCiphertext)
+		}
+	}
+	return nil
+}
+
+// NewSimulator creates a simulator of an evaluator on params
+func NewSimulator(params ckks.Parameters, config SimulatorConfig) *Simulator {
+	minLevel := 2 // as the Evaluator without a bootstrapper
+	if config.Bootstrapping {
+		minLevel = params.LevelsConsumedPerRescaling()
+	}
+	return &Simulator{
+		params:   params,
+		config:   config,
+		stats:    &Stats{},
+		minLevel: minLevel,
+		rng:      rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Params returns the CKKS parameters
+func (s *Simulator) Params() ckks.Parameters {
+	return s.params
+}
+
+// Stats returns the operation statistics
+func (s *Simulator) Stats() *Stats {
+	return s.stats
+}
+
+// Slots returns the number of slots
+func (s *Simulator) Slots() int {
+	return s.params.MaxSlots()
+}
+
+// Encrypt returns a simulated fresh encryption of values, padded with zeros
+func (s *Simulator) Encrypt(values []float64) (*rlwe.Ciphertext, error) {
+	if len(values) > s.Slots() {
+		return nil, fmt.Errorf("%d values do not fit in %d slots", len(values), s.Slots())
+	}
+	slots := make([]float64, s.Slots())
+	copy(slots, values)
+	return s.ciphertext(s.perturb(slots, s.config.Noise), s.params.MaxLevel(), s.params.DefaultScale()), nil
+}
+
+// EncryptBlocks splits a column into blocks of Slots rows and encrypts them,
+// as do_encrypt lays out a table
+func (s *Simulator) EncryptBlocks(column []float64) ([]*rlwe.Ciphertext, error) {
+	var blocks []*rlwe.Ciphertext
+	for first := 0; first < len(column); first += s.Slots() {
+		block, err := s.Encrypt(column[first:min(first+s.Slots(), len(column))])
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Decrypt returns the slots of a simulated ciphertext
+func (s *Simulator) Decrypt(ct *rlwe.Ciphertext) ([]float64, error) {
+	return s.values(ct)
+}
+
+// ciphertext returns a simulated ciphertext holding values at a level and
+// scale
+func (s *Simulator) ciphertext(values []float64, level int, scale rlwe.Scale) *rlwe.Ciphertext {
+	rows := make([][]uint64, level+1)
+	rows[0] = make([]uint64, len(values))
+	for i, v := range values {
+		rows[0][i] = math.Float64bits(v)
+	}
+	return &rlwe.Ciphertext{Element: rlwe.Element[ring.Poly]{
+		MetaData: &rlwe.MetaData{
+			PlaintextMetaData: rlwe.PlaintextMetaData{
+				Scale:         scale,
+				LogDimensions: s.params.LogMaxDimensions(),
+				IsBatched:     true,
+			},
+		},
+		Value: []ring.Poly{{Coeffs: rows}},
+	}}
+}
+
+// values returns a copy of the slots of a simulated ciphertext
+func (s *Simulator) values(ct *rlwe.Ciphertext) ([]float64, error) {
+	if ct == nil || len(ct.Value) != 1 || len(ct.Value[0].Coeffs) == 0 || len(ct.Value[0].Coeffs[0]) != s.Slots() {
+		return nil, fmt.Errorf("not a simulated ciphertext")
+	}
+	values := make([]float64, s.Slots())
+	for i, bits := range ct.Value[0].Coeffs[0] {
+		values[i] = math.Float64frombits(bits)
+	}
+	return values, nil
+}
+
+// perturb adds Gaussian noise of standard deviation sigma to values in place
+func (s *Simulator) perturb(values []float64, sigma float64) []float64 {
+	if sigma == 0 {
+		return values
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range values {
+		values[i] += s.rng.NormFloat64() * sigma
+	}
+	return values
+}
+
+func (s *Simulator) count(n *int64) {
+	s.stats.mu.Lock()
+	*n++
+	s.stats.mu.Unlock()
+}
+
+// NeedsBootstrap returns true if the ciphertext needs bootstrapping
+func (s *Simulator) NeedsBootstrap(ct *rlwe.Ciphertext) bool {
+	return ct.Level() <= s.minLevel
+}
+
+// CanBootstrap returns true if bootstrapping is simulated
+func (s *Simulator) CanBootstrap() bool {
+	return s.config.Bootstrapping
+}
+
+// Bootstrap refreshes a ciphertext to the maximum level and default scale
+func (s *Simulator) Bootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if !s.config.Bootstrapping {
+		return nil, fmt.Errorf("bootstrapping not available")
+	}
+	values, err := s.values(ct)
+	if err != nil {
+		return nil, err
+	}
+	s.count(&s.stats.BootstrapCount)
+	return s.ciphertext(s.perturb(values, s.config.BootstrapNoise), s.params.MaxLevel(), s.params.DefaultScale()), nil
+}
+
+// MaybeBootstrap bootstraps if needed, otherwise returns the original
+func (s *Simulator) MaybeBootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if s.NeedsBootstrap(ct) && s.CanBootstrap() {
+		return s.Bootstrap(ct)
+	}
+	return ct, nil
+}
+
+// align returns op0 and op1 at a common scale, consuming the levels and
+// counting the operations of the Evaluator's alignment
+func (s *Simulator) align(op string, op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, *rlwe.Ciphertext, error) {
+	if scalesMatch(op0.Scale, op1.Scale) {
+		return op0, op1, nil
+	}
+	if lower, higher, swapped, n := integerRatio(op0, op1); n != nil {
+		values, err := s.values(lower)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.count(&s.stats.MulCount)
+		scaled := s.ciphertext(values, lower.Level(), higher.Scale)
+		if swapped {
+			return higher, scaled, nil
+		}
+		return scaled, higher, nil
+	}
+
+	hi, lo, swapped := alignOrder(op0, op1)
+	level, k, _, err := alignment(s.params, op, hi, lo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if level < s.minLevel && s.CanBootstrap() {
+		a, err := s.Bootstrap(op0)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := s.Bootstrap(op1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return a, b, nil
+	}
+
+	// hi is multiplied by a constant 1 and rescaled down to level
+	values, err := s.values(hi)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.count(&s.stats.MulCount)
+	for i := 0; i < k/s.params.LevelsConsumedPerRescaling(); i++ {
+		s.count(&s.stats.RescaleCount)
+		s.perturb(values, s.config.Noise)
+	}
+	matched := s.ciphertext(values, level, lo.Scale)
+	if values, err = s.values(lo); err != nil {
+		return nil, nil, err
+	}
+	lo = s.ciphertext(values, level, lo.Scale)
+	if swapped {
+		return lo, matched, nil
+	}
+	return matched, lo, nil
+}
+
+// combine aligns op0 and op1 and applies f to their slots; the result is at
+// the lower of their levels
+func (s *Simulator) combine(op string, op0, op1 *rlwe.Ciphertext, f func(a, b float64) float64) (*rlwe.Ciphertext, error) {
+	op0, op1, err := s.align(op, op0, op1)
+	if err != nil {
+		return nil, err
+	}
+	a, err := s.values(op0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.values(op1)
+	if err != nil {
+		return nil, err
+	}
+	for i := range a {
+		a[i] = f(a[i], b[i])
+	}
+	s.count(&s.stats.AddCount)
+	return s.ciphertext(a, min(op0.Level(), op1.Level()), op0.Scale), nil
+}
+
+// Add adds two ciphertexts, aligning their scales
+func (s *Simulator) Add(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	return s.combine("add", op0, op1, func(a, b float64) float64 { return a + b })
+}
+
+// AddInPlace adds op1 to op0 in place, aligning their scales
+func (s *Simulator) AddInPlace(op0, op1 *rlwe.Ciphertext) error {
+	result, err := s.Add(op0, op1)
+	if err != nil {
+		return err
+	}
+	*op0 = *result
+	return nil
+}
+
+// Sub subtracts op1 from op0, aligning their scales
+func (s *Simulator) Sub(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	return s.combine("sub", op0, op1, func(a, b float64) float64 { return a - b })
+}
+
+// Mul multiplies two ciphertexts; the product has the product of their
+// scales, at the lower of their levels
+func (s *Simulator) Mul(op0, op1 *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	op0, err := s.MaybeBootstrap(op0)
+	if err != nil {
+		return nil, err
+	}
+	if op1, err = s.MaybeBootstrap(op1); err != nil {
+		return nil, err
+	}
+	a, err := s.values(op0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.values(op1)
+	if err != nil {
+		return nil, err
+	}
+	for i := range a {
+		a[i] *= b[i]
+	}
+	s.count(&s.stats.MulCount)
+	return s.ciphertext(s.perturb(a, s.config.Noise), min(op0.Level(), op1.Level()), op0.Scale.Mul(op1.Scale)), nil
+}
+
+// MulConst multiplies a ciphertext by a constant; as in lattigo, a constant
+// that is not an integer scales the result by the prime of its level
+func (s *Simulator) MulConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ciphertext, error) {
+	values, err := s.values(ct)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i] *= real(constant)
+	}
+	scale := ct.Scale
+	if real(constant) != math.Trunc(real(constant)) || imag(constant) != math.Trunc(imag(constant)) {
+		scale = scale.Mul(rlwe.NewScale(s.params.Q()[ct.Level()]))
+	}
+	s.count(&s.stats.MulCount)
+	return s.ciphertext(values, ct.Level(), scale), nil
+}
+
+// AddConst adds a constant to a ciphertext
+func (s *Simulator) AddConst(ct *rlwe.Ciphertext, constant complex128) (*rlwe.Ciphertext, error) {
+	values, err := s.values(ct)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i] += real(constant)
+	}
+	s.count(&s.stats.AddCount)
+	return s.ciphertext(values, ct.Level(), ct.Scale), nil
+}
+
+// Rescale divides the scale of a ciphertext by the primes of the levels it
+// consumes
+func (s *Simulator) Rescale(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	step := s.params.LevelsConsumedPerRescaling()
+	if ct.Level() < step {
+		return nil, depthError("rescale", ct.Level())
+	}
+	values, err := s.values(ct)
+	if err != nil {
+		return nil, err
+	}
+	scale := ct.Scale
+	for i := 0; i < step; i++ {
+		scale = scale.Div(rlwe.NewScale(s.params.Q()[ct.Level()-i]))
+	}
+	s.count(&s.stats.RescaleCount)
+	return s.ciphertext(s.perturb(values, s.config.Noise), ct.Level()-step, scale), nil
+}
+
+// Rotate rotates a ciphertext by k positions
+func (s *Simulator) Rotate(ct *rlwe.Ciphertext, k int) (*rlwe.Ciphertext, error) {
+	values, err := s.values(ct)
+	if err != nil {
+		return nil, err
+	}
+	s.count(&s.stats.RotateCount)
+	return s.ciphertext(s.perturb(rotated(k)([][]float64{values}), s.config.Noise), ct.Level(), ct.Scale), nil
+}
+
+// SumSlots sums all slots into slot 0 using rotations
+func (s *Simulator) SumSlots(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	return sumSlots(s, ct)
+}
+
+// Power computes ct^n using binary exponentiation
+func (s *Simulator) Power(ct *rlwe.Ciphertext, n int) (*rlwe.Ciphertext, error) {
+	return binaryPower(s, ct, n)
+}
+
+// EvaluatePolynomial evaluates p(x) = coeffs[0] + coeffs[1]*x + ... +
+// coeffs[n-1]*x^(n-1) in PolynomialDepth levels
+func (s *Simulator) EvaluatePolynomial(ct *rlwe.Ciphertext, coeffs []float64) (*rlwe.Ciphertext, error) {
+	degree, err := polynomialDegree(coeffs)
+	if err != nil {
+		return nil, err
+	}
+	if degree == 0 {
+		return constantLike(s, ct, coeffs[0])
+	}
+	if ct, err = reserve(s, ct, degree, PolynomialDepth(degree)); err != nil {
+		return nil, err
+	}
+	return s.polynomial(ct, degree, func(x float64) float64 {
+		var y float64
+		for k := degree; k >= 0; k-- {
+			y = y*x + coeffs[k]
+		}
+		return y
+	})
+}
+
+// EvaluateChebyshev evaluates p(x) = Σ coeffs[k] T_k(y), with y the input
+// mapped from the interval to [-1, 1], in ChebyshevDepth levels
+func (s *Simulator) EvaluateChebyshev(ct *rlwe.Ciphertext, coeffs []float64, interval Interval) (*rlwe.Ciphertext, error) {
+	if !(interval.A < interval.B) {
+		return nil, fmt.Errorf("invalid Chebyshev interval [%g, %g]", interval.A, interval.B)
+	}
+	degree, err := polynomialDegree(coeffs)
+	if err != nil {
+		return nil, err
+	}
+	if degree == 0 {
+		return constantLike(s, ct, coeffs[0])
+	}
+	if ct, err = reserve(s, ct, degree, ChebyshevDepth(degree, interval)); err != nil {
+		return nil, err
+	}
+	if ct, err = mapInterval(s, ct, interval); err != nil {
+		return nil, err
+	}
+	return s.polynomial(ct, degree, func(y float64) float64 {
+		// Clenshaw's recurrence
+		var b1, b2 float64
+		for k := degree; k >= 1; k-- {
+			b1, b2 = 2*y*b1-b2+coeffs[k], b1
+		}
+		return y*b1 - b2 + coeffs[0]
+	})
+}
+
+// polynomial applies p to the slots of ct; like lattigo's polynomial
+// evaluator, the result has ct's scale, PolynomialDepth levels lower
+func (s *Simulator) polynomial(ct *rlwe.Ciphertext, degree int, p func(x float64) float64) (*rlwe.Ciphertext, error) {
+	values, err := s.values(ct)
+	if err != nil {
+		return nil, err
+	}
+	for i, x := range values {
+		values[i] = p(x)
+	}
+	level := ct.Level() - PolynomialDepth(degree)*s.params.LevelsConsumedPerRescaling()
+	return s.ciphertext(s.perturb(values, s.config.Noise), level, ct.Scale), nil
+}
+
+// ZeroCiphertextLike creates a zero ciphertext at the level and scale of x
+func (s *Simulator) ZeroCiphertextLike(x *rlwe.Ciphertext) *rlwe.Ciphertext {
+	return s.ciphertext(make([]float64, s.Slots()), x.Level(), x.Scale)
+}
+
+// Workers returns 1: the simulator evaluates serially, so that its noise
+// can be repeated
+func (s *Simulator) Workers() int {
+	return 1
+}
+
+// Parallel calls f(s, i) for every i in [0, n) in order, returning the first
+// error
+func (s *Simulator) Parallel(n int, f func(w Backend, i int) error) error {
+	for i := 0; i < n; i++ {
+		if err := f(s, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SumTree adds ciphertexts pairwise in a tree, as the Evaluator does
+func (s *Simulator) SumTree(cts []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	return sumTree(s, cts)
+}
+
+// MapSum returns the sum of f(s, i) for every i in [0, n)
+func (s *Simulator) MapSum(n int, f func(w Backend, i int) (*rlwe.Ciphertext, error)) (*rlwe.Ciphertext, error) {
+	return mapSum(s, n, f)
+}
+
+// Span does nothing: a simulator has no tracer
+func (s *Simulator) Span(label string) func() {
+	return func() {}
+}
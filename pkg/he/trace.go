@@ -0,0 +1,240 @@
+package he
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// DefaultTraceSlots is the number of leading slots a Tracer records
+const DefaultTraceSlots = 8
+
+// TraceRecord is the state of the result of one operation
+type TraceRecord struct {
+	Seq      int     `json:"seq"`
+	Op       string  `json:"op"`
+	Span     string  `json:"span,omitempty"` // Labels of the spans open at the operation, outermost first
+	Level    int     `json:"level"`
+	LogScale float64 `json:"log_scale"`
+	// LogNoise is log2 of the largest difference over the recorded slots
+	// between the decrypted result and the operation applied to the
+	// decrypted inputs: the error the operation added. Omitted if exact.
+	LogNoise *float64 `json:"log_noise,omitempty"`
+	// LogHeadroom is log2 of the ratio of the modulus at the result's level
+	// to its largest scaled value; values wrap around when it reaches 0
+	LogHeadroom float64   `json:"log_headroom"`
+	Min         float64   `json:"min"`
+	Max         float64   `json:"max"`
+	Values      []float64 `json:"values"` // The recorded slots
+}
+
+// Tracer is a secret-key oracle that decrypts the inputs and result of every
+// operation of the evaluators it is set on and records a TraceRecord. It
+// reveals everything the evaluator computes: use it in tests and staging
+// only, never with real data.
+type Tracer struct {
+	mu        sync.Mutex // guards the decryptor, encoder and records
+	params    ckks.Parameters
+	encoder   *ckks.Encoder
+	decryptor *rlwe.Decryptor
+	slots     int
+	records   []TraceRecord
+}
+
+// NewTracer creates a tracer decrypting with sk that records the first slots
+// of each result
+func NewTracer(params ckks.Parameters, sk *rlwe.SecretKey, slots int) *Tracer {
+	return &Tracer{
+		params:    params,
+		encoder:   ckks.NewEncoder(params),
+		decryptor: rlwe.NewDecryptor(params, sk),
+		slots:     min(max(slots, 1), params.MaxSlots()),
+	}
+}
+
+// Records returns the records so far, in the order of the operations
+func (t *Tracer) Records() []TraceRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceRecord(nil), t.records...)
+}
+
+// WriteJSON writes the records as a JSON trace
+func (t *Tracer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		Slots   int           `json:"slots"`
+		Records []TraceRecord `json:"records"`
+	}{t.slots, t.Records()}); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
+
+// decrypt decrypts and decodes every slot of a ciphertext; t.mu must be held
+func (t *Tracer) decrypt(ct *rlwe.Ciphertext) []float64 {
+	return t.decode(t.decryptor.DecryptNew(ct))
+}
+
+// decode decodes every slot of a plaintext; t.mu must be held
+func (t *Tracer) decode(pt *rlwe.Plaintext) []float64 {
+	values := make([]float64, t.params.MaxSlots())
+	if err := t.encoder.Decode(pt, values); err != nil {
+		// Record the failure as values no operation produces
+		for i := range values {
+			values[i] = math.NaN()
+		}
+	}
+	return values
+}
+
+// record decrypts result and records it against want, which computes the
+// expected slots from the decrypted inputs
+func (t *Tracer) record(span, op string, result *rlwe.Ciphertext, want func(in [][]float64) []float64, inputs ...*rlwe.Ciphertext) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	in := make([][]float64, len(inputs))
+	for i, ct := range inputs {
+		in[i] = t.decrypt(ct)
+	}
+	expected := want(in)
+	got := t.decrypt(result)[:t.slots]
+
+	r := TraceRecord{
+		Seq:      len(t.records),
+		Op:       op,
+		Span:     span,
+		Level:    result.Level(),
+		LogScale: result.LogScale(),
+		Min:      math.Inf(1),
+		Max:      math.Inf(-1),
+		Values:   got,
+	}
+	noise, largest := 0.0, 0.0
+	for i, v := range got {
+		r.Min, r.Max = math.Min(r.Min, v), math.Max(r.Max, v)
+		noise = math.Max(noise, math.Abs(v-expected[i]))
+		largest = math.Max(largest, math.Abs(v))
+	}
+	if noise > 0 {
+		logNoise := math.Log2(noise)
+		r.LogNoise = &logNoise
+	}
+	logQ := 0.0
+	for _, q := range t.params.Q()[:result.Level()+1] {
+		logQ += math.Log2(float64(q))
+	}
+	r.LogHeadroom = logQ - 1 - r.LogScale - math.Log2(math.Max(largest, 1))
+	t.records = append(t.records, r)
+}
+
+// SetTracer sets the tracer that records the operations of e and its worker
+// copies; nil turns tracing off
+func (e *Evaluator) SetTracer(t *Tracer) {
+	e.tracer = t
+	e.mu.Lock()
+	e.copies = nil // Worker copies are made again with the new tracer
+	e.mu.Unlock()
+}
+
+// Span labels the operations of e, nested in the spans already open, until
+// the returned function is called: defer e.Span("INVNTHSQRT iter 7")()
+func (e *Evaluator) Span(label string) func() {
+	if e.tracer == nil {
+		return func() {}
+	}
+	e.spans = append(e.spans, label)
+	depth := len(e.spans)
+	return func() {
+		e.spans = e.spans[:depth-1]
+	}
+}
+
+// spanPath returns the labels of the open spans, including those open in
+// the evaluator a worker copy runs for
+func (e *Evaluator) spanPath() string {
+	labels := e.spans
+	if e.spanBase != "" {
+		labels = append([]string{e.spanBase}, labels...)
+	}
+	return strings.Join(labels, " / ")
+}
+
+// trace records the result of an operation if e has a tracer; want computes
+// the expected slots from the decrypted inputs
+func (e *Evaluator) trace(op string, result *rlwe.Ciphertext, want func(in [][]float64) []float64, inputs ...*rlwe.Ciphertext) {
+	if e.tracer == nil {
+		return
+	}
+	e.tracer.record(e.spanPath(), op, result, want, inputs...)
+}
+
+// traceInputs decrypts the inputs of an operation that overwrites them, if
+// e has a tracer
+func (e *Evaluator) traceInputs(inputs ...*rlwe.Ciphertext) [][]float64 {
+	if e.tracer == nil {
+		return nil
+	}
+	e.tracer.mu.Lock()
+	defer e.tracer.mu.Unlock()
+	in := make([][]float64, len(inputs))
+	for i, ct := range inputs {
+		in[i] = e.tracer.decrypt(ct)
+	}
+	return in
+}
+
+// Expected slots of the operations, from their decrypted inputs
+
+func same(in [][]float64) []float64 {
+	return in[0]
+}
+
+func sum(in [][]float64) []float64 {
+	return zip(in[0], in[1], func(a, b float64) float64 { return a + b })
+}
+
+func difference(in [][]float64) []float64 {
+	return zip(in[0], in[1], func(a, b float64) float64 { return a - b })
+}
+
+func product(in [][]float64) []float64 {
+	return zip(in[0], in[1], func(a, b float64) float64 { return a * b })
+}
+
+func zip(a, b []float64, f func(a, b float64) float64) []float64 {
+	out := make([]float64, len(a))
+	for i := range out {
+		out[i] = f(a[i], b[i])
+	}
+	return out
+}
+
+func mapSlots(f func(x float64) float64) func(in [][]float64) []float64 {
+	return func(in [][]float64) []float64 {
+		out := make([]float64, len(in[0]))
+		for i, x := range in[0] {
+			out[i] = f(x)
+		}
+		return out
+	}
+}
+
+func rotated(k int) func(in [][]float64) []float64 {
+	return func(in [][]float64) []float64 {
+		n := len(in[0])
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = in[0][((i+k)%n+n)%n]
+		}
+		return out
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
 )
 
 // Operation represents the type of statistical operation
@@ -25,10 +28,57 @@ const (
 	OpLookup     Operation = "lookup"
 )
 
-// Condition represents a categorical filter condition
+// Condition represents a categorical filter condition.
+// In JSON the value is either a category code or a label of the column's
+// dictionary, e.g. {"column": "region", "value": "South"}; labels are
+// resolved to codes with JobSpec.ResolveLabels.
 type Condition struct {
 	Column string `json:"column"`
 	Value  int    `json:"value"`
+	Label  string `json:"-"`
+}
+
+// conditionJSON is the wire form of a Condition
+type conditionJSON struct {
+	Column string          `json:"column"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// UnmarshalJSON accepts a code or a label as the condition value
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	var raw conditionJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	c.Column = raw.Column
+	c.Value, c.Label = 0, ""
+	if len(raw.Value) == 0 {
+		return nil
+	}
+	if raw.Value[0] == '"' {
+		return json.Unmarshal(raw.Value, &c.Label)
+	}
+	if err := json.Unmarshal(raw.Value, &c.Value); err != nil {
+		return fmt.Errorf("condition on %s: value must be a category code or label: %w", c.Column, err)
+	}
+	return nil
+}
+
+// MarshalJSON writes the label if the condition has one, the code otherwise
+func (c Condition) MarshalJSON() ([]byte, error) {
+	var value interface{} = c.Value
+	if c.Label != "" {
+		value = c.Label
+	}
+	return json.Marshal(map[string]interface{}{"column": c.Column, "value": value})
+}
+
+// String returns the condition as column=label, or column=code without a label
+func (c Condition) String() string {
+	if c.Label != "" {
+		return fmt.Sprintf("%s=%s", c.Column, c.Label)
+	}
+	return fmt.Sprintf("%s=%d", c.Column, c.Value)
 }
 
 // JobSpec defines a statistical computation request
@@ -121,6 +171,66 @@ func (j *JobSpec) Validate() error {
 	return nil
 }
 
+// ResolveLabels sets the code of every labelled condition using code, which
+// maps a column and label to a category code, and the label of every coded
+// condition using label, which returns "" for columns without a dictionary
+func (j *JobSpec) ResolveLabels(code func(column, label string) (int, error), label func(column string, code int) string) error {
+	for i := range j.Conditions {
+		c := &j.Conditions[i]
+		if c.Label == "" {
+			c.Label = label(c.Column, c.Value)
+			continue
+		}
+		v, err := code(c.Column, c.Label)
+		if err != nil {
+			return fmt.Errorf("condition %d: %w", i, err)
+		}
+		c.Value = v
+	}
+	return nil
+}
+
+// NumericColumn returns the numerical column whose units the job's result is in,
+// or "" if the result does not depend on numerical values
+func (j *JobSpec) NumericColumn() string {
+	switch j.Operation {
+	case OpMean, OpVariance, OpStdev:
+		return j.InputColumns[0]
+	case OpBa, OpBv, OpLookup:
+		return j.TargetColumn
+	default:
+		return ""
+	}
+}
+
+// ResultTransform returns the map from the job's decrypted result back to
+// original units when NumericColumn is encrypted normalized as
+// z = (x - offset) / scale. It returns nil for unit-free results, and for
+// lookups, whose unselected slots cannot be told apart from selected minima.
+func (j *JobSpec) ResultTransform(scale, offset float64) *ResultTransform {
+	switch j.Operation {
+	case OpMean, OpBa:
+		return &ResultTransform{Scale: scale, Offset: offset}
+	case OpVariance, OpBv:
+		return &ResultTransform{Scale: scale * scale}
+	case OpStdev:
+		return &ResultTransform{Scale: scale}
+	default:
+		return nil
+	}
+}
+
+// ResultTransform maps a decrypted result to original units: Scale*v + Offset
+type ResultTransform struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// Apply maps a decrypted value to original units
+func (t *ResultTransform) Apply(v float64) float64 {
+	return t.Scale*v + t.Offset
+}
+
 // LoadJobSpec loads a job specification from a JSON file
 func LoadJobSpec(path string) (*JobSpec, error) {
 	f, err := os.Open(path)
@@ -164,14 +274,86 @@ func SaveJobSpec(path string, job *JobSpec) error {
 type JobResult struct {
 	JobID      string                 `json:"job_id"`
 	Operation  string                 `json:"operation"`
-	ResultPath string                 `json:"result_path"` // Path to encrypted result ciphertext
+	ResultPath string                 `json:"result_path"`          // Path to encrypted result ciphertext
+	ParamsHash string                 `json:"params_hash"`          // Hash of CKKS params the result is encrypted under
+	Transform  *ResultTransform       `json:"transform,omitempty"`  // Applied by ddia decrypt for normalized columns
+	Conditions []Condition            `json:"conditions,omitempty"` // Resolved conditions of BIN-OP jobs
+	Labels     map[string][]string    `json:"labels,omitempty"`     // Category dictionaries of the result's columns
+	Time       *schema.TimeEncoding   `json:"time,omitempty"`       // Set when the result is a date or timestamp
+	LBc        *LBcLayout             `json:"lbc,omitempty"`        // Set for LBc results, which ddia decrypt unpacks
+	Provenance *Provenance            `json:"provenance,omitempty"` // Set when the table's signature was verified
 	Metadata   map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// LBcLayout records how an LBc result packs the counts of its primary
+// column's categories into bit fields
+type LBcLayout struct {
+	Column      string `json:"column"`
+	Categories  int    `json:"categories"`
+	Masks       int    `json:"masks"` // BBMVs each row was multiplied by
+	Delta       int    `json:"delta"`
+	DeltaOffset int    `json:"delta_offset"`
+	LambdaBig   int    `json:"lambda_big"`
+}
+
+// Provenance records the signed table a result was computed from
+type Provenance struct {
+	Table    string   `json:"table"`
+	Signer   string   `json:"signer"`
+	Sources  []string `json:"sources,omitempty"` // Signers of the tables a merged table was built from
+	Manifest string   `json:"manifest_sha256"`
+}
+
+// SaveJobResult saves job result metadata to a JSON file
+func SaveJobResult(path string, result *JobResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create job result file: %w", err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return fmt.Errorf("failed to write job result: %w", err)
+	}
+	return nil
+}
+
+// LoadJobResult loads job result metadata from a JSON file
+func LoadJobResult(path string) (*JobResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job result file: %w", err)
+	}
+	defer f.Close()
+
+	var result JobResult
+	if err := json.NewDecoder(f).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse job result: %w", err)
+	}
+	return &result, nil
+}
+
 // JobPlan represents a planned execution of a job
 type JobPlan struct {
 	Job   *JobSpec
 	Steps []PlanStep
+
+	// Totals over all steps; Depth is the depth of the job's result
+	Depth      int
+	Rotations  int
+	Bootstraps int
+
+	// Budget check against a profile, filled in by EstimateJob
+	Profile     params.ProfileType
+	MaxLevel    int   // Levels left for the job: the profile's, less InputDepth
+	InputDepth  int   // Levels of the profile the job's inputs lack: trimmed at encryption or consumed, e.g. by retractions
+	Ciphertexts int   // Ciphertexts loaded from the table
+	MemoryBytes int64 // Approximate size of the loaded ciphertexts
+	BlockBytes  int64 // Approximate size of one block's ciphertexts, the unit streamed operations hold in memory
+	Fits        bool
+	Recommended params.ProfileType // Built-in profile that fits when Fits is false, if any
 }
 
 // PlanStep represents one step in job execution
@@ -180,89 +362,10 @@ type PlanStep struct {
 	Description string
 	Inputs      []string
 	Outputs     []string
-}
-
-// PlanJob creates an execution plan for a job
-func PlanJob(job *JobSpec) (*JobPlan, error) {
-	if err := job.Validate(); err != nil {
-		return nil, err
-	}
-
-	plan := &JobPlan{Job: job}
-
-	switch job.Operation {
-	case OpMean:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load data blocks and validity vectors"},
-			{Name: "masked_sum", Description: "Compute sum(x * v)"},
-			{Name: "count", Description: "Compute sum(v)"},
-			{Name: "inverse", Description: "Compute 1/count via INVNTHSQRT"},
-			{Name: "divide", Description: "Compute mean = sum * invCount"},
-		}
-	case OpVariance:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load data blocks and validity vectors"},
-			{Name: "mean", Description: "Compute mean"},
-			{Name: "sum_squares", Description: "Compute sum(x^2 * v)"},
-			{Name: "inverse", Description: "Compute 1/count"},
-			{Name: "variance", Description: "Compute E[X^2] - E[X]^2"},
-		}
-	case OpStdev:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load data blocks and validity vectors"},
-			{Name: "variance", Description: "Compute variance"},
-			{Name: "sqrt", Description: "Compute sqrt(variance) via INVNTHSQRT"},
-		}
-	case OpCorr:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load data blocks for both columns"},
-			{Name: "means", Description: "Compute means of X and Y"},
-			{Name: "covariance", Description: "Compute covariance"},
-			{Name: "variances", Description: "Compute variances of X and Y"},
-			{Name: "normalize", Description: "Compute cov/(stdevX * stdevY)"},
-		}
-	case OpBc:
-		plan.Steps = []PlanStep{
-			{Name: "load_bmvs", Description: "Load BMV blocks for conditions"},
-			{Name: "build_mask", Description: "Multiply BMVs to create combined mask"},
-			{Name: "sum", Description: "Sum mask values to get count"},
-		}
-	case OpBa:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load target column and BMVs"},
-			{Name: "build_mask", Description: "Build combined mask from conditions"},
-			{Name: "mean", Description: "Compute mean with mask as validity"},
-		}
-	case OpBv:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load target column and BMVs"},
-			{Name: "build_mask", Description: "Build combined mask from conditions"},
-			{Name: "variance", Description: "Compute variance with mask as validity"},
-		}
-	case OpLBc:
-		plan.Steps = []PlanStep{
-			{Name: "load_pbmv", Description: "Load PBMV for primary variable"},
-			{Name: "load_bbmv", Description: "Load BBMVs for other variables"},
-			{Name: "multiply", Description: "Compute batched products"},
-			{Name: "pack", Description: "Pack results for DDIA post-processing"},
-		}
-	case OpPercentile:
-		plan.Steps = []PlanStep{
-			{Name: "load_bmvs", Description: "Load BMVs for ordinal column"},
-			{Name: "frequencies", Description: "Compute frequency for each value"},
-			{Name: "cumulative", Description: "Build cumulative histogram"},
-			{Name: "compare", Description: "Compare cumulative/R with k/100"},
-			{Name: "find", Description: "Find first bucket above threshold"},
-		}
-	case OpLookup:
-		plan.Steps = []PlanStep{
-			{Name: "load_data", Description: "Load categorical and target columns"},
-			{Name: "equality", Description: "Compute DISCRETEEQUALZERO(cat - value)"},
-			{Name: "select", Description: "Multiply equality indicator by target"},
-		}
-	}
 
-	return plan, nil
+	Depth      int // Multiplicative depth of the step's output, counted from fresh ciphertexts
+	Rotations  int // Estimated rotations, 0 without a profile
+	Bootstraps int // Estimated bootstraps, 0 without bootstrapping
 }
 
 // ExecutorDeps holds dependencies for job execution
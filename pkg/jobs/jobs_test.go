@@ -2,7 +2,14 @@ package jobs
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/ops/approx"
+	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
 )
 
 func TestJobSpecValidation(t *testing.T) {
@@ -237,3 +244,275 @@ func TestPlanJob(t *testing.T) {
 		t.Error("Expected non-empty plan steps")
 	}
 }
+
+func TestDepthModelMatchesOps(t *testing.T) {
+	if it := numeric.DefaultINVConfig().Iterations; it != invIterations {
+		t.Errorf("INV iterations: model has %d, numeric has %d", invIterations, it)
+	}
+	if it := numeric.DefaultINVSQRTConfig().Iterations; it != invIterations {
+		t.Errorf("INVSQRT iterations: model has %d, numeric has %d", invIterations, it)
+	}
+	if it := approx.DefaultApproxSignConfig().Iterations; it != signIterations {
+		t.Errorf("APPROXSIGN iterations: model has %d, approx has %d", signIterations, it)
+	}
+	if d := approx.SignCoeffs(approx.DefaultApproxSignConfig()).Degree; polynomialDepth(d) != signDepth() {
+		t.Errorf("APPROXSIGN depth: model has %d, approx has degree %d", signDepth(), d)
+	}
+	for _, bound := range []int{0, 1, 2, 5, 7, 8, 9, 30, 64} {
+		coeffs := approx.DEZCoeffs(approx.DefaultDEZConfig(bound))
+		if coeffs.Degree != dezDegree(bound) {
+			t.Errorf("DEZ degree up to %d: model has %d, approx has %d", bound, dezDegree(bound), coeffs.Degree)
+		}
+		if dezDepth(bound) != he.ChebyshevDepth(coeffs.Degree, coeffs.Interval) {
+			t.Errorf("DEZ depth up to %d: model has %d, approx has %d", bound, dezDepth(bound), he.ChebyshevDepth(coeffs.Degree, coeffs.Interval))
+		}
+	}
+	if d := invDepth(1); d != 40 {
+		t.Errorf("Expected INV depth 40, got %d", d)
+	}
+	if d := invDepth(2); d != 60 {
+		t.Errorf("Expected INVSQRT depth 60, got %d", d)
+	}
+}
+
+func TestPlanJobDepth(t *testing.T) {
+	tests := []struct {
+		spec  JobSpec
+		depth int
+	}{
+		{JobSpec{ID: "mean", Operation: OpMean, Table: "t", InputColumns: []string{"x"}}, 41},
+		{JobSpec{ID: "var", Operation: OpVariance, Table: "t", InputColumns: []string{"x"}}, 44},
+		{JobSpec{ID: "stdev", Operation: OpStdev, Table: "t", InputColumns: []string{"x"}}, 105},
+		{JobSpec{ID: "corr", Operation: OpCorr, Table: "t", InputColumns: []string{"x", "y"}}, 107},
+		{JobSpec{ID: "bc", Operation: OpBc, Table: "t", Conditions: []Condition{{Column: "a", Value: 1}, {Column: "b", Value: 2}}}, 2},
+		{JobSpec{ID: "lbc", Operation: OpLBc, Table: "t", InputColumns: []string{"a", "b", "c"}}, 3},
+		{JobSpec{ID: "pct", Operation: OpPercentile, Table: "t", InputColumns: []string{"r"}, K: 90}, 48},
+		{JobSpec{ID: "lookup", Operation: OpLookup, Table: "t", LookupColumn: "a", LookupValue: 1, TargetColumn: "x"}, 1},
+	}
+
+	for _, tt := range tests {
+		plan, err := PlanJob(&tt.spec)
+		if err != nil {
+			t.Fatalf("%s: failed to plan: %v", tt.spec.ID, err)
+		}
+		if plan.Depth != tt.depth {
+			t.Errorf("%s: expected depth %d, got %d", tt.spec.ID, tt.depth, plan.Depth)
+		}
+	}
+}
+
+func TestEstimateJob(t *testing.T) {
+	profA, err := params.NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+
+	bc := &JobSpec{ID: "bc", Operation: OpBc, Table: "t", Conditions: []Condition{{Column: "gender", Value: 1}}}
+	plan, err := EstimateJob(bc, PlanTarget{Profile: profA, BlockCount: 2})
+	if err != nil {
+		t.Fatalf("Failed to estimate bc: %v", err)
+	}
+	if !plan.Fits || plan.Depth != 1 {
+		t.Errorf("bc should fit Profile A2 with depth 1, got fits=%v depth=%d", plan.Fits, plan.Depth)
+	}
+	if plan.Rotations != 13 {
+		t.Errorf("Expected 13 rotations for one SumSlots over 8192 slots, got %d", plan.Rotations)
+	}
+	if plan.Ciphertexts != 4 || plan.MemoryBytes <= 0 {
+		t.Errorf("Expected 4 ciphertexts and a memory estimate, got %d (%d bytes)", plan.Ciphertexts, plan.MemoryBytes)
+	}
+	if plan.BlockBytes != plan.MemoryBytes/2 {
+		t.Errorf("Expected a block to hold half of the 2-block memory estimate, got %d of %d bytes", plan.BlockBytes, plan.MemoryBytes)
+	}
+
+	mean := &JobSpec{ID: "mean", Operation: OpMean, Table: "t", InputColumns: []string{"income"}}
+	plan, err = EstimateJob(mean, PlanTarget{Profile: profA, BlockCount: 1})
+	if err != nil {
+		t.Fatalf("Failed to estimate mean: %v", err)
+	}
+	if plan.Fits {
+		t.Errorf("mean (depth %d) should not fit Profile A2 (%d levels)", plan.Depth, plan.MaxLevel)
+	}
+	if plan.Recommended != params.ProfileB2 {
+		t.Errorf("Expected Profile B2 to be recommended, got %q", plan.Recommended)
+	}
+
+	// Levels consumed by retractions are not available to the job
+	plan, err = EstimateJob(bc, PlanTarget{Profile: profA, BlockCount: 2, InputDepth: profA.MaxLevel()})
+	if err != nil {
+		t.Fatalf("Failed to estimate bc: %v", err)
+	}
+	if plan.Fits || plan.MaxLevel != 0 {
+		t.Errorf("bc should not fit a table with no levels left, got fits=%v levels=%d", plan.Fits, plan.MaxLevel)
+	}
+	if plan.Recommended != params.ProfileA2 {
+		t.Errorf("Expected a re-encryption with Profile A2 to be recommended, got %q", plan.Recommended)
+	}
+
+	// Only the levels of the kinds the job loads count
+	plan, err = EstimateJob(bc, PlanTarget{Profile: profA, BlockCount: 2, Levels: &schema.EntryLevels{Block: 0, Validity: 1, BMV: 1}})
+	if err != nil {
+		t.Fatalf("Failed to estimate bc: %v", err)
+	}
+	if !plan.Fits || plan.MaxLevel != 1 || plan.InputDepth != profA.MaxLevel()-1 {
+		t.Errorf("bc should fit BMVs and validity at level 1, got fits=%v levels=%d", plan.Fits, plan.MaxLevel)
+	}
+	if plan.MemoryBytes >= int64(plan.Ciphertexts)*int64(2*(1<<profA.LogN)*8*(profA.MaxLevel()+1)) {
+		t.Errorf("Trimmed ciphertexts should be estimated smaller than fresh ones, got %d bytes", plan.MemoryBytes)
+	}
+	plan, err = EstimateJob(bc, PlanTarget{Profile: profA, BlockCount: 2, Levels: &schema.EntryLevels{Block: 9, Validity: 9, BMV: 0}})
+	if err != nil {
+		t.Fatalf("Failed to estimate bc: %v", err)
+	}
+	if plan.Fits || plan.Recommended != params.ProfileA2 {
+		t.Errorf("bc should not fit BMVs at level 0 and recommend re-encrypting, got fits=%v recommended=%q", plan.Fits, plan.Recommended)
+	}
+
+	pct := &JobSpec{ID: "pct", Operation: OpPercentile, Table: "t", InputColumns: []string{"risk"}, K: 90}
+	if _, err := EstimateJob(pct, PlanTarget{Profile: profA}); err == nil {
+		t.Error("Expected an error for a percentile column with unknown category count")
+	}
+
+	// Without BMVs a lookup evaluates DISCRETEEQUALZERO, which fits Profile A2
+	// up to a bound of 7: 7 categories coded from 1, as missing cells are 0
+	lookup := &JobSpec{ID: "lookup", Operation: OpLookup, Table: "t", LookupColumn: "a", LookupValue: 1, TargetColumn: "x"}
+	plan, err = EstimateJob(lookup, PlanTarget{Profile: profA, BlockCount: 2, LookupDEZ: true, LookupBound: 7})
+	if err != nil {
+		t.Fatalf("Failed to estimate lookup: %v", err)
+	}
+	if !plan.Fits || plan.Depth != 7 {
+		t.Errorf("DEZ lookup up to 7 should fit Profile A2 with depth 7, got fits=%v depth=%d", plan.Fits, plan.Depth)
+	}
+	plan, err = EstimateJob(lookup, PlanTarget{Profile: profA, BlockCount: 2, LookupDEZ: true, LookupBound: 8})
+	if err != nil {
+		t.Fatalf("Failed to estimate lookup: %v", err)
+	}
+	if plan.Fits || plan.Depth != 8 {
+		t.Errorf("DEZ lookup up to 8 should need depth 8, got fits=%v depth=%d", plan.Fits, plan.Depth)
+	}
+	plan, err = EstimateJob(lookup, PlanTarget{Profile: profA, BlockCount: 2, LookupDEZ: true, LookupBound: 30})
+	if err != nil {
+		t.Fatalf("Failed to estimate lookup: %v", err)
+	}
+	if plan.Fits || plan.Depth != 9 || plan.Recommended != params.ProfileB2 {
+		t.Errorf("DEZ lookup up to 30 should need depth 9 and Profile B2, got fits=%v depth=%d recommended=%q", plan.Fits, plan.Depth, plan.Recommended)
+	}
+}
+
+func TestTableLevels(t *testing.T) {
+	profA, err := params.NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+	bc := func(conds int) *JobSpec {
+		job := &JobSpec{ID: "bc", Operation: OpBc, Table: "t"}
+		for i := 0; i < conds; i++ {
+			job.Conditions = append(job.Conditions, Condition{Column: "region", Value: 1})
+		}
+		return job
+	}
+	levels, err := TableLevels([]*JobSpec{bc(1), bc(3)}, PlanTarget{Profile: profA, BlockCount: 2})
+	if err != nil {
+		t.Fatalf("Failed to plan table levels: %v", err)
+	}
+	if levels != (schema.EntryLevels{Block: 0, Validity: 3, BMV: 3}) {
+		t.Errorf("Expected BMVs and validity at the depth of 3 conditions and blocks at 0, got %+v", levels)
+	}
+	plan, err := EstimateJob(bc(3), PlanTarget{Profile: profA, BlockCount: 2, Levels: &levels})
+	if err != nil || !plan.Fits {
+		t.Errorf("A job the levels were planned for should fit them: %v", err)
+	}
+
+	mean := &JobSpec{ID: "mean", Operation: OpMean, Table: "t", InputColumns: []string{"income"}}
+	if _, err := TableLevels([]*JobSpec{mean}, PlanTarget{Profile: profA}); err == nil {
+		t.Error("Expected an error for a job that does not fit the profile")
+	}
+}
+
+func TestEstimateJobBootstrapping(t *testing.T) {
+	profB, err := params.NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+
+	corr := &JobSpec{ID: "corr", Operation: OpCorr, Table: "t", InputColumns: []string{"x", "y"}}
+	plan, err := EstimateJob(corr, PlanTarget{Profile: profB, BlockCount: 1})
+	if err != nil {
+		t.Fatalf("Failed to estimate corr: %v", err)
+	}
+	if !plan.Fits {
+		t.Error("corr should fit a bootstrapping profile")
+	}
+	if plan.Bootstraps == 0 {
+		t.Errorf("corr at depth %d should need bootstraps on %d levels", plan.Depth, plan.MaxLevel)
+	}
+}
+
+func TestResultTransform(t *testing.T) {
+	mean := &JobSpec{ID: "m", Operation: OpMean, Table: "t", InputColumns: []string{"income"}}
+	if col := mean.NumericColumn(); col != "income" {
+		t.Errorf("Expected numeric column income, got %q", col)
+	}
+	if v := mean.ResultTransform(100000, 10).Apply(0.5); v != 50010 {
+		t.Errorf("Mean: expected 50010, got %g", v)
+	}
+
+	bv := &JobSpec{ID: "bv", Operation: OpBv, Table: "t", TargetColumn: "income", Conditions: []Condition{{Column: "gender", Value: 1}}}
+	if col := bv.NumericColumn(); col != "income" {
+		t.Errorf("Expected numeric column income, got %q", col)
+	}
+	if v := bv.ResultTransform(100, 10).Apply(0.25); v != 2500 {
+		t.Errorf("Variance: expected 2500, got %g", v)
+	}
+
+	corr := &JobSpec{ID: "c", Operation: OpCorr, Table: "t", InputColumns: []string{"x", "y"}}
+	if tr := corr.ResultTransform(100, 10); tr != nil {
+		t.Error("Correlation is unit-free and should have no transform")
+	}
+	bc := &JobSpec{ID: "bc", Operation: OpBc, Table: "t", Conditions: []Condition{{Column: "gender", Value: 1}}}
+	if col := bc.NumericColumn(); col != "" {
+		t.Errorf("Bin count has no numeric column, got %q", col)
+	}
+}
+
+func TestConditionLabels(t *testing.T) {
+	spec, err := ParseJobSpec(bytes.NewBufferString(`{
+		"id": "bc_labels",
+		"operation": "bc",
+		"table": "t",
+		"conditions": [{"column": "region", "value": "South"}, {"column": "gender", "value": 1}]
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse job spec: %v", err)
+	}
+	if c := spec.Conditions[0]; c.Label != "South" || c.Value != 0 {
+		t.Errorf("Expected label South, got %+v", c)
+	}
+	if c := spec.Conditions[1]; c.Label != "" || c.Value != 1 {
+		t.Errorf("Expected code 1, got %+v", c)
+	}
+
+	dict := map[string][]string{"region": {"North", "South"}, "gender": {"Male", "Female"}}
+	code := func(column, label string) (int, error) {
+		for i, l := range dict[column] {
+			if l == label {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("%q is not a label of %s", label, column)
+	}
+	label := func(column string, code int) string {
+		return dict[column][code-1]
+	}
+	if err := spec.ResolveLabels(code, label); err != nil {
+		t.Fatalf("ResolveLabels failed: %v", err)
+	}
+	if spec.Conditions[0].Value != 2 || spec.Conditions[1].String() != "gender=Male" {
+		t.Errorf("Unexpected resolved conditions: %v", spec.Conditions)
+	}
+
+	spec.Conditions = []Condition{{Column: "region", Label: "West"}}
+	if err := spec.ResolveLabels(code, label); err == nil {
+		t.Error("Expected an error for an unknown label")
+	}
+}
@@ -0,0 +1,375 @@
+package jobs
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// Depth model of the HE operations. The constants mirror the defaults the
+// ops packages run with (numeric.DefaultINVConfig, numeric.DefaultINVSQRTConfig,
+// approx.DefaultApproxSignConfig, approx.DefaultDEZConfig); jobs_test.go
+// checks that they stay in sync. Multiplications by constants are not
+// counted: they are folded into the next rescale.
+const (
+	invIterations  = 20 // INVNTHSQRT Newton iterations for 1/x and 1/sqrt(x)
+	signIterations = 3  // APPROXSIGN refinement iterations
+)
+
+// recommendProfiles are the built-in profiles EstimateJob may recommend, cheapest first
+var recommendProfiles = []params.ProfileType{params.ProfileA2, params.ProfileR, params.ProfileB2}
+
+// invDepth returns the depth of INVNTHSQRT for x^(-1/n): each Newton
+// iteration computes y^n, x*y^n and y*((n+1) - x*y^n)
+func invDepth(n int) int {
+	return invIterations * (2 + bits.Len(uint(n-1)))
+}
+
+// polynomialDepth returns the depth of a polynomial of the given degree
+// evaluated with baby-step giant-step, ceil(log2(degree+1))
+func polynomialDepth(degree int) int {
+	return bits.Len(uint(degree))
+}
+
+// signDepth returns the depth of APPROXSIGN: its iterations of s*(3 - s^2)/2
+// form one polynomial of degree 3^iterations
+func signDepth() int {
+	degree := 1
+	for i := 0; i < signIterations; i++ {
+		degree *= 3
+	}
+	return polynomialDepth(degree)
+}
+
+// dezDegree returns the Chebyshev degree of the DISCRETEEQUALZERO
+// polynomial over inputs in [-bound, bound], 4*2^d - 1 and at least 31
+func dezDegree(bound int) int {
+	return max(4<<bits.Len(uint(max(bound, 0))), 32) - 1
+}
+
+// dezDepth returns the depth of DISCRETEEQUALZERO over inputs in [-bound,
+// bound]: one polynomial on [-2^d, 2^d], 2^d > bound, whose mapping to
+// [-1, 1] costs a level unless d = 0
+func dezDepth(bound int) int {
+	depth := polynomialDepth(dezDegree(bound))
+	if bound > 0 {
+		depth++
+	}
+	return depth
+}
+
+// PlanTarget describes the table and profile a job is estimated against
+type PlanTarget struct {
+	Profile     *params.Profile
+	BlockCount  int
+	Categories  map[string]int      // Category count of each categorical/ordinal column
+	LookupDEZ   bool                // Lookup has no BMVs and falls back to DISCRETEEQUALZERO
+	LookupBound int                 // Largest |cat - value| of a DISCRETEEQUALZERO lookup, see schema.Column.LookupBound
+	InputDepth  int                 // Levels the table's ciphertexts have already consumed, e.g. by retractions
+	Levels      *schema.EntryLevels // Levels the table's ciphertexts were encrypted at, nil for the profile's top level
+}
+
+// tableInputs records which kinds of table ciphertexts a job loads
+type tableInputs struct {
+	blocks, validity, bmvs bool
+}
+
+// level returns the lowest level of the kinds loaded
+func (in tableInputs) level(levels schema.EntryLevels) int {
+	level := math.MaxInt
+	if in.blocks {
+		level = min(level, levels.Block)
+	}
+	if in.validity {
+		level = min(level, levels.Validity)
+	}
+	if in.bmvs {
+		level = min(level, levels.BMV)
+	}
+	return level
+}
+
+// estimator accumulates the cost of plan steps along the critical path
+type estimator struct {
+	plan *JobPlan
+	logS int // Rotations per SumSlots
+	span int // Levels available between bootstraps, 0 without bootstrapping
+}
+
+// step appends a plan step whose output sits at depth from+depth.
+// repeat is the number of independent ciphertexts going through the step,
+// and sums the number of SumSlots calls per ciphertext.
+func (e *estimator) step(name, description string, from, depth, repeat, sums int) int {
+	out := from + depth
+	s := PlanStep{
+		Name:        name,
+		Description: description,
+		Depth:       out,
+		Rotations:   repeat * sums * e.logS,
+	}
+	if e.span > 0 {
+		s.Bootstraps = repeat * (bootstrapsAt(out, e.span) - bootstrapsAt(from, e.span))
+	}
+	e.plan.Steps = append(e.plan.Steps, s)
+	return out
+}
+
+// bootstrapsAt returns the number of bootstraps a ciphertext goes through to
+// reach the given depth when span levels are usable between bootstraps
+func bootstrapsAt(depth, span int) int {
+	if depth <= span {
+		return 0
+	}
+	return (depth - 1) / span
+}
+
+// PlanJob creates an execution plan for a job.
+// Each step carries the multiplicative depth of its output; rotation,
+// bootstrap and memory estimates need a profile, see EstimateJob.
+func PlanJob(job *JobSpec) (*JobPlan, error) {
+	return planJob(job, PlanTarget{})
+}
+
+// EstimateJob plans a job against a table and profile and checks its depth
+// against the profile's level budget. If the job does not fit, the plan is
+// marked as not fitting and recommends a built-in profile that does.
+func EstimateJob(job *JobSpec, target PlanTarget) (*JobPlan, error) {
+	if target.Profile == nil {
+		return nil, fmt.Errorf("a profile is required to estimate job %s", job.ID)
+	}
+	plan, err := planJob(job, target)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Fits {
+		return plan, nil
+	}
+
+	// Re-encrypting with the same profile helps if the table was trimmed or
+	// used up levels
+	for _, candidate := range recommendProfiles {
+		if candidate == target.Profile.Type && plan.InputDepth == 0 {
+			continue
+		}
+		prof, err := params.NewProfile(candidate)
+		if err != nil {
+			return nil, err
+		}
+		alt := target
+		alt.Profile = prof
+		alt.InputDepth = 0 // A re-encrypted table starts at the top level
+		alt.Levels = nil
+		altPlan, err := planJob(job, alt)
+		if err != nil {
+			return nil, err
+		}
+		if altPlan.Fits {
+			plan.Recommended = candidate
+			break
+		}
+	}
+	return plan, nil
+}
+
+func planJob(job *JobSpec, target PlanTarget) (*JobPlan, error) {
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+
+	plan := &JobPlan{Job: job}
+	e := &estimator{plan: plan}
+	blocks := target.BlockCount
+	if blocks < 1 {
+		blocks = 1
+	}
+	if prof := target.Profile; prof != nil {
+		e.logS = bits.Len(uint(prof.Slots - 1))
+		if prof.BootstrapEnabled {
+			e.span = prof.MaxLevel() - prof.Params.LevelsConsumedPerRescaling()
+		}
+	}
+
+	inv, invSqrt := invDepth(1), invDepth(2)
+	conds := len(job.Conditions)
+	var loaded int // Ciphertexts loaded per block
+	inputs := jobInputs(job, target.LookupDEZ)
+
+	switch job.Operation {
+	case OpMean:
+		loaded = 2
+		e.step("load_data", "Load data blocks and validity vectors", 0, 0, 1, 0)
+		sum := e.step("masked_sum", "Compute sum(x * v)", 0, 1, 1, 1)
+		count := e.step("count", "Compute sum(v)", 0, 0, 1, 1)
+		invCount := e.step("inverse", "Compute 1/count via INVNTHSQRT", count, inv, 1, 0)
+		e.step("divide", "Compute mean = sum * invCount", max(sum, invCount), 1, 1, 0)
+	case OpVariance:
+		loaded = 2
+		e.step("load_data", "Load data blocks and validity vectors", 0, 0, 1, 0)
+		mean := e.step("mean", "Compute mean", 0, inv+1, 1, 2)
+		sq := e.step("sum_squares", "Compute sum((x - mean)^2 * v)", mean, 2, 1, 1)
+		invCount := e.step("inverse", "Compute 1/count", 0, inv, 1, 1)
+		e.step("variance", "Compute sum((x - mean)^2 * v) / count", max(sq, invCount), 1, 1, 0)
+	case OpStdev:
+		loaded = 2
+		e.step("load_data", "Load data blocks and validity vectors", 0, 0, 1, 0)
+		variance := e.step("variance", "Compute variance", 0, inv+4, 1, 4)
+		e.step("sqrt", "Compute sqrt(variance) via INVNTHSQRT", variance, invSqrt+1, 1, 0)
+	case OpCorr:
+		loaded = 4
+		e.step("load_data", "Load data blocks for both columns", 0, 0, 1, 0)
+		v := e.step("validity", "Combine validity vectors of X and Y", 0, 1, 1, 0)
+		means := e.step("means", "Compute means of X and Y", v, inv+1, 2, 2)
+		cov := e.step("covariance", "Compute covariance", means, 3, 1, 2)
+		variances := e.step("variances", "Compute variances of X and Y", v, inv+4, 2, 4)
+		e.step("normalize", "Compute cov/(stdevX * stdevY)", max(cov, variances), invSqrt+2, 2, 0)
+	case OpBc:
+		loaded = 1 + conds
+		e.step("load_bmvs", "Load BMV blocks for conditions", 0, 0, 1, 0)
+		mask := e.step("build_mask", "Multiply BMVs to create combined mask", 0, conds, blocks, 0)
+		e.step("sum", "Sum mask values to get count", mask, 0, 1, 1)
+	case OpBa:
+		loaded = 2 + conds
+		e.step("load_data", "Load target column and BMVs", 0, 0, 1, 0)
+		mask := e.step("build_mask", "Build combined mask from conditions", 0, conds, blocks, 0)
+		e.step("mean", "Compute mean with mask as validity", mask, inv+1, 1, 2)
+	case OpBv:
+		loaded = 2 + conds
+		e.step("load_data", "Load target column and BMVs", 0, 0, 1, 0)
+		mask := e.step("build_mask", "Build combined mask from conditions", 0, conds, blocks, 0)
+		e.step("variance", "Compute variance with mask as validity", mask, inv+4, 1, 4)
+	case OpLBc:
+		others := len(job.InputColumns) - 1
+		loaded = 2 + others
+		e.step("load_pbmv", "Load PBMV for primary variable", 0, 0, 1, 0)
+		e.step("load_bbmv", "Load BBMVs for other variables", 0, 0, 1, 0)
+		product := e.step("multiply", "Compute batched products", 0, 1+others, blocks, 0)
+		e.step("pack", "Pack results for DDIA post-processing", product, 0, 1, 0)
+	case OpPercentile:
+		categories := target.Categories[job.InputColumns[0]]
+		if target.Profile != nil && categories < 1 {
+			return nil, fmt.Errorf("unknown category count for column %s", job.InputColumns[0])
+		}
+		loaded = 1 + categories
+		e.step("load_bmvs", "Load BMVs for ordinal column", 0, 0, 1, 0)
+		freq := e.step("frequencies", "Compute frequency for each value", 0, 1, categories, 1)
+		cumul := e.step("cumulative", "Build cumulative histogram", freq, 0, 1, 0)
+		invR := e.step("inverse", "Compute 1/R via INVNTHSQRT", cumul, inv, 1, 0)
+		cmp := e.step("compare", "Compare cumulative/R with k/100", invR, 1+signDepth()+1, categories, 0)
+		e.step("find", "Find first bucket above threshold", cmp, 0, 1, 0)
+	case OpLookup:
+		loaded = 2
+		e.step("load_data", "Load categorical and target columns", 0, 0, 1, 0)
+		var eq int
+		if target.LookupDEZ {
+			eq = e.step("equality", "Compute DISCRETEEQUALZERO(cat - value)", 0, dezDepth(target.LookupBound), blocks, 0)
+		} else {
+			eq = e.step("equality", "Use the precomputed BMV for value", 0, 0, 1, 0)
+		}
+		e.step("select", "Multiply equality indicator by target", eq, 1, blocks, 0)
+	}
+
+	for _, s := range plan.Steps {
+		plan.Depth = max(plan.Depth, s.Depth)
+		plan.Rotations += s.Rotations
+		plan.Bootstraps += s.Bootstraps
+	}
+
+	prof := target.Profile
+	if prof == nil {
+		return plan, nil
+	}
+	plan.Profile = prof.Type
+	stored := schema.EntryLevels{Block: prof.MaxLevel(), Validity: prof.MaxLevel(), BMV: prof.MaxLevel()}
+	if target.Levels != nil {
+		stored = *target.Levels
+	}
+	plan.MaxLevel = inputs.level(stored) - target.InputDepth
+	plan.InputDepth = prof.MaxLevel() - plan.MaxLevel
+	plan.Ciphertexts = loaded * blocks
+	// A ciphertext holds two polynomials of N coefficients per level
+	ctBytes := int64(2 * (1 << prof.LogN) * (max(plan.MaxLevel, 0) + 1) * 8)
+	plan.MemoryBytes = int64(plan.Ciphertexts) * ctBytes
+	plan.BlockBytes = int64(loaded) * ctBytes
+	plan.Fits = prof.BootstrapEnabled || plan.Depth <= plan.MaxLevel
+	return plan, nil
+}
+
+// jobInputs returns the kinds of table ciphertexts a job loads
+func jobInputs(job *JobSpec, lookupDEZ bool) tableInputs {
+	switch job.Operation {
+	case OpMean, OpVariance, OpStdev, OpCorr:
+		return tableInputs{blocks: true, validity: true}
+	case OpBc, OpLBc, OpPercentile:
+		return tableInputs{validity: true, bmvs: true}
+	case OpBa, OpBv:
+		return tableInputs{blocks: true, validity: true, bmvs: true}
+	case OpLookup:
+		return tableInputs{blocks: true, bmvs: !lookupDEZ}
+	}
+	return tableInputs{}
+}
+
+// TableLevels returns the lowest levels a table can be encrypted at for the
+// given jobs to run on it: each kind of ciphertext at the depth of the
+// deepest job that loads it, and kinds no job loads at level 0. Jobs are
+// planned against target with the table at the profile's top level.
+func TableLevels(specs []*JobSpec, target PlanTarget) (schema.EntryLevels, error) {
+	if target.Profile == nil {
+		return schema.EntryLevels{}, fmt.Errorf("a profile is required to plan table levels")
+	}
+	target.InputDepth = 0
+	target.Levels = nil
+	top := target.Profile.MaxLevel()
+
+	var levels schema.EntryLevels
+	for _, job := range specs {
+		plan, err := planJob(job, target)
+		if err != nil {
+			return schema.EntryLevels{}, err
+		}
+		if !plan.Fits {
+			return schema.EntryLevels{}, fmt.Errorf("job %s needs depth %d but profile %s has %d levels", job.ID, plan.Depth, plan.Profile, top)
+		}
+		depth := min(plan.Depth, top) // Deeper jobs bootstrap
+		inputs := jobInputs(job, target.LookupDEZ)
+		if inputs.blocks {
+			levels.Block = max(levels.Block, depth)
+		}
+		if inputs.validity {
+			levels.Validity = max(levels.Validity, depth)
+		}
+		if inputs.bmvs {
+			levels.BMV = max(levels.BMV, depth)
+		}
+	}
+	return levels, nil
+}
+
+// String returns a printable summary of the plan
+func (p *JobPlan) String() string {
+	s := fmt.Sprintf("Plan for job %s (%s)\n", p.Job.ID, p.Job.Operation)
+	for i, step := range p.Steps {
+		s += fmt.Sprintf("  %d. %-12s depth %3d  rotations %4d  bootstraps %3d  %s\n",
+			i+1, step.Name, step.Depth, step.Rotations, step.Bootstraps, step.Description)
+	}
+	if p.Profile == "" {
+		return s + fmt.Sprintf("Total depth: %d\n", p.Depth)
+	}
+	levels := fmt.Sprintf("%d levels", p.MaxLevel)
+	if p.InputDepth > 0 {
+		levels += fmt.Sprintf(" (%d more trimmed or used by the table)", p.InputDepth)
+	}
+	s += fmt.Sprintf("Total: depth %d of %s on profile %s, %d rotations, %d bootstraps, %d ciphertexts (~%.1f MiB, ~%.1f MiB per block)\n",
+		p.Depth, levels, p.Profile, p.Rotations, p.Bootstraps, p.Ciphertexts, float64(p.MemoryBytes)/(1<<20), float64(p.BlockBytes)/(1<<20))
+	if p.Fits {
+		return s + "Fits the level budget\n"
+	}
+	s += fmt.Sprintf("Does not fit: needs %d levels but profile %s has %s and no bootstrapping\n", p.Depth, p.Profile, levels)
+	if p.Recommended != "" {
+		s += fmt.Sprintf("Recommended profile: %s (re-encrypt the table with it)\n", p.Recommended)
+	}
+	return s
+}
@@ -0,0 +1,354 @@
+// Package manifest provides signed manifests of encrypted tables: the data
+// owner signs the hash of every stored entry with Ed25519, so that consumers
+// can verify a table against a keyring of trusted owners before using it.
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hkanpak21/lattigostats/pkg/storage"
+)
+
+// File is the name of the signed manifest in a table
+const File = "manifest.json"
+
+// Version is the current manifest format version
+const Version = 1
+
+// Entry is the SHA-256 of one stored entry of the table
+type Entry struct {
+	storage.EntryKey
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists every entry of a table except the manifest itself.
+// A merged table records the signed manifests of the tables it was built from.
+// The manifest embeds the metadata it lists, which verified readers use
+// instead of metadata.json: writing the manifest then commits an update of a
+// signed table in one write.
+type Manifest struct {
+	Version  int       `json:"version"`
+	Owner    string    `json:"owner"` // Signer: the DataOwnerID of the table
+	Table    string    `json:"table"`
+	Created  time.Time `json:"created"`
+	Entries  []Entry   `json:"entries"`
+	Sources  []Signed  `json:"sources,omitempty"`
+	Metadata []byte    `json:"metadata,omitempty"`
+}
+
+// Signed is a manifest with the Ed25519 signature of its compact JSON
+// encoding, so that indenting the manifest file does not break the signature
+type Signed struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signer    string          `json:"signer"`
+	Signature string          `json:"signature"`
+}
+
+// PrivateKey is a signing key with the ID of its owner
+type PrivateKey struct {
+	ID  string             `json:"id"`
+	Key ed25519.PrivateKey `json:"private_key"`
+}
+
+// PublicKey is a verification key with the ID of its owner
+type PublicKey struct {
+	ID  string            `json:"id"`
+	Key ed25519.PublicKey `json:"public_key"`
+}
+
+// Keyring maps owner IDs to their trusted public keys
+type Keyring map[string]ed25519.PublicKey
+
+// GenerateKey creates a signing key for an owner
+func GenerateKey(id string) (*PrivateKey, error) {
+	if id == "" {
+		return nil, fmt.Errorf("key ID is required")
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return &PrivateKey{ID: id, Key: key}, nil
+}
+
+// Public returns the public key of k
+func (k *PrivateKey) Public() PublicKey {
+	return PublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// SavePrivateKey writes a signing key readable only by its owner
+func SavePrivateKey(path string, k *PrivateKey) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadPrivateKey reads a signing key
+func LoadPrivateKey(path string) (*PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	var k PrivateKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	if k.ID == "" || len(k.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("signing key %s is malformed", path)
+	}
+	return &k, nil
+}
+
+// SavePublicKey writes a public key
+func SavePublicKey(path string, k PublicKey) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadKeyring reads every *.pub public key in dir
+func LoadKeyring(dir string) (Keyring, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+	kr := make(Keyring)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		var k PublicKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %w", file, err)
+		}
+		if k.ID == "" || len(k.Key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("public key %s is malformed", file)
+		}
+		if _, ok := kr[k.ID]; ok {
+			return nil, fmt.Errorf("keyring %s has two keys for %q", dir, k.ID)
+		}
+		kr[k.ID] = k.Key
+	}
+	if len(kr) == 0 {
+		return nil, fmt.Errorf("keyring %s has no public keys", dir)
+	}
+	return kr, nil
+}
+
+// Build hashes every entry of a table except its manifest
+func Build(store *storage.TableStore, owner, table string, sources []Signed) (*Manifest, error) {
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Version: Version,
+		Owner:   owner,
+		Table:   table,
+		Created: time.Now().UTC(),
+		Sources: sources,
+	}
+	for _, key := range keys {
+		if isManifest(key) {
+			continue
+		}
+		data, err := store.LoadRaw(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", key, err)
+		}
+		sum := sha256.Sum256(data)
+		m.Entries = append(m.Entries, Entry{EntryKey: key, SHA256: hex.EncodeToString(sum[:])})
+		if key.Kind == storage.KindFile && key.Column == storage.MetadataFile {
+			m.Metadata = data
+		}
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].String() < m.Entries[j].String() })
+	return m, nil
+}
+
+// Sign signs a manifest; the key must belong to the manifest's owner
+func Sign(m *Manifest, key *PrivateKey) (*Signed, error) {
+	if key.ID != m.Owner {
+		return nil, fmt.Errorf("key of %q cannot sign a table owned by %q", key.ID, m.Owner)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return &Signed{
+		Manifest:  data,
+		Signer:    key.ID,
+		Signature: hex.EncodeToString(ed25519.Sign(key.Key, data)),
+	}, nil
+}
+
+// SignTable builds, signs and stores the manifest of a table
+func SignTable(store *storage.TableStore, key *PrivateKey, table string, sources []Signed) (*Signed, error) {
+	m, err := Build(store, key.ID, table, sources)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Sign(m, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed manifest: %w", err)
+	}
+	if err := store.SaveFile(File, data); err != nil {
+		return nil, fmt.Errorf("failed to save manifest: %w", err)
+	}
+	return s, nil
+}
+
+// Load reads the signed manifest of a table
+func Load(store *storage.TableStore) (*Signed, error) {
+	data, err := store.LoadFile(File)
+	if err != nil {
+		return nil, fmt.Errorf("table has no signed manifest: %w", err)
+	}
+	var s Signed
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &s, nil
+}
+
+// Verify checks the signature of s, and those of the source manifests it
+// records, against the keyring and returns the manifest
+func (kr Keyring) Verify(s *Signed) (*Manifest, error) {
+	pub, ok := kr[s.Signer]
+	if !ok {
+		return nil, fmt.Errorf("manifest is signed by %q, who is not in the keyring", s.Signer)
+	}
+	var data bytes.Buffer
+	if err := json.Compact(&data, s.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	sig, err := hex.DecodeString(s.Signature)
+	if err != nil || !ed25519.Verify(pub, data.Bytes(), sig) {
+		return nil, fmt.Errorf("invalid signature by %q on the manifest", s.Signer)
+	}
+	var m Manifest
+	if err := json.Unmarshal(s.Manifest, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	if m.Owner != s.Signer {
+		return nil, fmt.Errorf("manifest of %q is signed by %q", m.Owner, s.Signer)
+	}
+	for i := range m.Sources {
+		if _, err := kr.Verify(&m.Sources[i]); err != nil {
+			return nil, fmt.Errorf("source %d of the manifest: %w", i, err)
+		}
+	}
+	return &m, nil
+}
+
+// VerifyTable checks the table's manifest against the keyring and that the
+// table holds exactly the listed entries. Entries of generations newer than
+// any the manifest lists belong to an update that has not committed yet and
+// are ignored. The store then reads the metadata the manifest embeds and
+// checks every entry against its hash as it is read, so entries swapped
+// after verification are refused too.
+func (kr Keyring) VerifyTable(store *storage.TableStore) (*Signed, *Manifest, error) {
+	s, err := Load(store)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := kr.Verify(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make(map[storage.EntryKey]string, len(m.Entries))
+	newest := 0
+	for _, e := range m.Entries {
+		hashes[e.EntryKey] = e.SHA256
+		if e.Kind != storage.KindFile {
+			newest = max(newest, e.Gen)
+		}
+	}
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, nil, err
+	}
+	present := make(map[storage.EntryKey]bool, len(keys))
+	var extra []string
+	for _, key := range keys {
+		present[key] = true
+		if key.Kind != storage.KindFile && key.Gen > newest {
+			continue
+		}
+		if _, ok := hashes[key]; !ok && !isManifest(key) {
+			extra = append(extra, key.String())
+		}
+	}
+	if len(extra) > 0 {
+		return nil, nil, fmt.Errorf("table has entries not in its manifest: %s", strings.Join(extra, ", "))
+	}
+	for _, e := range m.Entries {
+		if !present[e.EntryKey] {
+			return nil, nil, fmt.Errorf("table is missing %s listed in its manifest", e.EntryKey)
+		}
+	}
+	store.Expect(hashes)
+	if m.Metadata != nil {
+		store.SetFile(storage.MetadataFile, m.Metadata)
+	}
+	return s, m, nil
+}
+
+// CheckAll reads every entry of a verified table, returning an error per
+// entry that does not match its hash
+func CheckAll(store *storage.TableStore, m *Manifest) []error {
+	var errs []error
+	for _, e := range m.Entries {
+		if _, err := store.LoadRaw(e.EntryKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func isManifest(key storage.EntryKey) bool {
+	return key.Kind == storage.KindFile && key.Column == File
+}
+
+// Digest returns the SHA-256 of the signed manifest, identifying the exact
+// table version it describes
+func (s *Signed) Digest() string {
+	var data bytes.Buffer
+	if err := json.Compact(&data, s.Manifest); err != nil {
+		data.Write(s.Manifest)
+	}
+	sum := sha256.Sum256(data.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckOwner returns an error if the manifest was not signed by the table's owner
+func (m *Manifest) CheckOwner(dataOwnerID string) error {
+	if m.Owner != dataOwnerID {
+		return fmt.Errorf("table of owner %q is signed by %q", dataOwnerID, m.Owner)
+	}
+	return nil
+}
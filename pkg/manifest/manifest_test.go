@@ -0,0 +1,212 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/storage"
+)
+
+func testTable(t *testing.T) *storage.TableStore {
+	t.Helper()
+	store, err := storage.NewTableStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		storage.MetadataFile: `{"data_owner_id":"owner1"}`,
+		"join_mask_0.json":   `[1,0,1]`,
+	} {
+		if err := store.SaveFile(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func testKeyring(t *testing.T, keys ...*PrivateKey) Keyring {
+	t.Helper()
+	dir := t.TempDir()
+	for _, k := range keys {
+		if err := SavePublicKey(filepath.Join(dir, k.ID+".pub"), k.Public()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kr, err := LoadKeyring(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestSignAndVerifyTable(t *testing.T) {
+	key, err := GenerateKey("owner1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "owner1.key")
+	if err := SavePrivateKey(keyPath, key); err != nil {
+		t.Fatal(err)
+	}
+	if key, err = LoadPrivateKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	store := testTable(t)
+	if _, err := SignTable(store, key, "t", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := storage.OpenTableStore(store.BasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := testKeyring(t, key).VerifyTable(reopened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Entries) != 2 || m.Owner != "owner1" || m.Table != "t" {
+		t.Errorf("unexpected manifest %+v", m)
+	}
+	if err := m.CheckOwner("owner1"); err != nil {
+		t.Error(err)
+	}
+	if err := m.CheckOwner("owner2"); err == nil {
+		t.Error("CheckOwner should fail for another owner")
+	}
+	if errs := CheckAll(reopened, m); len(errs) != 0 {
+		t.Errorf("CheckAll failed: %v", errs)
+	}
+
+	// Entries changed after verification are refused when read
+	os.WriteFile(filepath.Join(store.BasePath, "join_mask_0.json"), []byte(`[1,1,1]`), 0644)
+	if _, err := reopened.LoadFile("join_mask_0.json"); err == nil {
+		t.Error("LoadFile should refuse an entry that does not match the manifest")
+	}
+}
+
+func TestVerifyTableRejectsTampering(t *testing.T) {
+	key, _ := GenerateKey("owner1")
+	other, _ := GenerateKey("owner2")
+
+	store := testTable(t)
+	if _, err := SignTable(store, key, "t", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := testKeyring(t, other).VerifyTable(store); err == nil {
+		t.Error("VerifyTable should fail for an untrusted signer")
+	}
+
+	kr := testKeyring(t, key)
+	os.WriteFile(filepath.Join(store.BasePath, "extra.json"), []byte(`{}`), 0644)
+	if _, _, err := kr.VerifyTable(store); err == nil {
+		t.Error("VerifyTable should fail for an entry not in the manifest")
+	}
+	os.Remove(filepath.Join(store.BasePath, "extra.json"))
+	os.Remove(filepath.Join(store.BasePath, "join_mask_0.json"))
+	if _, _, err := kr.VerifyTable(store); err == nil {
+		t.Error("VerifyTable should fail for a missing entry")
+	}
+
+	// A manifest edited after signing no longer verifies
+	s, err := Load(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	json.Unmarshal(s.Manifest, &m)
+	m.Table = "forged"
+	s.Manifest, _ = json.Marshal(m)
+	if _, err := kr.Verify(s); err == nil {
+		t.Error("Verify should fail for a modified manifest")
+	}
+
+	if _, err := Sign(&m, other); err == nil {
+		t.Error("Sign should refuse a key of another owner")
+	}
+}
+
+func TestVerifyChecksSources(t *testing.T) {
+	owner, _ := GenerateKey("owner1")
+	dma, _ := GenerateKey("dma")
+
+	src, err := SignTable(testTable(t), owner, "t", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := SignTable(testTable(t), dma, "merged", []Signed{*src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := testKeyring(t, owner, dma).Verify(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sources) != 1 || m.Sources[0].Signer != "owner1" || m.Sources[0].Digest() != src.Digest() {
+		t.Errorf("unexpected sources %+v", m.Sources)
+	}
+	if _, err := testKeyring(t, dma).Verify(merged); err == nil {
+		t.Error("Verify should fail if a source signer is not trusted")
+	}
+}
+
+// TestVerifyTableDuringUpdate follows an update of a signed table: blocks of
+// the next generation are written first, then the manifest embedding the new
+// metadata, then metadata.json. A reader verifying at any point sees one
+// consistent version.
+func TestVerifyTableDuringUpdate(t *testing.T) {
+	key, _ := GenerateKey("owner1")
+	kr := testKeyring(t, key)
+	store := testTable(t)
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(store.BasePath, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verify := func(wantMeta string) {
+		t.Helper()
+		reader, err := storage.OpenTableStore(store.BasePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := kr.VerifyTable(reader); err != nil {
+			t.Fatalf("VerifyTable failed: %v", err)
+		}
+		if meta, err := reader.LoadFile(storage.MetadataFile); err != nil || string(meta) != wantMeta {
+			t.Errorf("reader sees metadata %s (%v), want %s", meta, err, wantMeta)
+		}
+	}
+
+	oldMeta, newMeta := `{"data_owner_id":"owner1"}`, `{"data_owner_id":"owner1","generation":1}`
+	write("blocks/x_0.bin", "block 0")
+	if _, err := SignTable(store, key, "t", nil); err != nil {
+		t.Fatal(err)
+	}
+	verify(oldMeta)
+
+	// Blocks of the uncommitted generation are ignored, but extra blocks of a
+	// committed one are not
+	write("blocks/x_0.g1.bin", "block 0, generation 1")
+	verify(oldMeta)
+	write("blocks/x_1.bin", "block 1")
+	if _, _, err := kr.VerifyTable(store); err == nil {
+		t.Error("VerifyTable should fail for an extra block of a signed generation")
+	}
+	os.Remove(filepath.Join(store.BasePath, "blocks/x_1.bin"))
+
+	// Replacing the manifest commits the update before metadata.json is written
+	store.SetFile(storage.MetadataFile, []byte(newMeta))
+	if _, err := SignTable(store, key, "t", nil); err != nil {
+		t.Fatal(err)
+	}
+	verify(newMeta)
+	write(storage.MetadataFile, newMeta)
+	verify(newMeta)
+
+	// A metadata.json swapped after signing is not read by verified readers
+	write(storage.MetadataFile, oldMeta)
+	verify(newMeta)
+}
@@ -6,6 +6,7 @@ package approx
 import (
 	"fmt"
 	"math"
+	"math/bits"
 
 	"github.com/hkanpak21/lattigostats/pkg/he"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
@@ -13,65 +14,69 @@ import (
 
 // ApproxOp provides approximate HE operations
 type ApproxOp struct {
-	eval *he.Evaluator
+	eval he.Backend
 }
 
 // NewApproxOp creates a new approximation operations handler
-func NewApproxOp(eval *he.Evaluator) *ApproxOp {
+func NewApproxOp(eval he.Backend) *ApproxOp {
 	return &ApproxOp{eval: eval}
 }
 
 // DEZConfig configures DISCRETEEQUALZERO
 type DEZConfig struct {
-	Sf      int     // Number of categories (determines normalization)
-	K       int     // Precision parameter (number of sinc iterations)
-	FilterP float64 // Filter polynomial exponent
+	Bound  int // Largest |x| of the inputs (determines normalization)
+	Degree int // Chebyshev degree of the polynomial approximating the indicator
 }
 
-// DefaultDEZConfig returns default DEZ configuration
-func DefaultDEZConfig(Sf int) DEZConfig {
-	d := int(math.Ceil(math.Log2(float64(Sf))))
-	k := 2 * d // heuristic from paper
+// DefaultDEZConfig returns default DEZ configuration for inputs in [-bound,
+// bound]: with d the smallest integer such that 2^d > bound, degree
+// 4*2^d - 1, at least 31, matches the indicator to 1e-4 at every integer of
+// (-2^d, 2^d). Up to a bound of 7, e.g. a lookup over 7 categories coded
+// from 1 with missing cells stored as 0, that is 6 levels with the
+// normalization, leaving one for a product on Profile A2; larger domains
+// need a level per doubling.
+func DefaultDEZConfig(bound int) DEZConfig {
 	return DEZConfig{
-		Sf:      Sf,
-		K:       k,
-		FilterP: 4, // p(s) = 4s^3 - 3s^4
+		Bound:  bound,
+		Degree: max(4<<dezBits(bound), 32) - 1,
 	}
 }
 
+// dezBits returns d, the smallest non-negative integer such that 2^d > bound
+func dezBits(bound int) int {
+	return bits.Len(uint(max(bound, 0)))
+}
+
 // ChebyshevCoeffs stores precomputed Chebyshev coefficients
 type ChebyshevCoeffs struct {
-	Coeffs []float64
-	Degree int
+	Coeffs   []float64
+	Degree   int
+	Interval he.Interval // Domain of the approximation; [-1, 1] if zero
 }
 
-// ComputeCosCoeffs computes Chebyshev coefficients for cos(πx) on [-1,1]
-func ComputeCosCoeffs(degree int) *ChebyshevCoeffs {
-	coeffs := make([]float64, degree+1)
-
-	// cos(πx) Chebyshev expansion
-	// We'll use the truncated Taylor/Chebyshev series
-	// cos(πx) ≈ Σ c_k T_k(x)
-	n := degree + 1
-	for k := 0; k <= degree; k++ {
-		sum := 0.0
-		for j := 0; j < n; j++ {
-			x := math.Cos(math.Pi * (float64(j) + 0.5) / float64(n))
-			fx := math.Cos(math.Pi * x)
-			Tk := math.Cos(float64(k) * math.Acos(x))
-			sum += fx * Tk
-		}
-		coeffs[k] = 2.0 * sum / float64(n)
-		if k == 0 {
-			coeffs[k] /= 2.0
-		}
+// domain returns the interval the coefficients approximate on
+func (c *ChebyshevCoeffs) domain() he.Interval {
+	if c.Interval == (he.Interval{}) {
+		return he.DefaultInterval
 	}
+	return c.Interval
+}
 
-	return &ChebyshevCoeffs{Coeffs: coeffs, Degree: degree}
+// Evaluate evaluates the polynomial on a plaintext value (for validation)
+func (c *ChebyshevCoeffs) Evaluate(x float64) float64 {
+	interval := c.domain()
+	y := (2*x - interval.A - interval.B) / (interval.B - interval.A)
+	// Clenshaw's recurrence
+	var b1, b2 float64
+	for k := c.Degree; k >= 1; k-- {
+		b1, b2 = 2*y*b1-b2+c.Coeffs[k], b1
+	}
+	return y*b1 - b2 + c.Coeffs[0]
 }
 
-// ComputeSincCoeffs computes Chebyshev coefficients for sinc(x) = sin(πx)/(πx)
-func ComputeSincCoeffs(degree int) *ChebyshevCoeffs {
+// ComputeChebyshevCoeffs interpolates f on an interval at the degree+1
+// Chebyshev nodes
+func ComputeChebyshevCoeffs(f func(float64) float64, degree int, interval he.Interval) *ChebyshevCoeffs {
 	coeffs := make([]float64, degree+1)
 
 	n := degree + 1
@@ -79,12 +84,7 @@ func ComputeSincCoeffs(degree int) *ChebyshevCoeffs {
 		sum := 0.0
 		for j := 0; j < n; j++ {
 			x := math.Cos(math.Pi * (float64(j) + 0.5) / float64(n))
-			var fx float64
-			if math.Abs(x) < 1e-10 {
-				fx = 1.0 // sinc(0) = 1
-			} else {
-				fx = math.Sin(math.Pi*x) / (math.Pi * x)
-			}
+			fx := f((x*(interval.B-interval.A) + interval.A + interval.B) / 2)
 			Tk := math.Cos(float64(k) * math.Acos(x))
 			sum += fx * Tk
 		}
@@ -94,183 +94,63 @@ func ComputeSincCoeffs(degree int) *ChebyshevCoeffs {
 		}
 	}
 
-	return &ChebyshevCoeffs{Coeffs: coeffs, Degree: degree}
+	return &ChebyshevCoeffs{Coeffs: coeffs, Degree: degree, Interval: interval}
 }
 
-// EvaluateChebyshev evaluates a Chebyshev polynomial on a ciphertext
-// Uses standard polynomial form converted from Chebyshev coefficients
-func (a *ApproxOp) EvaluateChebyshev(x *rlwe.Ciphertext, coeffs *ChebyshevCoeffs) (*rlwe.Ciphertext, error) {
-	if coeffs.Degree == 0 {
-		return a.eval.AddConst(x, complex(coeffs.Coeffs[0], 0))
-	}
-
-	// Build power cache: x^1, x^2, ..., x^degree using binary powering
-	powers := make([]*rlwe.Ciphertext, coeffs.Degree+1)
-	powers[1] = x.CopyNew()
-
-	for i := 2; i <= coeffs.Degree; i++ {
-		var err error
-		if i%2 == 0 {
-			// x^i = x^(i/2) * x^(i/2)
-			half := i / 2
-			powers[i], err = a.eval.Mul(powers[half], powers[half])
-			if err != nil {
-				return nil, fmt.Errorf("power %d mul failed: %w", i, err)
-			}
-		} else {
-			// x^i = x^(i-1) * x
-			powers[i], err = a.eval.Mul(powers[i-1], powers[1])
-			if err != nil {
-				return nil, fmt.Errorf("power %d mul failed: %w", i, err)
-			}
-		}
-		powers[i], err = a.eval.Rescale(powers[i])
-		if err != nil {
-			return nil, fmt.Errorf("power %d rescale failed: %w", i, err)
-		}
-
-		// Bootstrap if needed
-		powers[i], err = a.eval.MaybeBootstrap(powers[i])
-		if err != nil {
-			return nil, fmt.Errorf("power %d bootstrap failed: %w", i, err)
-		}
-	}
-
-	// Convert Chebyshev to standard polynomial form
-	stdCoeffs := chebyshevToStandard(coeffs.Coeffs)
-
-	// Evaluate: c_0 + c_1*x + c_2*x^2 + ...
-	// Start with c_1*x (first non-constant term)
-	var result *rlwe.Ciphertext
-	for k := 1; k < len(stdCoeffs); k++ {
-		if math.Abs(stdCoeffs[k]) < 1e-15 {
-			continue
-		}
-		term, err := a.eval.MulConst(powers[k], complex(stdCoeffs[k], 0))
-		if err != nil {
-			return nil, fmt.Errorf("term %d mul const failed: %w", k, err)
-		}
-
-		if result == nil {
-			result = term
-		} else {
-			err = a.eval.AddInPlace(result, term)
-			if err != nil {
-				return nil, fmt.Errorf("term %d add failed: %w", k, err)
-			}
-		}
-	}
-
-	// Add constant term c_0
-	if result == nil {
-		// No non-constant terms, just return constant
-		return a.eval.AddConst(x, complex(stdCoeffs[0]-1, 0))
-	}
-	return a.eval.AddConst(result, complex(stdCoeffs[0], 0))
+// ComputeCosCoeffs computes Chebyshev coefficients for cos(πx) on [-1,1]
+func ComputeCosCoeffs(degree int) *ChebyshevCoeffs {
+	return ComputeChebyshevCoeffs(func(x float64) float64 {
+		return math.Cos(math.Pi * x)
+	}, degree, he.DefaultInterval)
 }
 
-// chebyshevToStandard converts Chebyshev coefficients to standard polynomial
-func chebyshevToStandard(cheb []float64) []float64 {
-	n := len(cheb)
-	if n == 0 {
-		return []float64{}
-	}
-
-	// T_0 = 1
-	// T_1 = x
-	// T_n = 2xT_{n-1} - T_{n-2}
-	// Build matrix and solve
-
-	std := make([]float64, n)
-
-	// Simple approach: use recursion for T_k
-	// T_k expressed in x^j coefficients
-	T := make([][]float64, n)
-	for k := range T {
-		T[k] = make([]float64, n)
-	}
+// ComputeSincCoeffs computes Chebyshev coefficients for sinc(x) = sin(πx)/(πx)
+func ComputeSincCoeffs(degree int) *ChebyshevCoeffs {
+	return ComputeChebyshevCoeffs(sinc, degree, he.DefaultInterval)
+}
 
-	// T_0 = 1
-	T[0][0] = 1
-	if n > 1 {
-		// T_1 = x
-		T[1][1] = 1
+// sinc returns sin(πx)/(πx)
+func sinc(x float64) float64 {
+	if math.Abs(x) < 1e-10 {
+		return 1.0 // sinc(0) = 1
 	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
 
-	// T_k = 2x*T_{k-1} - T_{k-2}
-	for k := 2; k < n; k++ {
-		for j := 0; j < n; j++ {
-			if j > 0 {
-				T[k][j] += 2 * T[k-1][j-1]
-			}
-			T[k][j] -= T[k-2][j]
-		}
-	}
+// EvaluateChebyshev evaluates a Chebyshev polynomial on a ciphertext in the
+// Chebyshev basis, consuming he.ChebyshevDepth levels
+func (a *ApproxOp) EvaluateChebyshev(x *rlwe.Ciphertext, coeffs *ChebyshevCoeffs) (*rlwe.Ciphertext, error) {
+	return a.eval.EvaluateChebyshev(x, coeffs.Coeffs[:coeffs.Degree+1], coeffs.domain())
+}
 
-	// std = Σ cheb[k] * T[k]
-	for k := 0; k < n; k++ {
-		for j := 0; j < n; j++ {
-			std[j] += cheb[k] * T[k][j]
+// DEZCoeffs returns the Chebyshev interpolant on [-2^d, 2^d], 2^d >
+// config.Bound, of the product of cos(pi x / 2^i) for i = 1..d. The
+// product is sin(pi x) / (2^d sin(pi x / 2^d)), the truncated sinc
+// expansion: 1 at zero and zero at every other integer of (-2^d, 2^d),
+// with a slope of at most about 1 there, so noise on the input is not
+// amplified. At x = ±2^d it is ±1, which is why 2^d must exceed the bound.
+func DEZCoeffs(config DEZConfig) *ChebyshevCoeffs {
+	d := float64(dezBits(config.Bound))
+	bound := math.Exp2(d)
+	return ComputeChebyshevCoeffs(func(x float64) float64 {
+		p := 1.0
+		for i := 1.0; i <= d; i++ {
+			p *= math.Cos(math.Pi * x / math.Exp2(i))
 		}
-	}
-
-	return std
+		return p
+	}, config.Degree, he.Interval{A: -bound, B: bound})
 }
 
 // DISCRETEEQUALZERO computes an indicator function: ~1 if x==0 (integer), ~0 otherwise
-// Based on the paper's sinc-based approach with filtering
+// Based on the paper's sinc-based approach: the normalization and the
+// truncated sinc product are evaluated as one Chebyshev polynomial, in
+// he.ChebyshevDepth(config.Degree) levels
 func (a *ApproxOp) DISCRETEEQUALZERO(x *rlwe.Ciphertext, config DEZConfig) (*rlwe.Ciphertext, error) {
-	// Step 1: Normalize x -> x / 2^d where d = ceil(log2(Sf))
-	d := int(math.Ceil(math.Log2(float64(config.Sf))))
-	scale := 1.0 / math.Pow(2, float64(d))
-
-	normalized, err := a.eval.MulConst(x, complex(scale, 0))
-	if err != nil {
-		return nil, fmt.Errorf("normalize failed: %w", err)
-	}
-
-	// Bootstrap before expensive computation
-	normalized, err = a.eval.MaybeBootstrap(normalized)
-	if err != nil {
-		return nil, fmt.Errorf("normalize bootstrap failed: %w", err)
-	}
-
-	// Step 2: Compute sinc approximation using cos double-angle recursion
-	// sinc(x) = sin(πx)/(πx) ≈ product of cos terms via double-angle
-	// Or use Chebyshev approximation directly
-
-	// For simplicity, use Chebyshev polynomial approximation of sinc
-	sincCoeffs := ComputeSincCoeffs(16) // degree 16 approximation
-
-	sinc, err := a.EvaluateChebyshev(normalized, sincCoeffs)
+	defer a.eval.Span("DISCRETEEQUALZERO")()
+	result, err := a.EvaluateChebyshev(x, DEZCoeffs(config))
 	if err != nil {
-		return nil, fmt.Errorf("sinc eval failed: %w", err)
+		return nil, fmt.Errorf("DEZ polynomial failed: %w", err)
 	}
-
-	// Step 3: Apply sinc^K to sharpen the peak
-	result := sinc
-	for i := 1; i < config.K; i++ {
-		result, err = a.eval.Mul(result, sinc)
-		if err != nil {
-			return nil, fmt.Errorf("sinc^%d mul failed: %w", i+1, err)
-		}
-		result, err = a.eval.Rescale(result)
-		if err != nil {
-			return nil, fmt.Errorf("sinc^%d rescale failed: %w", i+1, err)
-		}
-		result, err = a.eval.MaybeBootstrap(result)
-		if err != nil {
-			return nil, fmt.Errorf("sinc^%d bootstrap failed: %w", i+1, err)
-		}
-	}
-
-	// Step 4: Apply filter polynomial p(s) = 4s^3 - 3s^4 to map to [0,1]
-	// This sharpens the indicator
-	result, err = a.ApplyFilterPolynomial(result)
-	if err != nil {
-		return nil, fmt.Errorf("filter failed: %w", err)
-	}
-
 	return result, nil
 }
 
@@ -318,72 +198,42 @@ func (a *ApproxOp) ApplyFilterPolynomial(s *rlwe.Ciphertext) (*rlwe.Ciphertext,
 
 // ApproxSignConfig configures the approximate sign function
 type ApproxSignConfig struct {
-	Degree     int // Polynomial degree for approximation
-	Iterations int // Refinement iterations
+	Iterations int // Refinement iterations; the polynomial has degree 3^Iterations
 }
 
 // DefaultApproxSignConfig returns default APPROXSIGN configuration
 func DefaultApproxSignConfig() ApproxSignConfig {
 	return ApproxSignConfig{
-		Degree:     15,
 		Iterations: 3,
 	}
 }
 
-// APPROXSIGN computes an approximate sign function
-// Returns ~-1 for x < 0, ~0 for x ≈ 0, ~+1 for x > 0
-func (a *ApproxOp) APPROXSIGN(x *rlwe.Ciphertext, config ApproxSignConfig) (*rlwe.Ciphertext, error) {
-	// Use polynomial approximation of sign function
-	// A common approach: iterate s <- s * (3 - s^2) / 2 starting from x/||x||
-
-	// For bounded input [-1, 1], we can use Chebyshev approximation of sign
-	result := x.CopyNew()
-
-	// Iterative refinement: s_{n+1} = s_n * (3 - s_n^2) / 2
+// SignCoeffs returns the Chebyshev coefficients on [-1, 1] of the
+// refinement s <- s * (3 - s^2) / 2 iterated config.Iterations times, a
+// polynomial of degree 3^Iterations that the interpolation reproduces exactly
+func SignCoeffs(config ApproxSignConfig) *ChebyshevCoeffs {
+	degree := 1
 	for i := 0; i < config.Iterations; i++ {
-		// s^2
-		s2, err := a.eval.Mul(result, result)
-		if err != nil {
-			return nil, fmt.Errorf("iter %d s^2 failed: %w", i, err)
-		}
-		s2, err = a.eval.Rescale(s2)
-		if err != nil {
-			return nil, fmt.Errorf("iter %d s^2 rescale failed: %w", i, err)
-		}
-
-		// 3 - s^2
-		threeMinusS2, err := a.eval.MulConst(s2, complex(-1, 0))
-		if err != nil {
-			return nil, fmt.Errorf("iter %d -s^2 failed: %w", i, err)
-		}
-		threeMinusS2, err = a.eval.AddConst(threeMinusS2, complex(3, 0))
-		if err != nil {
-			return nil, fmt.Errorf("iter %d 3-s^2 failed: %w", i, err)
-		}
-
-		// s * (3 - s^2)
-		result, err = a.eval.Mul(result, threeMinusS2)
-		if err != nil {
-			return nil, fmt.Errorf("iter %d mul failed: %w", i, err)
-		}
-		result, err = a.eval.Rescale(result)
-		if err != nil {
-			return nil, fmt.Errorf("iter %d rescale failed: %w", i, err)
-		}
-
-		// / 2
-		result, err = a.eval.MulConst(result, complex(0.5, 0))
-		if err != nil {
-			return nil, fmt.Errorf("iter %d /2 failed: %w", i, err)
+		degree *= 3
+	}
+	return ComputeChebyshevCoeffs(func(s float64) float64 {
+		for i := 0; i < config.Iterations; i++ {
+			s = s * (3 - s*s) / 2
 		}
+		return s
+	}, degree, he.DefaultInterval)
+}
 
-		// Bootstrap if needed
-		result, err = a.eval.MaybeBootstrap(result)
-		if err != nil {
-			return nil, fmt.Errorf("iter %d bootstrap failed: %w", i, err)
-		}
+// APPROXSIGN computes an approximate sign function
+// Returns ~-1 for x < 0, ~0 for x ≈ 0, ~+1 for x > 0
+// The iterations are evaluated as one polynomial, in
+// he.PolynomialDepth(3^Iterations) levels rather than two per iteration
+func (a *ApproxOp) APPROXSIGN(x *rlwe.Ciphertext, config ApproxSignConfig) (*rlwe.Ciphertext, error) {
+	defer a.eval.Span("APPROXSIGN")()
+	result, err := a.EvaluateChebyshev(x, SignCoeffs(config))
+	if err != nil {
+		return nil, fmt.Errorf("sign polynomial failed: %w", err)
 	}
-
 	return result, nil
 }
 
@@ -451,7 +301,7 @@ func (a *ApproxOp) TableLookup(
 }
 
 // PlaintextDEZ computes discrete equality to zero (for validation)
-func PlaintextDEZ(x float64, Sf int) float64 {
+func PlaintextDEZ(x float64) float64 {
 	// Returns 1 if x rounds to 0, 0 otherwise
 	if math.Abs(x) < 0.5 {
 		return 1.0
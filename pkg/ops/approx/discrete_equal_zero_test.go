@@ -0,0 +1,139 @@
+package approx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// newSimulator returns an exact simulator of Profile A2, which lookups up to
+// a bound of 7 fit
+func newSimulator(t *testing.T) *he.Simulator {
+	t.Helper()
+	profile, err := params.NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+	return he.NewSimulator(profile.Params, he.SimulatorConfig{})
+}
+
+func TestDEZMatchesPlaintext(t *testing.T) {
+	// Profile B2 has the levels of the larger domains without bootstrapping
+	profile, err := params.NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+	sim := he.NewSimulator(profile.Params, he.SimulatorConfig{})
+	// Bounds that are powers of two check the ends of the domain, where the
+	// product of cosines is ±1
+	for _, bound := range []int{-1, 0, 1, 2, 5, 8, 30, 64} {
+		x := make([]float64, 2*max(bound, 0)+1)
+		for i := range x {
+			x[i] = float64(i - max(bound, 0))
+		}
+		ct, err := sim.Encrypt(x)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := DefaultDEZConfig(bound)
+		eq, err := NewApproxOp(sim).DISCRETEEQUALZERO(ct, config)
+		if err != nil {
+			t.Fatalf("DISCRETEEQUALZERO up to %d failed: %v", bound, err)
+		}
+		coeffs := DEZCoeffs(config)
+		if want, levels := he.ChebyshevDepth(config.Degree, coeffs.Interval), ct.Level()-eq.Level(); levels != want {
+			t.Errorf("expected DEZ up to %d to consume %d levels, consumed %d", bound, want, levels)
+		}
+		got, err := sim.Decrypt(eq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, xi := range x {
+			if want := PlaintextDEZ(xi); math.Abs(got[i]-want) > 1e-3 {
+				t.Errorf("DEZ(%.0f) up to %d: expected %.0f, got %.6f", xi, bound, want, got[i])
+			}
+		}
+	}
+
+	// Up to a bound of 7 the lookup's product still fits Profile A2
+	sim = newSimulator(t)
+	ct, err := sim.Encrypt([]float64{0, 1, -7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq, err := NewApproxOp(sim).DISCRETEEQUALZERO(ct, DefaultDEZConfig(7))
+	if err != nil {
+		t.Fatalf("DISCRETEEQUALZERO failed: %v", err)
+	}
+	if eq.Level() < 1 {
+		t.Errorf("DEZ up to 7 should leave a level on Profile A2, left %d", eq.Level())
+	}
+}
+
+// TestDEZLookupMissingCells looks up every code of a column of 8 categories
+// coded from 1, whose missing cells are stored as 0: the difference of a
+// missing cell and code 8 is -8, the end of a domain sized by the category
+// count alone
+func TestDEZLookupMissingCells(t *testing.T) {
+	profile, err := params.NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+	sim := he.NewSimulator(profile.Params, he.SimulatorConfig{})
+	col := schema.Column{Name: "c", Type: schema.Categorical, CategoryCount: 8}
+	cells := []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 0}
+	ct, err := sim.Encrypt(cells)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultDEZConfig(col.LookupBound())
+	for _, code := range col.Codes() {
+		shifted, err := sim.AddConst(ct, complex(float64(-code), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eq, err := NewApproxOp(sim).DISCRETEEQUALZERO(shifted, config)
+		if err != nil {
+			t.Fatalf("DISCRETEEQUALZERO failed: %v", err)
+		}
+		got, err := sim.Decrypt(eq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, cell := range cells {
+			want := 0.0
+			if int(cell) == code {
+				want = 1
+			}
+			if math.Abs(got[i]-want) > 1e-3 {
+				t.Errorf("lookup of code %d on cell %.0f: expected %.0f, got %.6f", code, cell, want, got[i])
+			}
+		}
+	}
+}
+
+func TestApproxSignMatchesPlaintext(t *testing.T) {
+	sim := newSimulator(t)
+	x := []float64{-1, -0.8, -0.6, -0.5, 0.5, 0.6, 0.8, 1}
+	ct, err := sim.Encrypt(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := NewApproxOp(sim).APPROXSIGN(ct, DefaultApproxSignConfig())
+	if err != nil {
+		t.Fatalf("APPROXSIGN failed: %v", err)
+	}
+	got, err := sim.Decrypt(sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, xi := range x {
+		if want := PlaintextSign(xi); math.Abs(got[i]-want) > 5e-2 {
+			t.Errorf("sign(%.1f): expected %.0f, got %.4f", xi, want, got[i])
+		}
+	}
+}
@@ -14,12 +14,12 @@ import (
 
 // CategoricalOp computes categorical statistics on encrypted data
 type CategoricalOp struct {
-	eval      *he.Evaluator
+	eval      he.Backend
 	numericOp *numeric.NumericOp
 }
 
 // NewCategoricalOp creates a new categorical operations handler
-func NewCategoricalOp(eval *he.Evaluator) *CategoricalOp {
+func NewCategoricalOp(eval he.Backend) *CategoricalOp {
 	return &CategoricalOp{
 		eval:      eval,
 		numericOp: numeric.NewNumericOp(eval),
@@ -50,34 +50,127 @@ func (c *CategoricalOp) BuildMask(
 	blockCount := len(validityBlocks)
 	masks := make([]*rlwe.Ciphertext, blockCount)
 
-	for b := 0; b < blockCount; b++ {
-		// Start with validity mask
-		mask := validityBlocks[b].CopyNew()
-
-		// Multiply by each condition's BMV
-		for _, cond := range conditions {
-			bmv, err := bmvStore.GetBMV(cond.ColumnName, cond.Value, b)
+	err := c.eval.Parallel(blockCount, func(w he.Backend, b int) error {
+		bmvs := make([]*rlwe.Ciphertext, len(conditions))
+		for i, cond := range conditions {
+			var err error
+			bmvs[i], err = bmvStore.GetBMV(cond.ColumnName, cond.Value, b)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get BMV for %s=%d block %d: %w",
+				return fmt.Errorf("failed to get BMV for %s=%d block %d: %w",
 					cond.ColumnName, cond.Value, b, err)
 			}
-
-			mask, err = c.eval.Mul(mask, bmv)
-			if err != nil {
-				return nil, fmt.Errorf("block %d mul failed: %w", b, err)
-			}
-			mask, err = c.eval.Rescale(mask)
-			if err != nil {
-				return nil, fmt.Errorf("block %d rescale failed: %w", b, err)
-			}
 		}
-
+		mask, err := c.worker(w).mask(b, validityBlocks[b], bmvs)
+		if err != nil {
+			return err
+		}
 		masks[b] = mask
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return masks, nil
 }
 
+// worker returns the operation bound to the evaluator of a worker
+func (c *CategoricalOp) worker(eval he.Backend) *CategoricalOp {
+	if eval == c.eval {
+		return c
+	}
+	return NewCategoricalOp(eval)
+}
+
+// mask multiplies the validity block v of block b by the BMV block of
+// every condition
+func (c *CategoricalOp) mask(b int, v *rlwe.Ciphertext, bmvs []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	// Start with validity mask
+	mask := v.CopyNew()
+	for _, bmv := range bmvs {
+		var err error
+		mask, err = c.eval.Mul(mask, bmv)
+		if err != nil {
+			return nil, fmt.Errorf("block %d mul failed: %w", b, err)
+		}
+		mask, err = c.eval.Rescale(mask)
+		if err != nil {
+			return nil, fmt.Errorf("block %d rescale failed: %w", b, err)
+		}
+	}
+	return mask, nil
+}
+
+// maskStream turns a stream of [data..., v, bmv_1, ..., bmv_k] blocks into
+// [data..., mask] blocks, building each mask as its block is read
+type maskStream struct {
+	c       *CategoricalOp
+	src     numeric.BlockStream
+	bmvs    int
+	current int
+}
+
+// MaskStream returns a stream of [data..., mask] blocks from a stream of
+// [data..., v, bmv_1, ..., bmv_k] blocks, where bmv_i is the BMV block of
+// conditions[i]: the streaming form of BuildMask. It is a StagedStream, so
+// masks are built by the workers the blocks are evaluated on.
+func (c *CategoricalOp) MaskStream(src numeric.BlockStream, conditions []Condition) numeric.StagedStream {
+	return &maskStream{c: c, src: src, bmvs: len(conditions)}
+}
+
+func (m *maskStream) HasNext() bool {
+	return m.src.HasNext()
+}
+
+func (m *maskStream) Next() ([]*rlwe.Ciphertext, error) {
+	block, err := m.src.Next()
+	if err != nil {
+		return nil, err
+	}
+	m.current++
+	return m.Stage(m.c.eval, m.current-1, block)
+}
+
+// Source returns the stream of [data..., v, bmv_1, ..., bmv_k] blocks
+func (m *maskStream) Source() numeric.BlockStream {
+	return m.src
+}
+
+// Stage builds the mask of block b with eval
+func (m *maskStream) Stage(eval he.Backend, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+	vIndex := len(block) - m.bmvs - 1
+	if vIndex < 0 {
+		return nil, fmt.Errorf("block %d has %d ciphertexts, expected a validity block and %d BMVs",
+			b, len(block), m.bmvs)
+	}
+	mask, err := m.c.worker(eval).mask(b, block[vIndex], block[vIndex+1:])
+	if err != nil {
+		return nil, err
+	}
+	return append(block[:vIndex:vIndex], mask), nil
+}
+
+func (m *maskStream) Reset() {
+	m.src.Reset()
+	m.current = 0
+}
+
+// StreamBc computes bin-count over a stream of [v, bmv_1, ..., bmv_k] blocks
+func (c *CategoricalOp) StreamBc(src numeric.BlockStream, conditions []Condition) (*rlwe.Ciphertext, error) {
+	return c.numericOp.StreamCount(c.MaskStream(src, conditions))
+}
+
+// StreamBa computes bin-average over a stream of [x, v, bmv_1, ..., bmv_k] blocks
+func (c *CategoricalOp) StreamBa(src numeric.BlockStream, conditions []Condition) (*rlwe.Ciphertext, error) {
+	return c.numericOp.StreamMean(c.MaskStream(src, conditions))
+}
+
+// StreamBv computes bin-variance over a stream of [x, v, bmv_1, ..., bmv_k]
+// blocks; masks are rebuilt in the second pass rather than kept in memory
+func (c *CategoricalOp) StreamBv(src numeric.BlockStream, conditions []Condition) (*rlwe.Ciphertext, error) {
+	return c.numericOp.StreamVariance(c.MaskStream(src, conditions))
+}
+
 // Bc computes bin-count: count of rows matching all conditions
 func (c *CategoricalOp) Bc(
 	validityBlocks []*rlwe.Ciphertext,
@@ -249,12 +342,12 @@ func (b *BBMVEncoder) EncodeBBMVForValue(values []int, want int) []float64 {
 
 // LBcComputer computes Large-Bin-Count using PBMV/BBMV
 type LBcComputer struct {
-	eval   *he.Evaluator
+	eval   he.Backend
 	config LBcConfig
 }
 
 // NewLBcComputer creates a new LBc computer
-func NewLBcComputer(eval *he.Evaluator, config LBcConfig) *LBcComputer {
+func NewLBcComputer(eval he.Backend, config LBcConfig) *LBcComputer {
 	return &LBcComputer{
 		eval:   eval,
 		config: config,
@@ -297,9 +390,9 @@ func (l *LBcComputer) ComputeLBc(
 	validityBlocks []*rlwe.Ciphertext,
 ) (*LBcResult, error) {
 	blockCount := pbmvStore.BlockCount()
-	results := make([]*rlwe.Ciphertext, blockCount)
 
-	for b := 0; b < blockCount; b++ {
+	// Sum the per-block products across blocks
+	packed, err := l.eval.MapSum(blockCount, func(w he.Backend, b int) (*rlwe.Ciphertext, error) {
 		// Get PBMV for primary variable
 		pbmv, err := pbmvStore.GetPBMV(f0Column, b)
 		if err != nil {
@@ -310,11 +403,11 @@ func (l *LBcComputer) ComputeLBc(
 		result := pbmv.CopyNew()
 
 		// Multiply by validity
-		result, err = l.eval.Mul(result, validityBlocks[b])
+		result, err = w.Mul(result, validityBlocks[b])
 		if err != nil {
 			return nil, fmt.Errorf("block %d validity mul failed: %w", b, err)
 		}
-		result, err = l.eval.Rescale(result)
+		result, err = w.Rescale(result)
 		if err != nil {
 			return nil, fmt.Errorf("block %d validity rescale failed: %w", b, err)
 		}
@@ -330,32 +423,40 @@ func (l *LBcComputer) ComputeLBc(
 				return nil, fmt.Errorf("failed to get BBMV for %s block %d: %w", col, b, err)
 			}
 
-			result, err = l.eval.Mul(result, bbmv)
+			result, err = w.Mul(result, bbmv)
 			if err != nil {
 				return nil, fmt.Errorf("block %d %s mul failed: %w", b, col, err)
 			}
-			result, err = l.eval.Rescale(result)
+			result, err = w.Rescale(result)
 			if err != nil {
 				return nil, fmt.Errorf("block %d %s rescale failed: %w", b, col, err)
 			}
 		}
 
-		results[b] = result
+		return result, nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return l.result(packed, blockCount), nil
+}
 
-	// Sum across blocks
-	var packed *rlwe.Ciphertext
-	for i, r := range results {
-		if packed == nil {
-			packed = r.CopyNew()
-		} else {
-			err := l.eval.AddInPlace(packed, r)
-			if err != nil {
-				return nil, fmt.Errorf("block %d sum failed: %w", i, err)
-			}
-		}
+// StreamLBc computes Large-Bin-Count over a stream of blockCount blocks of
+// [pbmv, v, bbmv_1, ..., bbmv_m], where pbmv is the PBMV block of the
+// primary variable and bbmv_i the BBMV block of the i-th other variable: the
+// streaming form of ComputeLBc. The BBMVs are multiplied into the validity
+// as masks are, by the workers the blocks are evaluated on.
+func (l *LBcComputer) StreamLBc(src numeric.BlockStream, masks, blockCount int) (*LBcResult, error) {
+	c := NewCategoricalOp(l.eval)
+	packed, err := c.numericOp.StreamMaskedSlots(&maskStream{c: c, src: src, bmvs: masks})
+	if err != nil {
+		return nil, err
 	}
+	return l.result(packed, blockCount), nil
+}
 
+// result wraps the packed products of blockCount blocks
+func (l *LBcComputer) result(packed *rlwe.Ciphertext, blockCount int) *LBcResult {
 	slots := l.eval.Slots()
 	rowsPerBlock := slots // simplified
 	requiresAgg := blockCount*rowsPerBlock > slots*(1<<l.config.Delta)
@@ -365,7 +466,28 @@ func (l *LBcComputer) ComputeLBc(
 		NumBlocks:           blockCount,
 		RowsPerBlock:        rowsPerBlock,
 		RequiresAggregation: requiresAgg,
-	}, nil
+	}
+}
+
+// DecodeLBc recovers the per-category counts of the primary variable from
+// the decrypted slots of an LBc result, whose rows were multiplied by masks
+// BBMVs: summed over the slots, category v contributes its count times
+// 2^(δ + Δ*(v-1) + Λ*masks). Counts must stay below 2^Δ.
+func DecodeLBc(values []float64, categories, masks int, config LBcConfig) []float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	total = math.Ldexp(total, -(config.DeltaOffset + config.LambdaBig*masks))
+
+	field := math.Ldexp(1.0, config.Delta)
+	counts := make([]float64, categories)
+	for v := categories - 1; v >= 0; v-- {
+		weight := math.Ldexp(1.0, config.Delta*v)
+		counts[v] = math.Max(0, math.Min(math.Round(total/weight), field-1))
+		total -= counts[v] * weight
+	}
+	return counts
 }
 
 // PlaintextBc computes bin-count from plaintext (for validation)
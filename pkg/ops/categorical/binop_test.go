@@ -0,0 +1,184 @@
+package categorical
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+func TestBinOpsMatchPlaintext(t *testing.T) {
+	profile, err := params.NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+	sim := he.NewSimulator(profile.Params, he.SimulatorConfig{
+		Bootstrapping:  profile.BootstrapEnabled,
+		Noise:          1e-9,
+		BootstrapNoise: 1e-7,
+		Seed:           1,
+	})
+
+	rows := sim.Slots() + 500
+	target := make([]float64, rows)
+	v := make([]float64, rows)
+	valid := make([]bool, rows)
+	region := make([]int, rows)
+	bmv := make([]float64, rows)
+	for i := range target {
+		target[i] = float64(i*53%97) / 100
+		valid[i] = i%5 != 0
+		if valid[i] {
+			v[i] = 1
+		}
+		region[i] = i%3 + 1
+		if region[i] == 2 {
+			bmv[i] = 1
+		}
+	}
+	encrypt := func(column []float64) []*rlwe.Ciphertext {
+		blocks, err := sim.EncryptBlocks(column)
+		if err != nil {
+			t.Fatalf("EncryptBlocks failed: %v", err)
+		}
+		return blocks
+	}
+	xs, vs, bmvs := encrypt(target), encrypt(v), encrypt(bmv)
+
+	c := NewCategoricalOp(sim)
+	conditions := []Condition{{ColumnName: "region", Value: 2}}
+	values, codes := [][]int{region}, []int{2}
+	tests := []struct {
+		name    string
+		run     func(s numeric.BlockStream) (*rlwe.Ciphertext, error)
+		columns [][]*rlwe.Ciphertext
+		want    float64
+	}{
+		{"bc", func(s numeric.BlockStream) (*rlwe.Ciphertext, error) { return c.StreamBc(s, conditions) },
+			[][]*rlwe.Ciphertext{vs, bmvs}, float64(PlaintextBc(values, codes, valid))},
+		{"ba", func(s numeric.BlockStream) (*rlwe.Ciphertext, error) { return c.StreamBa(s, conditions) },
+			[][]*rlwe.Ciphertext{xs, vs, bmvs}, PlaintextBa(target, values, codes, valid)},
+		{"bv", func(s numeric.BlockStream) (*rlwe.Ciphertext, error) { return c.StreamBv(s, conditions) },
+			[][]*rlwe.Ciphertext{xs, vs, bmvs}, PlaintextBv(target, values, codes, valid)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := numeric.SliceStream(tt.columns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ct, err := tt.run(s)
+			if err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+			got, err := sim.Decrypt(ct)
+			if err != nil {
+				t.Fatalf("Decrypt failed: %v", err)
+			}
+			if math.Abs(got[0]-tt.want) > 1e-4*math.Max(1, math.Abs(tt.want)) {
+				t.Errorf("expected %.6f, got %.6f", tt.want, got[0])
+			}
+		})
+	}
+}
+
+type mapPBMVStore map[int]*rlwe.Ciphertext
+
+func (m mapPBMVStore) GetPBMV(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
+	return m[blockIndex], nil
+}
+
+func (m mapPBMVStore) BlockCount() int {
+	return len(m)
+}
+
+type mapBBMVStore map[int]*rlwe.Ciphertext
+
+func (m mapBBMVStore) GetBBMV(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
+	return m[blockIndex], nil
+}
+
+func (m mapBBMVStore) BlockCount() int {
+	return len(m)
+}
+
+func TestDecodeLBc(t *testing.T) {
+	profile, err := params.NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+	sim := he.NewSimulator(profile.Params, he.SimulatorConfig{Noise: 1e-9, Seed: 1})
+	config := DefaultLBcConfig()
+
+	rows := sim.Slots() + 100
+	risk := make([]int, rows)
+	region := make([]int, rows)
+	v := make([]float64, rows)
+	valid := make([]bool, rows)
+	for i := range risk {
+		risk[i] = i%3 + 1
+		region[i] = i%4 + 1
+		valid[i] = i%7 != 0
+		if valid[i] {
+			v[i] = 1
+		}
+	}
+	// Only a few rows per category, since counts must stay below 2^Δ
+	for i := 200; i < rows; i++ {
+		v[i], valid[i] = 0, false
+	}
+
+	pbmvs, bbmvs, vs := mapPBMVStore{}, mapBBMVStore{}, make([]*rlwe.Ciphertext, 0)
+	pbmvEnc := NewPBMVEncoder(3, sim.Slots(), config)
+	bbmvEnc := NewBBMVEncoder(sim.Slots(), config)
+	for b := 0; b*sim.Slots() < rows; b++ {
+		start, end := b*sim.Slots(), min((b+1)*sim.Slots(), rows)
+		encrypt := func(values []float64) *rlwe.Ciphertext {
+			blocks, err := sim.EncryptBlocks(values)
+			if err != nil {
+				t.Fatalf("EncryptBlocks failed: %v", err)
+			}
+			return blocks[0]
+		}
+		pbmvs[b] = encrypt(pbmvEnc.EncodePBMV(risk[start:end]))
+		bbmvs[b] = encrypt(bbmvEnc.EncodeBBMVForValue(region[start:end], 2))
+		vs = append(vs, encrypt(v[start:end]))
+	}
+
+	result, err := NewLBcComputer(sim, config).ComputeLBc("risk", pbmvs, []string{"region"}, map[string]BBMVStore{"region": bbmvs}, vs)
+	if err != nil {
+		t.Fatalf("ComputeLBc failed: %v", err)
+	}
+
+	// The streaming form reads the same blocks as [pbmv, v, bbmv]
+	pbmvBlocks, bbmvBlocks := make([]*rlwe.Ciphertext, len(vs)), make([]*rlwe.Ciphertext, len(vs))
+	for b := range vs {
+		pbmvBlocks[b], bbmvBlocks[b] = pbmvs[b], bbmvs[b]
+	}
+	s, err := numeric.SliceStream(pbmvBlocks, vs, bbmvBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := NewLBcComputer(sim, config).StreamLBc(s, 1, len(vs))
+	if err != nil {
+		t.Fatalf("StreamLBc failed: %v", err)
+	}
+
+	for name, r := range map[string]*LBcResult{"ComputeLBc": result, "StreamLBc": streamed} {
+		values, err := sim.Decrypt(r.PackedResults[0])
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+
+		counts := DecodeLBc(values, 3, 1, config)
+		for c := 1; c <= 3; c++ {
+			want := PlaintextBc([][]int{risk, region}, []int{c, 2}, valid)
+			if int(counts[c-1]) != want {
+				t.Errorf("%s: category %d: expected count %d, got %g", name, c, want, counts[c-1])
+			}
+		}
+	}
+}
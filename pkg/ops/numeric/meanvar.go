@@ -12,70 +12,34 @@ import (
 
 // NumericOp computes numerical statistics on encrypted data
 type NumericOp struct {
-	eval *he.Evaluator
+	eval he.Backend
+
+	// InvSqrtConfig is used by Stdev and Correlation to compute 1/sqrt(variance)
+	InvSqrtConfig INVNTHSQRTConfig
 }
 
 // NewNumericOp creates a new numeric operations handler
-func NewNumericOp(eval *he.Evaluator) *NumericOp {
-	return &NumericOp{eval: eval}
+func NewNumericOp(eval he.Backend) *NumericOp {
+	return &NumericOp{eval: eval, InvSqrtConfig: DefaultINVSQRTConfig()}
 }
 
 // MaskedSum computes sum(x * v) across blocks
 // x: data blocks, v: validity/mask blocks
 func (n *NumericOp) MaskedSum(xBlocks, vBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	if len(xBlocks) != len(vBlocks) {
-		return nil, fmt.Errorf("block count mismatch: %d vs %d", len(xBlocks), len(vBlocks))
-	}
-	if len(xBlocks) == 0 {
-		return nil, fmt.Errorf("no blocks provided")
-	}
-
-	var result *rlwe.Ciphertext
-	for i := range xBlocks {
-		// Multiply x * v
-		masked, err := n.eval.Mul(xBlocks[i], vBlocks[i])
-		if err != nil {
-			return nil, fmt.Errorf("block %d mul failed: %w", i, err)
-		}
-		masked, err = n.eval.Rescale(masked)
-		if err != nil {
-			return nil, fmt.Errorf("block %d rescale failed: %w", i, err)
-		}
-
-		if result == nil {
-			result = masked
-		} else {
-			err = n.eval.AddInPlace(result, masked)
-			if err != nil {
-				return nil, fmt.Errorf("block %d add failed: %w", i, err)
-			}
-		}
+	s, err := SliceStream(xBlocks, vBlocks)
+	if err != nil {
+		return nil, err
 	}
-
-	// Sum across slots
-	return n.eval.SumSlots(result)
+	return n.StreamMaskedSum(s)
 }
 
 // Count computes sum(v) - the count of valid entries
 func (n *NumericOp) Count(vBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	if len(vBlocks) == 0 {
-		return nil, fmt.Errorf("no blocks provided")
-	}
-
-	var result *rlwe.Ciphertext
-	for i, v := range vBlocks {
-		if result == nil {
-			result = v.CopyNew()
-		} else {
-			err := n.eval.AddInPlace(result, v)
-			if err != nil {
-				return nil, fmt.Errorf("block %d add failed: %w", i, err)
-			}
-		}
+	s, err := SliceStream(vBlocks)
+	if err != nil {
+		return nil, err
 	}
-
-	// Sum across slots
-	return n.eval.SumSlots(result)
+	return n.StreamCount(s)
 }
 
 // MaskedSumOfSquares computes sum(x^2 * v)
@@ -87,36 +51,30 @@ func (n *NumericOp) MaskedSumOfSquares(xBlocks, vBlocks []*rlwe.Ciphertext) (*rl
 		return nil, fmt.Errorf("no blocks provided")
 	}
 
-	var result *rlwe.Ciphertext
-	for i := range xBlocks {
+	result, err := n.eval.MapSum(len(xBlocks), func(w he.Backend, i int) (*rlwe.Ciphertext, error) {
 		// Compute x^2
-		xSquared, err := n.eval.Mul(xBlocks[i], xBlocks[i])
+		xSquared, err := w.Mul(xBlocks[i], xBlocks[i])
 		if err != nil {
 			return nil, fmt.Errorf("block %d square failed: %w", i, err)
 		}
-		xSquared, err = n.eval.Rescale(xSquared)
+		xSquared, err = w.Rescale(xSquared)
 		if err != nil {
 			return nil, fmt.Errorf("block %d rescale1 failed: %w", i, err)
 		}
 
 		// Multiply by validity
-		masked, err := n.eval.Mul(xSquared, vBlocks[i])
+		masked, err := w.Mul(xSquared, vBlocks[i])
 		if err != nil {
 			return nil, fmt.Errorf("block %d mask failed: %w", i, err)
 		}
-		masked, err = n.eval.Rescale(masked)
+		masked, err = w.Rescale(masked)
 		if err != nil {
 			return nil, fmt.Errorf("block %d rescale2 failed: %w", i, err)
 		}
-
-		if result == nil {
-			result = masked
-		} else {
-			err = n.eval.AddInPlace(result, masked)
-			if err != nil {
-				return nil, fmt.Errorf("block %d add failed: %w", i, err)
-			}
-		}
+		return masked, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return n.eval.SumSlots(result)
@@ -150,6 +108,15 @@ func DefaultINVSQRTConfig() INVNTHSQRTConfig {
 	}
 }
 
+// NormalizedINVSQRTConfig returns the inverse sqrt config for variances of
+// columns normalized to [0, 1]: the variance is at most 0.25, so 1/sqrt(var)
+// is at least 2 and Newton iteration converges from y0 = 1 for var < 3
+func NormalizedINVSQRTConfig() INVNTHSQRTConfig {
+	config := DefaultINVSQRTConfig()
+	config.InitialGuess = 1
+	return config
+}
+
 // INVNTHSQRT computes x^(-1/n) using Newton iteration
 // For n=1: computes 1/x
 // For n=2: computes 1/sqrt(x)
@@ -158,6 +125,8 @@ func (n *NumericOp) INVNTHSQRT(x *rlwe.Ciphertext, config INVNTHSQRTConfig) (*rl
 	if config.N < 1 {
 		return nil, fmt.Errorf("n must be positive")
 	}
+	// Closing the span also closes that of an iteration left by an error
+	defer n.eval.Span("INVNTHSQRT")()
 
 	// Bootstrap x if needed at start
 	var err error
@@ -166,10 +135,18 @@ func (n *NumericOp) INVNTHSQRT(x *rlwe.Ciphertext, config INVNTHSQRTConfig) (*rl
 		return nil, fmt.Errorf("initial bootstrap failed: %w", err)
 	}
 
-	// Initialize y with constant
-	nPlusOne := float64(config.N + 1)
-	nFloat := float64(config.N)
-	invN := 1.0 / nFloat
+	// Iterate y <- y * ((n+1)/n - (x/n) * y^n) with x/n computed once: a
+	// product by the non-integer 1/n in every iteration would raise the
+	// scale by a prime that no rescale takes out again
+	nPlusOne := float64(config.N+1) / float64(config.N)
+	if config.N > 1 {
+		if x, err = n.eval.MulConst(x, complex(1/float64(config.N), 0)); err != nil {
+			return nil, fmt.Errorf("x/n failed: %w", err)
+		}
+		if x, err = n.eval.Rescale(x); err != nil {
+			return nil, fmt.Errorf("x/n rescale failed: %w", err)
+		}
+	}
 
 	// Initialize y as a ciphertext containing the initial guess in all slots
 	// Method: Create a zero ciphertext from x, then add the constant
@@ -181,6 +158,8 @@ func (n *NumericOp) INVNTHSQRT(x *rlwe.Ciphertext, config INVNTHSQRTConfig) (*rl
 
 	// Newton iteration
 	for iter := 0; iter < config.Iterations; iter++ {
+		endIter := n.eval.Span(fmt.Sprintf("iter %d", iter))
+
 		// Maybe bootstrap
 		if config.BootstrapFrequency > 0 && iter > 0 && iter%config.BootstrapFrequency == 0 {
 			if n.eval.NeedsBootstrap(yCt) {
@@ -217,37 +196,32 @@ func (n *NumericOp) INVNTHSQRT(x *rlwe.Ciphertext, config INVNTHSQRTConfig) (*rl
 			return nil, fmt.Errorf("iteration %d bootstrap xyN failed: %w", iter, err)
 		}
 
-		// Compute (n+1) - x*y^n
+		// Compute (n+1)/n - (x/n)*y^n
 		diff, err := n.eval.AddConst(xyN, complex(-nPlusOne, 0))
 		if err != nil {
 			return nil, fmt.Errorf("iteration %d sub failed: %w", iter, err)
 		}
-		// Negate: we want (n+1) - x*y^n = -((x*y^n) - (n+1))
+		// Negate: we want (n+1)/n - (x/n)*y^n = -(((x/n)*y^n) - (n+1)/n)
 		diff, err = n.eval.MulConst(diff, -1)
 		if err != nil {
 			return nil, fmt.Errorf("iteration %d negate failed: %w", iter, err)
 		}
 
-		// Compute y * ((n+1) - x*y^n)
-		yNew, err := n.eval.Mul(yCt, diff)
+		// y = y * ((n+1)/n - (x/n)*y^n)
+		yCt, err = n.eval.Mul(yCt, diff)
 		if err != nil {
 			return nil, fmt.Errorf("iteration %d mul y*diff failed: %w", iter, err)
 		}
-		yNew, err = n.eval.Rescale(yNew)
+		yCt, err = n.eval.Rescale(yCt)
 		if err != nil {
 			return nil, fmt.Errorf("iteration %d final rescale failed: %w", iter, err)
 		}
 		// Bootstrap after final rescale if needed
-		yNew, err = n.eval.MaybeBootstrap(yNew)
-		if err != nil {
-			return nil, fmt.Errorf("iteration %d bootstrap yNew failed: %w", iter, err)
-		}
-
-		// Divide by n: y = y * ((n+1) - x*y^n) / n
-		yCt, err = n.eval.MulConst(yNew, complex(invN, 0))
+		yCt, err = n.eval.MaybeBootstrap(yCt)
 		if err != nil {
-			return nil, fmt.Errorf("iteration %d div by n failed: %w", iter, err)
+			return nil, fmt.Errorf("iteration %d bootstrap y failed: %w", iter, err)
 		}
+		endIter()
 	}
 
 	return yCt, nil
@@ -256,30 +230,11 @@ func (n *NumericOp) INVNTHSQRT(x *rlwe.Ciphertext, config INVNTHSQRTConfig) (*rl
 // Mean computes the mean of x given validity mask v
 // mean = sum(x * v) / sum(v)
 func (n *NumericOp) Mean(xBlocks, vBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	// Compute sum(x * v)
-	sumXV, err := n.MaskedSum(xBlocks, vBlocks)
-	if err != nil {
-		return nil, fmt.Errorf("masked sum failed: %w", err)
-	}
-
-	// Compute count = sum(v)
-	count, err := n.Count(vBlocks)
+	s, err := SliceStream(xBlocks, vBlocks)
 	if err != nil {
-		return nil, fmt.Errorf("count failed: %w", err)
-	}
-
-	// Compute 1/count using INVNTHSQRT
-	invCount, err := n.INVNTHSQRT(count, DefaultINVConfig())
-	if err != nil {
-		return nil, fmt.Errorf("inverse count failed: %w", err)
-	}
-
-	// mean = sum * invCount
-	mean, err := n.eval.Mul(sumXV, invCount)
-	if err != nil {
-		return nil, fmt.Errorf("mean mul failed: %w", err)
+		return nil, err
 	}
-	return n.eval.Rescale(mean)
+	return n.StreamMean(s)
 }
 
 // Variance computes the variance of x given validity mask v
@@ -287,97 +242,20 @@ func (n *NumericOp) Mean(xBlocks, vBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext,
 //
 //	= sum(x^2 * v) / sum(v) - mean^2
 func (n *NumericOp) Variance(xBlocks, vBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	// Compute mean first
-	mean, err := n.Mean(xBlocks, vBlocks)
-	if err != nil {
-		return nil, fmt.Errorf("mean failed: %w", err)
-	}
-
-	// Stable two-pass variance: sum((x - mean)^2 * v) / sum(v)
-	var sumSqDiffV *rlwe.Ciphertext
-	for i := range xBlocks {
-		// diff = x - mean
-		diff, err := n.eval.Sub(xBlocks[i], mean)
-		if err != nil {
-			return nil, fmt.Errorf("block %d sub failed: %w", i, err)
-		}
-
-		// diffSq = diff^2
-		diffSq, err := n.eval.Mul(diff, diff)
-		if err != nil {
-			return nil, fmt.Errorf("block %d mul sq failed: %w", i, err)
-		}
-		diffSq, err = n.eval.Rescale(diffSq)
-		if err != nil {
-			return nil, fmt.Errorf("block %d rescale sq failed: %w", i, err)
-		}
-
-		// masked = diffSq * v
-		masked, err := n.eval.Mul(diffSq, vBlocks[i])
-		if err != nil {
-			return nil, fmt.Errorf("block %d mask failed: %w", i, err)
-		}
-		masked, err = n.eval.Rescale(masked)
-		if err != nil {
-			return nil, fmt.Errorf("block %d masked rescale failed: %w", i, err)
-		}
-
-		if sumSqDiffV == nil {
-			sumSqDiffV = masked
-		} else {
-			err = n.eval.AddInPlace(sumSqDiffV, masked)
-			if err != nil {
-				return nil, fmt.Errorf("block %d add failed: %w", i, err)
-			}
-		}
-	}
-
-	// sum across slots
-	sum, err := n.eval.SumSlots(sumSqDiffV)
-	if err != nil {
-		return nil, fmt.Errorf("sum slots failed: %w", err)
-	}
-
-	// Divide by count
-	count, err := n.Count(vBlocks)
-	if err != nil {
-		return nil, fmt.Errorf("count failed: %w", err)
-	}
-	invCount, err := n.INVNTHSQRT(count, DefaultINVConfig())
+	s, err := SliceStream(xBlocks, vBlocks)
 	if err != nil {
-		return nil, fmt.Errorf("inv count failed: %w", err)
-	}
-
-	variance, err := n.eval.Mul(sum, invCount)
-	if err != nil {
-		return nil, fmt.Errorf("variance mul failed: %w", err)
+		return nil, err
 	}
-	return n.eval.Rescale(variance)
+	return n.StreamVariance(s)
 }
 
 // Stdev computes the standard deviation (sqrt of variance)
 func (n *NumericOp) Stdev(xBlocks, vBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	// Compute variance
-	variance, err := n.Variance(xBlocks, vBlocks)
+	s, err := SliceStream(xBlocks, vBlocks)
 	if err != nil {
-		return nil, fmt.Errorf("variance failed: %w", err)
-	}
-
-	// Compute 1/sqrt(var) then invert using multiplication
-	// Actually, stdev = sqrt(var) = var * (1/sqrt(var)) is circular
-	// We need: stdev = sqrt(var)
-	// Use: 1/sqrt(var) via INVNTHSQRT with n=2, then compute var * (1/sqrt(var)) = sqrt(var)
-	invSqrt, err := n.INVNTHSQRT(variance, DefaultINVSQRTConfig())
-	if err != nil {
-		return nil, fmt.Errorf("inv sqrt variance failed: %w", err)
-	}
-
-	// stdev = var * (1/sqrt(var)) = sqrt(var)
-	stdev, err := n.eval.Mul(variance, invSqrt)
-	if err != nil {
-		return nil, fmt.Errorf("stdev mul failed: %w", err)
+		return nil, err
 	}
-	return n.eval.Rescale(stdev)
+	return n.StreamStdev(s)
 }
 
 // MaskedCrossSum computes sum(x * y * v)
@@ -389,36 +267,30 @@ func (n *NumericOp) MaskedCrossSum(xBlocks, yBlocks, vBlocks []*rlwe.Ciphertext)
 		return nil, fmt.Errorf("no blocks provided")
 	}
 
-	var result *rlwe.Ciphertext
-	for i := range xBlocks {
+	result, err := n.eval.MapSum(len(xBlocks), func(w he.Backend, i int) (*rlwe.Ciphertext, error) {
 		// Compute x * y
-		xy, err := n.eval.Mul(xBlocks[i], yBlocks[i])
+		xy, err := w.Mul(xBlocks[i], yBlocks[i])
 		if err != nil {
 			return nil, fmt.Errorf("block %d xy mul failed: %w", i, err)
 		}
-		xy, err = n.eval.Rescale(xy)
+		xy, err = w.Rescale(xy)
 		if err != nil {
 			return nil, fmt.Errorf("block %d xy rescale failed: %w", i, err)
 		}
 
 		// Multiply by validity
-		masked, err := n.eval.Mul(xy, vBlocks[i])
+		masked, err := w.Mul(xy, vBlocks[i])
 		if err != nil {
 			return nil, fmt.Errorf("block %d mask failed: %w", i, err)
 		}
-		masked, err = n.eval.Rescale(masked)
+		masked, err = w.Rescale(masked)
 		if err != nil {
 			return nil, fmt.Errorf("block %d masked rescale failed: %w", i, err)
 		}
-
-		if result == nil {
-			result = masked
-		} else {
-			err = n.eval.AddInPlace(result, masked)
-			if err != nil {
-				return nil, fmt.Errorf("block %d add failed: %w", i, err)
-			}
-		}
+		return masked, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return n.eval.SumSlots(result)
@@ -428,112 +300,11 @@ func (n *NumericOp) MaskedCrossSum(xBlocks, yBlocks, vBlocks []*rlwe.Ciphertext)
 // corr = cov(x,y) / (stdev(x) * stdev(y))
 // cov(x,y) = sum((x - meanX)*(y - meanY) * vX * vY) / sum(vX * vY)
 func (n *NumericOp) Correlation(xBlocks, yBlocks, vxBlocks, vyBlocks []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	// Intersect validity masks: vCommon = vx * vy
-	vCommon := make([]*rlwe.Ciphertext, len(vxBlocks))
-	for i := range vxBlocks {
-		v, err := n.eval.Mul(vxBlocks[i], vyBlocks[i])
-		if err != nil {
-			return nil, fmt.Errorf("block %d validity intersection failed: %w", i, err)
-		}
-		vCommon[i], err = n.eval.Rescale(v)
-		if err != nil {
-			return nil, fmt.Errorf("block %d validity rescale failed: %w", i, err)
-		}
-	}
-
-	// Compute means using common mask
-	meanX, err := n.Mean(xBlocks, vCommon)
-	if err != nil {
-		return nil, fmt.Errorf("mean x failed: %w", err)
-	}
-	meanY, err := n.Mean(yBlocks, vCommon)
-	if err != nil {
-		return nil, fmt.Errorf("mean y failed: %w", err)
-	}
-
-	// Compute Covariance: sum((x - meanX)*(y - meanY) * v) / count
-	var sumDiffXDiffYV *rlwe.Ciphertext
-	for i := range xBlocks {
-		// dx = x - meanX, dy = y - meanY
-		dx, _ := n.eval.Sub(xBlocks[i], meanX)
-		dy, _ := n.eval.Sub(yBlocks[i], meanY)
-
-		// dxy = dx * dy
-		dxy, err := n.eval.Mul(dx, dy)
-		if err != nil {
-			return nil, err
-		}
-		dxy, err = n.eval.Rescale(dxy)
-		if err != nil {
-			return nil, err
-		}
-
-		// masked = dxy * vCommon
-		masked, err := n.eval.Mul(dxy, vCommon[i])
-		if err != nil {
-			return nil, err
-		}
-		masked, err = n.eval.Rescale(masked)
-		if err != nil {
-			return nil, err
-		}
-
-		if sumDiffXDiffYV == nil {
-			sumDiffXDiffYV = masked
-		} else {
-			n.eval.AddInPlace(sumDiffXDiffYV, masked)
-		}
-	}
-
-	sum, err := n.eval.SumSlots(sumDiffXDiffYV)
-	if err != nil {
-		return nil, err
-	}
-
-	count, _ := n.Count(vCommon)
-	invCount, _ := n.INVNTHSQRT(count, DefaultINVConfig())
-
-	cov, err := n.eval.Mul(sum, invCount)
-	if err != nil {
-		return nil, err
-	}
-	cov, err = n.eval.Rescale(cov)
-	if err != nil {
-		return nil, err
-	}
-
-	// Compute stdevs using common mask
-	varX, err := n.Variance(xBlocks, vCommon)
-	if err != nil {
-		return nil, err
-	}
-	varY, err := n.Variance(yBlocks, vCommon)
-	if err != nil {
-		return nil, err
-	}
-
-	invSqrtVarX, err := n.INVNTHSQRT(varX, DefaultINVSQRTConfig())
-	if err != nil {
-		return nil, fmt.Errorf("invSqrtVarX failed: %w", err)
-	}
-	invSqrtVarY, err := n.INVNTHSQRT(varY, DefaultINVSQRTConfig())
-	if err != nil {
-		return nil, fmt.Errorf("invSqrtVarY failed: %w", err)
-	}
-
-	corr, err := n.eval.Mul(cov, invSqrtVarX)
-	if err != nil {
-		return nil, err
-	}
-	corr, err = n.eval.Rescale(corr)
-	if err != nil {
-		return nil, err
-	}
-	corr, err = n.eval.Mul(corr, invSqrtVarY)
+	s, err := SliceStream(xBlocks, yBlocks, vxBlocks, vyBlocks)
 	if err != nil {
 		return nil, err
 	}
-	return n.eval.Rescale(corr)
+	return n.StreamCorrelation(s)
 }
 
 // PlaintextMean computes mean from plaintext values (for validation)
@@ -0,0 +1,110 @@
+package numeric
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// newSimulator returns a noisy simulator of Profile B2, which bootstraps
+func newSimulator(t *testing.T) *he.Simulator {
+	t.Helper()
+	profile, err := params.NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+	return he.NewSimulator(profile.Params, he.SimulatorConfig{
+		Bootstrapping:  profile.BootstrapEnabled,
+		Noise:          1e-9,
+		BootstrapNoise: 1e-7,
+		Seed:           1,
+	})
+}
+
+func encryptBlocks(t *testing.T, sim *he.Simulator, column []float64) []*rlwe.Ciphertext {
+	t.Helper()
+	blocks, err := sim.EncryptBlocks(column)
+	if err != nil {
+		t.Fatalf("EncryptBlocks failed: %v", err)
+	}
+	return blocks
+}
+
+func decryptFirst(t *testing.T, sim *he.Simulator, ct *rlwe.Ciphertext) float64 {
+	t.Helper()
+	values, err := sim.Decrypt(ct)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	return values[0]
+}
+
+func TestStatisticsMatchPlaintext(t *testing.T) {
+	sim := newSimulator(t)
+
+	// Three blocks, the last one partial
+	rows := 2*sim.Slots() + 100
+	x := make([]float64, rows)
+	y := make([]float64, rows)
+	v := make([]float64, rows)
+	valid := make([]bool, rows)
+	for i := range x {
+		x[i] = float64(i*37%101) / 100
+		y[i] = 0.5*x[i] + float64(i%13)/26
+		valid[i] = i%7 != 0
+		if valid[i] {
+			v[i] = 1
+		}
+	}
+	xs, ys, vs := encryptBlocks(t, sim, x), encryptBlocks(t, sim, y), encryptBlocks(t, sim, v)
+
+	n := NewNumericOp(sim)
+	n.InvSqrtConfig = NormalizedINVSQRTConfig()
+	tests := []struct {
+		name string
+		run  func() (*rlwe.Ciphertext, error)
+		want float64
+	}{
+		{"mean", func() (*rlwe.Ciphertext, error) { return n.Mean(xs, vs) }, PlaintextMean(x, valid)},
+		{"variance", func() (*rlwe.Ciphertext, error) { return n.Variance(xs, vs) }, PlaintextVariance(x, valid)},
+		{"stdev", func() (*rlwe.Ciphertext, error) { return n.Stdev(xs, vs) }, PlaintextStdev(x, valid)},
+		{"correlation", func() (*rlwe.Ciphertext, error) { return n.Correlation(xs, ys, vs, vs) }, PlaintextCorrelation(x, y, valid)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct, err := tt.run()
+			if err != nil {
+				t.Fatalf("%s failed: %v", tt.name, err)
+			}
+			if got := decryptFirst(t, sim, ct); math.Abs(got-tt.want) > 1e-4 {
+				t.Errorf("expected %.6f, got %.6f", tt.want, got)
+			}
+		})
+	}
+	if sim.Stats().BootstrapCount == 0 {
+		t.Error("expected the inverses to bootstrap on Profile B2")
+	}
+}
+
+func TestINVNTHSQRTExhaustsDepthWithoutBootstrapping(t *testing.T) {
+	profile, err := params.NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+	sim := he.NewSimulator(profile.Params, he.SimulatorConfig{})
+	count, err := sim.Encrypt([]float64{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewNumericOp(sim).INVNTHSQRT(count, DefaultINVConfig())
+	if err == nil {
+		t.Fatal("expected 20 Newton iterations to exhaust Profile A2's levels")
+	}
+	if !errors.Is(err, he.ErrDepthExhausted) {
+		t.Errorf("expected ErrDepthExhausted, got %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package numeric
+
+import (
+	"fmt"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// BlockStream yields the ciphertexts of a table one block at a time, so that
+// an operation holds a single block in memory. Reset restarts the stream for
+// operations that need a second pass, such as variance.
+type BlockStream interface {
+	HasNext() bool
+	Next() ([]*rlwe.Ciphertext, error)
+	Reset()
+}
+
+// sliceStream streams blocks already in memory
+type sliceStream struct {
+	columns [][]*rlwe.Ciphertext
+	current int
+}
+
+// SliceStream returns a stream whose block b holds block b of every column
+func SliceStream(columns ...[]*rlwe.Ciphertext) (BlockStream, error) {
+	if len(columns) == 0 || len(columns[0]) == 0 {
+		return nil, fmt.Errorf("no blocks provided")
+	}
+	for _, c := range columns[1:] {
+		if len(c) != len(columns[0]) {
+			return nil, fmt.Errorf("block count mismatch: %d vs %d", len(columns[0]), len(c))
+		}
+	}
+	return &sliceStream{columns: columns}, nil
+}
+
+func (s *sliceStream) HasNext() bool {
+	return s.current < len(s.columns[0])
+}
+
+func (s *sliceStream) Next() ([]*rlwe.Ciphertext, error) {
+	if !s.HasNext() {
+		return nil, fmt.Errorf("no more blocks")
+	}
+	block := make([]*rlwe.Ciphertext, len(s.columns))
+	for i, c := range s.columns {
+		block[i] = c[s.current]
+	}
+	s.current++
+	return block, nil
+}
+
+func (s *sliceStream) Reset() {
+	s.current = 0
+}
+
+// StagedStream is a stream whose blocks are computed from those of a source
+// stream, such as masks built from BMVs. Operations that fan blocks out to
+// workers read the source and stage each block on the worker evaluating it.
+type StagedStream interface {
+	BlockStream
+	// Source returns the stream the blocks are computed from
+	Source() BlockStream
+	// Stage computes block b from the source's block b with eval
+	Stage(eval he.Backend, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error)
+}
+
+// next reads the next block of a stream
+func next(s BlockStream, b int) ([]*rlwe.Ciphertext, error) {
+	block, err := s.Next()
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", b, err)
+	}
+	return block, nil
+}
+
+// accumulator folds per-block ciphertexts into a running sum, so that
+// blocks can be dropped as soon as they are added
+type accumulator struct {
+	eval he.Backend
+	sum  *rlwe.Ciphertext
+}
+
+// add adds ct to the running sum; ct is not modified
+func (a *accumulator) add(ct *rlwe.Ciphertext) error {
+	if a.sum == nil {
+		a.sum = ct.CopyNew()
+		return nil
+	}
+	return a.eval.AddInPlace(a.sum, ct)
+}
+
+// total returns the running sum summed across slots
+func (a *accumulator) total() (*rlwe.Ciphertext, error) {
+	if a.sum == nil {
+		return nil, fmt.Errorf("no blocks provided")
+	}
+	return a.eval.SumSlots(a.sum)
+}
+
+// worker returns the operation bound to the evaluator of a worker
+func (n *NumericOp) worker(eval he.Backend) *NumericOp {
+	if eval == n.eval {
+		return n
+	}
+	return &NumericOp{eval: eval, InvSqrtConfig: n.InvSqrtConfig}
+}
+
+// fold reads a stream of blocks of width ciphertexts in batches of one block
+// per worker and runs work on the blocks of a batch in parallel. The i-th
+// ciphertext work returns for each block is added to accs[i]; the partial
+// sums of a batch are added in a tree first. Only a batch of blocks is held
+// in memory at a time.
+func (n *NumericOp) fold(s BlockStream, width int, accs []*accumulator, work func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error)) error {
+	src := s
+	staged, isStaged := s.(StagedStream)
+	if isStaged {
+		src = staged.Source()
+	}
+	for first := 0; src.HasNext(); {
+		var blocks [][]*rlwe.Ciphertext
+		for len(blocks) < n.eval.Workers() && src.HasNext() {
+			block, err := next(src, first+len(blocks))
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+		}
+
+		outs := make([][]*rlwe.Ciphertext, len(blocks))
+		err := n.eval.Parallel(len(blocks), func(w he.Backend, i int) error {
+			b, block := first+i, blocks[i]
+			defer w.Span(fmt.Sprintf("block %d", b))()
+			if isStaged {
+				var err error
+				if block, err = staged.Stage(w, b, block); err != nil {
+					return err
+				}
+			}
+			if len(block) != width {
+				return fmt.Errorf("block %d has %d ciphertexts, expected %d", b, len(block), width)
+			}
+			out, err := work(n.worker(w), b, block)
+			if err != nil {
+				return fmt.Errorf("block %d %w", b, err)
+			}
+			outs[i] = out
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for a, acc := range accs {
+			parts := make([]*rlwe.Ciphertext, len(outs))
+			for i, out := range outs {
+				parts[i] = out[a]
+			}
+			sum, err := n.eval.SumTree(parts)
+			if err != nil {
+				return fmt.Errorf("blocks %d..%d add failed: %w", first, first+len(blocks)-1, err)
+			}
+			if err := acc.add(sum); err != nil {
+				return fmt.Errorf("blocks %d..%d add failed: %w", first, first+len(blocks)-1, err)
+			}
+		}
+		first += len(blocks)
+	}
+	return nil
+}
+
+// mulRescale returns a * b rescaled
+func (n *NumericOp) mulRescale(a, b *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	ct, err := n.eval.Mul(a, b)
+	if err != nil {
+		return nil, err
+	}
+	return n.eval.Rescale(ct)
+}
+
+// StreamMaskedSum computes sum(x * v) over a stream of [x, v] blocks
+func (n *NumericOp) StreamMaskedSum(s BlockStream) (*rlwe.Ciphertext, error) {
+	sum := accumulator{eval: n.eval}
+	err := n.fold(s, 2, []*accumulator{&sum}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		masked, err := w.mulRescale(block[0], block[1])
+		if err != nil {
+			return nil, fmt.Errorf("mul failed: %w", err)
+		}
+		return []*rlwe.Ciphertext{masked}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sum.total()
+}
+
+// StreamMaskedSums computes sum(x_i * v) for every i over a stream of
+// [x_1, ..., x_k, v] blocks, such as the frequencies of k BMVs
+func (n *NumericOp) StreamMaskedSums(s BlockStream, k int) ([]*rlwe.Ciphertext, error) {
+	sums := make([]accumulator, k)
+	accs := make([]*accumulator, k)
+	for i := range sums {
+		sums[i].eval = n.eval
+		accs[i] = &sums[i]
+	}
+	err := n.fold(s, k+1, accs, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		out := make([]*rlwe.Ciphertext, k)
+		for i := range out {
+			var err error
+			if out[i], err = w.mulRescale(block[i], block[k]); err != nil {
+				return nil, fmt.Errorf("mul %d failed: %w", i, err)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	totals := make([]*rlwe.Ciphertext, k)
+	for i, acc := range accs {
+		if totals[i], err = acc.total(); err != nil {
+			return nil, fmt.Errorf("sum %d failed: %w", i, err)
+		}
+	}
+	return totals, nil
+}
+
+// StreamMaskedSlots computes x * v summed over a stream of [x, v] blocks
+// slot by slot, without summing across slots
+func (n *NumericOp) StreamMaskedSlots(s BlockStream) (*rlwe.Ciphertext, error) {
+	sum := accumulator{eval: n.eval}
+	err := n.fold(s, 2, []*accumulator{&sum}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		masked, err := w.mulRescale(block[0], block[1])
+		if err != nil {
+			return nil, fmt.Errorf("mul failed: %w", err)
+		}
+		return []*rlwe.Ciphertext{masked}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sum.sum == nil {
+		return nil, fmt.Errorf("no blocks provided")
+	}
+	return sum.sum, nil
+}
+
+// StreamCount computes sum(v) over a stream of [v] blocks
+func (n *NumericOp) StreamCount(s BlockStream) (*rlwe.Ciphertext, error) {
+	count := accumulator{eval: n.eval}
+	err := n.fold(s, 1, []*accumulator{&count}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		return block, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return count.total()
+}
+
+// streamMean makes one pass over [x, v] blocks folding sum(x * v) and
+// sum(v), and returns the mean with 1/count for a second pass to reuse
+func (n *NumericOp) streamMean(s BlockStream) (mean, invCount *rlwe.Ciphertext, err error) {
+	sum := accumulator{eval: n.eval}
+	count := accumulator{eval: n.eval}
+	err = n.fold(s, 2, []*accumulator{&sum, &count}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		masked, err := w.mulRescale(block[0], block[1])
+		if err != nil {
+			return nil, fmt.Errorf("mul failed: %w", err)
+		}
+		return []*rlwe.Ciphertext{masked, block[1]}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sumXV, err := sum.total()
+	if err != nil {
+		return nil, nil, fmt.Errorf("masked sum failed: %w", err)
+	}
+	total, err := count.total()
+	if err != nil {
+		return nil, nil, fmt.Errorf("count failed: %w", err)
+	}
+	invCount, err = n.INVNTHSQRT(total, DefaultINVConfig())
+	if err != nil {
+		return nil, nil, fmt.Errorf("inverse count failed: %w", err)
+	}
+	mean, err = n.mulRescale(sumXV, invCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mean mul failed: %w", err)
+	}
+	return mean, invCount, nil
+}
+
+// StreamMean computes the mean over a stream of [x, v] blocks in one pass
+func (n *NumericOp) StreamMean(s BlockStream) (*rlwe.Ciphertext, error) {
+	mean, _, err := n.streamMean(s)
+	return mean, err
+}
+
+// StreamVariance computes the variance over a stream of [x, v] blocks in two
+// passes: the mean, then sum((x - mean)^2 * v) / sum(v)
+func (n *NumericOp) StreamVariance(s BlockStream) (*rlwe.Ciphertext, error) {
+	mean, invCount, err := n.streamMean(s)
+	if err != nil {
+		return nil, fmt.Errorf("mean failed: %w", err)
+	}
+
+	s.Reset()
+	sumSq := accumulator{eval: n.eval}
+	err = n.fold(s, 2, []*accumulator{&sumSq}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		masked, err := w.maskedSquaredDiff(block[0], mean, block[1])
+		if err != nil {
+			return nil, err
+		}
+		return []*rlwe.Ciphertext{masked}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := sumSq.total()
+	if err != nil {
+		return nil, fmt.Errorf("sum slots failed: %w", err)
+	}
+	variance, err := n.mulRescale(sum, invCount)
+	if err != nil {
+		return nil, fmt.Errorf("variance mul failed: %w", err)
+	}
+	return variance, nil
+}
+
+// maskedSquaredDiff returns (x - mean)^2 * v
+func (n *NumericOp) maskedSquaredDiff(x, mean, v *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	diff, err := n.eval.Sub(x, mean)
+	if err != nil {
+		return nil, fmt.Errorf("sub failed: %w", err)
+	}
+	diffSq, err := n.mulRescale(diff, diff)
+	if err != nil {
+		return nil, fmt.Errorf("mul sq failed: %w", err)
+	}
+	masked, err := n.mulRescale(diffSq, v)
+	if err != nil {
+		return nil, fmt.Errorf("mask failed: %w", err)
+	}
+	return masked, nil
+}
+
+// StreamStdev computes the standard deviation over a stream of [x, v] blocks
+func (n *NumericOp) StreamStdev(s BlockStream) (*rlwe.Ciphertext, error) {
+	variance, err := n.StreamVariance(s)
+	if err != nil {
+		return nil, fmt.Errorf("variance failed: %w", err)
+	}
+
+	// stdev = var * (1/sqrt(var)) = sqrt(var)
+	invSqrt, err := n.INVNTHSQRT(variance, n.InvSqrtConfig)
+	if err != nil {
+		return nil, fmt.Errorf("inv sqrt variance failed: %w", err)
+	}
+	stdev, err := n.mulRescale(variance, invSqrt)
+	if err != nil {
+		return nil, fmt.Errorf("stdev mul failed: %w", err)
+	}
+	return stdev, nil
+}
+
+// StreamCorrelation computes the Pearson correlation over a stream of
+// [x, y, vx, vy] blocks in two passes, on the rows valid in both columns:
+// the means, then the covariance and both variances
+func (n *NumericOp) StreamCorrelation(s BlockStream) (*rlwe.Ciphertext, error) {
+	sumX := accumulator{eval: n.eval}
+	sumY := accumulator{eval: n.eval}
+	count := accumulator{eval: n.eval}
+	err := n.fold(s, 4, []*accumulator{&sumX, &sumY, &count}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		v, err := w.mulRescale(block[2], block[3])
+		if err != nil {
+			return nil, fmt.Errorf("validity intersection failed: %w", err)
+		}
+		out := []*rlwe.Ciphertext{nil, nil, v}
+		for i, x := range block[:2] {
+			if out[i], err = w.mulRescale(x, v); err != nil {
+				return nil, fmt.Errorf("mul failed: %w", err)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := count.total()
+	if err != nil {
+		return nil, fmt.Errorf("count failed: %w", err)
+	}
+	invCount, err := n.INVNTHSQRT(total, DefaultINVConfig())
+	if err != nil {
+		return nil, fmt.Errorf("inverse count failed: %w", err)
+	}
+	var means [2]*rlwe.Ciphertext
+	for i, acc := range []*accumulator{&sumX, &sumY} {
+		sum, err := acc.total()
+		if err != nil {
+			return nil, err
+		}
+		if means[i], err = n.mulRescale(sum, invCount); err != nil {
+			return nil, fmt.Errorf("mean mul failed: %w", err)
+		}
+	}
+
+	s.Reset()
+	cov := accumulator{eval: n.eval}
+	varX := accumulator{eval: n.eval}
+	varY := accumulator{eval: n.eval}
+	err = n.fold(s, 4, []*accumulator{&cov, &varX, &varY}, func(w *NumericOp, b int, block []*rlwe.Ciphertext) ([]*rlwe.Ciphertext, error) {
+		v, err := w.mulRescale(block[2], block[3])
+		if err != nil {
+			return nil, fmt.Errorf("validity intersection failed: %w", err)
+		}
+		dx, err := w.eval.Sub(block[0], means[0])
+		if err != nil {
+			return nil, fmt.Errorf("sub failed: %w", err)
+		}
+		dy, err := w.eval.Sub(block[1], means[1])
+		if err != nil {
+			return nil, fmt.Errorf("sub failed: %w", err)
+		}
+		out := make([]*rlwe.Ciphertext, 3)
+		for i, f := range [][2]*rlwe.Ciphertext{{dx, dy}, {dx, dx}, {dy, dy}} {
+			prod, err := w.mulRescale(f[0], f[1])
+			if err != nil {
+				return nil, fmt.Errorf("mul failed: %w", err)
+			}
+			if out[i], err = w.mulRescale(prod, v); err != nil {
+				return nil, fmt.Errorf("mask failed: %w", err)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Divide the three sums by the count
+	var moments [3]*rlwe.Ciphertext
+	for i, acc := range []*accumulator{&cov, &varX, &varY} {
+		sum, err := acc.total()
+		if err != nil {
+			return nil, err
+		}
+		if moments[i], err = n.mulRescale(sum, invCount); err != nil {
+			return nil, fmt.Errorf("moment mul failed: %w", err)
+		}
+	}
+
+	// corr = cov / (sqrt(varX) * sqrt(varY))
+	corr := moments[0]
+	for i, variance := range moments[1:] {
+		invSqrt, err := n.INVNTHSQRT(variance, n.InvSqrtConfig)
+		if err != nil {
+			return nil, fmt.Errorf("inv sqrt of variance %d failed: %w", i, err)
+		}
+		if corr, err = n.mulRescale(corr, invSqrt); err != nil {
+			return nil, fmt.Errorf("correlation mul failed: %w", err)
+		}
+	}
+	return corr, nil
+}
@@ -14,13 +14,13 @@ import (
 
 // OrdinalOp computes ordinal statistics on encrypted data
 type OrdinalOp struct {
-	eval      *he.Evaluator
+	eval      he.Backend
 	numericOp *numeric.NumericOp
 	approxOp  *approx.ApproxOp
 }
 
 // NewOrdinalOp creates a new ordinal operations handler
-func NewOrdinalOp(eval *he.Evaluator) *OrdinalOp {
+func NewOrdinalOp(eval he.Backend) *OrdinalOp {
 	return &OrdinalOp{
 		eval:      eval,
 		numericOp: numeric.NewNumericOp(eval),
@@ -28,6 +28,14 @@ func NewOrdinalOp(eval *he.Evaluator) *OrdinalOp {
 	}
 }
 
+// worker returns the operation bound to the evaluator of a worker
+func (o *OrdinalOp) worker(eval he.Backend) *OrdinalOp {
+	if eval == o.eval {
+		return o
+	}
+	return NewOrdinalOp(eval)
+}
+
 // PercentileConfig configures k-percentile computation
 type PercentileConfig struct {
 	K          float64 // Percentile value (0-100)
@@ -51,50 +59,73 @@ func (o *OrdinalOp) Percentile(
 ) (*rlwe.Ciphertext, error) {
 	blockCount := bmvStore.BlockCount()
 
-	// Step 1: Compute frequency for each value by summing BMV blocks
+	// Step 1: Compute frequency for each value by summing BMV blocks; the
+	// values are spread over the workers
 	freqs := make([]*rlwe.Ciphertext, config.Categories)
-	for v := 1; v <= config.Categories; v++ {
+	err := o.eval.Parallel(config.Categories, func(w he.Backend, i int) error {
+		v := i + 1
+		defer w.Span(fmt.Sprintf("frequency of %d", v))()
 		var sum *rlwe.Ciphertext
 		for b := 0; b < blockCount; b++ {
 			bmv, err := bmvStore.GetBMV(v, b)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get BMV for value %d block %d: %w", v, b, err)
+				return fmt.Errorf("failed to get BMV for value %d block %d: %w", v, b, err)
 			}
 
 			// Multiply by validity
-			masked, err := o.eval.Mul(bmv, validityBlocks[b])
+			masked, err := w.Mul(bmv, validityBlocks[b])
 			if err != nil {
-				return nil, fmt.Errorf("value %d block %d mul failed: %w", v, b, err)
+				return fmt.Errorf("value %d block %d mul failed: %w", v, b, err)
 			}
-			masked, err = o.eval.Rescale(masked)
+			masked, err = w.Rescale(masked)
 			if err != nil {
-				return nil, fmt.Errorf("value %d block %d rescale failed: %w", v, b, err)
+				return fmt.Errorf("value %d block %d rescale failed: %w", v, b, err)
 			}
 
 			if sum == nil {
 				sum = masked
 			} else {
-				err = o.eval.AddInPlace(sum, masked)
+				err = w.AddInPlace(sum, masked)
 				if err != nil {
-					return nil, fmt.Errorf("value %d block %d add failed: %w", v, b, err)
+					return fmt.Errorf("value %d block %d add failed: %w", v, b, err)
 				}
 			}
 		}
 
 		// Sum across slots to get total frequency for this value
-		freq, err := o.eval.SumSlots(sum)
+		freq, err := w.SumSlots(sum)
 		if err != nil {
-			return nil, fmt.Errorf("value %d sum slots failed: %w", v, err)
+			return fmt.Errorf("value %d sum slots failed: %w", v, err)
 		}
-		freqs[v-1] = freq
+		freqs[i] = freq
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o.percentile(freqs, config)
+}
+
+// StreamPercentile computes the k-th percentile of an ordinal variable over
+// a stream of [bmv_1, ..., bmv_S, v] blocks, where bmv_i is the BMV block of
+// the i-th category: the streaming form of Percentile
+func (o *OrdinalOp) StreamPercentile(src numeric.BlockStream, config PercentileConfig) (*rlwe.Ciphertext, error) {
+	// Step 1: Compute frequency for each value as blocks are read
+	freqs, err := o.numericOp.StreamMaskedSums(src, config.Categories)
+	if err != nil {
+		return nil, fmt.Errorf("frequencies failed: %w", err)
 	}
+	return o.percentile(freqs, config)
+}
 
+// percentile finds the k-th percentile bucket from the frequency of every category
+func (o *OrdinalOp) percentile(freqs []*rlwe.Ciphertext, config PercentileConfig) (*rlwe.Ciphertext, error) {
 	// Step 2: Compute cumulative histogram
 	// cumul[i] = sum(freq[0..i])
 	cumul := make([]*rlwe.Ciphertext, config.Categories)
 	cumul[0] = freqs[0].CopyNew()
+	var err error
 	for i := 1; i < config.Categories; i++ {
-		var err error
 		cumul[i], err = o.eval.Add(cumul[i-1], freqs[i])
 		if err != nil {
 			return nil, fmt.Errorf("cumul %d add failed: %w", i, err)
@@ -113,38 +144,46 @@ func (o *OrdinalOp) Percentile(
 	signConfig := approx.DefaultApproxSignConfig()
 
 	// For each bucket, compute: sign(cumul[i]/R - k/100)
-	// Then apply mapping to get indicator
+	// Then apply mapping to get indicator; the buckets are spread over the
+	// workers
 	indicators := make([]*rlwe.Ciphertext, config.Categories)
-	for i := 0; i < config.Categories; i++ {
+	err = o.eval.Parallel(config.Categories, func(w he.Backend, i int) error {
+		op := o.worker(w)
+		defer w.Span(fmt.Sprintf("threshold of bucket %d", i+1))()
+
 		// cumul[i] * invR
-		ratio, err := o.eval.Mul(cumul[i], invR)
+		ratio, err := w.Mul(cumul[i], invR)
 		if err != nil {
-			return nil, fmt.Errorf("ratio %d mul failed: %w", i, err)
+			return fmt.Errorf("ratio %d mul failed: %w", i, err)
 		}
-		ratio, err = o.eval.Rescale(ratio)
+		ratio, err = w.Rescale(ratio)
 		if err != nil {
-			return nil, fmt.Errorf("ratio %d rescale failed: %w", i, err)
+			return fmt.Errorf("ratio %d rescale failed: %w", i, err)
 		}
 
 		// ratio - k/100
-		diff, err := o.eval.AddConst(ratio, complex(-kThreshold, 0))
+		diff, err := w.AddConst(ratio, complex(-kThreshold, 0))
 		if err != nil {
-			return nil, fmt.Errorf("diff %d failed: %w", i, err)
+			return fmt.Errorf("diff %d failed: %w", i, err)
 		}
 
 		// Approximate sign
-		sign, err := o.approxOp.APPROXSIGN(diff, signConfig)
+		sign, err := op.approxOp.APPROXSIGN(diff, signConfig)
 		if err != nil {
-			return nil, fmt.Errorf("sign %d failed: %w", i, err)
+			return fmt.Errorf("sign %d failed: %w", i, err)
 		}
 
 		// Map sign to indicator using f(x) = -0.5(x-0.5)^2 + 1.125
 		// This maps: sign=-1 (below threshold) -> 0
 		//            sign=1 (at or above threshold) -> 1
-		indicators[i], err = o.applyFlipMapping(sign)
+		indicators[i], err = op.applyFlipMapping(sign)
 		if err != nil {
-			return nil, fmt.Errorf("flip %d failed: %w", i, err)
+			return fmt.Errorf("flip %d failed: %w", i, err)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Step 5: Find the first bucket where indicator becomes 1
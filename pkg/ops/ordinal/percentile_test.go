@@ -0,0 +1,104 @@
+package ordinal
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// sliceBMVStore holds the BMV blocks of every value, from 1
+type sliceBMVStore [][]*rlwe.Ciphertext
+
+func (s sliceBMVStore) GetBMV(value int, blockIndex int) (*rlwe.Ciphertext, error) {
+	return s[value-1][blockIndex], nil
+}
+
+func (s sliceBMVStore) BlockCount() int {
+	return len(s[0])
+}
+
+func TestPercentileMatchesPlaintext(t *testing.T) {
+	profile, err := params.NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+	sim := he.NewSimulator(profile.Params, he.SimulatorConfig{
+		Bootstrapping:  profile.BootstrapEnabled,
+		Noise:          1e-9,
+		BootstrapNoise: 1e-7,
+		Seed:           1,
+	})
+
+	const categories = 3
+	rows := sim.Slots() + 300
+	values := make([]int, rows)
+	valid := make([]bool, rows)
+	v := make([]float64, rows)
+	bmvs := make([][]float64, categories)
+	for c := range bmvs {
+		bmvs[c] = make([]float64, rows)
+	}
+	for i := range values {
+		// 10% in bucket 1, 80% in bucket 2 and 10% in bucket 3
+		values[i] = 2
+		switch i % 10 {
+		case 0:
+			values[i] = 1
+		case 9:
+			values[i] = 3
+		}
+		valid[i] = i%9 != 0
+		if valid[i] {
+			v[i] = 1
+		}
+		bmvs[values[i]-1][i] = 1
+	}
+	encrypt := func(column []float64) []*rlwe.Ciphertext {
+		blocks, err := sim.EncryptBlocks(column)
+		if err != nil {
+			t.Fatalf("EncryptBlocks failed: %v", err)
+		}
+		return blocks
+	}
+	store := make(sliceBMVStore, categories)
+	for c := range store {
+		store[c] = encrypt(bmvs[c])
+	}
+	vs := encrypt(v)
+
+	// The three refinements of APPROXSIGN only separate cumulative ratios
+	// about 0.4 or more from k/100, which holds for these percentiles
+	o := NewOrdinalOp(sim)
+	for _, k := range []float64{50, 55} {
+		config := PercentileConfig{K: k, Categories: categories}
+		ct, err := o.Percentile(vs, store, config)
+		if err != nil {
+			t.Fatalf("Percentile %.0f failed: %v", k, err)
+		}
+
+		// The streaming form reads the same blocks as [bmv_1, ..., bmv_S, v]
+		s, err := numeric.SliceStream(append(append([][]*rlwe.Ciphertext{}, store...), vs)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed, err := o.StreamPercentile(s, config)
+		if err != nil {
+			t.Fatalf("StreamPercentile %.0f failed: %v", k, err)
+		}
+
+		want := PlaintextPercentile(values, valid, k)
+		for name, ct := range map[string]*rlwe.Ciphertext{"Percentile": ct, "StreamPercentile": streamed} {
+			got, err := sim.Decrypt(ct)
+			if err != nil {
+				t.Fatalf("Decrypt failed: %v", err)
+			}
+			if math.Round(got[0]) != float64(want) {
+				t.Errorf("%s %.0f: expected bucket %d, got %.4f", name, k, want, got[0])
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package params
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
+	"github.com/tuneinsight/lattigo/v6/ring"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileSpec is the declarative description of a profile as stored in a profile file
+type ProfileSpec struct {
+	Name            string            `json:"name,omitempty" yaml:"name,omitempty"`
+	LogN            int               `json:"log_n" yaml:"log_n"`
+	LogQ            []int             `json:"log_q" yaml:"log_q"`
+	LogP            []int             `json:"log_p" yaml:"log_p"`
+	LogDefaultScale int               `json:"log_default_scale" yaml:"log_default_scale"`
+	RingType        string            `json:"ring_type,omitempty" yaml:"ring_type,omitempty"` // "standard" (default) or "conjugate_invariant"
+	Xs              *DistributionSpec `json:"xs,omitempty" yaml:"xs,omitempty"`               // Secret distribution (default: Lattigo's)
+	Xe              *DistributionSpec `json:"xe,omitempty" yaml:"xe,omitempty"`               // Error distribution (default: Lattigo's)
+	Bootstrap       *BootstrapSpec    `json:"bootstrap,omitempty" yaml:"bootstrap,omitempty"`
+}
+
+// DistributionSpec describes a secret or error distribution
+type DistributionSpec struct {
+	Type  string  `json:"type" yaml:"type"`                       // "ternary" or "gaussian"
+	H     int     `json:"h,omitempty" yaml:"h,omitempty"`         // Ternary: Hamming weight
+	P     float64 `json:"p,omitempty" yaml:"p,omitempty"`         // Ternary: density of non-zero coefficients
+	Sigma float64 `json:"sigma,omitempty" yaml:"sigma,omitempty"` // Gaussian: standard deviation
+	Bound float64 `json:"bound,omitempty" yaml:"bound,omitempty"` // Gaussian: truncation bound
+}
+
+// BootstrapSpec describes the bootstrapping circuit of a profile.
+// Zero values fall back to Lattigo's bootstrapping defaults.
+type BootstrapSpec struct {
+	LogN                  int               `json:"log_n,omitempty" yaml:"log_n,omitempty"` // Default: same as the profile (+1 on the conjugate-invariant ring)
+	LogP                  []int             `json:"log_p,omitempty" yaml:"log_p,omitempty"`
+	LogSlots              int               `json:"log_slots,omitempty" yaml:"log_slots,omitempty"`
+	Xs                    *DistributionSpec `json:"xs,omitempty" yaml:"xs,omitempty"` // Default: same as the profile
+	EvalModLogScale       int               `json:"eval_mod_log_scale,omitempty" yaml:"eval_mod_log_scale,omitempty"`
+	EphemeralSecretWeight int               `json:"ephemeral_secret_weight,omitempty" yaml:"ephemeral_secret_weight,omitempty"`
+	Mod1Degree            int               `json:"mod1_degree,omitempty" yaml:"mod1_degree,omitempty"`
+	DoubleAngle           int               `json:"double_angle,omitempty" yaml:"double_angle,omitempty"`
+}
+
+// distribution converts the spec into a Lattigo distribution
+func (d *DistributionSpec) distribution() (ring.DistributionParameters, error) {
+	switch strings.ToLower(d.Type) {
+	case "ternary":
+		if (d.H == 0) == (d.P == 0) {
+			return nil, fmt.Errorf("ternary distribution requires exactly one of h or p")
+		}
+		return ring.Ternary{H: d.H, P: d.P}, nil
+	case "gaussian", "discrete_gaussian":
+		if d.Sigma <= 0 {
+			return nil, fmt.Errorf("gaussian distribution requires a positive sigma")
+		}
+		bound := d.Bound
+		if bound == 0 {
+			bound = 6 * d.Sigma
+		}
+		return ring.DiscreteGaussian{Sigma: d.Sigma, Bound: bound}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution type %q", d.Type)
+	}
+}
+
+// ringType converts a ring type name into a Lattigo ring type
+func ringType(name string) (ring.Type, error) {
+	switch strings.ToLower(name) {
+	case "", "standard":
+		return ring.Standard, nil
+	case "conjugate_invariant", "conjugateinvariant", "real":
+		return ring.ConjugateInvariant, nil
+	default:
+		return ring.Standard, fmt.Errorf("unknown ring type %q", name)
+	}
+}
+
+// LoadProfile loads a custom profile from a JSON or YAML file and validates
+// it against policy.
+// The format is chosen by extension: .yaml/.yml is YAML, anything else is JSON.
+func LoadProfile(path string, policy Policy) (*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open profile file: %w", err)
+	}
+	defer f.Close()
+
+	ext := strings.ToLower(filepath.Ext(path))
+	spec, err := ParseProfileSpec(f, ext == ".yaml" || ext == ".yml")
+	if err != nil {
+		return nil, err
+	}
+	return NewProfileFromSpec(spec, policy)
+}
+
+// ParseProfileSpec decodes a profile spec from JSON, or from YAML if isYAML is set
+func ParseProfileSpec(r io.Reader, isYAML bool) (*ProfileSpec, error) {
+	var spec ProfileSpec
+	if isYAML {
+		decoder := yaml.NewDecoder(r)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("failed to parse profile YAML: %w", err)
+		}
+	} else {
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("failed to parse profile JSON: %w", err)
+		}
+	}
+	return &spec, nil
+}
+
+// NewProfileFromSpec builds a profile from its declarative spec and validates
+// it against policy
+func NewProfileFromSpec(spec *ProfileSpec, policy Policy) (*Profile, error) {
+	if len(spec.LogQ) == 0 {
+		return nil, fmt.Errorf("profile must declare a log_q chain")
+	}
+	if spec.LogDefaultScale <= 0 {
+		return nil, fmt.Errorf("profile must declare a positive log_default_scale")
+	}
+
+	literal := ckks.ParametersLiteral{
+		LogN:            spec.LogN,
+		LogQ:            spec.LogQ,
+		LogP:            spec.LogP,
+		LogDefaultScale: spec.LogDefaultScale,
+	}
+	var err error
+	if literal.RingType, err = ringType(spec.RingType); err != nil {
+		return nil, err
+	}
+	if spec.Xs != nil {
+		if literal.Xs, err = spec.Xs.distribution(); err != nil {
+			return nil, fmt.Errorf("invalid xs: %w", err)
+		}
+	}
+	if spec.Xe != nil {
+		if literal.Xe, err = spec.Xe.distribution(); err != nil {
+			return nil, fmt.Errorf("invalid xe: %w", err)
+		}
+	}
+
+	params, err := ckks.NewParametersFromLiteral(literal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create custom profile parameters: %w", err)
+	}
+
+	name := ProfileType(spec.Name)
+	if name == "" {
+		name = ProfileCustom
+	}
+
+	logQP := append(append([]int{}, spec.LogQ...), spec.LogP...)
+	profile := &Profile{
+		Type:     name,
+		LogN:     spec.LogN,
+		Slots:    params.MaxSlots(),
+		LogScale: spec.LogDefaultScale,
+		LogQP:    logQP,
+		Literal:  literal,
+		Params:   params,
+	}
+
+	if spec.Bootstrap != nil {
+		btpLiteral, err := spec.Bootstrap.literal(params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap section: %w", err)
+		}
+		profile.BootstrapEnabled = true
+		profile.BootstrapLiteral = btpLiteral
+		if _, err := profile.BootstrappingParameters(); err != nil {
+			return nil, err
+		}
+	}
+
+	profile.ParamsHash = profile.computeHash()
+	if err := profile.Validate(policy); err != nil {
+		return nil, fmt.Errorf("invalid custom profile: %w", err)
+	}
+	return profile, nil
+}
+
+// literal converts the spec into a Lattigo bootstrapping literal for the given residual parameters
+func (b *BootstrapSpec) literal(params ckks.Parameters) (*bootstrapping.ParametersLiteral, error) {
+	logN := b.LogN
+	if logN == 0 {
+		logN = params.LogN()
+		// Bootstrapping a conjugate-invariant ciphertext goes through the standard ring of twice the degree
+		if params.RingType() == ring.ConjugateInvariant {
+			logN++
+		}
+	}
+	lit := &bootstrapping.ParametersLiteral{
+		LogN: &logN,
+		LogP: b.LogP,
+		Xs:   params.Xs(),
+	}
+	if b.Xs != nil {
+		xs, err := b.Xs.distribution()
+		if err != nil {
+			return nil, fmt.Errorf("invalid xs: %w", err)
+		}
+		lit.Xs = xs
+	}
+	if b.LogSlots != 0 {
+		v := b.LogSlots
+		lit.LogSlots = &v
+	}
+	if b.EvalModLogScale != 0 {
+		v := b.EvalModLogScale
+		lit.EvalModLogScale = &v
+	}
+	if b.EphemeralSecretWeight != 0 {
+		v := b.EphemeralSecretWeight
+		lit.EphemeralSecretWeight = &v
+	}
+	if b.Mod1Degree != 0 {
+		v := b.Mod1Degree
+		lit.Mod1Degree = &v
+	}
+	if b.DoubleAngle != 0 {
+		v := b.DoubleAngle
+		lit.DoubleAngle = &v
+	}
+	return lit, nil
+}
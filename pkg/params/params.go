@@ -1,7 +1,19 @@
 // Package params provides CKKS parameter profiles for Lattigo-STAT.
-// It defines two main profiles:
-// - Profile A (no-bootstrap): for simpler ops with limited depth
-// - Profile B (bootstrapped): for full functionality including INVNTHSQRT, DISCRETEEQUALZERO, k-percentile
+// The built-in profiles are:
+//   - Profile A2 (no bootstrapping): 7 levels for the cheaper ops, including DISCRETEEQUALZERO
+//   - Profile B2 (bootstrapped): full functionality including INVNTHSQRT and k-percentile
+//   - Profile R (real): Profile A2's modulus chain on the conjugate-invariant ring, with N real slots
+//   - Profiles T and TB (test-only): small, insecure rings, without and with
+//     bootstrapping, for fast tests and golden fixtures
+//
+// The original Profiles A and B are below 128-bit security. They are kept,
+// deprecated, so that existing keys and tables still load with a Policy that
+// sets AllowInsecure.
+//
+// Custom profiles can be loaded from a JSON or YAML file with LoadProfile.
+// Validate rejects any profile whose estimated security (EstimateSecurity)
+// is below the Policy's floor, and any test-only profile, unless the Policy
+// sets AllowInsecure.
 package params
 
 import (
@@ -9,8 +21,13 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/ring"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
@@ -18,18 +35,31 @@ import (
 type ProfileType string
 
 const (
-	ProfileA ProfileType = "A" // No bootstrapping, limited depth
-	ProfileB ProfileType = "B" // With bootstrapping, full functionality
+	ProfileA  ProfileType = "A"  // No bootstrapping, limited depth (deprecated: insecure, use A2)
+	ProfileB  ProfileType = "B"  // With bootstrapping, full functionality (deprecated: insecure, use B2)
+	ProfileA2 ProfileType = "A2" // No bootstrapping, 7 levels, 128-bit secure
+	ProfileB2 ProfileType = "B2" // With bootstrapping, 128-bit secure
+	ProfileR  ProfileType = "R"  // Conjugate-invariant ring, N real slots, no bootstrapping
+	ProfileT  ProfileType = "T"  // Small insecure ring for tests only
+	ProfileTB ProfileType = "TB" // Small insecure ring with bootstrapping for tests only
+
+	ProfileCustom ProfileType = "custom" // Loaded from a profile file without a name
 )
 
 // Profile contains all CKKS parameters and derived values
 type Profile struct {
 	Type             ProfileType
 	LogN             int   // Ring degree: N = 2^LogN
-	Slots            int   // N/2 slots for CKKS
+	Slots            int   // N/2 slots for CKKS, N on the conjugate-invariant ring
 	LogScale         int   // Default scale: 2^LogScale
 	LogQP            []int // Modulus chain bit-sizes
 	BootstrapEnabled bool
+	TestOnly         bool        // Insecure parameters, rejected by Validate unless the Policy allows them
+	ReplacedBy       ProfileType // Secure replacement of a deprecated profile, empty if current
+
+	// Literals the parameters were built from
+	Literal          ckks.ParametersLiteral
+	BootstrapLiteral *bootstrapping.ParametersLiteral // nil if BootstrapEnabled is false
 
 	// Derived Lattigo parameters
 	Params     ckks.Parameters
@@ -48,6 +78,11 @@ func (p *Profile) RotationSteps() []int {
 
 // NewProfileA creates a no-bootstrap profile suitable for mean/var/Bc/Ba/Bv
 // with limited multiplicative depth
+//
+// Deprecated: Profile A's 1780-bit modulus is far above the 438-bit
+// HE-standard bound for 128-bit security at LogN=14, so Validate rejects it
+// unless the Policy sets AllowInsecure. It is kept unchanged so that existing keys,
+// tables and results still match its params hash; use Profile A2 for new data.
 func NewProfileA() (*Profile, error) {
 	// LogN=14 gives 8192 slots, suitable for medium-scale datasets
 	logN := 14
@@ -61,12 +96,13 @@ func NewProfileA() (*Profile, error) {
 	}
 	logP := []int{60, 60} // Special modulus for key-switching
 
-	params, err := ckks.NewParametersFromLiteral(ckks.ParametersLiteral{
+	literal := ckks.ParametersLiteral{
 		LogN:            logN,
 		LogQ:            logQ,
 		LogP:            logP,
 		LogDefaultScale: 40,
-	})
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Profile A parameters: %w", err)
 	}
@@ -78,6 +114,8 @@ func NewProfileA() (*Profile, error) {
 		LogScale:         40,
 		LogQP:            append(logQ, logP...),
 		BootstrapEnabled: false,
+		ReplacedBy:       ProfileA2,
+		Literal:          literal,
 		Params:           params,
 	}
 	profile.ParamsHash = profile.computeHash()
@@ -86,7 +124,12 @@ func NewProfileA() (*Profile, error) {
 }
 
 // NewProfileB creates a bootstrapping-enabled profile for full functionality
-// Supports INVNTHSQRT, DISCRETEEQUALZERO, k-percentile, etc.
+// Supports INVNTHSQRT, k-percentile, etc.
+//
+// Deprecated: with its bootstrapping moduli, Profile B exceeds the 1761-bit
+// bound for 128-bit security at LogN=16, so Validate rejects it unless the
+// Policy sets AllowInsecure. It is kept unchanged so that existing keys, tables and
+// results still match its params hash; use Profile B2 for new data.
 func NewProfileB() (*Profile, error) {
 	// LogN=16 gives 32768 slots and room for bootstrapping
 	logN := 16
@@ -102,16 +145,25 @@ func NewProfileB() (*Profile, error) {
 
 	logP := []int{61, 61, 61, 61} // Special modulus
 
-	params, err := ckks.NewParametersFromLiteral(ckks.ParametersLiteral{
+	literal := ckks.ParametersLiteral{
 		LogN:            logN,
 		LogQ:            logQ,
 		LogP:            logP,
 		LogDefaultScale: 45,
-	})
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Profile B parameters: %w", err)
 	}
 
+	// Bootstrapping circuit shares the ring degree and secret distribution
+	btpLogN := logN
+	btpLiteral := &bootstrapping.ParametersLiteral{
+		LogN: &btpLogN,
+		LogP: []int{61, 61, 61, 61},
+		Xs:   params.Xs(),
+	}
+
 	profile := &Profile{
 		Type:             ProfileB,
 		LogN:             logN,
@@ -119,6 +171,231 @@ func NewProfileB() (*Profile, error) {
 		LogScale:         45,
 		LogQP:            append(logQ, logP...),
 		BootstrapEnabled: true,
+		ReplacedBy:       ProfileB2,
+		Literal:          literal,
+		BootstrapLiteral: btpLiteral,
+		Params:           params,
+	}
+	profile.ParamsHash = profile.computeHash()
+
+	return profile, nil
+}
+
+// NewProfileA2 creates the 128-bit secure replacement of Profile A: a
+// no-bootstrap profile for Bc, LBc and lookups with 7 levels
+func NewProfileA2() (*Profile, error) {
+	logN := 14
+	slots := 1 << (logN - 1) // N/2
+
+	// Modulus chain for 7 levels of multiplication
+	// Prime sizes: 60 bits for Q0, 40 bits for subsequent levels.
+	// LogQP = 401 stays below the 438-bit HE-standard bound for 128-bit security.
+	logQ := []int{60}
+	for i := 0; i < 7; i++ {
+		logQ = append(logQ, 40)
+	}
+	logP := []int{61} // Special modulus for key-switching
+
+	literal := ckks.ParametersLiteral{
+		LogN:            logN,
+		LogQ:            logQ,
+		LogP:            logP,
+		LogDefaultScale: 40,
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Profile A2 parameters: %w", err)
+	}
+
+	profile := &Profile{
+		Type:             ProfileA2,
+		LogN:             logN,
+		Slots:            slots,
+		LogScale:         40,
+		LogQP:            append(logQ, logP...),
+		BootstrapEnabled: false,
+		Literal:          literal,
+		Params:           params,
+	}
+	profile.ParamsHash = profile.computeHash()
+
+	return profile, nil
+}
+
+// NewProfileB2 creates the 128-bit secure replacement of Profile B, with two
+// fewer computation levels between bootstraps
+func NewProfileB2() (*Profile, error) {
+	logN := 16
+	slots := 1 << (logN - 1)
+
+	// Q0 + computation levels; the bootstrapping circuit adds its own levels
+	// on top, and the total must stay below 1761 bits for 128-bit security.
+	logQ := make([]int, 0)
+	logQ = append(logQ, 60) // Q0
+	for i := 0; i < 14; i++ {
+		logQ = append(logQ, 45)
+	}
+
+	logP := []int{61, 61, 61, 61} // Special modulus
+
+	literal := ckks.ParametersLiteral{
+		LogN:            logN,
+		LogQ:            logQ,
+		LogP:            logP,
+		LogDefaultScale: 45,
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Profile B2 parameters: %w", err)
+	}
+
+	// Bootstrapping circuit shares the ring degree and secret distribution
+	btpLogN := logN
+	btpLiteral := &bootstrapping.ParametersLiteral{
+		LogN: &btpLogN,
+		LogP: []int{61, 61, 61, 61},
+		Xs:   params.Xs(),
+	}
+
+	profile := &Profile{
+		Type:             ProfileB2,
+		LogN:             logN,
+		Slots:            slots,
+		LogScale:         45,
+		LogQP:            append(logQ, logP...),
+		BootstrapEnabled: true,
+		Literal:          literal,
+		BootstrapLiteral: btpLiteral,
+		Params:           params,
+	}
+	profile.ParamsHash = profile.computeHash()
+
+	return profile, nil
+}
+
+// NewProfileR creates a no-bootstrap profile on the conjugate-invariant ring
+// Z[X+X^-1]/(X^2N+1). It has the depth of Profile A2, but encodes N real values
+// per ciphertext instead of N/2 complex ones, which halves the number of
+// ciphertexts (and the storage) of a table. All our statistics are real-valued.
+func NewProfileR() (*Profile, error) {
+	logN := 14
+	slots := 1 << logN // N real slots
+
+	logQ := []int{60}
+	for i := 0; i < 7; i++ {
+		logQ = append(logQ, 40)
+	}
+	logP := []int{61}
+
+	literal := ckks.ParametersLiteral{
+		LogN:            logN,
+		LogQ:            logQ,
+		LogP:            logP,
+		LogDefaultScale: 40,
+		RingType:        ring.ConjugateInvariant,
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Profile R parameters: %w", err)
+	}
+
+	profile := &Profile{
+		Type:             ProfileR,
+		LogN:             logN,
+		Slots:            slots,
+		LogScale:         40,
+		LogQP:            append(logQ, logP...),
+		BootstrapEnabled: false,
+		Literal:          literal,
+		Params:           params,
+	}
+	profile.ParamsHash = profile.computeHash()
+
+	return profile, nil
+}
+
+// NewProfileT creates a small test-only profile with the same modulus chain
+// shape as Profile A2 on a LogN=12 ring. It is far below 128-bit security and
+// exists so that tests and golden fixtures run in seconds.
+func NewProfileT() (*Profile, error) {
+	logN := 12
+	slots := 1 << (logN - 1)
+
+	// Same depth as Profile A2 so that every Profile A2 job also runs on T
+	logQ := []int{60}
+	for i := 0; i < 7; i++ {
+		logQ = append(logQ, 40)
+	}
+	logP := []int{61}
+
+	literal := ckks.ParametersLiteral{
+		LogN:            logN,
+		LogQ:            logQ,
+		LogP:            logP,
+		LogDefaultScale: 40,
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Profile T parameters: %w", err)
+	}
+
+	profile := &Profile{
+		Type:             ProfileT,
+		LogN:             logN,
+		Slots:            slots,
+		LogScale:         40,
+		LogQP:            append(logQ, logP...),
+		BootstrapEnabled: false,
+		TestOnly:         true,
+		Literal:          literal,
+		Params:           params,
+	}
+	profile.ParamsHash = profile.computeHash()
+
+	return profile, nil
+}
+
+// NewProfileTB creates a test-only bootstrapping profile: Profile T's chain
+// on a LogN=11 ring, with the bootstrapping circuit on top. It runs the jobs
+// that need more depth than Profile T has (INVNTHSQRT, APPROXSIGN) in seconds
+func NewProfileTB() (*Profile, error) {
+	logN := 11
+	slots := 1 << (logN - 1)
+
+	logQ := []int{60}
+	for i := 0; i < 7; i++ {
+		logQ = append(logQ, 40)
+	}
+	logP := []int{61, 61}
+
+	literal := ckks.ParametersLiteral{
+		LogN:            logN,
+		LogQ:            logQ,
+		LogP:            logP,
+		LogDefaultScale: 40,
+	}
+	params, err := ckks.NewParametersFromLiteral(literal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Profile TB parameters: %w", err)
+	}
+
+	btpLogN := logN
+	btpLiteral := &bootstrapping.ParametersLiteral{
+		LogN: &btpLogN,
+		LogP: []int{61, 61, 61, 61},
+		Xs:   params.Xs(),
+	}
+
+	profile := &Profile{
+		Type:             ProfileTB,
+		LogN:             logN,
+		Slots:            slots,
+		LogScale:         40,
+		LogQP:            append(logQ, logP...),
+		BootstrapEnabled: true,
+		TestOnly:         true,
+		Literal:          literal,
+		BootstrapLiteral: btpLiteral,
 		Params:           params,
 	}
 	profile.ParamsHash = profile.computeHash()
@@ -126,37 +403,134 @@ func NewProfileB() (*Profile, error) {
 	return profile, nil
 }
 
-// computeHash generates a deterministic hash of the parameter configuration
+// NewProfile creates one of the built-in profiles by type
+func NewProfile(t ProfileType) (*Profile, error) {
+	switch t {
+	case ProfileA:
+		return NewProfileA()
+	case ProfileB:
+		return NewProfileB()
+	case ProfileA2:
+		return NewProfileA2()
+	case ProfileB2:
+		return NewProfileB2()
+	case ProfileR:
+		return NewProfileR()
+	case ProfileT:
+		return NewProfileT()
+	case ProfileTB:
+		return NewProfileTB()
+	default:
+		return nil, fmt.Errorf("unknown profile: %s", t)
+	}
+}
+
+// ResolveProfile returns the profile loaded from path if it is set, checked
+// against policy, and the built-in profile called name otherwise.
+// This backs the -profile / -profile-file flag pair of the CLIs.
+func ResolveProfile(name, path string, policy Policy) (*Profile, error) {
+	if path != "" {
+		return LoadProfile(path, policy)
+	}
+	return NewProfile(ProfileType(name))
+}
+
+// DetectProfile returns the built-in profile whose parameter hash is hash.
+// Custom profiles cannot be detected and must be given by file.
+func DetectProfile(hash string) (*Profile, error) {
+	for _, t := range []ProfileType{ProfileA2, ProfileR, ProfileT, ProfileTB, ProfileA, ProfileB2, ProfileB} {
+		p, err := NewProfile(t)
+		if err != nil {
+			return nil, err
+		}
+		if p.ParamsHash == hash {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no built-in profile has params hash %s; pass the custom profile with -profile-file", shortHash(hash))
+}
+
+// BootstrappingParameters builds the bootstrapping parameters for the profile
+func (p *Profile) BootstrappingParameters() (bootstrapping.Parameters, error) {
+	if !p.BootstrapEnabled || p.BootstrapLiteral == nil {
+		return bootstrapping.Parameters{}, fmt.Errorf("profile %s does not support bootstrapping", p.Type)
+	}
+	btpParams, err := bootstrapping.NewParametersFromLiteral(p.Params, *p.BootstrapLiteral)
+	if err != nil {
+		return bootstrapping.Parameters{}, fmt.Errorf("failed to create bootstrapping parameters: %w", err)
+	}
+	return btpParams, nil
+}
+
+// computeHash generates a deterministic hash of the complete parameter configuration.
+// It covers the resolved CKKS parameters (moduli, LogN, ring type, Xs/Xe, scale)
+// and the bootstrapping literal, but not the profile name, so that two profiles
+// with identical parameters bind to the same keys and tables.
 func (p *Profile) computeHash() string {
+	paramsJSON, _ := p.Params.MarshalJSON()
 	data, _ := json.Marshal(struct {
-		Type     ProfileType
-		LogN     int
-		LogQP    []int
-		LogScale int
+		Params    json.RawMessage
+		Bootstrap *bootstrapping.ParametersLiteral
 	}{
-		Type:     p.Type,
-		LogN:     p.LogN,
-		LogQP:    p.LogQP,
-		LogScale: p.LogScale,
+		Params:    paramsJSON,
+		Bootstrap: p.BootstrapLiteral,
 	})
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
 }
 
-// Validate checks that the parameters are consistent and usable
-func (p *Profile) Validate() error {
+// CheckHash returns an error if hash does not match the profile's parameter hash.
+// what names the artifact being checked (e.g. "table", "public key") in the error.
+func (p *Profile) CheckHash(hash, what string) error {
+	if hash != p.ParamsHash {
+		return fmt.Errorf("parameter mismatch: %s was created with params hash %s, but profile %s has params hash %s",
+			what, shortHash(hash), p.Type, shortHash(p.ParamsHash))
+	}
+	return nil
+}
+
+// shortHash abbreviates a hash for error messages
+func shortHash(hash string) string {
+	if hash == "" {
+		return "<none>"
+	}
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
+
+// Validate checks that the parameters are consistent and meet policy
+func (p *Profile) Validate(policy Policy) error {
 	if p.LogN < 10 || p.LogN > 17 {
 		return fmt.Errorf("LogN must be between 10 and 17, got %d", p.LogN)
 	}
-	if p.Slots != 1<<(p.LogN-1) {
-		return fmt.Errorf("Slots mismatch: expected %d, got %d", 1<<(p.LogN-1), p.Slots)
+	if p.Slots != p.Params.MaxSlots() {
+		return fmt.Errorf("Slots mismatch: expected %d, got %d", p.Params.MaxSlots(), p.Slots)
 	}
 	if len(p.LogQP) < 2 {
 		return fmt.Errorf("modulus chain too short")
 	}
+	if policy.AllowInsecure {
+		return nil
+	}
+	if p.TestOnly {
+		return fmt.Errorf("profile %s is test-only and insecure; pass -insecure to use it", p.Type)
+	}
+	if err := p.ValidateSecurity(policy.minBits()); err != nil {
+		if p.ReplacedBy != "" {
+			return fmt.Errorf("%w; profile %s is deprecated, re-encrypt with profile %s or pass -insecure to use existing data", err, p.Type, p.ReplacedBy)
+		}
+		return err
+	}
 	return nil
 }
 
+// IsConjugateInvariant returns true if the profile uses the real-only conjugate-invariant ring
+func (p *Profile) IsConjugateInvariant() bool {
+	return p.Params.RingType() == ring.ConjugateInvariant
+}
+
 // MaxLevel returns the maximum ciphertext level (number of Q primes - 1)
 func (p *Profile) MaxLevel() int {
 	return p.Params.MaxLevel()
@@ -169,6 +543,86 @@ func (p *Profile) GetRLWEParams() rlwe.Parameters {
 
 // String returns a human-readable description of the profile
 func (p *Profile) String() string {
-	return fmt.Sprintf("Profile %s: LogN=%d, Slots=%d, LogScale=%d, MaxLevel=%d, Bootstrap=%v, Hash=%s",
+	s := fmt.Sprintf("Profile %s: LogN=%d, Slots=%d, LogScale=%d, MaxLevel=%d, Bootstrap=%v, Hash=%s",
 		p.Type, p.LogN, p.Slots, p.LogScale, p.MaxLevel(), p.BootstrapEnabled, p.ParamsHash[:16])
+	if p.IsConjugateInvariant() {
+		s += " (conjugate-invariant ring)"
+	}
+	if p.TestOnly {
+		s += " (TEST ONLY, INSECURE)"
+	}
+	if p.ReplacedBy != "" {
+		s += fmt.Sprintf(" (DEPRECATED, INSECURE: use profile %s)", p.ReplacedBy)
+	}
+	return s
+}
+
+// KeyInfo is the parameter metadata written next to a generated key set (params.json)
+type KeyInfo struct {
+	Profile    string `json:"profile"`
+	ParamsHash string `json:"params_hash"`
+	LogN       int    `json:"log_n"`
+	LogScale   int    `json:"log_scale"`
+	Slots      int    `json:"slots"`
+	// SecurityBits is the estimated classical bit-security at key generation
+	SecurityBits float64 `json:"security_bits,omitempty"`
+	TestOnly     bool    `json:"test_only,omitempty"`
+	// Seeded marks key sets generated from a fixed seed (see SeededKeyGenerator)
+	Seeded bool `json:"seeded,omitempty"`
+}
+
+// KeyInfo returns the key set metadata for the profile
+func (p *Profile) KeyInfo() *KeyInfo {
+	info := &KeyInfo{
+		Profile:    string(p.Type),
+		ParamsHash: p.ParamsHash,
+		LogN:       p.LogN,
+		LogScale:   p.LogScale,
+		Slots:      p.Slots,
+		TestOnly:   p.TestOnly,
+	}
+	if est, err := p.Security(); err == nil {
+		info.SecurityBits = math.Round(est.Bits)
+	}
+	return info
+}
+
+// SaveKeyInfo saves key set metadata to a JSON file
+func SaveKeyInfo(path string, info *KeyInfo) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create params file: %w", err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(info)
+}
+
+// LoadKeyInfo loads key set metadata from a JSON file
+func LoadKeyInfo(path string) (*KeyInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open params file: %w", err)
+	}
+	defer f.Close()
+
+	var info KeyInfo
+	if err := json.NewDecoder(f).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to parse params file: %w", err)
+	}
+	return &info, nil
+}
+
+// CheckKeyDir verifies that the key set in dir (via its params.json) was generated for this profile
+func (p *Profile) CheckKeyDir(dir string) error {
+	info, err := LoadKeyInfo(filepath.Join(dir, "params.json"))
+	if err != nil {
+		return err
+	}
+	if info.ParamsHash == "" {
+		return fmt.Errorf("key set %s has no params hash (generated by an older version); regenerate the keys", dir)
+	}
+	return p.CheckHash(info.ParamsHash, "key set "+dir)
 }
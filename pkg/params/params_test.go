@@ -1,7 +1,16 @@
 package params
 
 import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/ring"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
 func TestNewProfileA(t *testing.T) {
@@ -22,8 +31,13 @@ func TestNewProfileA(t *testing.T) {
 		t.Errorf("Expected Slots=8192, got %d", profile.Slots)
 	}
 
-	if err := profile.Validate(); err != nil {
-		t.Errorf("Profile validation failed: %v", err)
+	// The deprecated definition must not change, or existing tables stop matching
+	if profile.MaxLevel() != 40 {
+		t.Errorf("Expected MaxLevel=40, got %d", profile.MaxLevel())
+	}
+
+	if err := profile.Validate(Policy{}); err == nil || !strings.Contains(err.Error(), "profile A2") {
+		t.Errorf("Validate should reject deprecated Profile A and name A2, got %v", err)
 	}
 
 	if profile.ParamsHash == "" {
@@ -31,6 +45,24 @@ func TestNewProfileA(t *testing.T) {
 	}
 }
 
+func TestNewProfileA2(t *testing.T) {
+	profile, err := NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+
+	if profile.LogN != 14 || profile.Slots != 1<<13 {
+		t.Errorf("Expected LogN=14 and 8192 slots, got LogN=%d and %d slots", profile.LogN, profile.Slots)
+	}
+	if profile.MaxLevel() != 7 {
+		t.Errorf("Expected MaxLevel=7, got %d", profile.MaxLevel())
+	}
+
+	if err := profile.Validate(Policy{}); err != nil {
+		t.Errorf("Profile validation failed: %v", err)
+	}
+}
+
 func TestNewProfileB(t *testing.T) {
 	profile, err := NewProfileB()
 	if err != nil {
@@ -53,11 +85,211 @@ func TestNewProfileB(t *testing.T) {
 		t.Error("Profile B should have bootstrapping enabled")
 	}
 
-	if err := profile.Validate(); err != nil {
+	if profile.MaxLevel() != 16 {
+		t.Errorf("Expected MaxLevel=16, got %d", profile.MaxLevel())
+	}
+
+	if err := profile.Validate(Policy{}); err == nil {
+		t.Error("Validate should reject deprecated Profile B")
+	}
+
+	if err := profile.Validate(Policy{AllowInsecure: true}); err != nil {
+		t.Errorf("Validate should accept deprecated Profile B with AllowInsecure: %v", err)
+	}
+}
+
+func TestNewProfileB2(t *testing.T) {
+	profile, err := NewProfileB2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B2: %v", err)
+	}
+
+	if !profile.BootstrapEnabled {
+		t.Error("Profile B2 should have bootstrapping enabled")
+	}
+	if profile.MaxLevel() != 14 {
+		t.Errorf("Expected MaxLevel=14, got %d", profile.MaxLevel())
+	}
+
+	if err := profile.Validate(Policy{}); err != nil {
 		t.Errorf("Profile validation failed: %v", err)
 	}
 }
 
+func TestNewProfileR(t *testing.T) {
+	profile, err := NewProfileR()
+	if err != nil {
+		t.Fatalf("Failed to create Profile R: %v", err)
+	}
+
+	if !profile.IsConjugateInvariant() {
+		t.Error("Profile R should use the conjugate-invariant ring")
+	}
+
+	// N real slots instead of N/2 complex slots
+	if profile.Slots != 1<<14 {
+		t.Errorf("Expected Slots=16384, got %d", profile.Slots)
+	}
+
+	if err := profile.Validate(Policy{}); err != nil {
+		t.Errorf("Profile validation failed: %v", err)
+	}
+
+	profileA, err := NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+	if profile.ParamsHash == profileA.ParamsHash {
+		t.Error("Profiles R and A2 should have different params hashes")
+	}
+}
+
+func TestNewProfileT(t *testing.T) {
+	profile, err := NewProfileT()
+	if err != nil {
+		t.Fatalf("Failed to create Profile T: %v", err)
+	}
+
+	if profile.LogN != 12 {
+		t.Errorf("Expected LogN=12, got %d", profile.LogN)
+	}
+	if !profile.TestOnly {
+		t.Error("Profile T should be test-only")
+	}
+
+	if err := profile.Validate(Policy{}); err == nil {
+		t.Error("Validate should reject a test-only profile")
+	}
+
+	if err := profile.Validate(Policy{AllowInsecure: true}); err != nil {
+		t.Errorf("Validate should accept a test-only profile with AllowInsecure: %v", err)
+	}
+}
+
+func TestNewProfileTB(t *testing.T) {
+	profile, err := NewProfileTB()
+	if err != nil {
+		t.Fatalf("Failed to create Profile TB: %v", err)
+	}
+
+	if !profile.TestOnly || !profile.BootstrapEnabled {
+		t.Error("Profile TB should be test-only with bootstrapping enabled")
+	}
+	if _, err := profile.BootstrappingParameters(); err != nil {
+		t.Errorf("Failed to create bootstrapping parameters: %v", err)
+	}
+
+	detected, err := DetectProfile(profile.ParamsHash)
+	if err != nil {
+		t.Fatalf("Failed to detect Profile TB: %v", err)
+	}
+	if detected.Type != ProfileTB {
+		t.Errorf("Expected Profile TB to be detected, got %s", detected.Type)
+	}
+}
+
+func TestSeededKeyGenerator(t *testing.T) {
+	profile, err := NewProfileT()
+	if err != nil {
+		t.Fatalf("Failed to create Profile T: %v", err)
+	}
+	p := profile.Params
+
+	genKeys := func(seed string) (*rlwe.SecretKey, *rlwe.PublicKey, []byte) {
+		kgen, err := NewSeededKeyGenerator(p, seed)
+		if err != nil {
+			t.Fatalf("NewSeededKeyGenerator failed: %v", err)
+		}
+		sk := kgen.GenSecretKeyNew()
+		pk := kgen.GenPublicKeyNew(sk)
+		var data []byte
+		for _, key := range []interface{ MarshalBinary() ([]byte, error) }{sk, pk, kgen.GenRelinearizationKeyNew(sk)} {
+			b, err := key.MarshalBinary()
+			if err != nil {
+				t.Fatalf("Failed to marshal key: %v", err)
+			}
+			data = append(data, b...)
+		}
+		return sk, pk, data
+	}
+
+	sk, pk, keys := genKeys("fixtures")
+	if _, _, again := genKeys("fixtures"); !bytes.Equal(keys, again) {
+		t.Error("Same seed should produce identical keys")
+	}
+	if _, _, other := genKeys("other"); bytes.Equal(keys, other) {
+		t.Error("Different seeds should produce different keys")
+	}
+	if _, err := NewSeededKeyGenerator(p, ""); err == nil {
+		t.Error("Expected error for empty seed")
+	}
+
+	// Seeded ciphertexts are reproducible and decrypt, multiply and rotate
+	// correctly under the seeded keys
+	kgen, err := NewSeededKeyGenerator(p, "fixtures")
+	if err != nil {
+		t.Fatalf("NewSeededKeyGenerator failed: %v", err)
+	}
+	galEl := p.GaloisElement(1)
+	evk := rlwe.NewMemEvaluationKeySet(kgen.GenRelinearizationKeyNew(sk), kgen.GenGaloisKeysNew([]uint64{galEl}, sk)...)
+
+	encoder := ckks.NewEncoder(p)
+	values := make([]float64, p.MaxSlots())
+	for i := range values {
+		values[i] = float64(i%7) / 7
+	}
+	pt := ckks.NewPlaintext(p, p.MaxLevel())
+	if err := encoder.Encode(values, pt); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	encrypt := func() *rlwe.Ciphertext {
+		enc, err := NewSeededEncryptor(p, pk, "fixtures")
+		if err != nil {
+			t.Fatalf("NewSeededEncryptor failed: %v", err)
+		}
+		ct, err := enc.EncryptNew(pt)
+		if err != nil {
+			t.Fatalf("EncryptNew failed: %v", err)
+		}
+		return ct
+	}
+	ct := encrypt()
+	first, _ := ct.MarshalBinary()
+	second, _ := encrypt().MarshalBinary()
+	if !bytes.Equal(first, second) {
+		t.Error("Same seed should produce identical ciphertexts")
+	}
+
+	eval := ckks.NewEvaluator(p, evk)
+	sq, err := eval.MulRelinNew(ct, ct)
+	if err != nil {
+		t.Fatalf("MulRelin failed: %v", err)
+	}
+	if err := eval.Rescale(sq, sq); err != nil {
+		t.Fatalf("Rescale failed: %v", err)
+	}
+	rot, err := eval.RotateNew(ct, 1)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	dec := rlwe.NewDecryptor(p, sk)
+	decode := func(ct *rlwe.Ciphertext) []float64 {
+		out := make([]float64, p.MaxSlots())
+		if err := encoder.Decode(dec.DecryptNew(ct), out); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		return out
+	}
+	got, gotSq, gotRot := decode(ct), decode(sq), decode(rot)
+	for i, v := range values {
+		next := values[(i+1)%len(values)]
+		if math.Abs(got[i]-v) > 1e-6 || math.Abs(gotSq[i]-v*v) > 1e-6 || math.Abs(gotRot[i]-next) > 1e-6 {
+			t.Fatalf("Slot %d: expected %.6f, %.6f, %.6f, got %.6f, %.6f, %.6f", i, v, v*v, next, got[i], gotSq[i], gotRot[i])
+		}
+	}
+}
+
 func TestRotationSteps(t *testing.T) {
 	profile, err := NewProfileA()
 	if err != nil {
@@ -92,3 +324,274 @@ func TestProfileString(t *testing.T) {
 		t.Error("String representation should not be empty")
 	}
 }
+
+func TestLoadProfileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	data := `{
+		"name": "C",
+		"log_n": 15,
+		"log_q": [60, 40, 40, 40, 40],
+		"log_p": [61, 61],
+		"log_default_scale": 40,
+		"xs": {"type": "ternary", "h": 192}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write profile file: %v", err)
+	}
+
+	profile, err := LoadProfile(path, Policy{})
+	if err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+
+	if profile.Type != ProfileType("C") {
+		t.Errorf("Expected type C, got %v", profile.Type)
+	}
+	if profile.Slots != 1<<14 {
+		t.Errorf("Expected Slots=16384, got %d", profile.Slots)
+	}
+	if profile.MaxLevel() != 4 {
+		t.Errorf("Expected MaxLevel=4, got %d", profile.MaxLevel())
+	}
+	if profile.BootstrapEnabled {
+		t.Error("Profile without bootstrap section should not enable bootstrapping")
+	}
+}
+
+func TestLoadProfileYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.yaml")
+	data := `
+log_n: 14
+log_q: [60, 45, 45, 45]
+log_p: [61, 61]
+log_default_scale: 45
+xe:
+  type: gaussian
+  sigma: 3.2
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write profile file: %v", err)
+	}
+
+	profile, err := LoadProfile(path, Policy{})
+	if err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+
+	if profile.Type != ProfileCustom {
+		t.Errorf("Expected type custom, got %v", profile.Type)
+	}
+	if profile.LogScale != 45 {
+		t.Errorf("Expected LogScale=45, got %d", profile.LogScale)
+	}
+}
+
+func TestLoadProfileConjugateInvariant(t *testing.T) {
+	data := `{"log_n": 13, "log_q": [55, 40, 40], "log_p": [56], "log_default_scale": 40, "ring_type": "conjugate_invariant"}`
+	spec, err := ParseProfileSpec(strings.NewReader(data), false)
+	if err != nil {
+		t.Fatalf("Failed to parse profile: %v", err)
+	}
+	profile, err := NewProfileFromSpec(spec, Policy{})
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+	if !profile.IsConjugateInvariant() {
+		t.Error("Expected a conjugate-invariant profile")
+	}
+	if profile.Slots != 1<<13 {
+		t.Errorf("Expected Slots=8192, got %d", profile.Slots)
+	}
+}
+
+func TestLoadProfileInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown field", data: `{"log_n": 14, "log_q": [60, 40], "log_p": [60], "log_default_scale": 40, "bogus": 1}`},
+		{name: "missing chain", data: `{"log_n": 14, "log_p": [60], "log_default_scale": 40}`},
+		{name: "bad ring type", data: `{"log_n": 14, "log_q": [60, 40], "log_p": [60], "log_default_scale": 40, "ring_type": "cyclic"}`},
+		{name: "bad distribution", data: `{"log_n": 14, "log_q": [60, 40], "log_p": [60], "log_default_scale": 40, "xs": {"type": "uniform"}}`},
+		{name: "insecure modulus", data: `{"log_n": 13, "log_q": [60, 40, 40, 40, 40], "log_p": [60], "log_default_scale": 40}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := ParseProfileSpec(strings.NewReader(tt.data), false)
+			if err == nil {
+				_, err = NewProfileFromSpec(spec, Policy{})
+			}
+			if err == nil {
+				t.Error("Expected error for invalid profile")
+			}
+		})
+	}
+}
+
+func TestEstimateSecurity(t *testing.T) {
+	tests := []struct {
+		name    string
+		logN    int
+		logQP   float64
+		xs      ring.DistributionParameters
+		minBits float64
+		maxBits float64
+	}{
+		{name: "128-bit bound", logN: 14, logQP: 438, xs: ring.Ternary{P: 2.0 / 3}, minBits: 127.9, maxBits: 128.1},
+		{name: "192-bit bound", logN: 15, logQP: 611, xs: ring.Ternary{P: 2.0 / 3}, minBits: 191.9, maxBits: 192.1},
+		{name: "between bounds", logN: 14, logQP: 401, xs: ring.Ternary{P: 2.0 / 3}, minBits: 128, maxBits: 192},
+		{name: "oversized modulus", logN: 14, logQP: 1780, xs: ring.Ternary{P: 2.0 / 3}, minBits: 0, maxBits: 64},
+		{name: "gaussian secret", logN: 13, logQP: 220, xs: ring.DiscreteGaussian{Sigma: 3.2, Bound: 19}, minBits: 127.9, maxBits: 128.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			est, err := EstimateSecurity(tt.logN, tt.logQP, tt.xs)
+			if err != nil {
+				t.Fatalf("EstimateSecurity failed: %v", err)
+			}
+			if est.Bits < tt.minBits || est.Bits > tt.maxBits {
+				t.Errorf("Expected bits in [%.1f, %.1f], got %.2f", tt.minBits, tt.maxBits, est.Bits)
+			}
+		})
+	}
+
+	if _, err := EstimateSecurity(9, 20, ring.Ternary{P: 2.0 / 3}); err == nil {
+		t.Error("Expected error for LogN without bounds")
+	}
+	est, err := EstimateSecurity(15, 400, ring.Ternary{H: 192})
+	if err != nil {
+		t.Fatalf("EstimateSecurity failed: %v", err)
+	}
+	if len(est.Notes) == 0 {
+		t.Error("Expected a note for sparse secrets")
+	}
+}
+
+func TestBuiltinProfilesSecure(t *testing.T) {
+	for _, pt := range []ProfileType{ProfileA2, ProfileB2, ProfileR} {
+		profile, err := NewProfile(pt)
+		if err != nil {
+			t.Fatalf("Failed to create profile %s: %v", pt, err)
+		}
+		est, err := profile.Security()
+		if err != nil {
+			t.Fatalf("Failed to estimate security of profile %s: %v", pt, err)
+		}
+		if est.Bits < 128 {
+			t.Errorf("Profile %s: expected at least 128-bit security, got %s", pt, est)
+		}
+	}
+}
+
+func TestValidateSecurityFloor(t *testing.T) {
+	profile, err := NewProfileA2()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A2: %v", err)
+	}
+	if err := profile.ValidateSecurity(256); err == nil {
+		t.Error("Expected error for a 256-bit floor")
+	}
+	if err := profile.Validate(Policy{MinBits: 256}); err == nil {
+		t.Error("Validate should enforce the policy's 256-bit floor")
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	profile, err := ResolveProfile("A", "", Policy{})
+	if err != nil {
+		t.Fatalf("Failed to resolve profile A: %v", err)
+	}
+	if profile.Type != ProfileA {
+		t.Errorf("Expected type ProfileA, got %v", profile.Type)
+	}
+
+	if _, err := ResolveProfile("Z", "", Policy{}); err == nil {
+		t.Error("Expected error for unknown profile name")
+	}
+}
+
+func TestDetectProfile(t *testing.T) {
+	// Deprecated profiles are still detected, so that existing data loads with AllowInsecure
+	for _, pt := range []ProfileType{ProfileA, ProfileA2, ProfileB2, ProfileR} {
+		profile, err := NewProfile(pt)
+		if err != nil {
+			t.Fatalf("Failed to create profile %s: %v", pt, err)
+		}
+		detected, err := DetectProfile(profile.ParamsHash)
+		if err != nil {
+			t.Fatalf("Failed to detect profile %s: %v", pt, err)
+		}
+		if detected.Type != pt {
+			t.Errorf("Expected profile %s to be detected, got %s", pt, detected.Type)
+		}
+	}
+	if _, err := DetectProfile("unknown"); err == nil {
+		t.Error("Expected error for an unknown params hash")
+	}
+}
+
+func TestParamsHash(t *testing.T) {
+	profileA, err := NewProfileA()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A: %v", err)
+	}
+	profileB, err := NewProfileB()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B: %v", err)
+	}
+
+	if profileA.ParamsHash == profileB.ParamsHash {
+		t.Error("Profiles A and B should have different params hashes")
+	}
+
+	// The hash covers the parameters, not the profile name
+	renamed, err := NewProfileA()
+	if err != nil {
+		t.Fatalf("Failed to create Profile A: %v", err)
+	}
+	renamed.Type = "renamed"
+	if renamed.computeHash() != profileA.ParamsHash {
+		t.Error("Params hash should not depend on the profile name")
+	}
+
+	if err := profileA.CheckHash(profileA.ParamsHash, "table"); err != nil {
+		t.Errorf("CheckHash failed on matching hash: %v", err)
+	}
+	if err := profileA.CheckHash(profileB.ParamsHash, "table"); err == nil {
+		t.Error("CheckHash should fail on mismatched hash")
+	}
+}
+
+func TestKeyInfo(t *testing.T) {
+	profile, err := NewProfileA()
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := SaveKeyInfo(filepath.Join(dir, "params.json"), profile.KeyInfo()); err != nil {
+		t.Fatalf("Failed to save key info: %v", err)
+	}
+
+	info, err := LoadKeyInfo(filepath.Join(dir, "params.json"))
+	if err != nil {
+		t.Fatalf("Failed to load key info: %v", err)
+	}
+	if info.ParamsHash != profile.ParamsHash {
+		t.Errorf("Expected params hash %s, got %s", profile.ParamsHash, info.ParamsHash)
+	}
+
+	if err := profile.CheckKeyDir(dir); err != nil {
+		t.Errorf("CheckKeyDir failed on matching key set: %v", err)
+	}
+
+	profileB, err := NewProfileB()
+	if err != nil {
+		t.Fatalf("Failed to create Profile B: %v", err)
+	}
+	if err := profileB.CheckKeyDir(dir); err == nil {
+		t.Error("CheckKeyDir should fail for a key set of another profile")
+	}
+}
@@ -0,0 +1,152 @@
+package params
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/ring"
+)
+
+// DefaultMinSecurityBits is the bit-security floor Profile.Validate enforces
+// unless a Policy sets another. It can be lowered for experiments, but keys
+// generated below 128 bits should never protect real data.
+const DefaultMinSecurityBits = 128.0
+
+// Policy is the security Profile.Validate requires of a profile. The zero
+// value enforces DefaultMinSecurityBits and rejects test-only profiles.
+type Policy struct {
+	MinBits       float64 // Bit-security floor; 0 means DefaultMinSecurityBits
+	AllowInsecure bool    // Admit test-only profiles and parameter sets below the floor; never for real data
+}
+
+// minBits returns the floor the policy enforces
+func (p Policy) minBits() float64 {
+	if p.MinBits == 0 {
+		return DefaultMinSecurityBits
+	}
+	return p.MinBits
+}
+
+// heStandardBounds holds the maximum LogQP for 128, 192 and 256-bit classical
+// security from the HomomorphicEncryption.org security standard (Nov. 2018),
+// indexed by LogN. LogN 16 and 17 are not tabulated by the standard and are
+// extrapolated linearly in N, as is common practice (e.g. Lattigo's PN16QP1761).
+var heStandardBounds = map[string]map[int][3]float64{
+	"ternary": {
+		10: {27, 19, 14},
+		11: {54, 37, 29},
+		12: {109, 75, 58},
+		13: {218, 152, 118},
+		14: {438, 305, 237},
+		15: {881, 611, 476},
+		16: {1761, 1222, 952},
+		17: {3522, 2444, 1904},
+	},
+	"gaussian": {
+		10: {29, 21, 16},
+		11: {56, 39, 31},
+		12: {111, 77, 60},
+		13: {220, 154, 120},
+		14: {440, 307, 239},
+		15: {883, 613, 478},
+		16: {1766, 1226, 956},
+		17: {3532, 2452, 1912},
+	},
+}
+
+// securityLevels are the bit-security levels of the heStandardBounds columns
+var securityLevels = [3]float64{128, 192, 256}
+
+// SecurityEstimate is the estimated security of a parameter set
+type SecurityEstimate struct {
+	LogN   int      `json:"log_n"`
+	LogQP  float64  `json:"log_qp"`       // Total modulus size in bits
+	Secret string   `json:"secret"`       // Secret distribution family used for the lookup
+	Bits   float64  `json:"bits"`         // Estimated classical bit-security
+	Max128 float64  `json:"max_logqp128"` // Largest LogQP allowed for 128-bit security at this LogN
+	Notes  []string `json:"notes,omitempty"`
+}
+
+// String returns the printable security claim
+func (s *SecurityEstimate) String() string {
+	return fmt.Sprintf("~%.0f-bit classical security (HE standard, %s secret, LogN=%d, LogQP=%.1f, 128-bit max LogQP=%.0f)",
+		s.Bits, s.Secret, s.LogN, s.LogQP, s.Max128)
+}
+
+// EstimateSecurity estimates the classical bit-security of an RLWE instance with
+// ring degree 2^logN, total modulus of logQP bits and secret distribution xs.
+//
+// The estimate interpolates between the HE-standard bounds, assuming security
+// is proportional to 1/LogQP between (and beyond) the tabulated points.
+func EstimateSecurity(logN int, logQP float64, xs ring.DistributionParameters) (*SecurityEstimate, error) {
+	est := &SecurityEstimate{LogN: logN, LogQP: logQP}
+
+	switch x := xs.(type) {
+	case ring.Ternary:
+		est.Secret = "ternary"
+		if x.H != 0 && x.H < (1<<logN)/2 {
+			est.Notes = append(est.Notes, fmt.Sprintf(
+				"sparse secret (hamming weight %d): HE-standard bounds assume dense secrets, the estimate is optimistic", x.H))
+		}
+	case ring.DiscreteGaussian:
+		est.Secret = "gaussian"
+	default:
+		return nil, fmt.Errorf("no security bounds for secret distribution %T", xs)
+	}
+
+	bounds, ok := heStandardBounds[est.Secret][logN]
+	if !ok {
+		return nil, fmt.Errorf("no security bounds for LogN=%d", logN)
+	}
+	est.Max128 = bounds[0]
+	if logN > 15 {
+		est.Notes = append(est.Notes, fmt.Sprintf("LogN=%d bounds are extrapolated from LogN=15", logN))
+	}
+
+	// Piecewise-linear interpolation of bits against 1/LogQP
+	inv := 1 / logQP
+	x := [3]float64{1 / bounds[0], 1 / bounds[1], 1 / bounds[2]}
+	seg := 0
+	if inv > x[1] {
+		seg = 1
+	}
+	slope := (securityLevels[seg+1] - securityLevels[seg]) / (x[seg+1] - x[seg])
+	est.Bits = math.Max(0, securityLevels[seg]+slope*(inv-x[seg]))
+
+	return est, nil
+}
+
+// Security estimates the security of the profile. For bootstrappable profiles,
+// the bootstrapping parameters (which extend the modulus chain) are the weakest
+// link and determine the estimate.
+func (p *Profile) Security() (*SecurityEstimate, error) {
+	params := p.Params
+	if p.BootstrapEnabled {
+		btpParams, err := p.BootstrappingParameters()
+		if err != nil {
+			return nil, err
+		}
+		params = btpParams.BootstrappingParameters
+	}
+	est, err := EstimateSecurity(params.LogN(), params.LogQP(), params.Xs())
+	if err != nil {
+		return nil, err
+	}
+	if params.RingType() == ring.ConjugateInvariant {
+		est.Notes = append(est.Notes,
+			"conjugate-invariant ring: estimated with the bounds of the standard ring of the same degree")
+	}
+	return est, nil
+}
+
+// ValidateSecurity returns an error if the profile's estimated security is below minBits
+func (p *Profile) ValidateSecurity(minBits float64) error {
+	est, err := p.Security()
+	if err != nil {
+		return fmt.Errorf("cannot estimate security: %w", err)
+	}
+	if est.Bits < minBits {
+		return fmt.Errorf("insecure parameters: %s is below the %.0f-bit floor", est, minBits)
+	}
+	return nil
+}
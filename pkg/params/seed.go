@@ -0,0 +1,251 @@
+package params
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/ring"
+	"github.com/tuneinsight/lattigo/v6/ring/ringqp"
+	"github.com/tuneinsight/lattigo/v6/utils/sampling"
+)
+
+// KeyGenerator generates a key set; *rlwe.KeyGenerator and
+// *SeededKeyGenerator implement it
+type KeyGenerator interface {
+	GenSecretKeyNew() *rlwe.SecretKey
+	GenPublicKeyNew(sk *rlwe.SecretKey) *rlwe.PublicKey
+	GenRelinearizationKeyNew(sk *rlwe.SecretKey, evkParams ...rlwe.EvaluationKeyParameters) *rlwe.RelinearizationKey
+	GenGaloisKeysNew(galEls []uint64, sk *rlwe.SecretKey, evkParams ...rlwe.EvaluationKeyParameters) []*rlwe.GaloisKey
+}
+
+// Encryptor encrypts plaintexts; *rlwe.Encryptor and *SeededEncryptor implement it
+type Encryptor interface {
+	EncryptNew(pt *rlwe.Plaintext) (*rlwe.Ciphertext, error)
+}
+
+// seededSamplers holds the samplers of rlwe.Encryptor, all reading from one
+// PRNG keyed by a seed instead of by crypto/rand
+type seededSamplers struct {
+	params  rlwe.Parameters
+	prng    sampling.PRNG
+	xs, xe  ring.Sampler
+	uniform ringqp.UniformSampler
+}
+
+// newSeededSamplers keys the PRNG with sha256(purpose || seed), so that key
+// generation and encryption with the same seed draw independent streams
+func newSeededSamplers(p rlwe.ParameterProvider, seed, purpose string) (*seededSamplers, error) {
+	if seed == "" {
+		return nil, fmt.Errorf("seed cannot be empty")
+	}
+	params := *p.GetRLWEParameters()
+	key := sha256.Sum256([]byte("lattigostats/" + purpose + "\x00" + seed))
+	prng, err := sampling.NewKeyedPRNG(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create seeded PRNG: %w", err)
+	}
+	xs, err := ring.NewSampler(prng, params.RingQ(), params.Xs(), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret sampler: %w", err)
+	}
+	xe, err := ring.NewSampler(prng, params.RingQ(), params.Xe(), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error sampler: %w", err)
+	}
+	return &seededSamplers{
+		params:  params,
+		prng:    prng,
+		xs:      xs,
+		xe:      xe,
+		uniform: ringqp.NewUniformSampler(prng, *params.RingQP()),
+	}, nil
+}
+
+// SeededKeyGenerator generates keys like rlwe.KeyGenerator, but from a PRNG
+// keyed by a seed rather than by crypto/rand, so that the same seed always
+// yields byte-identical keys. It never touches the process-wide
+// crypto/rand.Reader.
+//
+// Seeded keys are predictable: use them only with test-only profiles to
+// produce golden fixtures.
+type SeededKeyGenerator struct {
+	*seededSamplers
+}
+
+// NewSeededKeyGenerator creates a key generator for p whose randomness is derived from seed
+func NewSeededKeyGenerator(p rlwe.ParameterProvider, seed string) (*SeededKeyGenerator, error) {
+	s, err := newSeededSamplers(p, seed, "keygen")
+	if err != nil {
+		return nil, err
+	}
+	return &SeededKeyGenerator{s}, nil
+}
+
+// GenSecretKeyNew samples a secret key from the secret distribution of the parameters
+func (kgen *SeededKeyGenerator) GenSecretKeyNew() *rlwe.SecretKey {
+	sk := rlwe.NewSecretKey(kgen.params)
+	ringQP := kgen.params.RingQP().AtLevel(sk.LevelQ(), sk.LevelP())
+	kgen.xs.AtLevel(sk.LevelQ()).Read(sk.Value.Q)
+	if levelP := sk.LevelP(); levelP > -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(sk.Value.Q, levelP, sk.Value.Q, sk.Value.P)
+	}
+	ringQP.NTT(sk.Value, sk.Value)
+	ringQP.MForm(sk.Value, sk.Value)
+	return sk
+}
+
+// GenPublicKeyNew generates the public key of sk
+func (kgen *SeededKeyGenerator) GenPublicKeyNew(sk *rlwe.SecretKey) *rlwe.PublicKey {
+	pk := rlwe.NewPublicKey(kgen.params)
+	kgen.encryptZero(kgen.uniform, sk.Value, pk.Value[0], pk.Value[1])
+	return pk
+}
+
+// GenRelinearizationKeyNew generates the relinearization key of sk
+func (kgen *SeededKeyGenerator) GenRelinearizationKeyNew(sk *rlwe.SecretKey, evkParams ...rlwe.EvaluationKeyParameters) *rlwe.RelinearizationKey {
+	rlk := rlwe.NewRelinearizationKey(kgen.params, evkParams...)
+	sk2 := kgen.params.RingQ().NewPoly()
+	sk2.CopyLvl(rlk.LevelQ(), sk.Value.Q)
+	kgen.params.RingQ().AtLevel(rlk.LevelQ()).MulCoeffsMontgomery(sk2, sk.Value.Q, sk2)
+	kgen.genEvaluationKey(sk2, sk.Value, &rlk.EvaluationKey)
+	return rlk
+}
+
+// GenGaloisKeysNew generates the Galois keys of sk for every Galois element in galEls
+func (kgen *SeededKeyGenerator) GenGaloisKeysNew(galEls []uint64, sk *rlwe.SecretKey, evkParams ...rlwe.EvaluationKeyParameters) []*rlwe.GaloisKey {
+	gks := make([]*rlwe.GaloisKey, len(galEls))
+	for i, galEl := range galEls {
+		gk := rlwe.NewGaloisKey(kgen.params, evkParams...)
+		ringQP := kgen.params.RingQP().AtLevel(gk.LevelQ(), gk.LevelP())
+
+		// Encrypts [-a * pi_{k^-1}(sk) + sk, a], as rlwe.KeyGenerator does
+		index, err := ring.AutomorphismNTTIndex(ringQP.RingQ.N(), ringQP.RingQ.NthRoot(), kgen.params.ModInvGaloisElement(galEl))
+		if err != nil {
+			// Sanity check, this error should not happen
+			panic(err)
+		}
+		skOut := kgen.params.RingQP().NewPoly()
+		ringQP.RingQ.AutomorphismNTTWithIndex(sk.Value.Q, index, skOut.Q)
+		if ringQP.RingP != nil {
+			ringQP.RingP.AutomorphismNTTWithIndex(sk.Value.P, index, skOut.P)
+		}
+		kgen.genEvaluationKey(sk.Value.Q, skOut, &gk.EvaluationKey)
+
+		gk.GaloisElement = galEl
+		gk.NthRoot = ringQP.RingQ.NthRoot()
+		gks[i] = gk
+	}
+	return gks
+}
+
+// genEvaluationKey encrypts skIn times the gadget vector under skOut
+func (kgen *SeededKeyGenerator) genEvaluationKey(skIn ring.Poly, skOut ringqp.Poly, evk *rlwe.EvaluationKey) {
+	// A compressed key stores the seed of its uniform elements
+	uniform := kgen.uniform
+	if evk.IsCompressed() {
+		evk.Seed = make([]byte, 32)
+		if _, err := kgen.prng.Read(evk.Seed); err != nil {
+			panic(fmt.Errorf("unable to sample evaluation key seed: %w", err))
+		}
+		prng, err := sampling.NewKeyedPRNG(evk.Seed)
+		if err != nil {
+			panic(fmt.Errorf("sampling.NewKeyedPRNG: %w", err))
+		}
+		uniform = ringqp.NewUniformSampler(prng, *kgen.params.RingQP())
+	}
+
+	for i := range evk.Value {
+		for j := range evk.Value[i] {
+			c1 := kgen.params.RingQP().NewPoly()
+			if len(evk.Value[i][j]) > 1 {
+				c1 = evk.Value[i][j][1]
+			}
+			kgen.encryptZero(uniform, skOut, evk.Value[i][j][0], c1)
+		}
+	}
+
+	if err := rlwe.AddPolyTimesGadgetVectorToGadgetCiphertext(skIn, []rlwe.GadgetCiphertext{evk.GadgetCiphertext}, *kgen.params.RingQP(), kgen.params.RingQ().NewPoly()); err != nil {
+		// Sanity check, this error should not happen
+		panic(err)
+	}
+}
+
+// encryptZero writes the NTT and Montgomery form encryption of zero
+// (-c1*sk + e, c1) under sk, at the levels of c0
+func (kgen *SeededKeyGenerator) encryptZero(uniform ringqp.UniformSampler, sk, c0, c1 ringqp.Poly) {
+	levelQ, levelP := c0.LevelQ(), c0.LevelP()
+	ringQP := kgen.params.RingQP().AtLevel(levelQ, levelP)
+
+	uniform.AtLevel(levelQ, levelP).Read(c1)
+
+	kgen.xe.AtLevel(levelQ).Read(c0.Q)
+	if levelP != -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(c0.Q, levelP, c0.Q, c0.P)
+	}
+	ringQP.NTT(c0, c0)
+	ringQP.MForm(c0, c0)
+	ringQP.MulCoeffsMontgomeryThenSub(c1, sk, c0)
+}
+
+// SeededEncryptor encrypts with a public key like rlwe.Encryptor, but from a
+// PRNG keyed by a seed, so that the same seed, key and sequence of plaintexts
+// always yield byte-identical ciphertexts. Like SeededKeyGenerator, it is for
+// golden fixtures only.
+type SeededEncryptor struct {
+	*seededSamplers
+	pk *rlwe.PublicKey
+	be *ring.BasisExtender
+}
+
+// NewSeededEncryptor creates a public-key encryptor for p whose randomness is derived from seed
+func NewSeededEncryptor(p rlwe.ParameterProvider, pk *rlwe.PublicKey, seed string) (*SeededEncryptor, error) {
+	s, err := newSeededSamplers(p, seed, "encrypt")
+	if err != nil {
+		return nil, err
+	}
+	if s.params.PCount() == 0 {
+		return nil, fmt.Errorf("seeded encryption needs parameters with a special modulus P")
+	}
+	return &SeededEncryptor{
+		seededSamplers: s,
+		pk:             pk,
+		be:             ring.NewBasisExtender(s.params.RingQ(), s.params.RingP()),
+	}, nil
+}
+
+// EncryptNew encrypts pt under the public key into a new ciphertext with the metadata of pt
+func (enc *SeededEncryptor) EncryptNew(pt *rlwe.Plaintext) (*rlwe.Ciphertext, error) {
+	levelQ, levelP := pt.Level(), enc.params.MaxLevelP()
+	ct := rlwe.NewCiphertext(enc.params, 1, levelQ)
+	*ct.MetaData = *pt.MetaData
+
+	ringQP := enc.params.RingQP().AtLevel(levelQ, levelP)
+	ringQ := ringQP.RingQ
+
+	// (u*pk0 + e0, u*pk1 + e1) in QP, divided by P
+	u := enc.params.RingQP().NewPoly()
+	enc.xs.AtLevel(levelQ).Read(u.Q)
+	ringQP.ExtendBasisSmallNormAndCenter(u.Q, levelP, u.Q, u.P)
+	ringQP.NTT(u, u)
+
+	e := enc.params.RingQP().NewPoly()
+	for i := range ct.Value {
+		c := enc.params.RingQP().NewPoly()
+		ringQP.MulCoeffsMontgomery(u, enc.pk.Value[i], c)
+		ringQP.INTT(c, c)
+		enc.xe.AtLevel(levelQ).Read(e.Q)
+		ringQP.ExtendBasisSmallNormAndCenter(e.Q, levelP, e.Q, e.P)
+		ringQP.Add(c, e, c)
+		enc.be.ModDownQPtoQ(levelQ, levelP, c.Q, c.P, ct.Value[i])
+	}
+
+	if ct.IsNTT {
+		ringQ.NTT(ct.Value[0], ct.Value[0])
+		ringQ.NTT(ct.Value[1], ct.Value[1])
+	}
+
+	// ct has the metadata of pt, so both are in the same domain
+	ringQ.Add(ct.Value[0], pt.Value, ct.Value[0])
+	return ct, nil
+}
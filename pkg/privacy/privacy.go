@@ -189,9 +189,10 @@ func (i *Inspector) InspectCount(count int, jobID string, conditions map[string]
 
 // ContingencyTable represents a multi-way contingency table
 type ContingencyTable struct {
-	Dimensions []string         `json:"dimensions"`
-	Categories map[string][]int `json:"categories"`
-	Counts     map[string]int   `json:"counts"` // key is comma-separated category values
+	Dimensions []string            `json:"dimensions"`
+	Categories map[string][]int    `json:"categories"`
+	Labels     map[string][]string `json:"labels,omitempty"` // Category dictionaries; label i is code i+1
+	Counts     map[string]int      `json:"counts"`           // key is comma-separated category labels, or codes without a dictionary
 }
 
 // InspectContingencyTable inspects a contingency table (LBc result)
@@ -228,6 +229,7 @@ func (i *Inspector) InspectContingencyTable(table *ContingencyTable, jobID strin
 		result.TransformedValue = &ContingencyTable{
 			Dimensions: table.Dimensions,
 			Categories: table.Categories,
+			Labels:     table.Labels,
 			Counts:     transformedCounts,
 		}
 	}
@@ -281,6 +283,7 @@ func (i *Inspector) InspectPercentile(bucket int, count int, k float64, jobID st
 // LBcPostProcessor handles post-processing for LBc results
 type LBcPostProcessor struct {
 	policy *Policy
+	labels map[string][]string
 }
 
 // NewLBcPostProcessor creates a new LBc post-processor
@@ -291,6 +294,13 @@ func NewLBcPostProcessor(policy *Policy) *LBcPostProcessor {
 	return &LBcPostProcessor{policy: policy}
 }
 
+// WithLabels makes the post-processor key contingency table cells by the
+// category labels of each dimension that has a dictionary
+func (p *LBcPostProcessor) WithLabels(labels map[string][]string) *LBcPostProcessor {
+	p.labels = labels
+	return p
+}
+
 // PostProcessResult represents the output of LBc post-processing
 type PostProcessResult struct {
 	Table        *ContingencyTable `json:"table"`
@@ -327,6 +337,16 @@ func (p *LBcPostProcessor) ProcessDecryptedChunks(
 		Categories: make(map[string][]int),
 		Counts:     make(map[string]int),
 	}
+	dimLabels := make([][]string, len(dimensions))
+	for i, dim := range dimensions {
+		if labels, ok := p.labels[dim]; ok {
+			if table.Labels == nil {
+				table.Labels = make(map[string][]string)
+			}
+			table.Labels[dim] = labels
+			dimLabels[i] = labels
+		}
+	}
 
 	for i, dim := range dimensions {
 		cats := make([]int, categoryCounts[i])
@@ -338,7 +358,7 @@ func (p *LBcPostProcessor) ProcessDecryptedChunks(
 
 	// Populate counts
 	for i, v := range aggregated {
-		key := indexToKey(i, categoryCounts)
+		key := indexToKey(i, categoryCounts, dimLabels)
 		table.Counts[key] = int(math.Round(v))
 	}
 
@@ -364,8 +384,9 @@ func (p *LBcPostProcessor) ProcessDecryptedChunks(
 	}, nil
 }
 
-// indexToKey converts a flat index to a comma-separated category key
-func indexToKey(index int, categoryCounts []int) string {
+// indexToKey converts a flat index to a comma-separated category key,
+// using the label of a category where its dimension has one
+func indexToKey(index int, categoryCounts []int, labels [][]string) string {
 	if len(categoryCounts) == 0 {
 		return ""
 	}
@@ -382,7 +403,11 @@ func indexToKey(index int, categoryCounts []int) string {
 		if i > 0 {
 			key += ","
 		}
-		key += fmt.Sprintf("%d", v)
+		if v <= len(labels[i]) {
+			key += labels[i][v-1]
+		} else {
+			key += fmt.Sprintf("%d", v)
+		}
 	}
 	return key
 }
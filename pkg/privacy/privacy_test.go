@@ -136,3 +136,32 @@ func TestViolation(t *testing.T) {
 		t.Error("Expected non-empty message")
 	}
 }
+
+func TestProcessDecryptedChunksLabels(t *testing.T) {
+	proc := NewLBcPostProcessor(nil).WithLabels(map[string][]string{
+		"region": {"North", "South"},
+	})
+
+	// 2x3 table of region x risk, split over two chunks
+	chunks := [][]float64{
+		{5, 1, 2, 7, 0, 3},
+		{1, 0, 0, 1, 0, 3},
+	}
+	result, err := proc.ProcessDecryptedChunks(chunks, []string{"region", "risk"}, []int{2, 3}, "lbc_job")
+	if err != nil {
+		t.Fatalf("ProcessDecryptedChunks failed: %v", err)
+	}
+
+	want := map[string]int{
+		"North,1": 6, "North,2": 1, "North,3": 2,
+		"South,1": 8, "South,2": 0, "South,3": 6,
+	}
+	for key, count := range want {
+		if got, ok := result.Table.Counts[key]; !ok || got != count {
+			t.Errorf("Cell %s: expected %d, got %d (present=%v)", key, count, got, ok)
+		}
+	}
+	if len(result.Table.Labels["region"]) != 2 {
+		t.Error("Expected the region dictionary to be kept with the table")
+	}
+}
@@ -0,0 +1,327 @@
+package schema
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultMissingValues are the cells every column treats as missing
+var DefaultMissingValues = []string{"", "NA", "null"}
+
+// missingCandidates are the cells InferSchema recognizes as missing-value markers
+var missingCandidates = map[string]bool{
+	"": true, "NA": true, "N/A": true, "na": true, "n/a": true, "null": true, "NULL": true,
+	"None": true, "none": true, "NaN": true, "nan": true, "?": true, "-": true, ".": true,
+}
+
+// identifierShare is the share of distinct values from which a column looks
+// like a row identifier rather than a category: with one BMV per value, nearly
+// every row would get its own BMV
+const identifierShare = 0.9
+
+// InferOptions configures schema inference
+type InferOptions struct {
+	Name          string // Table name of the proposed schema
+	MaxCategories int    // Columns with at most this many distinct values are categorical
+	MaxRows       int    // Rows to scan; 0 scans the whole file
+}
+
+// DefaultInferOptions returns the default inference options
+func DefaultInferOptions() InferOptions {
+	return InferOptions{
+		Name:          "inferred",
+		MaxCategories: 20,
+	}
+}
+
+// InferReport summarizes what InferSchema saw in each column
+type InferReport struct {
+	Rows     int                 `json:"rows"`
+	Columns  []ColumnObservation `json:"columns"`
+	Skipped  []string            `json:"skipped,omitempty"` // Columns left out of the schema
+	Warnings []string            `json:"warnings,omitempty"`
+}
+
+// ColumnObservation is the evidence behind one column's inferred type
+type ColumnObservation struct {
+	Name     string     `json:"name"`
+	Type     ColumnType `json:"type,omitempty"`
+	Values   int        `json:"values"`   // Non-missing cells
+	Missing  int        `json:"missing"`  // Missing cells
+	Distinct int        `json:"distinct"` // Distinct values, capped at MaxCategories+1
+	Numeric  bool       `json:"numeric"`
+	Integer  bool       `json:"integer"`
+	Min      float64    `json:"min,omitempty"`
+	Max      float64    `json:"max,omitempty"`
+}
+
+// columnScan accumulates the statistics of one column in a single pass, using
+// memory bounded by the category cap rather than by the row count
+type columnScan struct {
+	name     string
+	values   int
+	missing  int
+	markers  map[string]bool
+	distinct map[string]bool
+	overflow bool
+	numeric  bool
+	integer  bool
+	boolean  bool
+	date     bool
+	instant  bool
+	min, max float64
+}
+
+func newColumnScan(name string) *columnScan {
+	return &columnScan{
+		name:     name,
+		markers:  make(map[string]bool),
+		distinct: make(map[string]bool),
+		numeric:  true,
+		integer:  true,
+		boolean:  true,
+		date:     true,
+		instant:  true,
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+func (c *columnScan) add(cell string, maxCategories int) {
+	if missingCandidates[cell] {
+		c.missing++
+		c.markers[cell] = true
+		return
+	}
+	c.values++
+	if !c.overflow && !c.distinct[cell] {
+		if len(c.distinct) == maxCategories {
+			c.overflow = true
+		} else {
+			c.distinct[cell] = true
+		}
+	}
+	if c.boolean {
+		if _, err := parseBool(cell); err != nil {
+			c.boolean = false
+		}
+	}
+	if c.date {
+		if _, err := time.Parse(DateLayout, cell); err != nil {
+			c.date = false
+		}
+	}
+	if c.instant {
+		if _, err := time.Parse(time.RFC3339, cell); err != nil {
+			c.instant = false
+		}
+	}
+	if !c.numeric {
+		return
+	}
+	v, err := strconv.ParseFloat(cell, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		c.numeric, c.integer = false, false
+		return
+	}
+	if _, err := strconv.Atoi(cell); err != nil {
+		c.integer = false
+	}
+	c.min = math.Min(c.min, v)
+	c.max = math.Max(c.max, v)
+}
+
+// identifierLike reports whether nearly every value of a column within the
+// category cap is distinct
+func (c *columnScan) identifierLike() bool {
+	return !c.overflow && float64(len(c.distinct)) >= identifierShare*float64(c.values)
+}
+
+// column proposes a schema column, or returns false if the column cannot be
+// encrypted, e.g. high-cardinality text such as identifiers. The message
+// explains a skipped column or warns about a kept one.
+func (c *columnScan) column(maxCategories int) (Column, bool, string) {
+	col := Column{Name: c.name}
+	for m := range c.markers {
+		if !isDefaultMissing(m) {
+			col.MissingValues = append(col.MissingValues, m)
+		}
+	}
+	sort.Strings(col.MissingValues)
+
+	distinct := len(c.distinct)
+	switch {
+	case c.values == 0:
+		return col, false, fmt.Sprintf("column %q has no values", c.name)
+
+	case c.date || c.instant:
+		col.Type = Date
+		if c.instant {
+			col.Type = Timestamp
+		}
+		col.Description = "inferred from time literals; set epoch near the data and derive year/quarter/month if needed"
+		return col, true, ""
+
+	case c.boolean && !c.numeric:
+		col.Type = Boolean
+		col.Description = "inferred from true/false literals"
+		return col, true, ""
+
+	case c.integer && c.identifierLike() && c.max-c.min < float64(2*distinct):
+		// A run of (nearly) all distinct integers numbers the rows; it is not
+		// a domain of category codes
+		col.Type = Numerical
+		col.Description = fmt.Sprintf("%d distinct integers in %d rows; looks like a row identifier, drop it unless it is a measurement", distinct, c.values)
+		if c.max > c.min {
+			col.MinValue, col.MaxValue = c.min, c.max
+		}
+		return col, true, fmt.Sprintf("column %q looks like a row identifier and is proposed as numerical", c.name)
+
+	case c.integer && !c.overflow && c.min >= 0:
+		// Small non-negative integer domains are category codes; contiguous
+		// ones with at least three levels are guessed to be ordered
+		base, top := int(c.min), int(c.max)
+		if base > 1 {
+			base = DefaultCodeBase
+		}
+		if span := top - base + 1; span <= maxCategories && span <= 2*distinct {
+			col.Type = Categorical
+			col.CategoryCount = span
+			col.Description = fmt.Sprintf("inferred from %d distinct integer codes in [%d, %d]", distinct, int(c.min), top)
+			if span == distinct && distinct >= 3 {
+				col.Type = Ordinal
+				col.Description += "; ordinal guessed from contiguous codes, declare categorical if unordered"
+			}
+			if base != DefaultCodeBase {
+				col.CodeBase = &base
+			}
+			return col, true, ""
+		}
+		fallthrough
+
+	case c.numeric:
+		col.Type = Numerical
+		if c.max > c.min {
+			col.MinValue, col.MaxValue = c.min, c.max
+			col.Description = fmt.Sprintf("inferred bounds are the observed range [%g, %g]", c.min, c.max)
+		} else {
+			col.Description = fmt.Sprintf("constant %g in the scanned rows", c.min)
+		}
+		return col, true, ""
+
+	case !c.overflow && !c.identifierLike():
+		col.Type = Categorical
+		for label := range c.distinct {
+			col.Labels = append(col.Labels, label)
+		}
+		sort.Strings(col.Labels)
+		col.CategoryCount = len(col.Labels)
+		col.Description = fmt.Sprintf("inferred from %d distinct labels; reorder and declare ordinal if ordered", distinct)
+		return col, true, ""
+
+	case c.overflow:
+		return col, false, fmt.Sprintf("column %q has more than %d distinct non-numeric values", c.name, distinct)
+
+	default:
+		return col, false, fmt.Sprintf("column %q has %d distinct non-numeric values in %d rows and looks like an identifier", c.name, distinct, c.values)
+	}
+}
+
+func (c *columnScan) observation(typ ColumnType) ColumnObservation {
+	obs := ColumnObservation{
+		Name:     c.name,
+		Type:     typ,
+		Values:   c.values,
+		Missing:  c.missing,
+		Distinct: len(c.distinct),
+		Numeric:  c.numeric && c.values > 0,
+		Integer:  c.integer && c.values > 0,
+	}
+	if c.overflow {
+		obs.Distinct++
+	}
+	if obs.Numeric {
+		obs.Min, obs.Max = c.min, c.max
+	}
+	return obs
+}
+
+// InferSchema proposes a table schema from a CSV stream with a header row.
+// It reads the stream once, so it works on inputs larger than memory.
+// Numerical bounds are the observed range and must be widened by hand if
+// later data may exceed them.
+func InferSchema(r io.Reader, opts InferOptions) (*TableSchema, *InferReport, error) {
+	if opts.MaxCategories <= 0 {
+		return nil, nil, fmt.Errorf("max categories must be positive")
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultInferOptions().Name
+	}
+
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	scans := make([]*columnScan, len(header))
+	for i, name := range header {
+		scans[i] = newColumnScan(name)
+	}
+
+	report := &InferReport{}
+	for opts.MaxRows == 0 || report.Rows < opts.MaxRows {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CSV row %d: %w", report.Rows+1, err)
+		}
+		for i, cell := range record {
+			scans[i].add(cell, opts.MaxCategories)
+		}
+		report.Rows++
+	}
+	if report.Rows == 0 {
+		return nil, nil, fmt.Errorf("CSV has no data rows")
+	}
+
+	s := &TableSchema{Name: opts.Name}
+	for _, scan := range scans {
+		col, ok, reason := scan.column(opts.MaxCategories)
+		if !ok {
+			report.Skipped = append(report.Skipped, scan.name)
+			report.Warnings = append(report.Warnings, reason)
+			report.Columns = append(report.Columns, scan.observation(""))
+			continue
+		}
+		if reason != "" {
+			report.Warnings = append(report.Warnings, reason)
+		}
+		s.Columns = append(s.Columns, col)
+		report.Columns = append(report.Columns, scan.observation(col.Type))
+	}
+	if opts.MaxRows > 0 && report.Rows == opts.MaxRows {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("only the first %d rows were scanned", report.Rows))
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, report, fmt.Errorf("inferred schema is invalid: %w", err)
+	}
+	return s, report, nil
+}
+
+func isDefaultMissing(cell string) bool {
+	for _, m := range DefaultMissingValues {
+		if cell == m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const inferCSV = `id,age,income,gender,city,score,flag
+1,25,50000,M,Paris,1,0
+2,31,N/A,F,Lyon,2,1
+3,47,72000.5,F,?,3,0
+4,52,61000,M,Paris,2,1
+5,38,,F,Nice,1,
+`
+
+func TestInferSchema(t *testing.T) {
+	s, report, err := InferSchema(strings.NewReader(inferCSV), InferOptions{Name: "people", MaxCategories: 4})
+	if err != nil {
+		t.Fatalf("InferSchema failed: %v", err)
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Inferred schema does not validate: %v", err)
+	}
+	if report.Rows != 5 {
+		t.Errorf("Expected 5 rows scanned, got %d", report.Rows)
+	}
+
+	wantTypes := map[string]ColumnType{
+		"id":     Numerical,
+		"age":    Numerical,
+		"income": Numerical,
+		"gender": Categorical,
+		"city":   Categorical,
+		"score":  Ordinal,
+		"flag":   Categorical,
+	}
+	for name, want := range wantTypes {
+		col := s.GetColumn(name)
+		if col == nil {
+			t.Errorf("Column %s missing from inferred schema", name)
+			continue
+		}
+		if col.Type != want {
+			t.Errorf("Column %s: expected %s, got %s", name, want, col.Type)
+		}
+	}
+
+	income := s.GetColumn("income")
+	if income.MinValue != 50000 || income.MaxValue != 72000.5 {
+		t.Errorf("income bounds: expected [50000, 72000.5], got [%g, %g]", income.MinValue, income.MaxValue)
+	}
+	if !income.IsMissing("N/A") || !income.IsMissing("") {
+		t.Errorf("income should treat N/A and empty cells as missing (markers %v)", income.MissingValues)
+	}
+
+	city := s.GetColumn("city")
+	if city.Categories() != 3 || city.Label(1) != "Lyon" || !city.IsMissing("?") {
+		t.Errorf("city: unexpected dictionary %v or markers %v", city.Labels, city.MissingValues)
+	}
+
+	flag := s.GetColumn("flag")
+	if flag.Base() != 0 || flag.Categories() != 2 {
+		t.Errorf("flag: expected codes 0..1, got base %d and %d categories", flag.Base(), flag.Categories())
+	}
+}
+
+func TestInferSchemaSkipsIdentifiers(t *testing.T) {
+	csv := "name,amount\nalice,1.5\nbob,2.5\ncarol,3.5\n"
+	s, report, err := InferSchema(strings.NewReader(csv), InferOptions{MaxCategories: 2})
+	if err != nil {
+		t.Fatalf("InferSchema failed: %v", err)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0] != "name" {
+		t.Errorf("Expected name to be skipped, got %v", report.Skipped)
+	}
+	if len(s.Columns) != 1 || s.Columns[0].Name != "amount" {
+		t.Errorf("Expected only amount in the schema, got %+v", s.Columns)
+	}
+}
+
+func TestInferSchemaMaxRows(t *testing.T) {
+	s, report, err := InferSchema(strings.NewReader(inferCSV), InferOptions{MaxCategories: 4, MaxRows: 2})
+	if err != nil {
+		t.Fatalf("InferSchema failed: %v", err)
+	}
+	if report.Rows != 2 || len(report.Warnings) == 0 {
+		t.Errorf("Expected 2 rows and a sampling warning, got %d rows and %v", report.Rows, report.Warnings)
+	}
+	if age := s.GetColumn("age"); age.MaxValue != 31 {
+		t.Errorf("age bounds should come from the scanned rows only, got max %g", age.MaxValue)
+	}
+}
+
+func TestInferSchemaIdentifierColumns(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("id,user,region\n")
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&b, "%d,user%d,%d\n", i, i, i%4+1)
+	}
+	s, report, err := InferSchema(strings.NewReader(b.String()), InferOptions{MaxCategories: 20})
+	if err != nil {
+		t.Fatalf("InferSchema failed: %v", err)
+	}
+
+	// Distinct integers within the category cap are not category codes
+	if id := s.GetColumn("id"); id == nil || id.Type != Numerical {
+		t.Errorf("id: expected a numerical column, got %+v", id)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0] != "user" {
+		t.Errorf("Expected user to be skipped as an identifier, got %v", report.Skipped)
+	}
+	if region := s.GetColumn("region"); region == nil || region.Type != Ordinal {
+		t.Errorf("region: expected an ordinal column, got %+v", region)
+	}
+	if len(report.Warnings) != 2 {
+		t.Errorf("Expected warnings for id and user, got %v", report.Warnings)
+	}
+}
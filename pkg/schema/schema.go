@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 )
 
 // ColumnType represents the type of data in a column
@@ -15,19 +17,43 @@ type ColumnType string
 const (
 	// Numerical represents real-valued columns (encoded as CKKS floats)
 	Numerical ColumnType = "numerical"
-	// Categorical represents integer-coded categorical columns [1..S_f]
+	// Categorical represents categorical columns coded as integers
+	// [base..base+S_f-1], optionally labelled through a category dictionary
 	Categorical ColumnType = "categorical"
-	// Ordinal represents ordered categorical columns [1..S_f]
+	// Ordinal represents ordered categorical columns [base..base+S_f-1]
 	Ordinal ColumnType = "ordinal"
+	// Date represents calendar dates, encoded as days since the column's epoch
+	Date ColumnType = "date"
+	// Timestamp represents instants, encoded as seconds since the column's epoch
+	Timestamp ColumnType = "timestamp"
+	// Boolean represents true/false columns coded 0/1, so that each block is
+	// itself the BMV of true
+	Boolean ColumnType = "boolean"
 )
 
+// DefaultCodeBase is the first category code of columns that do not declare one
+const DefaultCodeBase = 1
+
+// MetadataVersion is the format version of new tables. Tables of version
+// 1.0 predate declared code domains: their categorical and ordinal columns
+// have BMVs for codes 0..S_f-1, which LoadMetadata maps to a code base of 0.
+const MetadataVersion = "1.1"
+
 // Column defines a single column in the encrypted table
 type Column struct {
 	Name          string     `json:"name"`
 	Type          ColumnType `json:"type"`
 	CategoryCount int        `json:"category_count,omitempty"` // S_f for categorical/ordinal
-	MinValue      float64    `json:"min_value,omitempty"`      // For numerical normalization
-	MaxValue      float64    `json:"max_value,omitempty"`      // For numerical normalization
+	CodeBase      *int       `json:"code_base,omitempty"`      // First category code (default 1)
+	Labels        []string   `json:"labels,omitempty"`         // Category dictionary: code base+i is Labels[i]
+	MinValue      float64    `json:"min_value,omitempty"`      // Lower bound for numerical normalization
+	MaxValue      float64    `json:"max_value,omitempty"`      // Upper bound for numerical normalization
+	MissingValues []string   `json:"missing_values,omitempty"` // Cells treated as missing besides "", NA and null
+	Epoch         string     `json:"epoch,omitempty"`          // Origin of date/timestamp offsets (default 1970-01-01)
+	Format        string     `json:"format,omitempty"`         // Go time layout of date/timestamp cells
+	Derive        []string   `json:"derive,omitempty"`         // Calendar parts encrypted as derived ordinal columns
+	DerivedFrom   string     `json:"derived_from,omitempty"`   // Source date/timestamp column of a derived column
+	Part          string     `json:"part,omitempty"`           // Calendar part held by a derived column
 	Description   string     `json:"description,omitempty"`
 }
 
@@ -38,15 +64,292 @@ func (c *Column) Validate() error {
 	}
 	switch c.Type {
 	case Numerical:
-		// Numerical columns don't require category count
+		// Numerical columns don't require category count; bounds are optional
+		if c.MaxValue < c.MinValue {
+			return fmt.Errorf("numerical column %q has max_value %g below min_value %g", c.Name, c.MaxValue, c.MinValue)
+		}
+	case Date, Timestamp:
+		// Dates and timestamps are encoded as offsets from their epoch
+		if c.MaxValue < c.MinValue {
+			return fmt.Errorf("%s column %q has max_value %g below min_value %g", c.Type, c.Name, c.MaxValue, c.MinValue)
+		}
+	case Boolean:
+		if (c.CategoryCount != 0 && c.CategoryCount != 2) || (c.CodeBase != nil && *c.CodeBase != 0) {
+			return fmt.Errorf("boolean column %q must have codes 0 and 1", c.Name)
+		}
+		if len(c.Labels) != 0 && len(c.Labels) != 2 {
+			return fmt.Errorf("boolean column %q must have no labels or two", c.Name)
+		}
 	case Categorical, Ordinal:
-		if c.CategoryCount <= 0 {
+		if c.CategoryCount <= 0 && len(c.Labels) == 0 {
 			return fmt.Errorf("categorical/ordinal column %q must have positive category_count", c.Name)
 		}
+		if c.CodeBase != nil && *c.CodeBase < 0 {
+			return fmt.Errorf("column %q has negative code_base %d", c.Name, *c.CodeBase)
+		}
+		if c.CategoryCount > 0 && len(c.Labels) > c.CategoryCount {
+			return fmt.Errorf("column %q has %d labels but category_count %d", c.Name, len(c.Labels), c.CategoryCount)
+		}
+		seen := make(map[string]bool)
+		for _, label := range c.Labels {
+			if seen[label] {
+				return fmt.Errorf("column %q has duplicate label %q", c.Name, label)
+			}
+			seen[label] = true
+		}
 	default:
 		return fmt.Errorf("unknown column type %q for column %q", c.Type, c.Name)
 	}
-	return nil
+	if c.CodeBase != nil && !c.IsCoded() {
+		return fmt.Errorf("%s column %q cannot declare a code_base", c.Type, c.Name)
+	}
+	return c.validateTemporal()
+}
+
+// IsCoded reports whether the column holds category codes and has BMVs
+func (c *Column) IsCoded() bool {
+	return c.Type == Categorical || c.Type == Ordinal || c.Type == Boolean
+}
+
+// Categories returns S_f, the number of categories of a categorical/ordinal
+// column, taking it from the dictionary if category_count is omitted
+func (c *Column) Categories() int {
+	if c.Type == Boolean {
+		return 2
+	}
+	if c.CategoryCount > 0 {
+		return c.CategoryCount
+	}
+	return len(c.Labels)
+}
+
+// IsMissing reports whether a cell of the column holds a missing value
+func (c *Column) IsMissing(cell string) bool {
+	if isDefaultMissing(cell) {
+		return true
+	}
+	for _, m := range c.MissingValues {
+		if cell == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Base returns the first category code of a categorical/ordinal column
+func (c *Column) Base() int {
+	if c.Type == Boolean {
+		return 0
+	}
+	if c.CodeBase == nil {
+		return DefaultCodeBase
+	}
+	return *c.CodeBase
+}
+
+// Codes returns the code domain of a categorical/ordinal column in order:
+// base, base+1, ..., base+S_f-1
+func (c *Column) Codes() []int {
+	codes := make([]int, c.Categories())
+	for i := range codes {
+		codes[i] = c.Base() + i
+	}
+	return codes
+}
+
+// InDomain reports whether code is one of the column's category codes
+func (c *Column) InDomain(code int) bool {
+	return code >= c.Base() && code < c.Base()+c.Categories()
+}
+
+// LookupBound returns the largest |cell - code| over the cells of a
+// categorical/ordinal column and the codes of its domain, counting missing
+// cells, which are stored as 0
+func (c *Column) LookupBound() int {
+	first, last := c.Base(), c.Base()+c.Categories()-1
+	return max(max(last, 0)-first, last-min(first, 0))
+}
+
+// CellIssue classifies a categorical cell that has no valid category code
+type CellIssue string
+
+const (
+	// CellValid marks a cell holding a code of the column's domain
+	CellValid CellIssue = ""
+	// CellNonInteger marks a cell of an unlabelled column that is not an integer
+	CellNonInteger CellIssue = "non-integer"
+	// CellUnknownLabel marks a cell of a labelled column missing from its dictionary
+	CellUnknownLabel CellIssue = "unknown label"
+	// CellOutOfRange marks an integer cell outside the column's code domain
+	CellOutOfRange CellIssue = "out of range"
+)
+
+// ParseCode returns the category code of a non-missing cell: base plus the
+// position of its label if the column has a dictionary, the integer itself
+// otherwise. The issue is CellValid only if the code is in the column's domain.
+func (c *Column) ParseCode(value string) (int, CellIssue) {
+	if len(c.Labels) > 0 {
+		for i, label := range c.Labels {
+			if label == value {
+				return c.Base() + i, CellValid
+			}
+		}
+		return 0, CellUnknownLabel
+	}
+	if c.Type == Boolean {
+		b, err := parseBool(value)
+		if err != nil {
+			return 0, CellUnknownLabel
+		}
+		if b {
+			return 1, CellValid
+		}
+		return 0, CellValid
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, CellNonInteger
+	}
+	if !c.InDomain(code) {
+		return code, CellOutOfRange
+	}
+	return code, CellValid
+}
+
+// Code returns the category code of a cell, or an error if it has none.
+// Columns derived from a date/timestamp column also accept a time literal of
+// the source column, e.g. "2023-05-17" for code 5 of a month column.
+func (c *Column) Code(value string) (int, error) {
+	if c.IsTemporal() {
+		return 0, fmt.Errorf("%s column %q has no category codes; use a derived column such as %q", c.Type, c.Name, c.Name+"_"+PartMonth)
+	}
+	code, issue := c.ParseCode(value)
+	if issue != CellValid && c.DerivedFrom != "" {
+		if t, err := c.ParseTime(value); err == nil {
+			code, issue = c.ParseCode(c.DerivedCell(t))
+		}
+	}
+	switch issue {
+	case CellValid:
+		return code, nil
+	case CellUnknownLabel:
+		return 0, fmt.Errorf("%q is not a label of column %q", value, c.Name)
+	case CellNonInteger:
+		return 0, fmt.Errorf("column %q has no labels and %q is not an integer code", c.Name, value)
+	default:
+		return 0, fmt.Errorf("code %d of column %q is outside its domain [%d, %d]", code, c.Name, c.Base(), c.Base()+c.Categories()-1)
+	}
+}
+
+// Label returns the label of a category code, or the code itself if the
+// column has no dictionary entry for it
+func (c *Column) Label(code int) string {
+	if i := code - c.Base(); i >= 0 && i < len(c.Labels) {
+		return c.Labels[i]
+	}
+	if c.Type == Boolean && (code == 0 || code == 1) {
+		return BooleanLabels[code]
+	}
+	return strconv.Itoa(code)
+}
+
+// Dictionary returns the labels of the column's codes in order, if it has any
+func (c *Column) Dictionary() []string {
+	if len(c.Labels) == 0 && c.Type == Boolean {
+		return BooleanLabels
+	}
+	return c.Labels
+}
+
+// ColumnQuality counts the cells of a categorical/ordinal column that were
+// encrypted as invalid because they have no code in the column's domain
+type ColumnQuality struct {
+	Missing      int `json:"missing"`
+	NonInteger   int `json:"non_integer"`
+	UnknownLabel int `json:"unknown_label"`
+	OutOfRange   int `json:"out_of_range"`
+}
+
+// Add counts a cell with the given issue
+func (q *ColumnQuality) Add(issue CellIssue) {
+	switch issue {
+	case CellNonInteger:
+		q.NonInteger++
+	case CellUnknownLabel:
+		q.UnknownLabel++
+	case CellOutOfRange:
+		q.OutOfRange++
+	}
+}
+
+// Merge adds the counts of o
+func (q *ColumnQuality) Merge(o ColumnQuality) {
+	q.Missing += o.Missing
+	q.NonInteger += o.NonInteger
+	q.UnknownLabel += o.UnknownLabel
+	q.OutOfRange += o.OutOfRange
+}
+
+// Violations returns the number of non-missing cells without a valid code
+func (q ColumnQuality) Violations() int {
+	return q.NonInteger + q.UnknownLabel + q.OutOfRange
+}
+
+// String summarizes the counts
+func (q ColumnQuality) String() string {
+	return fmt.Sprintf("%d missing, %d non-integer, %d unknown label, %d out of range",
+		q.Missing, q.NonInteger, q.UnknownLabel, q.OutOfRange)
+}
+
+// BuildLabels builds a category dictionary from the distinct values of a
+// column, in sorted order
+func BuildLabels(values []string) []string {
+	seen := make(map[string]bool)
+	labels := make([]string, 0)
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			labels = append(labels, v)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// HasBounds reports whether a numerical, date or timestamp column declares a
+// min/max range, in which case it is encrypted normalized to [0, 1]. The
+// bounds of dates and timestamps are offsets from their epoch.
+func (c *Column) HasBounds() bool {
+	return (c.Type == Numerical || c.IsTemporal()) && c.MaxValue > c.MinValue
+}
+
+// Transform returns the normalization of a bounded numerical, date or timestamp column
+func (c *Column) Transform() (Transform, bool) {
+	if !c.HasBounds() {
+		return Transform{}, false
+	}
+	return Transform{Offset: c.MinValue, Scale: c.MaxValue - c.MinValue}, true
+}
+
+// Transform is the affine map between a numerical column's original units x
+// and the normalized values z that are encrypted: z = (x - Offset) / Scale
+type Transform struct {
+	Offset float64 `json:"offset"`
+	Scale  float64 `json:"scale"`
+}
+
+// Normalize maps x into [0, 1], rejecting values outside the declared bounds
+func (t Transform) Normalize(x float64) (float64, error) {
+	z := (x - t.Offset) / t.Scale
+	if z < 0 || z > 1 {
+		return 0, fmt.Errorf("value %g outside declared bounds [%g, %g]", x, t.Offset, t.Offset+t.Scale)
+	}
+	return z, nil
+}
+
+// Denormalize maps a normalized value back to original units
+func (t Transform) Denormalize(z float64) float64 {
+	return z*t.Scale + t.Offset
 }
 
 // TableSchema defines the structure of an encrypted table
@@ -74,6 +377,14 @@ func (s *TableSchema) Validate() error {
 		}
 		names[col.Name] = true
 	}
+	for _, col := range s.Columns {
+		if col.DerivedFrom == "" {
+			continue
+		}
+		if src := s.GetColumn(col.DerivedFrom); src == nil || !src.IsTemporal() {
+			return fmt.Errorf("column %q is derived from %q, which is not a date or timestamp column", col.Name, col.DerivedFrom)
+		}
+	}
 	return nil
 }
 
@@ -101,13 +412,122 @@ func (s *TableSchema) GetColumnIndex(name string) int {
 type TableMetadata struct {
 	Schema      TableSchema `json:"schema"`
 	RowCount    int         `json:"row_count"`     // R
-	Slots       int         `json:"slots"`         // N/2
+	Slots       int         `json:"slots"`         // N/2 (N on the conjugate-invariant ring)
 	BlockCount  int         `json:"block_count"`   // NB = ceil(R / Slots)
 	ParamsHash  string      `json:"params_hash"`   // Hash of CKKS params used
+	Profile     string      `json:"profile"`       // Name of the profile the hash belongs to
 	LogScale    int         `json:"log_scale"`     // Scale used for encoding
 	CreatedAt   string      `json:"created_at"`    // ISO 8601 timestamp
 	DataOwnerID string      `json:"data_owner_id"` // Identifier of data owner
 	Version     string      `json:"version"`       // Format version
+
+	// Transforms records the normalization of each bounded numerical column
+	Transforms map[string]Transform `json:"transforms,omitempty"`
+
+	// Quality records, per categorical/ordinal column, the cells that were
+	// encrypted as invalid
+	Quality map[string]ColumnQuality `json:"quality,omitempty"`
+
+	// Generation counts the appends made to the table. BlockGenerations holds,
+	// per block, the generation its ciphertexts were last written in; it is
+	// empty until an append rewrites a block.
+	Generation       int   `json:"generation,omitempty"`
+	BlockGenerations []int `json:"block_generations,omitempty"`
+
+	// Appends logs the rows appended after the table was created
+	Appends []AppendRecord `json:"appends,omitempty"`
+
+	// Retractions logs the rows withdrawn by the data owner. Their slots
+	// stay in the table but are zero in every ciphertext of their block, so
+	// they count in no result.
+	Retractions []RetractionRecord `json:"retractions,omitempty"`
+
+	// Levels records the level each kind of ciphertext was encrypted at when
+	// the table was trimmed below its profile's top level; nil if it was not
+	Levels *EntryLevels `json:"levels,omitempty"`
+}
+
+// EntryLevels holds a level per kind of table ciphertext. BMV levels also
+// apply to PBMVs and BBMVs, which are packed from BMVs.
+type EntryLevels struct {
+	Block    int `json:"block"`
+	Validity int `json:"validity"`
+	BMV      int `json:"bmv"`
+}
+
+// Lowest returns the lowest of the levels
+func (l EntryLevels) Lowest() int {
+	return min(l.Block, l.Validity, l.BMV)
+}
+
+// StoredLevels returns the levels the table's ciphertexts were encrypted at,
+// given the top level of its profile
+func (m *TableMetadata) StoredLevels(top int) EntryLevels {
+	if m.Levels != nil {
+		return *m.Levels
+	}
+	return EntryLevels{Block: top, Validity: top, BMV: top}
+}
+
+// AppendRecord describes one append of rows to a table
+type AppendRecord struct {
+	Generation int    `json:"generation"`
+	FirstRow   int    `json:"first_row"`
+	Rows       int    `json:"rows"`
+	Time       string `json:"time"` // ISO 8601 timestamp
+}
+
+// RetractionRecord describes one retraction of rows. It records how many rows
+// were withdrawn but not which: only the blocks that were rewritten.
+type RetractionRecord struct {
+	Generation int    `json:"generation"`
+	Rows       int    `json:"rows"`
+	Blocks     []int  `json:"blocks"`
+	Time       string `json:"time"` // ISO 8601 timestamp
+}
+
+// RetractionDepth returns the number of levels retractions consumed in the
+// block that went through the most of them; each retraction masks a block's
+// ciphertexts with one plaintext multiplication
+func (m *TableMetadata) RetractionDepth() int {
+	depth := 0
+	for b := 0; b < m.BlockCount; b++ {
+		depth = max(depth, m.BlockRetractions(b))
+	}
+	return depth
+}
+
+// BlockRetractions returns the number of retractions that masked a block,
+// which is the number of levels its ciphertexts are below fresh ones
+func (m *TableMetadata) BlockRetractions(blockIndex int) int {
+	n := 0
+	for _, r := range m.Retractions {
+		for _, b := range r.Blocks {
+			if b == blockIndex {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// BlockGeneration returns the generation a block was last written in
+func (m *TableMetadata) BlockGeneration(blockIndex int) int {
+	if blockIndex < len(m.BlockGenerations) {
+		return m.BlockGenerations[blockIndex]
+	}
+	return 0
+}
+
+// Dictionaries returns the category dictionaries of all labelled columns
+func (m *TableMetadata) Dictionaries() map[string][]string {
+	dicts := make(map[string][]string)
+	for _, col := range m.Schema.Columns {
+		if dict := col.Dictionary(); len(dict) > 0 {
+			dicts[col.Name] = dict
+		}
+	}
+	return dicts
 }
 
 // NewTableMetadata creates metadata for a new table
@@ -130,7 +550,7 @@ func NewTableMetadata(schema TableSchema, rowCount, slots int, paramsHash string
 		ParamsHash:  paramsHash,
 		LogScale:    logScale,
 		DataOwnerID: dataOwnerID,
-		Version:     "1.0",
+		Version:     MetadataVersion,
 	}, nil
 }
 
@@ -149,6 +569,37 @@ func (m *TableMetadata) Validate() error {
 	if m.BlockCount != expectedBlocks {
 		return fmt.Errorf("block count mismatch: expected %d, got %d", expectedBlocks, m.BlockCount)
 	}
+	for name, t := range m.Transforms {
+		if m.Schema.GetColumn(name) == nil {
+			return fmt.Errorf("transform for unknown column %q", name)
+		}
+		if t.Scale <= 0 {
+			return fmt.Errorf("transform for column %q has non-positive scale %g", name, t.Scale)
+		}
+	}
+	for name := range m.Quality {
+		if m.Schema.GetColumn(name) == nil {
+			return fmt.Errorf("quality summary for unknown column %q", name)
+		}
+	}
+	if len(m.BlockGenerations) > 0 && len(m.BlockGenerations) != m.BlockCount {
+		return fmt.Errorf("block generations cover %d blocks, expected %d", len(m.BlockGenerations), m.BlockCount)
+	}
+	for b, g := range m.BlockGenerations {
+		if g < 0 || g > m.Generation {
+			return fmt.Errorf("block %d has generation %d outside 0..%d", b, g, m.Generation)
+		}
+	}
+	for _, r := range m.Retractions {
+		for _, b := range r.Blocks {
+			if b < 0 || b >= m.BlockCount || m.BlockGeneration(b) < r.Generation {
+				return fmt.Errorf("retraction of generation %d lists block %d, which was not rewritten by it", r.Generation, b)
+			}
+		}
+	}
+	if m.Levels != nil && m.Levels.Lowest() < 0 {
+		return fmt.Errorf("negative ciphertext level in %+v", *m.Levels)
+	}
 	return nil
 }
 
@@ -159,14 +610,18 @@ func (m *TableMetadata) SaveToFile(path string) error {
 		return fmt.Errorf("failed to create metadata file: %w", err)
 	}
 	defer f.Close()
-	return m.WriteTo(f)
+	_, err = m.WriteTo(f)
+	return err
 }
 
 // WriteTo writes metadata as JSON to the given writer
-func (m *TableMetadata) WriteTo(w io.Writer) error {
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(m)
+func (m *TableMetadata) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
 }
 
 // LoadMetadataFromFile loads metadata from a JSON file
@@ -186,12 +641,28 @@ func LoadMetadata(r io.Reader) (*TableMetadata, error) {
 	if err := decoder.Decode(&m); err != nil {
 		return nil, fmt.Errorf("failed to decode metadata: %w", err)
 	}
+	m.upgradeLegacyCodes()
 	if err := m.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid metadata: %w", err)
 	}
 	return &m, nil
 }
 
+// upgradeLegacyCodes declares the code base of 0 that version 1.0 tables
+// encrypted their categorical and ordinal columns with
+func (m *TableMetadata) upgradeLegacyCodes() {
+	if m.Version != "1.0" {
+		return
+	}
+	for i := range m.Schema.Columns {
+		col := &m.Schema.Columns[i]
+		if (col.Type == Categorical || col.Type == Ordinal) && col.CodeBase == nil {
+			base := 0
+			col.CodeBase = &base
+		}
+	}
+}
+
 // BlockRange returns the row indices covered by a given block
 // Returns (startRow, endRow) where endRow is exclusive
 func (m *TableMetadata) BlockRange(blockIndex int) (int, int) {
@@ -2,6 +2,8 @@ package schema
 
 import (
 	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +47,74 @@ func TestColumnValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "numerical with bounds",
+			column: Column{
+				Name:     "income",
+				Type:     Numerical,
+				MinValue: 0,
+				MaxValue: 100000,
+			},
+			wantErr: false,
+		},
+		{
+			name: "numerical with inverted bounds",
+			column: Column{
+				Name:     "income",
+				Type:     Numerical,
+				MinValue: 100,
+				MaxValue: 10,
+			},
+			wantErr: true,
+		},
+		{
+			name: "categorical with zero code base",
+			column: Column{
+				Name:          "gender",
+				Type:          Categorical,
+				CategoryCount: 2,
+				CodeBase:      intPtr(0),
+			},
+			wantErr: false,
+		},
+		{
+			name: "categorical with negative code base",
+			column: Column{
+				Name:          "gender",
+				Type:          Categorical,
+				CategoryCount: 2,
+				CodeBase:      intPtr(-1),
+			},
+			wantErr: true,
+		},
+		{
+			name: "categorical with labels only",
+			column: Column{
+				Name:   "region",
+				Type:   Categorical,
+				Labels: []string{"North", "South"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "categorical with duplicate labels",
+			column: Column{
+				Name:   "region",
+				Type:   Categorical,
+				Labels: []string{"North", "North"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "categorical with more labels than categories",
+			column: Column{
+				Name:          "region",
+				Type:          Categorical,
+				CategoryCount: 1,
+				Labels:        []string{"North", "South"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "categorical without category count",
 			column: Column{
@@ -185,7 +255,7 @@ func TestTableMetadataSerialization(t *testing.T) {
 
 	// Test serialization
 	var buf bytes.Buffer
-	if err := meta.WriteTo(&buf); err != nil {
+	if _, err := meta.WriteTo(&buf); err != nil {
 		t.Fatalf("Failed to write metadata: %v", err)
 	}
 
@@ -206,6 +276,35 @@ func TestTableMetadataSerialization(t *testing.T) {
 	}
 }
 
+func TestLoadLegacyMetadataCodes(t *testing.T) {
+	legacy := `{
+  "schema": {"name": "t", "columns": [
+    {"name": "region", "type": "categorical", "category_count": 3},
+    {"name": "risk", "type": "ordinal", "category_count": 2, "code_base": 1},
+    {"name": "income", "type": "numerical"}
+  ]},
+  "row_count": 10, "slots": 16, "block_count": 1, "params_hash": "abc", "version": "1.0"
+}`
+	meta, err := LoadMetadata(strings.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("Failed to load legacy metadata: %v", err)
+	}
+	if codes := meta.Schema.GetColumn("region").Codes(); !reflect.DeepEqual(codes, []int{0, 1, 2}) {
+		t.Errorf("Version 1.0 column without code_base: expected codes [0 1 2], got %v", codes)
+	}
+	if codes := meta.Schema.GetColumn("risk").Codes(); !reflect.DeepEqual(codes, []int{1, 2}) {
+		t.Errorf("Declared code_base should be kept: expected codes [1 2], got %v", codes)
+	}
+
+	current, err := LoadMetadata(strings.NewReader(strings.Replace(legacy, `"1.0"`, `"`+MetadataVersion+`"`, 1)))
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if base := current.Schema.GetColumn("region").Base(); base != DefaultCodeBase {
+		t.Errorf("Version %s column without code_base: expected base %d, got %d", MetadataVersion, DefaultCodeBase, base)
+	}
+}
+
 func TestTableMetadataBlockRange(t *testing.T) {
 	schema := TableSchema{
 		Name:    "test",
@@ -242,3 +341,242 @@ func TestTableMetadataBlockRange(t *testing.T) {
 		t.Errorf("Expected 10 rows in block 3, got %d", meta.RowsInBlock(3))
 	}
 }
+
+func TestTableMetadataGenerations(t *testing.T) {
+	schema := TableSchema{
+		Name:    "test",
+		Columns: []Column{{Name: "id", Type: Numerical}},
+	}
+	meta, err := NewTableMetadata(schema, 100, 30, "hash", 40, "owner")
+	if err != nil {
+		t.Fatalf("Failed to create metadata: %v", err)
+	}
+	if meta.BlockGeneration(3) != 0 {
+		t.Errorf("Blocks of a new table should be at generation 0")
+	}
+
+	meta.Generation = 2
+	meta.BlockGenerations = []int{0, 1, 2, 2}
+	if err := meta.Validate(); err != nil {
+		t.Errorf("Expected valid generations, got %v", err)
+	}
+	if meta.BlockGeneration(1) != 1 {
+		t.Errorf("Expected block 1 at generation 1, got %d", meta.BlockGeneration(1))
+	}
+
+	meta.BlockGenerations = []int{0, 1, 2}
+	if err := meta.Validate(); err == nil {
+		t.Error("Expected an error for generations not covering every block")
+	}
+	meta.BlockGenerations = []int{0, 1, 3, 2}
+	if err := meta.Validate(); err == nil {
+		t.Error("Expected an error for a generation after the table's")
+	}
+}
+
+func TestTableMetadataRetractions(t *testing.T) {
+	schema := TableSchema{
+		Name:    "test",
+		Columns: []Column{{Name: "id", Type: Numerical}},
+	}
+	meta, err := NewTableMetadata(schema, 100, 30, "hash", 40, "owner")
+	if err != nil {
+		t.Fatalf("Failed to create metadata: %v", err)
+	}
+	if meta.RetractionDepth() != 0 {
+		t.Errorf("A new table should have no retraction depth")
+	}
+
+	meta.Generation = 2
+	meta.BlockGenerations = []int{1, 2, 0, 2}
+	meta.Retractions = []RetractionRecord{
+		{Generation: 1, Rows: 2, Blocks: []int{0, 1}},
+		{Generation: 2, Rows: 1, Blocks: []int{1, 3}},
+	}
+	if err := meta.Validate(); err != nil {
+		t.Errorf("Expected valid retractions, got %v", err)
+	}
+	if d := meta.RetractionDepth(); d != 2 {
+		t.Errorf("Block 1 went through two retractions, got depth %d", d)
+	}
+
+	meta.Retractions = append(meta.Retractions, RetractionRecord{Generation: 2, Rows: 1, Blocks: []int{2}})
+	if err := meta.Validate(); err == nil {
+		t.Error("Expected an error for a retraction listing a block it did not rewrite")
+	}
+}
+
+func TestTableMetadataLevels(t *testing.T) {
+	schema := TableSchema{
+		Name:    "test",
+		Columns: []Column{{Name: "id", Type: Numerical}},
+	}
+	meta, err := NewTableMetadata(schema, 100, 30, "hash", 40, "owner")
+	if err != nil {
+		t.Fatalf("Failed to create metadata: %v", err)
+	}
+	if got := meta.StoredLevels(9); got != (EntryLevels{Block: 9, Validity: 9, BMV: 9}) {
+		t.Errorf("An untrimmed table should be at the top level, got %+v", got)
+	}
+
+	meta.Levels = &EntryLevels{Block: 9, Validity: 3, BMV: 2}
+	if got := meta.StoredLevels(9); got != *meta.Levels || got.Lowest() != 2 {
+		t.Errorf("Expected the recorded levels, got %+v", got)
+	}
+	if err := meta.Validate(); err != nil {
+		t.Errorf("Expected valid levels, got %v", err)
+	}
+	meta.Levels.BMV = -1
+	if err := meta.Validate(); err == nil {
+		t.Error("Expected an error for a negative level")
+	}
+}
+
+func TestColumnTransform(t *testing.T) {
+	col := Column{Name: "temp", Type: Numerical, MinValue: -40, MaxValue: 60}
+	tr, ok := col.Transform()
+	if !ok {
+		t.Fatal("Bounded numerical column should have a transform")
+	}
+
+	for _, x := range []float64{-40, 0, 25.5, 60} {
+		z, err := tr.Normalize(x)
+		if err != nil {
+			t.Fatalf("Normalize(%g) failed: %v", x, err)
+		}
+		if z < 0 || z > 1 {
+			t.Errorf("Normalize(%g) = %g, expected a value in [0, 1]", x, z)
+		}
+		if back := tr.Denormalize(z); back != x {
+			t.Errorf("Denormalize(Normalize(%g)) = %g", x, back)
+		}
+	}
+
+	for _, x := range []float64{-40.5, 61} {
+		if _, err := tr.Normalize(x); err == nil {
+			t.Errorf("Normalize(%g) should fail outside [-40, 60]", x)
+		}
+	}
+
+	if _, ok := (&Column{Name: "raw", Type: Numerical}).Transform(); ok {
+		t.Error("Unbounded numerical column should not have a transform")
+	}
+	if _, ok := (&Column{Name: "c", Type: Categorical, CategoryCount: 3, MaxValue: 3}).Transform(); ok {
+		t.Error("Categorical column should not have a transform")
+	}
+}
+
+func TestColumnLabels(t *testing.T) {
+	col := Column{Name: "region", Type: Categorical, Labels: BuildLabels([]string{"South", "North", "South", "West"})}
+	if col.Categories() != 3 {
+		t.Fatalf("Expected 3 categories, got %d (%v)", col.Categories(), col.Labels)
+	}
+
+	code, err := col.Code("South")
+	if err != nil || code != 2 {
+		t.Errorf("Code(South): expected 2, got %d (%v)", code, err)
+	}
+	if col.Label(code) != "South" {
+		t.Errorf("Label(%d): expected South, got %s", code, col.Label(code))
+	}
+	if _, err := col.Code("East"); err == nil {
+		t.Error("Code should reject values missing from the dictionary")
+	}
+
+	coded := Column{Name: "gender", Type: Categorical, CategoryCount: 2}
+	if code, err := coded.Code("2"); err != nil || code != 2 {
+		t.Errorf("Code(2) without labels: expected 2, got %d (%v)", code, err)
+	}
+	if coded.Label(2) != "2" {
+		t.Errorf("Label(2) without labels: expected 2, got %s", coded.Label(2))
+	}
+}
+
+func TestColumnCodeDomain(t *testing.T) {
+	col := Column{Name: "gender", Type: Categorical, CategoryCount: 3}
+	if col.Base() != DefaultCodeBase {
+		t.Errorf("Expected default base %d, got %d", DefaultCodeBase, col.Base())
+	}
+	if got := col.Codes(); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("Expected codes [1 2 3], got %v", got)
+	}
+
+	zero := Column{Name: "gender", Type: Categorical, CategoryCount: 3, CodeBase: intPtr(0)}
+	if got := zero.Codes(); got[0] != 0 || got[2] != 2 {
+		t.Errorf("Expected codes [0 1 2], got %v", got)
+	}
+
+	tests := []struct {
+		col   Column
+		cell  string
+		code  int
+		issue CellIssue
+	}{
+		{col, "1", 1, CellValid},
+		{col, "3", 3, CellValid},
+		{col, "0", 0, CellOutOfRange},
+		{col, "4", 4, CellOutOfRange},
+		{col, "1.5", 0, CellNonInteger},
+		{zero, "0", 0, CellValid},
+		{zero, "3", 3, CellOutOfRange},
+	}
+	for _, tt := range tests {
+		code, issue := tt.col.ParseCode(tt.cell)
+		if issue != tt.issue || (issue != CellOutOfRange && code != tt.code) {
+			t.Errorf("ParseCode(%q) with base %d: expected (%d, %q), got (%d, %q)",
+				tt.cell, tt.col.Base(), tt.code, tt.issue, code, issue)
+		}
+	}
+	if _, err := col.Code("4"); err == nil {
+		t.Error("Code should reject codes outside the domain")
+	}
+
+	labelled := Column{Name: "region", Type: Categorical, Labels: []string{"North", "South"}, CodeBase: intPtr(0)}
+	if code, issue := labelled.ParseCode("South"); issue != CellValid || code != 1 {
+		t.Errorf("ParseCode(South) with base 0: expected 1, got %d (%q)", code, issue)
+	}
+	if labelled.Label(0) != "North" {
+		t.Errorf("Label(0) with base 0: expected North, got %s", labelled.Label(0))
+	}
+	if _, issue := labelled.ParseCode("1"); issue != CellUnknownLabel {
+		t.Errorf("ParseCode(1) on a labelled column: expected unknown label, got %q", issue)
+	}
+
+	// A lookup's difference reaches the largest code for missing cells,
+	// stored as 0, and the bound holds without a category_count
+	bounds := []struct {
+		col  Column
+		want int
+	}{
+		{col, 3},
+		{zero, 2},
+		{labelled, 1},
+		{Column{Name: "smoker", Type: Boolean}, 1},
+		{Column{Name: "level", Type: Ordinal, CategoryCount: 8}, 8},
+		{Column{Name: "delta", Type: Ordinal, CategoryCount: 3, CodeBase: intPtr(-1)}, 2},
+		{Column{Name: "grade", Type: Ordinal, CategoryCount: 2, CodeBase: intPtr(5)}, 6},
+	}
+	for _, tt := range bounds {
+		if got := tt.col.LookupBound(); got != tt.want {
+			t.Errorf("LookupBound of %s: expected %d, got %d", tt.col.Name, tt.want, got)
+		}
+	}
+}
+
+func TestColumnQuality(t *testing.T) {
+	var q ColumnQuality
+	q.Missing++
+	for _, issue := range []CellIssue{CellValid, CellNonInteger, CellOutOfRange, CellOutOfRange, CellUnknownLabel} {
+		q.Add(issue)
+	}
+	if q.Violations() != 4 {
+		t.Errorf("Expected 4 violations, got %d (%s)", q.Violations(), q)
+	}
+	if q.OutOfRange != 2 || q.NonInteger != 1 || q.UnknownLabel != 1 || q.Missing != 1 {
+		t.Errorf("Unexpected counts: %s", q)
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
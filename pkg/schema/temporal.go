@@ -0,0 +1,208 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEpoch is the origin of date/timestamp offsets for columns that do not declare one
+const DefaultEpoch = "1970-01-01"
+
+// DateLayout is the default layout of date cells
+const DateLayout = "2006-01-02"
+
+// Calendar parts of a date/timestamp column that can be encrypted as derived
+// ordinal columns
+const (
+	PartYear    = "year"
+	PartQuarter = "quarter"
+	PartMonth   = "month"
+)
+
+// BooleanLabels are the labels of codes 0 and 1 of a boolean column
+var BooleanLabels = []string{"false", "true"}
+
+var (
+	monthLabels   = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+	quarterLabels = []string{"Q1", "Q2", "Q3", "Q4"}
+)
+
+// IsTemporal reports whether the column holds dates or timestamps
+func (c *Column) IsTemporal() bool {
+	return c.Type == Date || c.Type == Timestamp
+}
+
+// Layout returns the Go time layout of the column's cells
+func (c *Column) Layout() string {
+	if c.Format != "" {
+		return c.Format
+	}
+	if c.Type == Timestamp {
+		return time.RFC3339
+	}
+	return DateLayout
+}
+
+// ParseTime parses a date/timestamp cell in the column's layout
+func (c *Column) ParseTime(cell string) (time.Time, error) {
+	t, err := time.Parse(c.Layout(), cell)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a %s of column %q in layout %q", cell, c.Type, c.Name, c.Layout())
+	}
+	return t, nil
+}
+
+// TimeEncoding returns how the column's times are encrypted as offsets
+func (c *Column) TimeEncoding() (TimeEncoding, bool) {
+	if !c.IsTemporal() {
+		return TimeEncoding{}, false
+	}
+	e := TimeEncoding{Epoch: c.Epoch, Unit: "day", Layout: c.Layout()}
+	if e.Epoch == "" {
+		e.Epoch = DefaultEpoch
+	}
+	if c.Type == Timestamp {
+		e.Unit = "second"
+	}
+	return e, true
+}
+
+// TimeValue returns the encrypted value of a date/timestamp cell: whole days
+// since the epoch for dates, seconds since the epoch for timestamps
+func (c *Column) TimeValue(cell string) (float64, error) {
+	e, ok := c.TimeEncoding()
+	if !ok {
+		return 0, fmt.Errorf("column %q is not a date or timestamp", c.Name)
+	}
+	t, err := c.ParseTime(cell)
+	if err != nil {
+		return 0, err
+	}
+	return e.Offset(t)
+}
+
+// TimeEncoding is the map between a date/timestamp column's times and the
+// offsets that are encrypted
+type TimeEncoding struct {
+	Epoch  string `json:"epoch"`
+	Unit   string `json:"unit"` // "day" or "second"
+	Layout string `json:"layout"`
+}
+
+// epoch parses the epoch as a date or an RFC 3339 timestamp
+func (e TimeEncoding) epoch() (time.Time, error) {
+	if t, err := time.Parse(DateLayout, e.Epoch); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, e.Epoch)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("epoch %q is neither a date nor an RFC 3339 timestamp", e.Epoch)
+	}
+	return t, nil
+}
+
+// Offset returns the offset of t from the epoch in the encoding's unit
+func (e TimeEncoding) Offset(t time.Time) (float64, error) {
+	epoch, err := e.epoch()
+	if err != nil {
+		return 0, err
+	}
+	if e.Unit == "second" {
+		return t.Sub(epoch).Seconds(), nil
+	}
+	return math.Floor(t.Sub(epoch).Hours() / 24), nil
+}
+
+// Format renders an offset, e.g. a decrypted mean, as a time in the
+// encoding's layout, rounding to the nearest unit
+func (e TimeEncoding) Format(v float64) (string, error) {
+	epoch, err := e.epoch()
+	if err != nil {
+		return "", err
+	}
+	if e.Unit == "second" {
+		return epoch.Add(time.Duration(math.Round(v)) * time.Second).Format(e.Layout), nil
+	}
+	return epoch.AddDate(0, 0, int(math.Round(v))).Format(e.Layout), nil
+}
+
+// PartCode returns the code of a calendar part of t
+func PartCode(part string, t time.Time) int {
+	switch part {
+	case PartYear:
+		return t.Year()
+	case PartQuarter:
+		return (int(t.Month())-1)/3 + 1
+	default:
+		return int(t.Month())
+	}
+}
+
+// DerivedColumn returns the ordinal column holding a calendar part of a
+// date/timestamp column. Years are coded as themselves over [minYear, maxYear];
+// months and quarters are 1-based and labelled.
+func (c *Column) DerivedColumn(part string, minYear, maxYear int) Column {
+	d := Column{
+		Name:        c.Name + "_" + part,
+		Type:        Ordinal,
+		Format:      c.Layout(),
+		DerivedFrom: c.Name,
+		Part:        part,
+		Description: fmt.Sprintf("%s of %s", part, c.Name),
+	}
+	switch part {
+	case PartYear:
+		base := minYear
+		d.CodeBase = &base
+		d.CategoryCount = maxYear - minYear + 1
+	case PartQuarter:
+		d.Labels = quarterLabels
+		d.CategoryCount = len(quarterLabels)
+	default:
+		d.Labels = monthLabels
+		d.CategoryCount = len(monthLabels)
+	}
+	return d
+}
+
+// DerivedCell returns the cell of a derived column for time t of its source column
+func (c *Column) DerivedCell(t time.Time) string {
+	return c.Label(PartCode(c.Part, t))
+}
+
+// parseBool parses the usual spellings of a boolean cell
+func parseBool(cell string) (bool, error) {
+	switch strings.ToLower(cell) {
+	case "yes", "y":
+		return true, nil
+	case "no", "n":
+		return false, nil
+	}
+	return strconv.ParseBool(cell)
+}
+
+// validateTemporal checks the date/timestamp settings of a column
+func (c *Column) validateTemporal() error {
+	if c.IsTemporal() {
+		e, _ := c.TimeEncoding()
+		if _, err := e.epoch(); err != nil {
+			return fmt.Errorf("column %q: %w", c.Name, err)
+		}
+		for _, part := range c.Derive {
+			if part != PartYear && part != PartQuarter && part != PartMonth {
+				return fmt.Errorf("column %q cannot derive unknown part %q", c.Name, part)
+			}
+		}
+		return nil
+	}
+	if c.Epoch != "" || len(c.Derive) > 0 {
+		return fmt.Errorf("column %q declares an epoch or derived parts but is not a date or timestamp", c.Name)
+	}
+	if c.DerivedFrom != "" && c.Type != Ordinal {
+		return fmt.Errorf("derived column %q must be ordinal", c.Name)
+	}
+	return nil
+}
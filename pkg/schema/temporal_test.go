@@ -0,0 +1,144 @@
+package schema
+
+import (
+	"testing"
+)
+
+func TestDateEncoding(t *testing.T) {
+	col := Column{Name: "signup", Type: Date, Epoch: "2022-01-01"}
+	if err := col.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	v, err := col.TimeValue("2022-02-01")
+	if err != nil || v != 31 {
+		t.Fatalf("TimeValue(2022-02-01): expected 31, got %g (%v)", v, err)
+	}
+	e, _ := col.TimeEncoding()
+	if got, _ := e.Format(31.4); got != "2022-02-01" {
+		t.Errorf("Format(31.4): expected 2022-02-01, got %s", got)
+	}
+	if _, err := col.TimeValue("01/02/2022"); err == nil {
+		t.Error("TimeValue should reject cells in another layout")
+	}
+
+	ts := Column{Name: "seen", Type: Timestamp, Epoch: "2022-01-01T00:00:00Z"}
+	v, err = ts.TimeValue("2022-01-01T01:00:00Z")
+	if err != nil || v != 3600 {
+		t.Fatalf("TimeValue of a timestamp: expected 3600, got %g (%v)", v, err)
+	}
+	e, _ = ts.TimeEncoding()
+	if got, _ := e.Format(3600); got != "2022-01-01T01:00:00Z" {
+		t.Errorf("Format(3600): expected 2022-01-01T01:00:00Z, got %s", got)
+	}
+
+	for _, bad := range []Column{
+		{Name: "d", Type: Date, Epoch: "yesterday"},
+		{Name: "d", Type: Date, Derive: []string{"week"}},
+		{Name: "n", Type: Numerical, Epoch: "2022-01-01"},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate should reject %+v", bad)
+		}
+	}
+}
+
+func TestTemporalNormalization(t *testing.T) {
+	ts := Column{Name: "seen", Type: Timestamp, MinValue: 1.6e9, MaxValue: 1.8e9}
+	if err := ts.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	tr, ok := ts.Transform()
+	if !ok {
+		t.Fatal("A bounded timestamp column should be normalized")
+	}
+
+	// A mean of normalized offsets maps back to the time it stands for
+	cells := []string{"2023-11-14T22:13:20Z", "2023-11-15T22:13:20Z"}
+	sum := 0.0
+	for _, cell := range cells {
+		v, err := ts.TimeValue(cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := tr.Normalize(v)
+		if err != nil {
+			t.Fatalf("Normalize(%g) failed: %v", v, err)
+		}
+		sum += z
+	}
+	e, _ := ts.TimeEncoding()
+	if got, _ := e.Format(tr.Denormalize(sum / 2)); got != "2023-11-15T10:13:20Z" {
+		t.Errorf("Mean: expected 2023-11-15T10:13:20Z, got %s", got)
+	}
+
+	if _, err := tr.Normalize(1.9e9); err == nil {
+		t.Error("Normalize should reject an offset outside the bounds")
+	}
+	bad := Column{Name: "d", Type: Date, MinValue: 10, MaxValue: 5}
+	if err := bad.Validate(); err == nil {
+		t.Error("Validate should reject max_value below min_value")
+	}
+}
+
+func TestDerivedColumns(t *testing.T) {
+	src := Column{Name: "signup", Type: Date, Derive: []string{PartYear, PartQuarter, PartMonth}}
+	tm, err := src.ParseTime("2023-05-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	year := src.DerivedColumn(PartYear, 2021, 2024)
+	if year.Base() != 2021 || year.Categories() != 4 || year.DerivedCell(tm) != "2023" {
+		t.Errorf("year: unexpected domain %v or cell %s", year.Codes(), year.DerivedCell(tm))
+	}
+	quarter := src.DerivedColumn(PartQuarter, 2021, 2024)
+	if quarter.DerivedCell(tm) != "Q2" {
+		t.Errorf("quarter: expected Q2, got %s", quarter.DerivedCell(tm))
+	}
+	month := src.DerivedColumn(PartMonth, 2021, 2024)
+	if code, err := month.Code("2023-05-20"); err != nil || code != 5 {
+		t.Errorf("month Code(2023-05-20): expected 5, got %d (%v)", code, err)
+	}
+	if code, err := month.Code("May"); err != nil || code != 5 {
+		t.Errorf("month Code(May): expected 5, got %d (%v)", code, err)
+	}
+
+	s := TableSchema{Name: "t", Columns: []Column{src, year, quarter, month}}
+	if err := s.Validate(); err != nil {
+		t.Errorf("Schema with derived columns should validate: %v", err)
+	}
+	orphan := TableSchema{Name: "t", Columns: []Column{year}}
+	if err := orphan.Validate(); err == nil {
+		t.Error("Validate should reject a derived column without its source")
+	}
+	if _, err := src.Code("2023-05-20"); err == nil {
+		t.Error("Code should reject conditions on a date column")
+	}
+}
+
+func TestBooleanColumn(t *testing.T) {
+	col := Column{Name: "active", Type: Boolean}
+	if err := col.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if col.Base() != 0 || col.Categories() != 2 || !col.IsCoded() {
+		t.Errorf("Expected codes 0..1, got %v", col.Codes())
+	}
+	for cell, want := range map[string]int{"true": 1, "TRUE": 1, "yes": 1, "1": 1, "false": 0, "no": 0, "0": 0} {
+		if code, issue := col.ParseCode(cell); issue != CellValid || code != want {
+			t.Errorf("ParseCode(%q): expected %d, got %d (%q)", cell, want, code, issue)
+		}
+	}
+	if _, issue := col.ParseCode("maybe"); issue != CellUnknownLabel {
+		t.Errorf("ParseCode(maybe): expected unknown label, got %q", issue)
+	}
+	if col.Label(1) != "true" || col.Label(0) != "false" {
+		t.Errorf("Unexpected labels %s/%s", col.Label(0), col.Label(1))
+	}
+
+	bad := Column{Name: "active", Type: Boolean, CategoryCount: 3}
+	if err := bad.Validate(); err == nil {
+		t.Error("Validate should reject a boolean column with three categories")
+	}
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BlobStore holds the files of a table directory as named blobs. Names are
+// slash-separated paths relative to the table, such as "blocks/age_0.bin".
+// Get and Stat return errors wrapping fs.ErrNotExist for missing blobs.
+type BlobStore interface {
+	Get(name string) ([]byte, error)
+	Put(name string, data []byte) error
+	List(prefix string) ([]BlobInfo, error) // Every blob whose name starts with prefix, sorted by name
+	Stat(name string) (BlobInfo, error)
+	Delete(name string) error
+}
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Name string
+	Size int64
+}
+
+// notFound returns the error of a missing blob
+func notFound(name string) error {
+	return fmt.Errorf("blob %s: %w", name, fs.ErrNotExist)
+}
+
+// checkBlobName rejects names that could escape the table
+func checkBlobName(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) || path.Clean(name) != name ||
+		name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid blob name %q", name)
+	}
+	return nil
+}
+
+// DirStore is a BlobStore over a local directory
+type DirStore struct {
+	Root string
+}
+
+// NewDirStore returns a blob store over the directory root
+func NewDirStore(root string) *DirStore {
+	return &DirStore{Root: root}
+}
+
+func (d *DirStore) file(name string) (string, error) {
+	if err := checkBlobName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.Root, filepath.FromSlash(name)), nil
+}
+
+// Get reads a blob
+func (d *DirStore) Get(name string) ([]byte, error) {
+	file, err := d.file(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+// Put writes a blob, creating its parent directories. The data is written to
+// a temporary file renamed over the blob, so readers never see a partial blob.
+func (d *DirStore) Put(name string, data []byte) error {
+	file, err := d.file(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// List returns the blobs under the directory whose names start with prefix
+func (d *DirStore) List(prefix string) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	err := filepath.WalkDir(d.Root, func(file string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() || isTempBlob(e.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(d.Root, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Name: name, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", d.Root, err)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Name < blobs[j].Name })
+	return blobs, nil
+}
+
+// isTempBlob reports whether a file is the temporary file of an unfinished Put
+func isTempBlob(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp")
+}
+
+// Stat describes a blob
+func (d *DirStore) Stat(name string) (BlobInfo, error) {
+	file, err := d.file(name)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if info.IsDir() {
+		return BlobInfo{}, notFound(name)
+	}
+	return BlobInfo{Name: name, Size: info.Size()}, nil
+}
+
+// Delete removes a blob
+func (d *DirStore) Delete(name string) error {
+	file, err := d.file(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// MemStore is an in-memory BlobStore
+type MemStore struct {
+	mu    sync.RWMutex
+	blobs map[string][]byte
+}
+
+// NewMemStore returns an empty in-memory blob store
+func NewMemStore() *MemStore {
+	return &MemStore{blobs: make(map[string][]byte)}
+}
+
+// Get returns a copy of a blob
+func (m *MemStore) Get(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.blobs[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Put stores a copy of data
+func (m *MemStore) Put(name string, data []byte) error {
+	if err := checkBlobName(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// List returns the blobs whose names start with prefix
+func (m *MemStore) List(prefix string) ([]BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var blobs []BlobInfo
+	for name, data := range m.blobs {
+		if strings.HasPrefix(name, prefix) {
+			blobs = append(blobs, BlobInfo{Name: name, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Name < blobs[j].Name })
+	return blobs, nil
+}
+
+// Stat describes a blob
+func (m *MemStore) Stat(name string) (BlobInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.blobs[name]
+	if !ok {
+		return BlobInfo{}, notFound(name)
+	}
+	return BlobInfo{Name: name, Size: int64(len(data))}, nil
+}
+
+// Delete removes a blob
+func (m *MemStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[name]; !ok {
+		return notFound(name)
+	}
+	delete(m.blobs, name)
+	return nil
+}
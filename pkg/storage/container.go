@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Container file layout:
+//
+//	header (64 bytes): magic, version, index offset, index length, index SHA-256
+//	entries:           the serialized ciphertexts and files, back to back
+//	index:             JSON array of ContainerEntry
+//
+// The header is rewritten with the index location when the container is closed,
+// so a container that was not closed has a zero index offset and is rejected.
+const (
+	containerMagic      = "LSTCONT\x00"
+	containerVersion    = 1
+	containerHeaderSize = 64
+)
+
+// ContainerExt is the conventional extension of table container files
+const ContainerExt = ".lstc"
+
+// EntryKind identifies what a stored entry holds
+type EntryKind string
+
+const (
+	KindBlock    EntryKind = "block"
+	KindValidity EntryKind = "validity"
+	KindBMV      EntryKind = "bmv"
+	KindPBMV     EntryKind = "pbmv"
+	KindBBMV     EntryKind = "bbmv"
+	KindFile     EntryKind = "file" // Non-ciphertext files such as metadata.json, keyed by name
+)
+
+// EntryKey identifies an entry of a table: Value is the category value of a
+// BMV, Column the file name of a file entry. Gen is the generation of a block
+// rewritten by an append; the ciphertexts it replaced keep their generation.
+type EntryKey struct {
+	Kind   EntryKind `json:"kind"`
+	Column string    `json:"column"`
+	Value  int       `json:"value,omitempty"`
+	Block  int       `json:"block"`
+	Gen    int       `json:"gen,omitempty"`
+}
+
+func (k EntryKey) String() string {
+	var s string
+	switch k.Kind {
+	case KindFile:
+		return k.Column
+	case KindBMV:
+		s = fmt.Sprintf("%s %s=%d[%d]", k.Kind, k.Column, k.Value, k.Block)
+	default:
+		s = fmt.Sprintf("%s %s[%d]", k.Kind, k.Column, k.Block)
+	}
+	if k.Gen > 0 {
+		s += fmt.Sprintf(" gen %d", k.Gen)
+	}
+	return s
+}
+
+// ContainerEntry locates an entry in a container file
+type ContainerEntry struct {
+	EntryKey
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	SHA256 string `json:"sha256"`
+}
+
+// Container is a single-file table: every entry is indexed by key and
+// checksummed, so missing, truncated or corrupted entries are detected
+type Container struct {
+	mu       sync.Mutex
+	f        *os.File
+	path     string
+	writable bool
+	end      int64
+	index    map[EntryKey]ContainerEntry
+}
+
+// CreateContainer creates an empty container file for writing; it must be
+// closed to be readable
+func CreateContainer(path string) (*Container, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+	if _, err := f.Write(make([]byte, containerHeaderSize)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write container header: %w", err)
+	}
+	return &Container{
+		f:        f,
+		path:     path,
+		writable: true,
+		end:      containerHeaderSize,
+		index:    make(map[EntryKey]ContainerEntry),
+	}, nil
+}
+
+// OpenContainer opens a container file for reading, checking its header and index
+func OpenContainer(path string) (*Container, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container: %w", err)
+	}
+	c := &Container{f: f, path: path, index: make(map[EntryKey]ContainerEntry)}
+	if err := c.readIndex(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("invalid container %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// IsContainer reports whether path is a container file
+func IsContainer(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(containerMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == containerMagic
+}
+
+func (c *Container) readIndex() error {
+	header := make([]byte, containerHeaderSize)
+	if _, err := io.ReadFull(c.f, header); err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(header[:8]) != containerMagic {
+		return fmt.Errorf("bad magic")
+	}
+	if v := binary.LittleEndian.Uint32(header[8:12]); v != containerVersion {
+		return fmt.Errorf("unsupported container version %d", v)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(header[16:24]))
+	indexLength := int64(binary.LittleEndian.Uint64(header[24:32]))
+	if indexOffset == 0 {
+		return fmt.Errorf("container was not closed after writing")
+	}
+
+	info, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	if indexOffset+indexLength > info.Size() {
+		return fmt.Errorf("index at %d+%d is beyond end of file (%d bytes): file is truncated", indexOffset, indexLength, info.Size())
+	}
+	data := make([]byte, indexLength)
+	if _, err := c.f.ReadAt(data, indexOffset); err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], header[32:64]) {
+		return fmt.Errorf("index checksum mismatch")
+	}
+
+	var entries []ContainerEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to decode index: %w", err)
+	}
+	for _, e := range entries {
+		if e.Offset < containerHeaderSize || e.Offset+e.Length > indexOffset {
+			return fmt.Errorf("entry %s at %d+%d lies outside the data section", e.EntryKey, e.Offset, e.Length)
+		}
+		c.index[e.EntryKey] = e
+	}
+	c.end = indexOffset
+	return nil
+}
+
+// Put appends an entry, replacing any earlier entry with the same key
+func (c *Container) Put(key EntryKey, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.writable {
+		return fmt.Errorf("container %s is read-only", c.path)
+	}
+	if _, err := c.f.WriteAt(data, c.end); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	sum := sha256.Sum256(data)
+	c.index[key] = ContainerEntry{
+		EntryKey: key,
+		Offset:   c.end,
+		Length:   int64(len(data)),
+		SHA256:   hex.EncodeToString(sum[:]),
+	}
+	c.end += int64(len(data))
+	return nil
+}
+
+// Get returns the data of an entry after checking its checksum
+func (c *Container) Get(key EntryKey) ([]byte, error) {
+	c.mu.Lock()
+	e, ok := c.index[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s not found in container %s", key, c.path)
+	}
+	data := make([]byte, e.Length)
+	if _, err := c.f.ReadAt(data, e.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != e.SHA256 {
+		return nil, fmt.Errorf("%w for %s in container %s", ErrChecksum, key, c.path)
+	}
+	return data, nil
+}
+
+// Has reports whether the container holds an entry
+func (c *Container) Has(key EntryKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.index[key]
+	return ok
+}
+
+// Stat returns the index entry of a key
+func (c *Container) Stat(key EntryKey) (ContainerEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.index[key]
+	return e, ok
+}
+
+// Entries returns the index sorted by offset
+func (c *Container) Entries() []ContainerEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]ContainerEntry, 0, len(c.index))
+	for _, e := range c.index {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+	return entries
+}
+
+// Verify checks the checksum of every entry and returns an error per failing entry
+func (c *Container) Verify() []error {
+	var errs []error
+	for _, e := range c.Entries() {
+		if _, err := c.Get(e.EntryKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Close writes the index and header of a container being written, then closes the file
+func (c *Container) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.writable {
+		return c.f.Close()
+	}
+
+	entries := make([]ContainerEntry, 0, len(c.index))
+	for _, e := range c.index {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+	data, err := json.Marshal(entries)
+	if err != nil {
+		c.f.Close()
+		return fmt.Errorf("failed to encode container index: %w", err)
+	}
+	if _, err := c.f.WriteAt(data, c.end); err != nil {
+		c.f.Close()
+		return fmt.Errorf("failed to write container index: %w", err)
+	}
+
+	header := make([]byte, containerHeaderSize)
+	copy(header, containerMagic)
+	binary.LittleEndian.PutUint32(header[8:12], containerVersion)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(c.end))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(len(data)))
+	sum := sha256.Sum256(data)
+	copy(header[32:64], sum[:])
+	if _, err := c.f.WriteAt(header, 0); err != nil {
+		c.f.Close()
+		return fmt.Errorf("failed to write container header: %w", err)
+	}
+	c.writable = false
+	return c.f.Close()
+}
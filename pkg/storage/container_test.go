@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainerRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "table"+ContainerExt)
+	c, err := CreateContainer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[EntryKey][]byte{
+		{Kind: KindBlock, Column: "income", Block: 0}:              []byte("block"),
+		{Kind: KindBMV, Column: "region_code", Value: 3, Block: 1}: []byte("bmv"),
+		{Kind: KindFile, Column: MetadataFile}:                     []byte("{}"),
+	}
+	for key, data := range entries {
+		if err := c.Put(key, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsContainer(path) {
+		t.Fatal("IsContainer should recognize the file")
+	}
+	c, err = OpenContainer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for key, want := range entries {
+		got, err := c.Get(key)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Get(%s): expected %q, got %q (%v)", key, want, got, err)
+		}
+	}
+	if _, err := c.Get(EntryKey{Kind: KindBlock, Column: "income", Block: 1}); err == nil {
+		t.Error("Get should fail for a missing entry")
+	}
+	if errs := c.Verify(); len(errs) != 0 {
+		t.Errorf("Verify failed: %v", errs)
+	}
+}
+
+func TestContainerDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "table"+ContainerExt)
+	c, err := CreateContainer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := EntryKey{Kind: KindValidity, Column: "income", Block: 0}
+	if err := c.Put(key, bytes.Repeat([]byte{7}, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte of the entry
+	corrupt := append([]byte{}, data...)
+	corrupt[containerHeaderSize+10] ^= 0xff
+	corruptPath := filepath.Join(dir, "corrupt"+ContainerExt)
+	os.WriteFile(corruptPath, corrupt, 0644)
+	cc, err := OpenContainer(corruptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.Get(key); err == nil {
+		t.Error("Get should detect a corrupted entry")
+	}
+	cc.Close()
+
+	// Cut the file before its index
+	truncPath := filepath.Join(dir, "trunc"+ContainerExt)
+	os.WriteFile(truncPath, data[:containerHeaderSize+32], 0644)
+	if _, err := OpenContainer(truncPath); err == nil {
+		t.Error("OpenContainer should reject a truncated file")
+	}
+
+	// A container that was never closed has no index
+	open, err := CreateContainer(filepath.Join(dir, "open"+ContainerExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	open.Put(key, []byte("x"))
+	open.f.Close()
+	if _, err := OpenContainer(filepath.Join(dir, "open"+ContainerExt)); err == nil {
+		t.Error("OpenContainer should reject a container that was not closed")
+	}
+}
+
+func TestPackUnpack(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "table")
+	store, err := NewTableStore(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"metadata.json":              "{}",
+		"blocks/net_income_0.bin":    "b0",
+		"validity/net_income_0.bin":  "v0",
+		"bmvs/region_code_v12_3.bin": "bmv",
+		"pbmv/region_code_0.bin":     "p",
+		"bbmv/region_code_0.bin":     "bb",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !store.HasBMV("region_code", 12, 3) {
+		t.Error("HasBMV should find the BMV file")
+	}
+
+	packed := filepath.Join(dir, "table"+ContainerExt)
+	n, err := Pack(src, packed)
+	if err != nil || n != len(files) {
+		t.Fatalf("Pack: expected %d entries, got %d (%v)", len(files), n, err)
+	}
+	ps, err := OpenTableStore(packed)
+	if err != nil || !ps.IsContainer() {
+		t.Fatalf("OpenTableStore should open the container: %v", err)
+	}
+	if !ps.HasBMV("region_code", 12, 3) {
+		t.Error("Packed table should hold the BMV")
+	}
+	if data, err := ps.LoadFile(MetadataFile); err != nil || string(data) != "{}" {
+		t.Errorf("LoadFile(metadata.json): got %q (%v)", data, err)
+	}
+	ps.Close()
+
+	dst := filepath.Join(dir, "unpacked")
+	if n, err := Unpack(packed, dst); err != nil || n != len(files) {
+		t.Fatalf("Unpack: expected %d entries, got %d (%v)", len(files), n, err)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s: expected %q, got %q (%v)", name, content, data, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// Ciphertext file layout:
+//
+//	magic (8 bytes), version (uint32), header length (uint32)
+//	header: JSON CiphertextHeader
+//	length (uint64), followed by the Lattigo ciphertext bytes
+//
+// Legacy files hold only the length and the ciphertext bytes. Read as a
+// length, the magic is far larger than any ciphertext, so the two formats
+// cannot be confused.
+const (
+	ciphertextMagic   = "LSTCTXT\x00"
+	ciphertextVersion = 1
+	maxHeaderLength   = 1 << 16
+	// maxCiphertextLength bounds the length prefix, so that reading a file
+	// that is not a ciphertext fails instead of allocating the garbage length
+	maxCiphertextLength = 1 << 36
+)
+
+// KindResult marks the result ciphertext of a job
+const KindResult EntryKind = "result"
+
+// Content describes what a ciphertext file holds: a table entry, or the
+// result of the job JobID
+type Content struct {
+	EntryKey
+	JobID string `json:"job_id,omitempty"`
+}
+
+func (c Content) String() string {
+	if c.Kind == KindResult {
+		return fmt.Sprintf("result of job %s", c.JobID)
+	}
+	return c.EntryKey.String()
+}
+
+// CiphertextHeader is the self-describing header of a ciphertext file
+type CiphertextHeader struct {
+	Version    int     `json:"-"`
+	ParamsHash string  `json:"params_hash,omitempty"`
+	Level      int     `json:"level"`
+	LogScale   float64 `json:"log_scale"`
+	Slots      int     `json:"slots"`
+	Content    Content `json:"content"`
+}
+
+// newCiphertextHeader describes ct, encrypted under the parameters with the given hash
+func newCiphertextHeader(ct *rlwe.Ciphertext, paramsHash string, content Content) CiphertextHeader {
+	return CiphertextHeader{
+		Version:    ciphertextVersion,
+		ParamsHash: paramsHash,
+		Level:      ct.Level(),
+		LogScale:   ct.LogScale(),
+		Slots:      ct.Slots(),
+		Content:    content,
+	}
+}
+
+// check verifies that the header describes ct
+func (h *CiphertextHeader) check(ct *rlwe.Ciphertext) error {
+	if ct.Level() != h.Level {
+		return fmt.Errorf("header says level %d, ciphertext is at level %d", h.Level, ct.Level())
+	}
+	if ct.Slots() != h.Slots {
+		return fmt.Errorf("header says %d slots, ciphertext has %d", h.Slots, ct.Slots())
+	}
+	if math.Abs(ct.LogScale()-h.LogScale) > 1e-6 {
+		return fmt.Errorf("header says log-scale %.4f, ciphertext has %.4f", h.LogScale, ct.LogScale())
+	}
+	return nil
+}
+
+// Check returns an error if the ciphertext was written under other parameters
+// or holds other content. An empty paramsHash skips the parameter check.
+func (h *CiphertextHeader) Check(paramsHash string, content Content) error {
+	if paramsHash != "" && h.ParamsHash != "" && h.ParamsHash != paramsHash {
+		return fmt.Errorf("ciphertext was written with params hash %s, expected %s",
+			shortHash(h.ParamsHash), shortHash(paramsHash))
+	}
+	if h.Content != content {
+		return fmt.Errorf("ciphertext holds %s, expected %s", h.Content, content)
+	}
+	return nil
+}
+
+// shortHash abbreviates a hash for error messages
+func shortHash(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
+
+// writeCiphertextHeader writes the magic, version and JSON header
+func writeCiphertextHeader(w io.Writer, h CiphertextHeader) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("failed to encode ciphertext header: %w", err)
+	}
+	prefix := make([]byte, len(ciphertextMagic)+8)
+	copy(prefix, ciphertextMagic)
+	binary.LittleEndian.PutUint32(prefix[8:12], ciphertextVersion)
+	binary.LittleEndian.PutUint32(prefix[12:16], uint32(len(data)))
+	if _, err := w.Write(prefix); err != nil {
+		return fmt.Errorf("failed to write ciphertext header: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write ciphertext header: %w", err)
+	}
+	return nil
+}
+
+// readCiphertextHeader reads the header that follows the magic
+func readCiphertextHeader(r io.Reader) (*CiphertextHeader, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("failed to read ciphertext header: %w", err)
+	}
+	version := binary.LittleEndian.Uint32(prefix[0:4])
+	if version == 0 || version > ciphertextVersion {
+		return nil, fmt.Errorf("unsupported ciphertext format version %d", version)
+	}
+	length := binary.LittleEndian.Uint32(prefix[4:8])
+	if length > maxHeaderLength {
+		return nil, fmt.Errorf("ciphertext header of %d bytes is too large", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read ciphertext header: %w", err)
+	}
+	var h CiphertextHeader
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, fmt.Errorf("failed to decode ciphertext header: %w", err)
+	}
+	h.Version = int(version)
+	return &h, nil
+}
+
+// splitMagic reads the first 8 bytes of a ciphertext file and reports whether
+// they are the header magic; otherwise they are the length of a legacy file
+func splitMagic(r io.Reader) (bool, uint64, error) {
+	var first [8]byte
+	if _, err := io.ReadFull(r, first[:]); err != nil {
+		return false, 0, fmt.Errorf("failed to read length: %w", err)
+	}
+	if bytes.Equal(first[:], []byte(ciphertextMagic)) {
+		return true, 0, nil
+	}
+	return false, binary.LittleEndian.Uint64(first[:]), nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+func testCiphertext(t *testing.T) (*rlwe.Ciphertext, string) {
+	t.Helper()
+	prof, err := params.NewProfileT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := rlwe.NewCiphertext(prof.Params, 1, 3)
+	ct.Scale = prof.Params.DefaultScale()
+	return ct, prof.ParamsHash
+}
+
+func TestCiphertextHeaderRoundTrip(t *testing.T) {
+	ct, hash := testCiphertext(t)
+	content := Content{EntryKey: EntryKey{Kind: KindBMV, Column: "region", Value: 2, Block: 1}}
+
+	var buf bytes.Buffer
+	if err := WriteCiphertext(&buf, ct, hash, content); err != nil {
+		t.Fatal(err)
+	}
+	got, header, err := ReadCiphertext(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header == nil {
+		t.Fatal("expected a header")
+	}
+	if header.Version != ciphertextVersion || header.ParamsHash != hash || header.Content != content {
+		t.Errorf("unexpected header %+v", header)
+	}
+	if header.Level != 3 || header.Slots != ct.Slots() || header.LogScale != ct.LogScale() {
+		t.Errorf("header does not describe the ciphertext: %+v", header)
+	}
+	if got.Level() != ct.Level() {
+		t.Errorf("expected level %d, got %d", ct.Level(), got.Level())
+	}
+
+	if err := header.Check(hash, content); err != nil {
+		t.Errorf("Check should pass: %v", err)
+	}
+	if err := header.Check("other", content); err == nil {
+		t.Error("Check should fail for another params hash")
+	}
+	other := content
+	other.Value = 3
+	if err := header.Check(hash, other); err == nil {
+		t.Error("Check should fail for other content")
+	}
+}
+
+func TestReadLegacyCiphertext(t *testing.T) {
+	ct, _ := testCiphertext(t)
+	data, err := ct.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	buf.Write(data)
+
+	got, header, err := ReadCiphertext(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != nil {
+		t.Errorf("legacy input should have no header, got %+v", header)
+	}
+	if got.Level() != ct.Level() {
+		t.Errorf("expected level %d, got %d", ct.Level(), got.Level())
+	}
+}
+
+func TestReadCiphertextRejectsBadHeader(t *testing.T) {
+	ct, hash := testCiphertext(t)
+	var buf bytes.Buffer
+	if err := WriteCiphertext(&buf, ct, hash, Content{EntryKey: EntryKey{Kind: KindResult}, JobID: "job"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A header whose level disagrees with the ciphertext is corrupt
+	tampered := bytes.Replace(buf.Bytes(), []byte(`"level":3`), []byte(`"level":2`), 1)
+	if _, _, err := ReadCiphertext(bytes.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "level") {
+		t.Errorf("expected a level mismatch, got %v", err)
+	}
+
+	future := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint32(future[8:12], ciphertextVersion+1)
+	if _, _, err := ReadCiphertext(bytes.NewReader(future)); err == nil {
+		t.Error("expected an error for an unknown format version")
+	}
+}
+
+func TestTableStoreChecksHeaders(t *testing.T) {
+	ct, hash := testCiphertext(t)
+	dir := t.TempDir()
+	store, err := NewTableStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.ParamsHash = hash
+	if err := store.SaveBlock("income", 0, ct); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadBlock("income", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file moved to another entry's name is caught by its header
+	if err := store.SaveBlock("age", 0, ct); err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := LoadCiphertext(filepath.Join(dir, "blocks", "age_0.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveCiphertext(filepath.Join(dir, "blocks", "income_0.bin"), data, hash,
+		Content{EntryKey: EntryKey{Kind: KindBlock, Column: "age", Block: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadBlock("income", 0); err == nil {
+		t.Error("LoadBlock should reject a block of another column")
+	}
+
+	store.ParamsHash = "other"
+	if _, err := store.LoadBlock("age", 0); err == nil {
+		t.Error("LoadBlock should reject a block written under other parameters")
+	}
+}
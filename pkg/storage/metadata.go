@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// LoadMetadata reads the table metadata and pins the store to the snapshot
+// it describes. Appends never overwrite the ciphertexts of a snapshot and
+// commit by replacing the metadata, so a job that loaded the metadata keeps
+// reading one consistent version of the table.
+func (ts *TableStore) LoadMetadata() (*schema.TableMetadata, error) {
+	data, err := ts.LoadFile(MetadataFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	meta, err := schema.LoadMetadata(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	ts.SetGenerations(meta.BlockGenerations)
+	return meta, nil
+}
+
+// SaveMetadata writes the table metadata and pins the store to it. Blob
+// stores replace the metadata atomically, which makes it the commit point of
+// a table update.
+func (ts *TableStore) SaveMetadata(meta *schema.TableMetadata) error {
+	var data bytes.Buffer
+	if _, err := meta.WriteTo(&data); err != nil {
+		return err
+	}
+	if err := ts.SaveFile(MetadataFile, data.Bytes()); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	ts.SetGenerations(meta.BlockGenerations)
+	return nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+func TestSnapshotGenerations(t *testing.T) {
+	ct, hash := testCiphertext(t)
+	blobs := NewMemStore()
+	writer := NewBlobTableStore("mem", blobs)
+	writer.ParamsHash = hash
+	meta, err := schema.NewTableMetadata(schema.TableSchema{
+		Name:    "t",
+		Columns: []schema.Column{{Name: "age", Type: schema.Numerical}},
+	}, 10, 8, hash, 40, "owner1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for b := 0; b < 2; b++ {
+		if err := writer.SaveBlock("age", b, ct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.SaveMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader pinned before the append keeps reading the old block 1
+	reader := NewBlobTableStore("mem", blobs)
+	reader.ParamsHash = hash
+	if _, err := reader.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+
+	appended := ct.CopyNew()
+	appended.Resize(1, ct.Level()-1)
+	if err := writer.SaveEntry(EntryKey{Kind: KindBlock, Column: "age", Block: 1, Gen: 1}, appended); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobs.Stat("blocks/age_1.g1.bin"); err != nil {
+		t.Errorf("generation 1 should be stored next to generation 0: %v", err)
+	}
+	if got, err := reader.LoadBlock("age", 1); err != nil || got.Level() != ct.Level() {
+		t.Fatalf("uncommitted generation should not be visible: %v", err)
+	}
+
+	meta.RowCount = 14
+	meta.Generation = 1
+	meta.BlockGenerations = []int{0, 1}
+	if err := writer.SaveMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reader.LoadBlock("age", 1); err != nil || got.Level() != ct.Level() {
+		t.Errorf("pinned reader should read generation 0 of block 1: %v", err)
+	}
+	fresh := NewBlobTableStore("mem", blobs)
+	fresh.ParamsHash = hash
+	if _, err := fresh.LoadMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fresh.LoadBlock("age", 1); err != nil || got.Level() != appended.Level() {
+		t.Errorf("new reader should read generation 1 of block 1: %v", err)
+	}
+	if got, err := fresh.LoadBlock("age", 0); err != nil || got.Level() != ct.Level() {
+		t.Errorf("block 0 was not rewritten and should stay at generation 0: %v", err)
+	}
+
+	keys, err := fresh.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, key := range keys {
+		found = found || key == EntryKey{Kind: KindBlock, Column: "age", Block: 1, Gen: 1}
+	}
+	if !found {
+		t.Errorf("Keys should list generation 1 of block 1, got %v", keys)
+	}
+}
+
+func TestDirStoreHidesUnfinishedPuts(t *testing.T) {
+	dir := t.TempDir()
+	blobs := NewDirStore(dir)
+	if err := blobs.Put("blocks/age_0.bin", []byte("block")); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "blocks", ".age_1.bin.123.tmp"), []byte("partial"), 0644)
+	list, err := blobs.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "blocks/age_0.bin" {
+		t.Errorf("expected only the finished blob, got %v", list)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseEntryName recovers the key of a .bin file in a table subdirectory;
+// column names may contain underscores, so indices are parsed from the right
+func parseEntryName(kind EntryKind, name string) (EntryKey, error) {
+	base := strings.TrimSuffix(name, ".bin")
+	if base == name {
+		return EntryKey{}, fmt.Errorf("%s is not a .bin file", name)
+	}
+	gen := 0
+	if g := strings.LastIndex(base, ".g"); g > 0 {
+		if n, err := strconv.Atoi(base[g+2:]); err == nil && n > 0 {
+			base, gen = base[:g], n
+		}
+	}
+	i := strings.LastIndex(base, "_")
+	if i <= 0 {
+		return EntryKey{}, fmt.Errorf("%s has no block index", name)
+	}
+	block, err := strconv.Atoi(base[i+1:])
+	if err != nil {
+		return EntryKey{}, fmt.Errorf("%s has an invalid block index", name)
+	}
+	key := EntryKey{Kind: kind, Column: base[:i], Block: block, Gen: gen}
+	if kind != KindBMV {
+		return key, nil
+	}
+	j := strings.LastIndex(key.Column, "_v")
+	if j <= 0 {
+		return EntryKey{}, fmt.Errorf("%s has no category value", name)
+	}
+	value, err := strconv.Atoi(key.Column[j+2:])
+	if err != nil {
+		return EntryKey{}, fmt.Errorf("%s has an invalid category value", name)
+	}
+	key.Column, key.Value = key.Column[:j], value
+	return key, nil
+}
+
+// Pack copies a table directory, local or in object storage, into a new container file. Entries are copied
+// byte for byte, so unpacking restores identical files.
+func Pack(dir, path string) (int, error) {
+	src, err := OpenTableStore(dir)
+	if err != nil {
+		return 0, err
+	}
+	if src.IsContainer() {
+		return 0, fmt.Errorf("%s is already a container", dir)
+	}
+	c, err := CreateContainer(path)
+	if err != nil {
+		return 0, err
+	}
+
+	keys, err := src.Keys()
+	if err != nil {
+		c.Close()
+		return 0, err
+	}
+	for _, key := range keys {
+		data, err := src.LoadRaw(key)
+		if err != nil {
+			c.Close()
+			return 0, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		if err := c.Put(key, data); err != nil {
+			c.Close()
+			return 0, err
+		}
+	}
+	return len(keys), c.Close()
+}
+
+// Unpack writes every entry of a container file into a new table directory,
+// local or in object storage, checking each checksum on the way
+func Unpack(path, dir string) (int, error) {
+	c, err := OpenContainer(path)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	var dst *TableStore
+	if strings.HasPrefix(dir, S3Scheme) {
+		dst, err = NewS3TableStore(dir)
+	} else {
+		dst, err = NewTableStore(dir)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	entries := c.Entries()
+	for _, e := range entries {
+		if e.Column == "" || e.Column == "." || e.Column == ".." || strings.ContainsAny(e.Column, `/\`) {
+			return 0, fmt.Errorf("refusing to unpack %s: name is not a plain file name", e.EntryKey)
+		}
+		data, err := c.Get(e.EntryKey)
+		if err != nil {
+			return 0, err
+		}
+		if err := dst.put(e.EntryKey, data); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
@@ -3,18 +3,44 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 )
 
-// TableStore manages the storage of an encrypted table
+// MetadataFile is the name of the table metadata file
+const MetadataFile = "metadata.json"
+
+// TableStore manages the storage of an encrypted table, either as .bin
+// blobs in a BlobStore (a local directory, memory or object storage) or as
+// a single container file
 type TableStore struct {
 	BasePath string
+	// ParamsHash is written into the header of every ciphertext saved and,
+	// if set, checked against the header of every ciphertext loaded
+	ParamsHash  string
+	blobs       BlobStore
+	container   *Container
+	expected    map[EntryKey]string
+	generations []int
+	files       map[string][]byte
+}
+
+// tableDirs are the subdirectories of a table directory, by entry kind
+var tableDirs = map[EntryKind]string{
+	KindBlock:    "blocks",
+	KindValidity: "validity",
+	KindBMV:      "bmvs",
+	KindPBMV:     "pbmv",
+	KindBBMV:     "bbmv",
 }
 
 // NewTableStore creates a new table store at the given path
@@ -33,67 +59,352 @@ func NewTableStore(basePath string) (*TableStore, error) {
 			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
-	return &TableStore{BasePath: basePath}, nil
+	return NewBlobTableStore(basePath, NewDirStore(basePath)), nil
+}
+
+// NewBlobTableStore returns a table store over a blob store; name is only
+// used in messages
+func NewBlobTableStore(name string, blobs BlobStore) *TableStore {
+	return &TableStore{BasePath: name, blobs: blobs}
+}
+
+// NewS3TableStore returns a table store over the objects of an s3://bucket/prefix path
+func NewS3TableStore(path string) (*TableStore, error) {
+	cfg, err := S3ConfigFromEnv(path)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := NewS3Store(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlobTableStore(path, blobs), nil
+}
+
+// NewContainerStore creates a new table store writing a single container
+// file; Close must be called once the table is written
+func NewContainerStore(path string) (*TableStore, error) {
+	c, err := CreateContainer(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TableStore{BasePath: path, container: c}, nil
+}
+
+// CreateTableStore creates an object storage table for s3:// paths, a
+// container store if path has the container extension, and a table
+// directory otherwise
+func CreateTableStore(path string) (*TableStore, error) {
+	if strings.HasPrefix(path, S3Scheme) {
+		if strings.HasSuffix(path, ContainerExt) {
+			return nil, fmt.Errorf("container files must be local; store the table in object storage as a directory")
+		}
+		return NewS3TableStore(path)
+	}
+	if strings.HasSuffix(path, ContainerExt) {
+		return NewContainerStore(path)
+	}
+	return NewTableStore(path)
 }
 
-// OpenTableStore opens an existing table store
+// OpenTableStore opens an existing table in object storage, directory or container file
 func OpenTableStore(basePath string) (*TableStore, error) {
+	if strings.HasPrefix(basePath, S3Scheme) {
+		ts, err := NewS3TableStore(basePath)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ts.blobs.Stat(MetadataFile); err != nil {
+			return nil, fmt.Errorf("table store not found: %w", err)
+		}
+		return ts, nil
+	}
 	info, err := os.Stat(basePath)
 	if err != nil {
 		return nil, fmt.Errorf("table store not found: %w", err)
 	}
 	if !info.IsDir() {
-		return nil, fmt.Errorf("table store path is not a directory")
+		if !IsContainer(basePath) {
+			return nil, fmt.Errorf("table store path is neither a directory nor a container file")
+		}
+		c, err := OpenContainer(basePath)
+		if err != nil {
+			return nil, err
+		}
+		return &TableStore{BasePath: basePath, container: c}, nil
 	}
-	return &TableStore{BasePath: basePath}, nil
+	return NewBlobTableStore(basePath, NewDirStore(basePath)), nil
 }
 
-// blockPath returns the path for a column block
-func (ts *TableStore) blockPath(columnName string, blockIndex int) string {
-	return filepath.Join(ts.BasePath, "blocks", fmt.Sprintf("%s_%d.bin", columnName, blockIndex))
+// IsContainer reports whether the table is stored as a container file
+func (ts *TableStore) IsContainer() bool {
+	return ts.container != nil
 }
 
-// validityPath returns the path for a validity block
-func (ts *TableStore) validityPath(columnName string, blockIndex int) string {
-	return filepath.Join(ts.BasePath, "validity", fmt.Sprintf("%s_%d.bin", columnName, blockIndex))
+// Container returns the container file of the table, or nil for a directory
+func (ts *TableStore) Container() *Container {
+	return ts.container
 }
 
-// bmvPath returns the path for a BMV block
-func (ts *TableStore) bmvPath(columnName string, categoryValue int, blockIndex int) string {
-	return filepath.Join(ts.BasePath, "bmvs", fmt.Sprintf("%s_v%d_%d.bin", columnName, categoryValue, blockIndex))
+// Blobs returns the blob store of the table, or nil for a container
+func (ts *TableStore) Blobs() BlobStore {
+	return ts.blobs
 }
 
-// pbmvPath returns the path for a PBMV block
-func (ts *TableStore) pbmvPath(columnName string, blockIndex int) string {
-	return filepath.Join(ts.BasePath, "pbmv", fmt.Sprintf("%s_%d.bin", columnName, blockIndex))
+// Close finishes writing a container; it is a no-op for blob stores
+func (ts *TableStore) Close() error {
+	if ts.container == nil {
+		return nil
+	}
+	return ts.container.Close()
+}
+
+// blobName returns the name of an entry in the directory layout
+func blobName(key EntryKey) string {
+	var base string
+	switch key.Kind {
+	case KindFile:
+		return key.Column
+	case KindBMV:
+		base = fmt.Sprintf("%s_v%d_%d", key.Column, key.Value, key.Block)
+	default:
+		base = fmt.Sprintf("%s_%d", key.Column, key.Block)
+	}
+	if key.Gen > 0 {
+		base += fmt.Sprintf(".g%d", key.Gen)
+	}
+	return tableDirs[key.Kind] + "/" + base + ".bin"
+}
+
+// SetGenerations pins the store to a snapshot of the table: block b is read
+// and written at generation generations[b], or 0 if generations is shorter
+func (ts *TableStore) SetGenerations(generations []int) {
+	ts.generations = generations
+}
+
+// resolve sets the generation of a block entry from the pinned snapshot
+func (ts *TableStore) resolve(key EntryKey) EntryKey {
+	if key.Kind != KindFile && key.Block < len(ts.generations) {
+		key.Gen = ts.generations[key.Block]
+	}
+	return key
 }
 
-// bbmvPath returns the path for a BBMV block
-func (ts *TableStore) bbmvPath(columnName string, blockIndex int) string {
-	return filepath.Join(ts.BasePath, "bbmv", fmt.Sprintf("%s_%d.bin", columnName, blockIndex))
+// put stores the bytes of an entry
+func (ts *TableStore) put(key EntryKey, data []byte) error {
+	if ts.container != nil {
+		if err := ts.container.Put(key, data); err != nil {
+			return err
+		}
+		ts.trust(key, data)
+		return nil
+	}
+	if err := ts.blobs.Put(blobName(key), data); err != nil {
+		return fmt.Errorf("failed to save %s: %w", key, err)
+	}
+	ts.trust(key, data)
+	return nil
 }
 
-// metadataPath returns the path for table metadata
-func (ts *TableStore) metadataPath() string {
-	return filepath.Join(ts.BasePath, "metadata.json")
+// trust adds the hash of data to the expected hashes, if any, so that an
+// entry the store writes itself can be read back after Expect
+func (ts *TableStore) trust(key EntryKey, data []byte) {
+	if ts.expected != nil {
+		sum := sha256.Sum256(data)
+		ts.expected[key] = hex.EncodeToString(sum[:])
+	}
 }
 
-// SaveCiphertext saves a ciphertext to a file
-func SaveCiphertext(path string, ct *rlwe.Ciphertext) error {
+// save stores a ciphertext entry
+func (ts *TableStore) save(key EntryKey, ct *rlwe.Ciphertext) error {
+	var buf bytes.Buffer
+	if err := WriteCiphertext(&buf, ct, ts.ParamsHash, Content{EntryKey: key}); err != nil {
+		return err
+	}
+	return ts.put(key, buf.Bytes())
+}
+
+// load reads a ciphertext entry, checking that its header matches the key
+func (ts *TableStore) load(key EntryKey) (*rlwe.Ciphertext, error) {
+	data, err := ts.LoadRaw(key)
+	if err != nil {
+		return nil, err
+	}
+	return ts.decode(key, data)
+}
+
+// decode parses the stored bytes of a ciphertext entry, checking that its
+// header matches the key
+func (ts *TableStore) decode(key EntryKey, data []byte) (*rlwe.Ciphertext, error) {
+	ct, header, err := ReadCiphertext(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", key, err)
+	}
+	if header != nil {
+		if err := header.Check(ts.ParamsHash, Content{EntryKey: key}); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return ct, nil
+}
+
+// LoadEntry loads a ciphertext entry at the generation in key, regardless
+// of the snapshot the store is pinned to
+func (ts *TableStore) LoadEntry(key EntryKey) (*rlwe.Ciphertext, error) {
+	return ts.load(key)
+}
+
+// SaveEntry saves a ciphertext entry at the generation in key
+func (ts *TableStore) SaveEntry(key EntryKey, ct *rlwe.Ciphertext) error {
+	return ts.save(key, ct)
+}
+
+// Has reports whether the table holds an entry
+func (ts *TableStore) Has(key EntryKey) bool {
+	if ts.container != nil {
+		return ts.container.Has(key)
+	}
+	_, err := ts.blobs.Stat(blobName(key))
+	return err == nil
+}
+
+// Size returns the stored size of an entry in bytes
+func (ts *TableStore) Size(key EntryKey) (int64, error) {
+	if ts.container != nil {
+		e, ok := ts.container.Stat(key)
+		if !ok {
+			return 0, fmt.Errorf("%s not found in container %s", key, ts.BasePath)
+		}
+		return e.Length, nil
+	}
+	info, err := ts.blobs.Stat(blobName(key))
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return info.Size, nil
+}
+
+// HasBMV reports whether the table holds a BMV block
+func (ts *TableStore) HasBMV(columnName string, categoryValue int, blockIndex int) bool {
+	return ts.Has(ts.resolve(EntryKey{Kind: KindBMV, Column: columnName, Value: categoryValue, Block: blockIndex}))
+}
+
+// SaveFile stores a non-ciphertext file of the table, such as metadata.json
+func (ts *TableStore) SaveFile(name string, data []byte) error {
+	if err := ts.put(EntryKey{Kind: KindFile, Column: name}, data); err != nil {
+		return err
+	}
+	delete(ts.files, name)
+	return nil
+}
+
+// SetFile makes reads of a non-ciphertext file return data instead of the
+// stored bytes until the file is saved, e.g. the metadata a verified
+// manifest embeds
+func (ts *TableStore) SetFile(name string, data []byte) {
+	if ts.files == nil {
+		ts.files = make(map[string][]byte)
+	}
+	ts.files[name] = data
+	ts.trust(EntryKey{Kind: KindFile, Column: name}, data)
+}
+
+// LoadFile reads a non-ciphertext file of the table
+func (ts *TableStore) LoadFile(name string) ([]byte, error) {
+	return ts.LoadRaw(EntryKey{Kind: KindFile, Column: name})
+}
+
+// LoadRaw reads the stored bytes of an entry, checking them against the
+// expected hash if one was set with Expect
+func (ts *TableStore) LoadRaw(key EntryKey) ([]byte, error) {
+	data, ok := ts.files[key.Column]
+	var err error
+	switch {
+	case ok && key.Kind == KindFile:
+	case ts.container == nil:
+		data, err = ts.blobs.Get(blobName(key))
+	default:
+		data, err = ts.container.Get(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ts.expected != nil {
+		want, ok := ts.expected[key]
+		if !ok {
+			return nil, fmt.Errorf("%s is not listed in the table manifest", key)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+			return nil, fmt.Errorf("%s does not match its hash in the table manifest: %w", key, ErrChecksum)
+		}
+	}
+	return data, nil
+}
+
+// Expect makes every later read check the entry against its SHA-256 in
+// hashes; entries without a hash are refused, except those the store saves
+// or sets itself afterwards, whose hashes are added to hashes
+func (ts *TableStore) Expect(hashes map[EntryKey]string) {
+	ts.expected = hashes
+}
+
+// Keys lists the entries of the table
+func (ts *TableStore) Keys() ([]EntryKey, error) {
+	var keys []EntryKey
+	if ts.container != nil {
+		for _, e := range ts.container.Entries() {
+			keys = append(keys, e.EntryKey)
+		}
+		return keys, nil
+	}
+
+	blobs, err := ts.blobs.List("")
+	if err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]EntryKind, len(tableDirs))
+	for kind, dir := range tableDirs {
+		kinds[dir] = kind
+	}
+	for _, b := range blobs {
+		dir, file, nested := strings.Cut(b.Name, "/")
+		if !nested {
+			keys = append(keys, EntryKey{Kind: KindFile, Column: b.Name})
+			continue
+		}
+		kind, ok := kinds[dir]
+		if !ok {
+			return nil, fmt.Errorf("unexpected blob %s in table %s", b.Name, ts.BasePath)
+		}
+		key, err := parseEntryName(kind, file)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected blob in %s: %w", dir, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SaveCiphertext saves a ciphertext to a file with a header recording the
+// parameters hash and the content
+func SaveCiphertext(path string, ct *rlwe.Ciphertext, paramsHash string, content Content) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("failed to create ciphertext file: %w", err)
 	}
 	defer f.Close()
-	return WriteCiphertext(f, ct)
+	return WriteCiphertext(f, ct, paramsHash, content)
 }
 
-// WriteCiphertext writes a ciphertext to a writer
-func WriteCiphertext(w io.Writer, ct *rlwe.Ciphertext) error {
+// WriteCiphertext writes a ciphertext with its header to a writer
+func WriteCiphertext(w io.Writer, ct *rlwe.Ciphertext, paramsHash string, content Content) error {
 	data, err := ct.MarshalBinary()
 	if err != nil {
 		return fmt.Errorf("failed to marshal ciphertext: %w", err)
 	}
+	if err := writeCiphertextHeader(w, newCiphertextHeader(ct, paramsHash, content)); err != nil {
+		return err
+	}
 	// Write length prefix
 	length := uint64(len(data))
 	if err := binary.Write(w, binary.LittleEndian, length); err != nil {
@@ -106,84 +417,103 @@ func WriteCiphertext(w io.Writer, ct *rlwe.Ciphertext) error {
 	return nil
 }
 
-// LoadCiphertext loads a ciphertext from a file
-func LoadCiphertext(path string) (*rlwe.Ciphertext, error) {
+// LoadCiphertext loads a ciphertext from a file; the header is nil for
+// legacy files written without one
+func LoadCiphertext(path string) (*rlwe.Ciphertext, *CiphertextHeader, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open ciphertext file: %w", err)
+		return nil, nil, fmt.Errorf("failed to open ciphertext file: %w", err)
 	}
 	defer f.Close()
 	return ReadCiphertext(f)
 }
 
-// ReadCiphertext reads a ciphertext from a reader
-func ReadCiphertext(r io.Reader) (*rlwe.Ciphertext, error) {
-	// Read length prefix
-	var length uint64
-	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
-		return nil, fmt.Errorf("failed to read length: %w", err)
+// ReadCiphertext reads a ciphertext from a reader and checks it against its
+// header; the header is nil for legacy input written without one
+func ReadCiphertext(r io.Reader) (*rlwe.Ciphertext, *CiphertextHeader, error) {
+	hasHeader, length, err := splitMagic(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var header *CiphertextHeader
+	if hasHeader {
+		if header, err = readCiphertextHeader(r); err != nil {
+			return nil, nil, err
+		}
+		// Read length prefix
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, nil, fmt.Errorf("failed to read length: %w", err)
+		}
+	}
+	if length > maxCiphertextLength {
+		return nil, nil, fmt.Errorf("ciphertext length %d is implausible: not a ciphertext file", length)
 	}
 	// Read data
 	data := make([]byte, length)
 	if _, err := io.ReadFull(r, data); err != nil {
-		return nil, fmt.Errorf("failed to read ciphertext data: %w", err)
+		return nil, nil, fmt.Errorf("failed to read ciphertext data: %w", err)
 	}
 	// Unmarshal
 	ct := new(rlwe.Ciphertext)
 	if err := ct.UnmarshalBinary(data); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal ciphertext: %w", err)
+		return nil, nil, fmt.Errorf("failed to unmarshal ciphertext: %w", err)
 	}
-	return ct, nil
+	if header != nil {
+		if err := header.check(ct); err != nil {
+			return nil, nil, fmt.Errorf("corrupt ciphertext header: %w", err)
+		}
+	}
+	return ct, header, nil
 }
 
 // SaveBlock saves a column block
 func (ts *TableStore) SaveBlock(columnName string, blockIndex int, ct *rlwe.Ciphertext) error {
-	return SaveCiphertext(ts.blockPath(columnName, blockIndex), ct)
+	return ts.save(ts.resolve(EntryKey{Kind: KindBlock, Column: columnName, Block: blockIndex}), ct)
 }
 
 // LoadBlock loads a column block
 func (ts *TableStore) LoadBlock(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
-	return LoadCiphertext(ts.blockPath(columnName, blockIndex))
+	return ts.load(ts.resolve(EntryKey{Kind: KindBlock, Column: columnName, Block: blockIndex}))
 }
 
 // SaveValidity saves a validity block
 func (ts *TableStore) SaveValidity(columnName string, blockIndex int, ct *rlwe.Ciphertext) error {
-	return SaveCiphertext(ts.validityPath(columnName, blockIndex), ct)
+	return ts.save(ts.resolve(EntryKey{Kind: KindValidity, Column: columnName, Block: blockIndex}), ct)
 }
 
 // LoadValidity loads a validity block
 func (ts *TableStore) LoadValidity(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
-	return LoadCiphertext(ts.validityPath(columnName, blockIndex))
+	return ts.load(ts.resolve(EntryKey{Kind: KindValidity, Column: columnName, Block: blockIndex}))
 }
 
 // SaveBMV saves a BMV block
 func (ts *TableStore) SaveBMV(columnName string, categoryValue int, blockIndex int, ct *rlwe.Ciphertext) error {
-	return SaveCiphertext(ts.bmvPath(columnName, categoryValue, blockIndex), ct)
+	return ts.save(ts.resolve(EntryKey{Kind: KindBMV, Column: columnName, Value: categoryValue, Block: blockIndex}), ct)
 }
 
 // LoadBMV loads a BMV block
 func (ts *TableStore) LoadBMV(columnName string, categoryValue int, blockIndex int) (*rlwe.Ciphertext, error) {
-	return LoadCiphertext(ts.bmvPath(columnName, categoryValue, blockIndex))
+	return ts.load(ts.resolve(EntryKey{Kind: KindBMV, Column: columnName, Value: categoryValue, Block: blockIndex}))
 }
 
 // SavePBMV saves a PBMV block
 func (ts *TableStore) SavePBMV(columnName string, blockIndex int, ct *rlwe.Ciphertext) error {
-	return SaveCiphertext(ts.pbmvPath(columnName, blockIndex), ct)
+	return ts.save(ts.resolve(EntryKey{Kind: KindPBMV, Column: columnName, Block: blockIndex}), ct)
 }
 
 // LoadPBMV loads a PBMV block
 func (ts *TableStore) LoadPBMV(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
-	return LoadCiphertext(ts.pbmvPath(columnName, blockIndex))
+	return ts.load(ts.resolve(EntryKey{Kind: KindPBMV, Column: columnName, Block: blockIndex}))
 }
 
 // SaveBBMV saves a BBMV block
 func (ts *TableStore) SaveBBMV(columnName string, blockIndex int, ct *rlwe.Ciphertext) error {
-	return SaveCiphertext(ts.bbmvPath(columnName, blockIndex), ct)
+	return ts.save(ts.resolve(EntryKey{Kind: KindBBMV, Column: columnName, Block: blockIndex}), ct)
 }
 
 // LoadBBMV loads a BBMV block
 func (ts *TableStore) LoadBBMV(columnName string, blockIndex int) (*rlwe.Ciphertext, error) {
-	return LoadCiphertext(ts.bbmvPath(columnName, blockIndex))
+	return ts.load(ts.resolve(EntryKey{Kind: KindBBMV, Column: columnName, Block: blockIndex}))
 }
 
 // BlockIterator provides streaming access to blocks
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// BlockKeys returns the entries streamed for one block, in the order their
+// ciphertexts are returned; their generations are resolved by the store
+type BlockKeys func(block int) []EntryKey
+
+// Prefetcher streams the blocks of a table: a background goroutine loads
+// blocks ahead of the consumer, holding at most depth of them in memory, so
+// operations over any number of blocks run in fixed memory
+type Prefetcher struct {
+	store      *TableStore
+	keys       BlockKeys
+	blockCount int
+	depth      int
+	current    int
+	blocks     chan prefetched
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// prefetched is one loaded block, or the error that stopped loading
+type prefetched struct {
+	cts []*rlwe.Ciphertext
+	err error
+}
+
+// NewPrefetcher creates a stream over blocks 0..blockCount-1 that loads up
+// to depth blocks ahead; Close must be called if it is not read to the end
+func (ts *TableStore) NewPrefetcher(keys BlockKeys, blockCount, depth int) *Prefetcher {
+	p := &Prefetcher{
+		store:      ts,
+		keys:       keys,
+		blockCount: blockCount,
+		depth:      max(depth, 1),
+	}
+	p.start()
+	return p
+}
+
+// PrefetchDepth returns how many blocks of a stream fit in budget bytes,
+// estimating every block by the stored size of block 0; it is at least one
+func (ts *TableStore) PrefetchDepth(keys BlockKeys, budget int64) (int, error) {
+	var blockBytes int64
+	for _, key := range keys(0) {
+		size, err := ts.Size(ts.resolve(key))
+		if err != nil {
+			return 0, err
+		}
+		blockBytes += size
+	}
+	if blockBytes == 0 {
+		return 1, nil
+	}
+	return int(max(budget/blockBytes, 1)), nil
+}
+
+// Depth returns the number of blocks loaded ahead of the consumer
+func (p *Prefetcher) Depth() int {
+	return p.depth
+}
+
+// start launches the loader from block 0. The channel holds depth-1 blocks
+// and the loader one more while it waits to send.
+func (p *Prefetcher) start() {
+	p.current = 0
+	p.blocks = make(chan prefetched, p.depth-1)
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.load(p.blocks, p.stop, p.done)
+}
+
+// load reads blocks in order until the end, an error or a stop
+func (p *Prefetcher) load(blocks chan<- prefetched, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for b := 0; b < p.blockCount; b++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		keys := p.keys(b)
+		r := prefetched{cts: make([]*rlwe.Ciphertext, len(keys))}
+		for i, key := range keys {
+			if r.cts[i], r.err = p.store.load(p.store.resolve(key)); r.err != nil {
+				r.err = fmt.Errorf("block %d: %w", b, r.err)
+				break
+			}
+		}
+		select {
+		case blocks <- r:
+		case <-stop:
+			return
+		}
+		if r.err != nil {
+			return
+		}
+	}
+}
+
+// HasNext returns true if there are more blocks
+func (p *Prefetcher) HasNext() bool {
+	return p.current < p.blockCount
+}
+
+// Next returns the ciphertexts of the next block, in the order of its keys
+func (p *Prefetcher) Next() ([]*rlwe.Ciphertext, error) {
+	if !p.HasNext() {
+		return nil, fmt.Errorf("no more blocks")
+	}
+	r := <-p.blocks
+	if r.err != nil {
+		p.current = p.blockCount
+		return nil, r.err
+	}
+	p.current++
+	return r.cts, nil
+}
+
+// Reset restarts the stream from block 0, for operations making a second pass
+func (p *Prefetcher) Reset() {
+	p.Close()
+	p.start()
+}
+
+// Close stops the loader and drops the blocks it prefetched
+func (p *Prefetcher) Close() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+}
@@ -0,0 +1,90 @@
+package storage
+
+import "testing"
+
+func testStreamTable(t *testing.T, blocks int) (*TableStore, BlockKeys) {
+	t.Helper()
+	ct, hash := testCiphertext(t)
+	store := NewBlobTableStore("mem", NewMemStore())
+	store.ParamsHash = hash
+	for b := 0; b < blocks; b++ {
+		if err := store.SaveBlock("age", b, ct); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveValidity("age", b, ct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, func(b int) []EntryKey {
+		return []EntryKey{{Kind: KindBlock, Column: "age", Block: b}, {Kind: KindValidity, Column: "age", Block: b}}
+	}
+}
+
+func TestPrefetcher(t *testing.T) {
+	store, keys := testStreamTable(t, 5)
+	for _, depth := range []int{0, 1, 3, 10} {
+		p := store.NewPrefetcher(keys, 5, depth)
+		for pass := 0; pass < 2; pass++ {
+			n := 0
+			for p.HasNext() {
+				block, err := p.Next()
+				if err != nil {
+					t.Fatalf("depth %d: %v", depth, err)
+				}
+				if len(block) != 2 || block[0] == nil || block[1] == nil {
+					t.Fatalf("depth %d: unexpected block %v", depth, block)
+				}
+				n++
+			}
+			if n != 5 {
+				t.Errorf("depth %d pass %d: expected 5 blocks, got %d", depth, pass, n)
+			}
+			if _, err := p.Next(); err == nil {
+				t.Error("Next past the end should fail")
+			}
+			p.Reset()
+		}
+		p.Close()
+	}
+
+	// Closing a stream that was not read to the end stops its loader
+	p := store.NewPrefetcher(keys, 5, 1)
+	if _, err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	p.Close()
+}
+
+func TestPrefetcherError(t *testing.T) {
+	store, keys := testStreamTable(t, 2)
+	p := store.NewPrefetcher(keys, 3, 2)
+	defer p.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := p.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Next(); err == nil {
+		t.Fatal("expected an error for the missing block")
+	}
+	if p.HasNext() {
+		t.Error("the stream should end after an error")
+	}
+}
+
+func TestPrefetchDepth(t *testing.T) {
+	store, keys := testStreamTable(t, 1)
+	size, err := store.Size(keys(0)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for budget, want := range map[int64]int{0: 1, 2*size - 1: 1, 2 * size: 1, 7 * size: 3} {
+		if depth, err := store.PrefetchDepth(keys, budget); err != nil || depth != want {
+			t.Errorf("budget %d: expected depth %d, got %d (%v)", budget, want, depth, err)
+		}
+	}
+	if _, err := store.PrefetchDepth(func(b int) []EntryKey { return []EntryKey{{Kind: KindBlock, Column: "x"}} }, 1<<20); err == nil {
+		t.Error("PrefetchDepth should fail for a missing block")
+	}
+}
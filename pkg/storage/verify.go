@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// ErrChecksum is wrapped by the errors of reads whose data does not match
+// the checksum the store holds for the entry
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ProblemKind classifies what is wrong with an entry of a table
+type ProblemKind string
+
+const (
+	ProblemMissing      ProblemKind = "missing"      // The metadata calls for the entry but the table does not hold it
+	ProblemCorrupt      ProblemKind = "corrupt"      // The entry cannot be read or decoded, or its header does not describe it
+	ProblemChecksum     ProblemKind = "checksum"     // The entry does not match its expected SHA-256
+	ProblemInconsistent ProblemKind = "inconsistent" // The ciphertext's level, scale or slots differ from the table's
+)
+
+// EntryProblem is one failed check of a table entry
+type EntryProblem struct {
+	Key    EntryKey    `json:"key"`
+	Kind   ProblemKind `json:"kind"`
+	Detail string      `json:"detail"`
+}
+
+func (p EntryProblem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Key, p.Kind, p.Detail)
+}
+
+// VerifyOptions selects the optional checks of VerifyTable
+type VerifyOptions struct {
+	// MaxLevel is the level of fresh ciphertexts of the table's profile. If
+	// set, or if the table records trimmed levels, each block must sit at its
+	// level less its retractions; otherwise only the entries of a block are
+	// checked to share a level.
+	MaxLevel int
+	// Checksums are expected SHA-256 sums of entries, e.g. from a signed
+	// manifest; entries without one are not checked
+	Checksums map[EntryKey]string
+}
+
+// TableReport summarizes the check of a table against its metadata
+type TableReport struct {
+	Entries      int               `json:"entries"`       // Ciphertext entries the metadata calls for
+	Verified     int               `json:"verified"`      // Entries that passed every check
+	Bytes        int64             `json:"bytes"`         // Stored size of the verified entries
+	Levels       map[int]int       `json:"levels"`        // Verified entries per level
+	LowestLevels map[EntryKind]int `json:"lowest_levels"` // Lowest level of the verified block, validity and BMV (or PBMV/BBMV) entries
+	Problems     []EntryProblem    `json:"problems,omitempty"`
+	Superseded   int               `json:"superseded"`             // Entries of earlier generations of a block, kept for running jobs
+	Unreferenced []EntryKey        `json:"unreferenced,omitempty"` // Ciphertext entries the metadata does not refer to
+}
+
+// OK reports whether every expected entry passed
+func (r *TableReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Count returns the number of problems of a kind
+func (r *TableReport) Count(kind ProblemKind) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// String returns a one-line summary of the report
+func (r *TableReport) String() string {
+	var levels []string
+	for _, l := range sortedKeys(r.Levels) {
+		levels = append(levels, fmt.Sprintf("%d at level %d", r.Levels[l], l))
+	}
+	s := fmt.Sprintf("%d of %d entries verified (%.1f MiB", r.Verified, r.Entries, float64(r.Bytes)/(1<<20))
+	if len(levels) > 0 {
+		s += "; " + strings.Join(levels, ", ")
+	}
+	s += ")"
+	for _, kind := range []ProblemKind{ProblemMissing, ProblemCorrupt, ProblemChecksum, ProblemInconsistent} {
+		if n := r.Count(kind); n > 0 {
+			s += fmt.Sprintf(", %d %s", n, kind)
+		}
+	}
+	if r.Superseded > 0 {
+		s += fmt.Sprintf(", %d superseded", r.Superseded)
+	}
+	if len(r.Unreferenced) > 0 {
+		s += fmt.Sprintf(", %d unreferenced", len(r.Unreferenced))
+	}
+	return s
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// levelGroup is a set of entries of a block that share a level
+type levelGroup struct {
+	block int
+	kind  EntryKind // Empty if all kinds share the level
+}
+
+// levelKind returns the kind whose level an entry is encrypted at
+func levelKind(kind EntryKind) EntryKind {
+	if kind == KindBlock || kind == KindValidity {
+		return kind
+	}
+	return KindBMV
+}
+
+// storedLevel returns the level of a kind; the BMV level is also that of
+// every kind when all share a level
+func storedLevel(levels schema.EntryLevels, kind EntryKind) int {
+	switch kind {
+	case KindBlock:
+		return levels.Block
+	case KindValidity:
+		return levels.Validity
+	}
+	return levels.BMV
+}
+
+// ExpectedEntries returns the ciphertext entries a table's metadata calls
+// for, at the generation of their block: the values and validity of every
+// column and the BMV of every code of categorical/ordinal columns. PBMVs and
+// BBMVs are not described by the metadata; a column that has them in any
+// block must have them in every block, so they are expected from the keys
+// the table holds.
+func ExpectedEntries(meta *schema.TableMetadata, keys []EntryKey) []EntryKey {
+	packed := make(map[EntryKey]bool)
+	for _, key := range keys {
+		if (key.Kind == KindPBMV || key.Kind == KindBBMV) && meta.Schema.GetColumn(key.Column) != nil {
+			packed[EntryKey{Kind: key.Kind, Column: key.Column}] = true
+		}
+	}
+
+	var expected []EntryKey
+	for b := 0; b < meta.BlockCount; b++ {
+		gen := meta.BlockGeneration(b)
+		add := func(key EntryKey) {
+			key.Block, key.Gen = b, gen
+			expected = append(expected, key)
+		}
+		for _, col := range meta.Schema.Columns {
+			add(EntryKey{Kind: KindBlock, Column: col.Name})
+			add(EntryKey{Kind: KindValidity, Column: col.Name})
+			if col.IsCoded() {
+				for _, code := range col.Codes() {
+					add(EntryKey{Kind: KindBMV, Column: col.Name, Value: code})
+				}
+			}
+			for _, kind := range []EntryKind{KindPBMV, KindBBMV} {
+				if packed[EntryKey{Kind: kind, Column: col.Name}] {
+					add(EntryKey{Kind: kind, Column: col.Name})
+				}
+			}
+		}
+	}
+	return expected
+}
+
+// VerifyTable checks a table against its metadata: every expected
+// ciphertext must exist, decode with a header naming it and, if the store
+// has a ParamsHash, the table's parameters, and have the table's scale and
+// slots. The entries of a block must share a level, or one per kind in
+// tables trimmed below the top level. Checksums held by the
+// store, a container's index or hashes set with Expect, are checked as
+// entries are read, as are opts.Checksums. Problems are reported per entry;
+// an error is returned only if the table cannot be listed.
+func (ts *TableStore) VerifyTable(meta *schema.TableMetadata, opts VerifyOptions) (*TableReport, error) {
+	keys, err := ts.Keys()
+	if err != nil {
+		return nil, err
+	}
+	expected := ExpectedEntries(meta, keys)
+	report := &TableReport{Entries: len(expected), Levels: make(map[int]int), LowestLevels: make(map[EntryKind]int)}
+
+	// Entries the metadata does not call for are either earlier generations
+	// of a rewritten block or unreferenced
+	want := make(map[EntryKey]bool, len(expected))
+	for _, key := range expected {
+		want[key] = true
+	}
+	for _, key := range keys {
+		if key.Kind == KindFile || want[key] {
+			continue
+		}
+		current := key
+		current.Gen = meta.BlockGeneration(key.Block)
+		if key.Block < meta.BlockCount && key.Gen < current.Gen && want[current] {
+			report.Superseded++
+		} else {
+			report.Unreferenced = append(report.Unreferenced, key)
+		}
+	}
+
+	problem := func(key EntryKey, kind ProblemKind, format string, args ...any) {
+		report.Problems = append(report.Problems, EntryProblem{Key: key, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+	pass := func(key EntryKey, level int, size int64) {
+		report.Verified++
+		report.Bytes += size
+		report.Levels[level]++
+		if lowest, ok := report.LowestLevels[levelKind(key.Kind)]; !ok || level < lowest {
+			report.LowestLevels[levelKind(key.Kind)] = level
+		}
+	}
+	groups := make(map[levelGroup]map[int][]EntryKey)
+	sizes := make(map[EntryKey]int64)
+	for _, key := range expected {
+		if !ts.Has(key) {
+			problem(key, ProblemMissing, "not in the table")
+			continue
+		}
+		data, err := ts.LoadRaw(key)
+		if errors.Is(err, ErrChecksum) {
+			problem(key, ProblemChecksum, "%v", err)
+			continue
+		} else if err != nil {
+			problem(key, ProblemCorrupt, "%v", err)
+			continue
+		}
+		if want, ok := opts.Checksums[key]; ok {
+			if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+				problem(key, ProblemChecksum, "SHA-256 differs from the expected %s", want)
+				continue
+			}
+		}
+		ct, err := ts.decode(key, data)
+		if err != nil {
+			problem(key, ProblemCorrupt, "%v", err)
+			continue
+		}
+		if math.Abs(ct.LogScale()-float64(meta.LogScale)) > 1e-6 {
+			problem(key, ProblemInconsistent, "log-scale %.4f, table has %d", ct.LogScale(), meta.LogScale)
+			continue
+		}
+		if ct.Slots() != meta.Slots {
+			problem(key, ProblemInconsistent, "%d slots, table has %d", ct.Slots(), meta.Slots)
+			continue
+		}
+		if key.Kind == KindPBMV || key.Kind == KindBBMV {
+			// Packed vectors are not built from fresh blocks
+			pass(key, ct.Level(), int64(len(data)))
+			continue
+		}
+		g := levelGroup{block: key.Block}
+		if meta.Levels != nil {
+			g.kind = levelKind(key.Kind)
+		}
+		if groups[g] == nil {
+			groups[g] = make(map[int][]EntryKey)
+		}
+		groups[g][ct.Level()] = append(groups[g][ct.Level()], key)
+		sizes[key] = int64(len(data))
+	}
+
+	// The entries of a block go through the same appends and retractions, so
+	// they share a level, or one per kind if the table was trimmed. It is
+	// known from the trimmed levels or the profile, else the most common one.
+	kinds := []EntryKind{""}
+	if meta.Levels != nil {
+		kinds = []EntryKind{KindBlock, KindValidity, KindBMV}
+	}
+	for b := 0; b < meta.BlockCount; b++ {
+		for _, kind := range kinds {
+			levels := groups[levelGroup{block: b, kind: kind}]
+			counts := make(map[int]int, len(levels))
+			for level, keys := range levels {
+				counts[level] = len(keys)
+			}
+			want := -1
+			if meta.Levels != nil || opts.MaxLevel > 0 {
+				want = storedLevel(meta.StoredLevels(opts.MaxLevel), kind) - meta.BlockRetractions(b)
+			} else {
+				for _, level := range sortedKeys(counts) {
+					if want < 0 || counts[level] >= counts[want] {
+						want = level
+					}
+				}
+			}
+			for _, level := range sortedKeys(counts) {
+				for _, key := range levels[level] {
+					if level != want {
+						problem(key, ProblemInconsistent, "level %d, block %d is at level %d", level, b, want)
+						continue
+					}
+					pass(key, level, sizes[key])
+				}
+			}
+		}
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		return report.Problems[i].Key.String() < report.Problems[j].Key.String()
+	})
+	return report, nil
+}
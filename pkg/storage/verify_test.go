@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"testing"
+
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// verifyTestTable stores a two-block table with a numerical and a
+// categorical column, every entry at the level of testCiphertext
+func verifyTestTable(t *testing.T) (*TableStore, *MemStore, *schema.TableMetadata) {
+	t.Helper()
+	ct, hash := testCiphertext(t)
+	blobs := NewMemStore()
+	store := NewBlobTableStore("mem", blobs)
+	store.ParamsHash = hash
+	meta, err := schema.NewTableMetadata(schema.TableSchema{
+		Name: "t",
+		Columns: []schema.Column{
+			{Name: "age", Type: schema.Numerical},
+			{Name: "region", Type: schema.Categorical, CategoryCount: 2},
+		},
+	}, ct.Slots()+1, ct.Slots(), hash, int(math.Round(ct.LogScale())), "owner1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range ExpectedEntries(meta, nil) {
+		if err := store.SaveEntry(key, ct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+	return store, blobs, meta
+}
+
+func TestVerifyTable(t *testing.T) {
+	store, _, meta := verifyTestTable(t)
+	if got := len(ExpectedEntries(meta, nil)); got != 2*(2+2+2) {
+		t.Fatalf("expected 12 entries for 2 blocks of 2 columns, got %d", got)
+	}
+	report, err := store.VerifyTable(meta, VerifyOptions{MaxLevel: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Verified != report.Entries || report.Levels[3] != report.Entries {
+		t.Errorf("intact table should verify: %s %v", report, report.Problems)
+	}
+
+	report, err = store.VerifyTable(meta, VerifyOptions{MaxLevel: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(ProblemInconsistent) != report.Entries {
+		t.Errorf("entries below the profile's level should be inconsistent: %s", report)
+	}
+}
+
+func TestVerifyTableProblems(t *testing.T) {
+	store, blobs, meta := verifyTestTable(t)
+	ct, _ := testCiphertext(t)
+
+	missing := EntryKey{Kind: KindBMV, Column: "region", Value: 1, Block: 1}
+	if err := blobs.Delete(blobName(missing)); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := EntryKey{Kind: KindValidity, Column: "age", Block: 0}
+	if err := blobs.Put(blobName(corrupt), []byte("not a ciphertext")); err != nil {
+		t.Fatal(err)
+	}
+	lower := EntryKey{Kind: KindBlock, Column: "region", Block: 1}
+	dropped := ct.CopyNew()
+	dropped.Resize(1, ct.Level()-1)
+	if err := store.SaveEntry(lower, dropped); err != nil {
+		t.Fatal(err)
+	}
+	stale := EntryKey{Kind: KindBlock, Column: "gone", Block: 0}
+	if err := store.SaveEntry(stale, ct); err != nil {
+		t.Fatal(err)
+	}
+	summed := EntryKey{Kind: KindBlock, Column: "age", Block: 0}
+	data, err := store.LoadRaw(summed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(append(data, 0))
+
+	report, err := store.VerifyTable(meta, VerifyOptions{Checksums: map[EntryKey]string{summed: hex.EncodeToString(sum[:])}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[EntryKey]ProblemKind{
+		missing: ProblemMissing,
+		corrupt: ProblemCorrupt,
+		lower:   ProblemInconsistent,
+		summed:  ProblemChecksum,
+	}
+	if len(report.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %v", len(want), report.Problems)
+	}
+	for _, p := range report.Problems {
+		if want[p.Key] != p.Kind {
+			t.Errorf("unexpected problem %s", p)
+		}
+	}
+	if report.OK() || report.Verified != report.Entries-len(want) {
+		t.Errorf("unexpected report %s", report)
+	}
+	if len(report.Unreferenced) != 1 || report.Unreferenced[0] != stale {
+		t.Errorf("expected %s to be unreferenced, got %v", stale, report.Unreferenced)
+	}
+}
+
+func TestVerifyTableGenerations(t *testing.T) {
+	store, _, meta := verifyTestTable(t)
+	ct, _ := testCiphertext(t)
+
+	// Block 1 is rewritten at generation 1 one level lower, as by a retraction
+	masked := ct.CopyNew()
+	masked.Resize(1, ct.Level()-1)
+	for _, key := range ExpectedEntries(meta, nil) {
+		if key.Block != 1 {
+			continue
+		}
+		key.Gen = 1
+		if err := store.SaveEntry(key, masked); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta.Generation = 1
+	meta.BlockGenerations = []int{0, 1}
+	meta.Retractions = []schema.RetractionRecord{{Generation: 1, Rows: 1, Blocks: []int{1}}}
+	if err := store.SaveMetadata(meta); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.VerifyTable(meta, VerifyOptions{MaxLevel: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Levels[3] != 6 || report.Levels[2] != 6 {
+		t.Errorf("retracted block should verify one level lower: %s %v", report, report.Problems)
+	}
+	if report.Superseded != 6 || len(report.Unreferenced) != 0 {
+		t.Errorf("generation 0 of block 1 should be superseded: %s", report)
+	}
+}
+
+func TestVerifyTableTrimmedLevels(t *testing.T) {
+	store, _, meta := verifyTestTable(t)
+	ct, _ := testCiphertext(t)
+
+	// BMVs trimmed one level below the blocks and validity
+	meta.Levels = &schema.EntryLevels{Block: 3, Validity: 3, BMV: 2}
+	report, err := store.VerifyTable(meta, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(ProblemInconsistent) != 4 {
+		t.Errorf("BMVs above their recorded level should be inconsistent: %s", report)
+	}
+
+	trimmed := ct.CopyNew()
+	trimmed.Resize(1, 2)
+	for _, key := range ExpectedEntries(meta, nil) {
+		if key.Kind == KindBMV {
+			if err := store.SaveEntry(key, trimmed); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	report, err = store.VerifyTable(meta, VerifyOptions{MaxLevel: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Levels[2] != 4 || report.Levels[3] != 8 {
+		t.Errorf("trimmed table should verify: %s %v", report, report.Problems)
+	}
+	if report.LowestLevels[KindBMV] != 2 || report.LowestLevels[KindBlock] != 3 || report.LowestLevels[KindValidity] != 3 {
+		t.Errorf("unexpected lowest levels %v", report.LowestLevels)
+	}
+}
@@ -0,0 +1,579 @@
+package integration
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+
+	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/jobs"
+	"github.com/hkanpak21/lattigostats/pkg/ops/categorical"
+	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
+	"github.com/hkanpak21/lattigostats/pkg/ops/ordinal"
+	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/schema"
+)
+
+// fixtureSeed is the seed used to generate the golden fixture keys and tables
+const fixtureSeed = "lattigostats-fixtures"
+
+// goldenPath lists the digests of the seeded Profile T keys and ciphertexts
+const goldenPath = "../fixtures/golden/profile_t.sha256"
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden fixture digests")
+
+// knownFailures are the expected results the encrypted path does not reach
+// yet, by job file. Their check is reported as a skip while it fails and as an
+// error once it passes, so that the entry is removed with the fix.
+var knownFailures = map[string]string{
+	"job_percentile.json": "APPROXSIGN with its default 3 iterations cannot separate cumulative ratios 0.05 apart, and bucket 4's ratio is exactly 0.9",
+}
+
+// loadFixtureColumns reads test/fixtures/test_data.csv into columns by header name
+func loadFixtureColumns(t *testing.T) map[string][]float64 {
+	t.Helper()
+
+	f, err := os.Open("../fixtures/test_data.csv")
+	if err != nil {
+		t.Fatalf("Failed to open fixture data: %v", err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read fixture data: %v", err)
+	}
+
+	columns := make(map[string][]float64)
+	for _, row := range records[1:] {
+		for i, name := range records[0] {
+			v, err := strconv.ParseFloat(row[i], 64)
+			if err != nil {
+				t.Fatalf("Invalid fixture value %q in column %s: %v", row[i], name, err)
+			}
+			columns[name] = append(columns[name], v)
+		}
+	}
+	return columns
+}
+
+// loadFixtureSchema reads test/fixtures/test_schema.json
+func loadFixtureSchema(t *testing.T) *schema.TableSchema {
+	t.Helper()
+
+	data, err := os.ReadFile("../fixtures/test_schema.json")
+	if err != nil {
+		t.Fatalf("Failed to read fixture schema: %v", err)
+	}
+	var s schema.TableSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("Failed to parse fixture schema: %v", err)
+	}
+	return &s
+}
+
+// fixtureResult is a row of the Expected Results table of test/fixtures/README.md
+type fixtureResult struct {
+	Job       string
+	Result    string
+	Expected  float64
+	Tolerance float64
+}
+
+// loadExpectedResults parses the Expected Results table of test/fixtures/README.md
+func loadExpectedResults(t *testing.T) []fixtureResult {
+	t.Helper()
+
+	f, err := os.Open("../fixtures/README.md")
+	if err != nil {
+		t.Fatalf("Failed to open fixture README: %v", err)
+	}
+	defer f.Close()
+
+	var results []fixtureResult
+	section := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "## ") {
+			section = line == "## Expected Results"
+			continue
+		}
+		if !section || !strings.HasPrefix(line, "|") {
+			continue
+		}
+		cells := strings.Split(strings.Trim(line, "|"), "|")
+		for i := range cells {
+			cells[i] = strings.Trim(strings.TrimSpace(cells[i]), "`")
+		}
+		if len(cells) < 4 || cells[0] == "Job" || strings.HasPrefix(cells[0], "-") {
+			continue
+		}
+		expected, err := strconv.ParseFloat(cells[2], 64)
+		if err != nil {
+			t.Fatalf("README: invalid expected value %q for %s: %v", cells[2], cells[0], err)
+		}
+		tolerance, err := strconv.ParseFloat(cells[3], 64)
+		if err != nil {
+			t.Fatalf("README: invalid tolerance %q for %s: %v", cells[3], cells[0], err)
+		}
+		results = append(results, fixtureResult{Job: cells[0], Result: cells[1], Expected: expected, Tolerance: tolerance})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read fixture README: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("README has no Expected Results table")
+	}
+	return results
+}
+
+// fixtureEnv is a key set generated from fixtureSeed with a seeded encryptor
+type fixtureEnv struct {
+	profile   *params.Profile
+	evaluator *he.Evaluator
+	encoder   *ckks.Encoder
+	encryptor params.Encryptor
+	decryptor *rlwe.Decryptor
+	sk        *rlwe.SecretKey
+	pk        *rlwe.PublicKey
+	rlk       *rlwe.RelinearizationKey
+}
+
+// newFixtureEnv generates the fixture key set of Profile T deterministically from fixtureSeed
+func newFixtureEnv(t *testing.T) *fixtureEnv {
+	t.Helper()
+
+	profile, err := params.NewProfileT()
+	if err != nil {
+		t.Fatalf("Failed to create Profile T: %v", err)
+	}
+	return newProfileEnv(t, profile)
+}
+
+// newProfileEnv generates a key set of profile from fixtureSeed. The
+// bootstrapping keys of a bootstrapping profile are generated by lattigo and
+// are not reproducible.
+func newProfileEnv(t *testing.T, profile *params.Profile) *fixtureEnv {
+	t.Helper()
+
+	p := profile.Params
+	kgen, err := params.NewSeededKeyGenerator(p, fixtureSeed)
+	if err != nil {
+		t.Fatalf("Failed to seed key generation: %v", err)
+	}
+	sk := kgen.GenSecretKeyNew()
+	pk := kgen.GenPublicKeyNew(sk)
+	rlk := kgen.GenRelinearizationKeyNew(sk)
+	galks := kgen.GenGaloisKeysNew(rlwe.GaloisElementsForInnerSum(p, 1, p.MaxSlots()), sk)
+	encryptor, err := params.NewSeededEncryptor(p, pk, fixtureSeed)
+	if err != nil {
+		t.Fatalf("Failed to seed encryption: %v", err)
+	}
+
+	var btp *bootstrapping.Evaluator
+	if profile.BootstrapEnabled {
+		btpParams, err := profile.BootstrappingParameters()
+		if err != nil {
+			t.Fatalf("Failed to create bootstrapping parameters: %v", err)
+		}
+		btpEvk, _, err := btpParams.GenEvaluationKeys(sk)
+		if err != nil {
+			t.Fatalf("Failed to generate bootstrapping keys: %v", err)
+		}
+		if btp, err = bootstrapping.NewEvaluator(btpParams, btpEvk); err != nil {
+			t.Fatalf("Failed to create bootstrapper: %v", err)
+		}
+	}
+
+	evaluator, err := he.NewEvaluator(p, rlwe.NewMemEvaluationKeySet(rlk, galks...), btp)
+	if err != nil {
+		t.Fatalf("Failed to create evaluator: %v", err)
+	}
+
+	return &fixtureEnv{
+		profile:   profile,
+		evaluator: evaluator,
+		encoder:   ckks.NewEncoder(p),
+		encryptor: encryptor,
+		decryptor: rlwe.NewDecryptor(p, sk),
+		sk:        sk,
+		pk:        pk,
+		rlk:       rlk,
+	}
+}
+
+// encrypt encrypts values (padded with zeros) into a single block
+func (env *fixtureEnv) encrypt(t *testing.T, values []float64) *rlwe.Ciphertext {
+	t.Helper()
+
+	p := env.profile.Params
+	slots := make([]float64, env.profile.Slots)
+	copy(slots, values)
+
+	pt := ckks.NewPlaintext(p, p.MaxLevel())
+	if err := env.encoder.Encode(slots, pt); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	ct, err := env.encryptor.EncryptNew(pt)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	return ct
+}
+
+// decryptFirst decrypts a ciphertext and returns slot 0
+func (env *fixtureEnv) decryptFirst(t *testing.T, ct *rlwe.Ciphertext) float64 {
+	t.Helper()
+	return env.decrypt(t, ct)[0]
+}
+
+// decrypt decrypts and decodes every slot of a ciphertext
+func (env *fixtureEnv) decrypt(t *testing.T, ct *rlwe.Ciphertext) []float64 {
+	t.Helper()
+
+	values := make([]float64, env.profile.Slots)
+	if err := env.encoder.Decode(env.decryptor.DecryptNew(ct), values); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	return values
+}
+
+// indicator returns 1 where column == value and 0 elsewhere
+func indicator(column []float64, value int) []float64 {
+	out := make([]float64, len(column))
+	for i, v := range column {
+		if int(v) == value {
+			out[i] = 1
+		}
+	}
+	return out
+}
+
+// memBMVStore is an in-memory categorical.BMVStore for a single block
+type memBMVStore map[string]*rlwe.Ciphertext
+
+func (m memBMVStore) GetBMV(columnName string, value int, blockIndex int) (*rlwe.Ciphertext, error) {
+	ct, ok := m[fmt.Sprintf("%s=%d", columnName, value)]
+	if !ok {
+		return nil, fmt.Errorf("no BMV for %s=%d", columnName, value)
+	}
+	return ct, nil
+}
+
+func (m memBMVStore) BlockCount() int {
+	return 1
+}
+
+// memOrdinalStore is an in-memory ordinal.BMVStore of one column for a single block
+type memOrdinalStore map[int]*rlwe.Ciphertext
+
+func (m memOrdinalStore) GetBMV(value int, blockIndex int) (*rlwe.Ciphertext, error) {
+	ct, ok := m[value]
+	if !ok {
+		return nil, fmt.Errorf("no BMV for value %d", value)
+	}
+	return ct, nil
+}
+
+func (m memOrdinalStore) BlockCount() int {
+	return 1
+}
+
+// digest returns the hex SHA-256 of a marshaled key or ciphertext
+func digest(t *testing.T, obj interface{ MarshalBinary() ([]byte, error) }) string {
+	t.Helper()
+
+	data, err := obj.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// TestSeededFixturesGolden checks the seeded Profile T keys and the
+// encrypted fixture columns byte for byte against the committed digests;
+// run with -update to rewrite them after an intended change
+func TestSeededFixturesGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	cols := loadFixtureColumns(t)
+	env := newFixtureEnv(t)
+
+	digests := map[string]string{
+		"secret.key": digest(t, env.sk),
+		"public.key": digest(t, env.pk),
+		"relin.key":  digest(t, env.rlk),
+	}
+	for _, name := range []string{"income", "spending", "risk_bucket"} {
+		digests[name+".ct"] = digest(t, env.encrypt(t, cols[name]))
+	}
+
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if *updateGolden {
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s  %s\n", digests[name], name)
+		}
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+			t.Fatalf("Failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(goldenPath, []byte(b.String()), 0644); err != nil {
+			t.Fatalf("Failed to write golden digests: %v", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Failed to read golden digests (run with -update to create them): %v", err)
+	}
+	golden := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("Invalid golden line %q", line)
+		}
+		golden[fields[1]] = fields[0]
+	}
+	for _, name := range names {
+		if golden[name] != digests[name] {
+			t.Errorf("%s: digest %s does not match golden %s", name, digests[name], golden[name])
+		}
+	}
+	if len(golden) != len(digests) {
+		t.Errorf("Golden file lists %d entries, expected %d", len(golden), len(digests))
+	}
+}
+
+// TestFixtureExpectedResults runs every job of the Expected Results table of
+// test/fixtures/README.md under encryption on the bootstrapping test Profile
+// TB, with the numerical columns normalized to their schema bounds as
+// do_encrypt encrypts them, and checks the decrypted results and the
+// plaintext references against the table
+func TestFixtureExpectedResults(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	expected := loadExpectedResults(t)
+	cols := loadFixtureColumns(t)
+	tableSchema := loadFixtureSchema(t)
+	profile, err := params.NewProfileTB()
+	if err != nil {
+		t.Fatalf("Failed to create Profile TB: %v", err)
+	}
+	env := newProfileEnv(t, profile)
+	rows := len(cols["income"])
+
+	// The jobs fan out to two workers, which bootstrap with copies of the
+	// bootstrapper, as da_run -workers 2 does
+	env.evaluator.SetWorkers(2)
+
+	valid := make([]float64, rows)
+	validBool := make([]bool, rows)
+	for i := range valid {
+		valid[i] = 1
+		validBool[i] = true
+	}
+	validity := []*rlwe.Ciphertext{env.encrypt(t, valid)}
+
+	// Columns are encrypted as do_encrypt does: numerical ones normalized,
+	// categorical and ordinal ones as one BMV per code
+	blocks := make(map[string][]*rlwe.Ciphertext)
+	transforms := make(map[string]schema.Transform)
+	bmvs := memBMVStore{}
+	ordinals := make(map[string]memOrdinalStore)
+	for _, col := range tableSchema.Columns {
+		values := cols[col.Name]
+		switch col.Type {
+		case schema.Numerical:
+			tr, ok := col.Transform()
+			if !ok {
+				continue
+			}
+			normalized := make([]float64, rows)
+			for i, x := range values {
+				if normalized[i], err = tr.Normalize(x); err != nil {
+					t.Fatalf("Normalize %s failed: %v", col.Name, err)
+				}
+			}
+			blocks[col.Name] = []*rlwe.Ciphertext{env.encrypt(t, normalized)}
+			transforms[col.Name] = tr
+		case schema.Categorical:
+			for v := 1; v <= col.CategoryCount; v++ {
+				bmvs[fmt.Sprintf("%s=%d", col.Name, v)] = env.encrypt(t, indicator(values, v))
+			}
+		case schema.Ordinal:
+			ordinals[col.Name] = memOrdinalStore{}
+			for v := 1; v <= col.CategoryCount; v++ {
+				ordinals[col.Name][v] = env.encrypt(t, indicator(values, v))
+			}
+		}
+	}
+
+	numOp := numeric.NewNumericOp(env.evaluator)
+	numOp.InvSqrtConfig = numeric.NormalizedINVSQRTConfig()
+	catOp := categorical.NewCategoricalOp(env.evaluator)
+	ordOp := ordinal.NewOrdinalOp(env.evaluator)
+
+	for _, want := range expected {
+		t.Run(want.Job, func(t *testing.T) {
+			job, err := jobs.LoadJobSpec(filepath.Join("../fixtures", want.Job))
+			if err != nil {
+				t.Fatalf("Failed to load job: %v", err)
+			}
+			if err := job.Validate(); err != nil {
+				t.Fatalf("Invalid job: %v", err)
+			}
+			conditions := make([]categorical.Condition, len(job.Conditions))
+			for i, c := range job.Conditions {
+				conditions[i] = categorical.Condition{ColumnName: c.Column, Value: c.Value}
+			}
+			codes := make([][]int, len(job.Conditions))
+			wantCodes := make([]int, len(job.Conditions))
+			for i, c := range job.Conditions {
+				for _, v := range cols[c.Column] {
+					codes[i] = append(codes[i], int(v))
+				}
+				wantCodes[i] = c.Value
+			}
+
+			var ct *rlwe.Ciphertext
+			var reference float64
+			switch job.Operation {
+			case jobs.OpMean:
+				ct, err = numOp.Mean(blocks[job.InputColumns[0]], validity)
+				reference = numeric.PlaintextMean(cols[job.InputColumns[0]], validBool)
+			case jobs.OpVariance:
+				ct, err = numOp.Variance(blocks[job.InputColumns[0]], validity)
+				reference = numeric.PlaintextVariance(cols[job.InputColumns[0]], validBool)
+			case jobs.OpCorr:
+				x, y := job.InputColumns[0], job.InputColumns[1]
+				ct, err = numOp.Correlation(blocks[x], blocks[y], validity, validity)
+				reference = numeric.PlaintextCorrelation(cols[x], cols[y], validBool)
+			case jobs.OpBc:
+				ct, err = catOp.Bc(validity, conditions, bmvs)
+				reference = float64(categorical.PlaintextBc(codes, wantCodes, validBool))
+			case jobs.OpBa:
+				ct, err = catOp.Ba(blocks[job.TargetColumn], validity, conditions, bmvs)
+				reference = categorical.PlaintextBa(cols[job.TargetColumn], codes, wantCodes, validBool)
+			case jobs.OpPercentile:
+				column := job.InputColumns[0]
+				ct, err = ordOp.Percentile(validity, ordinals[column], ordinal.PercentileConfig{K: job.K, Categories: len(ordinals[column])})
+				values := make([]int, rows)
+				for i, v := range cols[column] {
+					values[i] = int(v)
+				}
+				reference = float64(ordinal.PlaintextPercentile(values, validBool, job.K))
+			default:
+				t.Fatalf("Operation %s has no fixture check", job.Operation)
+			}
+			if err != nil {
+				t.Fatalf("%s failed: %v", job.Operation, err)
+			}
+
+			if math.Abs(reference-want.Expected) > want.Tolerance {
+				t.Errorf("%s: plaintext reference %.6f differs from README's %g", want.Result, reference, want.Expected)
+			}
+
+			got := env.decryptFirst(t, ct)
+			if column := job.NumericColumn(); column != "" {
+				tr := transforms[column]
+				got = job.ResultTransform(tr.Scale, tr.Offset).Apply(got)
+			}
+			mismatch := math.Abs(got-want.Expected) > want.Tolerance
+			if reason, known := knownFailures[want.Job]; known {
+				if !mismatch {
+					t.Errorf("%s: known failure now passes (got %.6f); remove it from knownFailures", want.Result, got)
+					return
+				}
+				t.Skipf("known failure: %s: expected %g ± %g, got %.6f (%s)", want.Result, want.Expected, want.Tolerance, got, reason)
+			}
+			if mismatch {
+				t.Errorf("%s: expected %g ± %g, got %.6f", want.Result, want.Expected, want.Tolerance, got)
+			}
+		})
+	}
+}
+
+// TestNormalizedFixtureColumn checks that statistics over a column encrypted
+// normalized to its schema bounds map back to the fixture's expected results
+func TestNormalizedFixtureColumn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	cols := loadFixtureColumns(t)
+	env := newFixtureEnv(t)
+
+	col := schema.Column{Name: "income", Type: schema.Numerical, MinValue: 0, MaxValue: 100000}
+	tr, ok := col.Transform()
+	if !ok {
+		t.Fatal("Bounded income column should have a transform")
+	}
+	normalized := make([]float64, len(cols["income"]))
+	valid := make([]float64, len(normalized))
+	for i, x := range cols["income"] {
+		z, err := tr.Normalize(x)
+		if err != nil {
+			t.Fatalf("Normalize failed: %v", err)
+		}
+		normalized[i], valid[i] = z, 1
+	}
+	if _, err := tr.Normalize(150000); err == nil {
+		t.Error("Values above max_value should be rejected")
+	}
+
+	numOp := numeric.NewNumericOp(env.evaluator)
+	blocks := []*rlwe.Ciphertext{env.encrypt(t, normalized)}
+	validity := []*rlwe.Ciphertext{env.encrypt(t, valid)}
+
+	sumCt, err := numOp.MaskedSum(blocks, validity)
+	if err != nil {
+		t.Fatalf("MaskedSum failed: %v", err)
+	}
+	sqCt, err := numOp.MaskedSumOfSquares(blocks, validity)
+	if err != nil {
+		t.Fatalf("MaskedSumOfSquares failed: %v", err)
+	}
+	countCt, err := numOp.Count(validity)
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	count := env.decryptFirst(t, countCt)
+	meanZ := env.decryptFirst(t, sumCt) / count
+	varZ := env.decryptFirst(t, sqCt)/count - meanZ*meanZ
+
+	mean := &jobs.JobSpec{ID: "m", Operation: jobs.OpMean, Table: "t", InputColumns: []string{"income"}}
+	if got := mean.ResultTransform(tr.Scale, tr.Offset).Apply(meanZ); math.Abs(got-52550) > 1 {
+		t.Errorf("Mean income: expected 52550, got %.3f", got)
+	}
+	variance := &jobs.JobSpec{ID: "v", Operation: jobs.OpVariance, Table: "t", InputColumns: []string{"income"}}
+	if got := variance.ResultTransform(tr.Scale, tr.Offset).Apply(varZ); math.Abs(got-228947500)/228947500 > 1e-4 {
+		t.Errorf("Variance income: expected 228947500, got %.3f", got)
+	}
+}
@@ -2,23 +2,30 @@
 package integration
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"strings"
 	"testing"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 
 	"github.com/hkanpak21/lattigostats/pkg/he"
+	"github.com/hkanpak21/lattigostats/pkg/ops/approx"
 	"github.com/hkanpak21/lattigostats/pkg/ops/categorical"
 	"github.com/hkanpak21/lattigostats/pkg/ops/numeric"
 	"github.com/hkanpak21/lattigostats/pkg/params"
+	"github.com/hkanpak21/lattigostats/pkg/storage"
 )
 
-// helper function to create test environment
+// helper function to create test environment on the small test-only Profile T
 func setupTestEnv(t *testing.T) (*params.Profile, *he.Evaluator, *rlwe.SecretKey, *rlwe.PublicKey, *ckks.Encoder) {
 	t.Helper()
 
-	profile, err := params.NewProfileA()
+	profile, err := params.NewProfileT()
 	if err != nil {
 		t.Fatalf("Failed to create profile: %v", err)
 	}
@@ -119,6 +126,94 @@ func TestMaskedSumComputation(t *testing.T) {
 		expectedSum, computedSum, relError)
 }
 
+// TestStreamingFromTable checks streamed masked sum and bin-count over a
+// stored table against the plaintext values
+func TestStreamingFromTable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	profile, evaluator, sk, pk, encoder := setupTestEnv(t)
+	ckksParams := profile.Params
+	encryptor := rlwe.NewEncryptor(ckksParams, pk)
+	encrypt := func(values []float64) *rlwe.Ciphertext {
+		pt := ckks.NewPlaintext(ckksParams, ckksParams.MaxLevel())
+		if err := encoder.Encode(values, pt); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		ct, err := encryptor.EncryptNew(pt)
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		return ct
+	}
+
+	store := storage.NewBlobTableStore("mem", storage.NewMemStore())
+	store.ParamsHash = profile.ParamsHash
+	const blocks = 4
+	expectedSum, expectedCount := 0.0, 0.0
+	for b := 0; b < blocks; b++ {
+		data := make([]float64, profile.Slots)
+		valid := make([]float64, profile.Slots)
+		region := make([]float64, profile.Slots)
+		for i := 0; i < 50; i++ {
+			data[i] = float64(b*50 + i)
+			valid[i] = float64((i + b) % 3 % 2)
+			region[i] = float64(i % 2)
+			expectedSum += data[i] * valid[i]
+			expectedCount += valid[i] * region[i]
+		}
+		if err := store.SaveBlock("x", b, encrypt(data)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveValidity("x", b, encrypt(valid)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveBMV("region", 1, b, encrypt(region)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decrypt := func(ct *rlwe.Ciphertext) float64 {
+		result := make([]complex128, profile.Slots)
+		if err := encoder.Decode(rlwe.NewDecryptor(ckksParams, sk).DecryptNew(ct), result); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		return real(result[0])
+	}
+
+	sumStream := store.NewPrefetcher(func(b int) []storage.EntryKey {
+		return []storage.EntryKey{
+			{Kind: storage.KindBlock, Column: "x", Block: b},
+			{Kind: storage.KindValidity, Column: "x", Block: b},
+		}
+	}, blocks, 1)
+	defer sumStream.Close()
+	sumCt, err := numeric.NewNumericOp(evaluator).StreamMaskedSum(sumStream)
+	if err != nil {
+		t.Fatalf("StreamMaskedSum failed: %v", err)
+	}
+	if sum := decrypt(sumCt); math.Abs(sum-expectedSum) > 0.01*expectedSum {
+		t.Errorf("StreamMaskedSum: expected %.2f, got %.2f", expectedSum, sum)
+	}
+
+	conditions := []categorical.Condition{{ColumnName: "region", Value: 1}}
+	bcStream := store.NewPrefetcher(func(b int) []storage.EntryKey {
+		return []storage.EntryKey{
+			{Kind: storage.KindValidity, Column: "x", Block: b},
+			{Kind: storage.KindBMV, Column: "region", Value: 1, Block: b},
+		}
+	}, blocks, 2)
+	defer bcStream.Close()
+	bcCt, err := categorical.NewCategoricalOp(evaluator).StreamBc(bcStream, conditions)
+	if err != nil {
+		t.Fatalf("StreamBc failed: %v", err)
+	}
+	if count := decrypt(bcCt); math.Abs(count-expectedCount) > 0.5 {
+		t.Errorf("StreamBc: expected %.0f, got %.2f", expectedCount, count)
+	}
+}
+
 // TestMeanComputation tests mean computation across blocks
 // NOTE: This test demonstrates the limitation of Profile A - it doesn't have
 // enough multiplicative depth for the full mean computation which requires
@@ -201,7 +296,7 @@ func TestPBMVEncoder(t *testing.T) {
 		t.Skip("Skipping integration test in short mode")
 	}
 
-	profile, err := params.NewProfileA()
+	profile, err := params.NewProfileT()
 	if err != nil {
 		t.Fatalf("Failed to create profile: %v", err)
 	}
@@ -236,7 +331,7 @@ func TestBBMVEncoder(t *testing.T) {
 		t.Skip("Skipping integration test in short mode")
 	}
 
-	profile, err := params.NewProfileA()
+	profile, err := params.NewProfileT()
 	if err != nil {
 		t.Fatalf("Failed to create profile: %v", err)
 	}
@@ -394,3 +489,556 @@ func TestEvaluatorOperations(t *testing.T) {
 
 	t.Log("Evaluator operations test passed")
 }
+
+// TestConjugateInvariantSumSlots tests that SumSlots covers all N real slots of Profile R
+func TestConjugateInvariantSumSlots(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	profile, err := params.NewProfileR()
+	if err != nil {
+		t.Fatalf("Failed to create Profile R: %v", err)
+	}
+	ckksParams := profile.Params
+
+	kgen := rlwe.NewKeyGenerator(ckksParams)
+	sk := kgen.GenSecretKeyNew()
+	pk := kgen.GenPublicKeyNew(sk)
+	rlk := kgen.GenRelinearizationKeyNew(sk)
+	galks := kgen.GenGaloisKeysNew(rlwe.GaloisElementsForInnerSum(ckksParams, 1, profile.Slots), sk)
+
+	evaluator, err := he.NewEvaluator(ckksParams, rlwe.NewMemEvaluationKeySet(rlk, galks...), nil)
+	if err != nil {
+		t.Fatalf("Failed to create evaluator: %v", err)
+	}
+	if evaluator.Slots() != ckksParams.N() {
+		t.Fatalf("Expected %d slots, got %d", ckksParams.N(), evaluator.Slots())
+	}
+
+	// Fill every slot, including the upper half that a standard ring would not have
+	data := make([]float64, profile.Slots)
+	expectedSum := 0.0
+	for i := range data {
+		data[i] = float64(i%10) / 10
+		expectedSum += data[i]
+	}
+
+	pt := evaluator.EncodeFloats(data, ckksParams.MaxLevel(), ckksParams.DefaultScale())
+	ct, err := rlwe.NewEncryptor(ckksParams, pk).EncryptNew(pt)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	sumCt, err := evaluator.SumSlots(ct)
+	if err != nil {
+		t.Fatalf("SumSlots failed: %v", err)
+	}
+
+	result := evaluator.DecodeFloats(rlwe.NewDecryptor(ckksParams, sk).DecryptNew(sumCt))
+	if math.Abs(result[0]-expectedSum) > 0.01 {
+		t.Errorf("SumSlots mismatch: expected %.4f, got %.4f", expectedSum, result[0])
+	}
+}
+
+// TestParallelWorkers checks that block operations fanned out to workers give
+// the serial results and count the same operations in the shared stats
+func TestParallelWorkers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	env := newFixtureEnv(t)
+	const blocks = 5
+	var xs, ys, vs, regions []*rlwe.Ciphertext
+	var expectedBc, expectedSq, expectedCross float64
+	for b := 0; b < blocks; b++ {
+		x := make([]float64, 40)
+		y := make([]float64, 40)
+		v := make([]float64, 40)
+		region := make([]float64, 40)
+		for i := range x {
+			x[i] = float64((b*40+i)%7) / 7
+			y[i] = float64((b+i)%5) / 5
+			v[i] = float64((i + b) % 3 % 2)
+			region[i] = float64(i % 2)
+			expectedBc += v[i] * region[i]
+			expectedSq += x[i] * x[i] * v[i] * region[i]
+			expectedCross += x[i] * y[i] * v[i]
+		}
+		xs = append(xs, env.encrypt(t, x))
+		ys = append(ys, env.encrypt(t, y))
+		vs = append(vs, env.encrypt(t, v))
+		regions = append(regions, env.encrypt(t, region))
+	}
+	conditions := []categorical.Condition{{ColumnName: "region", Value: 1}}
+
+	// Results and mul, add, rotate and rescale counts
+	run := func(workers int) ([3]float64, [4]int64) {
+		env.evaluator.SetWorkers(workers)
+		env.evaluator.Stats().Reset()
+		numOp := numeric.NewNumericOp(env.evaluator)
+		catOp := categorical.NewCategoricalOp(env.evaluator)
+
+		s, err := numeric.SliceStream(vs, regions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bcCt, err := catOp.StreamBc(s, conditions)
+		if err != nil {
+			t.Fatalf("StreamBc on %d workers failed: %v", workers, err)
+		}
+		masks, err := catOp.BuildMask(vs, conditions, sliceBMVStore(regions))
+		if err != nil {
+			t.Fatalf("BuildMask on %d workers failed: %v", workers, err)
+		}
+		sqCt, err := numOp.MaskedSumOfSquares(xs, masks)
+		if err != nil {
+			t.Fatalf("MaskedSumOfSquares on %d workers failed: %v", workers, err)
+		}
+		crossCt, err := numOp.MaskedCrossSum(xs, ys, vs)
+		if err != nil {
+			t.Fatalf("MaskedCrossSum on %d workers failed: %v", workers, err)
+		}
+		stats := env.evaluator.Stats()
+		return [3]float64{env.decryptFirst(t, bcCt), env.decryptFirst(t, sqCt), env.decryptFirst(t, crossCt)},
+			[4]int64{stats.MulCount, stats.AddCount, stats.RotateCount, stats.RescaleCount}
+	}
+
+	serial, serialStats := run(1)
+	parallel, parallelStats := run(3)
+	for i, want := range []float64{expectedBc, expectedSq, expectedCross} {
+		if math.Abs(serial[i]-want) > 1e-2 || math.Abs(parallel[i]-want) > 1e-2 {
+			t.Errorf("result %d: expected %.4f, got %.4f serially and %.4f on 3 workers", i, want, serial[i], parallel[i])
+		}
+	}
+	if serialStats != parallelStats {
+		t.Errorf("mul, add, rotate and rescale counts differ: %v serially, %v on 3 workers", serialStats, parallelStats)
+	}
+
+	// Every worker, the first included, fans out again serially rather than
+	// to the copies the other workers are using
+	env.evaluator.SetWorkers(3)
+	sums := make([]*rlwe.Ciphertext, 3)
+	err := env.evaluator.Parallel(len(sums), func(w he.Backend, i int) error {
+		if w.Workers() != 1 {
+			return fmt.Errorf("worker %d has %d workers", i, w.Workers())
+		}
+		var err error
+		sums[i], err = w.SumTree(xs)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("nested fan-out failed: %v", err)
+	}
+	for i, sum := range sums {
+		if got, want := env.decryptFirst(t, sum), env.decryptFirst(t, sums[0]); math.Abs(got-want) > 1e-6 {
+			t.Errorf("nested sum %d: expected %.6f, got %.6f", i, want, got)
+		}
+	}
+}
+
+// sliceBMVStore is a categorical.BMVStore of the blocks of a single BMV
+type sliceBMVStore []*rlwe.Ciphertext
+
+func (s sliceBMVStore) GetBMV(columnName string, value int, blockIndex int) (*rlwe.Ciphertext, error) {
+	return s[blockIndex], nil
+}
+
+func (s sliceBMVStore) BlockCount() int {
+	return len(s)
+}
+
+// TestEvaluatorAlignment checks that operands at different levels and scales
+// are aligned before they are added, and that running out of levels is
+// reported on a profile without bootstrapping
+func TestEvaluatorAlignment(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	env := newFixtureEnv(t)
+	eval := env.evaluator
+	x := env.encrypt(t, []float64{1000})
+	ones := env.encrypt(t, []float64{1})
+
+	// mean-like operand: two levels lower, with the scale the rescales left
+	m := env.encrypt(t, []float64{3})
+	for i := 0; i < 2; i++ {
+		prod, err := eval.Mul(m, ones)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, err = eval.Rescale(prod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.Level() != x.Level()-2 || m.Scale.Equal(x.Scale) {
+		t.Fatalf("test operand should be two levels down with another scale, got level %d", m.Level())
+	}
+	diff, err := eval.Sub(x, m)
+	if err != nil {
+		t.Fatalf("Sub failed: %v", err)
+	}
+	if got := env.decryptFirst(t, diff); math.Abs(got-997) > 1e-8 {
+		t.Errorf("Sub across levels: expected 997, got %.6f", got)
+	}
+
+	// In place, with the higher operand second
+	acc := m.CopyNew()
+	if err := eval.AddInPlace(acc, x); err != nil {
+		t.Fatalf("AddInPlace failed: %v", err)
+	}
+	if got := env.decryptFirst(t, acc); math.Abs(got-1003) > 1e-8 {
+		t.Errorf("AddInPlace across levels: expected 1003, got %.6f", got)
+	}
+
+	// Same level, unrescaled constant product: the scale is a prime times the
+	// other, which costs no level
+	half, err := eval.MulConst(x, complex(0.5, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := eval.Add(half, x)
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if got := env.decryptFirst(t, sum); math.Abs(got-1500) > 1e-8 || sum.Level() != x.Level() {
+		t.Errorf("Add across integer scales: expected 1500 at level %d, got %.6f at level %d", x.Level(), got, sum.Level())
+	}
+
+	// Same level, scales off by a fraction: one of them costs a level
+	dropped := x.CopyNew()
+	dropped.Resize(1, m.Level())
+	sum, err = eval.Add(dropped, m)
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if got := env.decryptFirst(t, sum); math.Abs(got-1003) > 1e-8 || sum.Level() != m.Level()-1 {
+		t.Errorf("Add across scales: expected 1003 at level %d, got %.6f at level %d", m.Level()-1, got, sum.Level())
+	}
+
+	// Profile T cannot bootstrap: exhausting its levels is an error
+	low := ones
+	for low.Level() > 0 {
+		prod, err := eval.Mul(low, ones)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if low, err = eval.Rescale(prod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prod, err := eval.Mul(low, ones)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eval.Rescale(prod); !errors.Is(err, he.ErrDepthExhausted) {
+		t.Errorf("Rescale at level 0: expected a depth error, got %v", err)
+	}
+	dropped.Resize(1, 0)
+	if _, err := eval.Add(dropped, low); !errors.Is(err, he.ErrDepthExhausted) {
+		t.Errorf("Add across scales at level 0: expected a depth error, got %v", err)
+	}
+}
+
+func TestPolynomialEvaluation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	env := newFixtureEnv(t)
+	eval := env.evaluator
+	top := env.profile.MaxLevel()
+	check := func(name string, ct *rlwe.Ciphertext, inputs []float64, f func(float64) float64, levels int, tol float64) {
+		t.Helper()
+		if ct.Level() != top-levels {
+			t.Errorf("%s: expected %d levels consumed, got %d", name, levels, top-ct.Level())
+		}
+		got := env.decrypt(t, ct)
+		for i, x := range inputs {
+			if want := f(x); math.Abs(got[i]-want) > tol {
+				t.Errorf("%s(%g): expected %.6f, got %.6f", name, x, want, got[i])
+			}
+		}
+	}
+
+	var inputs []float64
+	for x := -1.0; x <= 1; x += 0.125 {
+		inputs = append(inputs, x)
+	}
+	x := env.encrypt(t, inputs)
+
+	// Monomial basis: degree 7 in 3 levels, where Horner's rule took 7
+	coeffs := []float64{0.5, -1, 0.25, 2, 0, -0.75, 0.125, 0.3}
+	p, err := eval.EvaluatePolynomial(x, coeffs)
+	if err != nil {
+		t.Fatalf("EvaluatePolynomial failed: %v", err)
+	}
+	check("p", p, inputs, func(x float64) float64 {
+		y := 0.0
+		for i := len(coeffs) - 1; i >= 0; i-- {
+			y = y*x + coeffs[i]
+		}
+		return y
+	}, 3, 1e-6)
+
+	// Chebyshev basis: degree 16 on [-1, 1] in 5 levels
+	sinc := approx.ComputeSincCoeffs(16)
+	s, err := approx.NewApproxOp(eval).EvaluateChebyshev(x, sinc)
+	if err != nil {
+		t.Fatalf("EvaluateChebyshev failed: %v", err)
+	}
+	check("sinc", s, inputs, sinc.Evaluate, 5, 1e-6)
+
+	// APPROXSIGN: three iterations in 5 levels, where they took 9
+	sign, err := approx.NewApproxOp(eval).APPROXSIGN(x, approx.DefaultApproxSignConfig())
+	if err != nil {
+		t.Fatalf("APPROXSIGN failed: %v", err)
+	}
+	check("sign", sign, inputs, func(s float64) float64 {
+		for i := 0; i < 3; i++ {
+			s = s * (3 - s*s) / 2
+		}
+		return s
+	}, 5, 1e-6)
+
+	// DISCRETEEQUALZERO up to 7: one polynomial on [-8, 8] in 6 levels,
+	// which leaves a level for the lookup's product on 7 levels
+	var diffs []float64
+	for d := -7; d <= 7; d++ {
+		diffs = append(diffs, float64(d))
+	}
+	config := approx.DefaultDEZConfig(7)
+	eq, err := approx.NewApproxOp(eval).DISCRETEEQUALZERO(env.encrypt(t, diffs), config)
+	if err != nil {
+		t.Fatalf("DISCRETEEQUALZERO failed: %v", err)
+	}
+	check("dez", eq, diffs, approx.DEZCoeffs(config).Evaluate, 6, 1e-5)
+	check("dez", eq, diffs, approx.PlaintextDEZ, 6, 1e-3)
+
+	// Too few levels left is a depth error on a profile without bootstrapping
+	low := x.CopyNew()
+	low.Resize(1, 4)
+	if _, err := approx.NewApproxOp(eval).DISCRETEEQUALZERO(low, config); !errors.Is(err, he.ErrDepthExhausted) {
+		t.Errorf("DISCRETEEQUALZERO at level 4: expected a depth error, got %v", err)
+	}
+}
+
+// TestTracer checks that a tracer records the level, scale, noise and values
+// of every operation under the labels of the open spans, on worker copies too
+func TestTracer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	env := newFixtureEnv(t)
+	eval := env.evaluator
+	tracer := he.NewTracer(env.profile.Params, env.sk, 4)
+	eval.SetTracer(tracer)
+	defer eval.SetTracer(nil)
+
+	x := env.encrypt(t, []float64{0.5, -0.25, 1, 2})
+	endSpan := eval.Span("square")
+	sq, err := eval.Mul(x, x)
+	if err != nil {
+		t.Fatalf("Mul failed: %v", err)
+	}
+	if sq, err = eval.Rescale(sq); err != nil {
+		t.Fatalf("Rescale failed: %v", err)
+	}
+	endSpan()
+
+	records := tracer.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	mul, rescale := records[0], records[1]
+	if mul.Op != "mul" || rescale.Op != "rescale" || mul.Span != "square" || rescale.Span != "square" {
+		t.Errorf("expected mul and rescale in span square, got %q in %q and %q in %q", mul.Op, mul.Span, rescale.Op, rescale.Span)
+	}
+	if rescale.Level != sq.Level() || rescale.Level != mul.Level-1 || math.Abs(rescale.LogScale-sq.LogScale()) > 1e-9 {
+		t.Errorf("rescale recorded at level %d and log scale %.2f, ciphertext is at %d and %.2f",
+			rescale.Level, rescale.LogScale, sq.Level(), sq.LogScale())
+	}
+	if math.Abs(rescale.Values[3]-4) > 1e-3 || math.Abs(rescale.Max-4) > 1e-3 {
+		t.Errorf("expected slot 3 and max of 4, got %.4f and %.4f", rescale.Values[3], rescale.Max)
+	}
+	if rescale.LogNoise == nil || *rescale.LogNoise > -10 {
+		t.Errorf("expected the rescale to add noise below 2^-10, got %v", rescale.LogNoise)
+	}
+	if rescale.LogHeadroom <= 0 {
+		t.Errorf("expected headroom left after the rescale, got %.2f", rescale.LogHeadroom)
+	}
+
+	// Blocks on worker copies are labelled under the span open at the fan-out
+	const blocks = 4
+	var vs, regions []*rlwe.Ciphertext
+	for b := 0; b < blocks; b++ {
+		vs = append(vs, env.encrypt(t, []float64{1, 1, 0, 1}))
+		regions = append(regions, env.encrypt(t, []float64{1, 0, 1, 1}))
+	}
+	eval.SetWorkers(3)
+	defer eval.SetWorkers(1)
+	s, err := numeric.SliceStream(vs, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endSpan = eval.Span("bc")
+	bcCt, err := categorical.NewCategoricalOp(eval).StreamBc(s, []categorical.Condition{{ColumnName: "region", Value: 1}})
+	endSpan()
+	if err != nil {
+		t.Fatalf("StreamBc failed: %v", err)
+	}
+	if got := env.decryptFirst(t, bcCt); math.Abs(got-2*blocks) > 1e-2 {
+		t.Errorf("expected %d, got %.4f", 2*blocks, got)
+	}
+	spans := make(map[string]bool)
+	for _, r := range tracer.Records()[2:] {
+		spans[r.Span] = true
+	}
+	for b := 0; b < blocks; b++ {
+		if label := fmt.Sprintf("bc / block %d", b); !spans[label] {
+			t.Errorf("no record in span %q, got spans %v", label, spans)
+		}
+	}
+	for label := range spans {
+		if label != "bc" && !strings.HasPrefix(label, "bc / block ") || strings.Count(label, "block") > 1 {
+			t.Errorf("unexpected span %q", label)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tracer.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var trace struct {
+		Slots   int              `json:"slots"`
+		Records []he.TraceRecord `json:"records"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("failed to read the trace: %v", err)
+	}
+	if trace.Slots != 4 || len(trace.Records) != len(tracer.Records()) || trace.Records[1].Level != rescale.Level {
+		t.Errorf("trace did not round-trip: %d slots, %d records", trace.Slots, len(trace.Records))
+	}
+}
+
+// TestSimulatorMatchesEvaluator runs the same operations on the evaluator
+// and a simulator of its profile and checks that they agree on the levels,
+// scales, values and operation counts
+func TestSimulatorMatchesEvaluator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	env := newFixtureEnv(t)
+	eval := env.evaluator
+	eval.Stats().Reset()
+	sim := he.NewSimulator(env.profile.Params, he.SimulatorConfig{})
+
+	xs := []float64{0.5, -0.25, 1, 0.75, -1}
+	ys := []float64{2, 1, -0.5, 0.25, 1}
+	encrypt := func(b he.Backend, values []float64) *rlwe.Ciphertext {
+		if s, ok := b.(*he.Simulator); ok {
+			ct, err := s.Encrypt(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ct
+		}
+		return env.encrypt(t, values)
+	}
+
+	// Products at different levels and scales, so that both alignments run,
+	// then rotations and a polynomial
+	steps := []struct {
+		name string
+		run  func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error)
+	}{
+		{"product plus unrescaled half", func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+			xy, err := b.Mul(x, y)
+			if err != nil {
+				return nil, err
+			}
+			if xy, err = b.Rescale(xy); err != nil {
+				return nil, err
+			}
+			half, err := b.MulConst(x, 0.5)
+			if err != nil {
+				return nil, err
+			}
+			return b.Add(xy, half)
+		}},
+		{"product plus y", func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+			xy, err := b.Mul(x, y)
+			if err != nil {
+				return nil, err
+			}
+			if xy, err = b.Rescale(xy); err != nil {
+				return nil, err
+			}
+			return b.Add(xy, y)
+		}},
+		{"unrescaled square minus x", func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+			sq, err := b.Mul(x, x)
+			if err != nil {
+				return nil, err
+			}
+			return b.Sub(sq, x)
+		}},
+		{"cube", func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+			return b.Power(x, 3)
+		}},
+		{"sum of slots", func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+			return b.SumSlots(y)
+		}},
+		{"sign", func(b he.Backend, x, y *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+			return approx.NewApproxOp(b).APPROXSIGN(x, approx.DefaultApproxSignConfig())
+		}},
+	}
+	for _, step := range steps {
+		got, err := step.run(eval, encrypt(eval, xs), encrypt(eval, ys))
+		if err != nil {
+			t.Fatalf("%s failed on the evaluator: %v", step.name, err)
+		}
+		want, err := step.run(sim, encrypt(sim, xs), encrypt(sim, ys))
+		if err != nil {
+			t.Fatalf("%s failed on the simulator: %v", step.name, err)
+		}
+		if got.Level() != want.Level() || math.Abs(got.LogScale()-want.LogScale()) > 1e-9 {
+			t.Errorf("%s: evaluator at level %d and log scale %.4f, simulator at %d and %.4f",
+				step.name, got.Level(), got.LogScale(), want.Level(), want.LogScale())
+		}
+		gotValues := env.decrypt(t, got)
+		wantValues, err := sim.Decrypt(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range xs {
+			if math.Abs(gotValues[i]-wantValues[i]) > 1e-3 {
+				t.Errorf("%s slot %d: evaluator %.6f, simulator %.6f", step.name, i, gotValues[i], wantValues[i])
+			}
+		}
+		if step.name == "sum of slots" {
+			// The simulator does not count the operations inside polynomials
+			got, want := eval.Stats(), sim.Stats()
+			if got.MulCount != want.MulCount || got.AddCount != want.AddCount ||
+				got.RotateCount != want.RotateCount || got.RescaleCount != want.RescaleCount {
+				t.Errorf("mul, add, rotate and rescale counts: evaluator %d, %d, %d, %d, simulator %d, %d, %d, %d",
+					got.MulCount, got.AddCount, got.RotateCount, got.RescaleCount,
+					want.MulCount, want.AddCount, want.RotateCount, want.RescaleCount)
+			}
+		}
+	}
+
+	// The evaluator refuses the simulator's ciphertexts rather than treating
+	// their plaintext slots as an encryption
+	simulated := encrypt(sim, xs)
+	if _, err := eval.Add(encrypt(eval, xs), simulated); !errors.Is(err, he.ErrSimulatedCiphertext) {
+		t.Errorf("Add of a simulated ciphertext: expected ErrSimulatedCiphertext, got %v", err)
+	}
+	if _, err := approx.NewApproxOp(eval).APPROXSIGN(simulated, approx.DefaultApproxSignConfig()); !errors.Is(err, he.ErrSimulatedCiphertext) {
+		t.Errorf("APPROXSIGN of a simulated ciphertext: expected ErrSimulatedCiphertext, got %v", err)
+	}
+}
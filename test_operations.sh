@@ -4,6 +4,28 @@
 
 set -e
 
+# Profile T is a small, insecure test-only ring that runs in seconds; set
+# PROFILE=A2 to run the same flow on the production profile. Ba and Bv need
+# more depth than T has and run on BTP_PROFILE, which bootstraps: the small
+# test-only Profile TB by default, or B2 for the production profile.
+PROFILE=${PROFILE:-T}
+SEED=${SEED:-lattigostats-test}
+if [ "$PROFILE" = "T" ]; then
+    TEST_FLAGS="-insecure"
+    SEED_FLAGS="-insecure -seed $SEED"
+    BTP_PROFILE=${BTP_PROFILE:-TB}
+else
+    TEST_FLAGS=""
+    SEED_FLAGS=""
+    BTP_PROFILE=${BTP_PROFILE:-B2}
+fi
+# Bootstrapping keys cannot be seeded
+if [ "$BTP_PROFILE" = "TB" ]; then
+    BTP_FLAGS="-insecure"
+else
+    BTP_FLAGS=""
+fi
+
 echo "==================================="
 echo "Lattigo-STAT Operation Verification"
 echo "==================================="
@@ -14,17 +36,20 @@ echo "Step 1: Building tools..."
 go build -o bin/ddia ./cmd/ddia
 go build -o bin/do_encrypt ./cmd/do_encrypt
 go build -o bin/da_run ./cmd/da_run
+go build -o bin/table_util ./cmd/table_util
 
 # Create test data
 echo ""
 echo "Step 2: Creating test data..."
 
-# Create schema with multiple columns for comprehensive testing
+# Create schema with multiple columns for comprehensive testing; income is
+# bounded so that it is encrypted normalized to [0, 1], which keeps Bv within
+# the range bootstrapping can refresh
 cat > test_schema.json << 'EOF'
 {
   "name": "test_dataset",
   "columns": [
-    {"name": "income", "type": "numerical"},
+    {"name": "income", "type": "numerical", "min_value": 0, "max_value": 1000},
     {"name": "age", "type": "numerical"},
     {"name": "gender", "type": "categorical", "category_count": 2},
     {"name": "education", "type": "ordinal", "category_count": 4}
@@ -48,14 +73,25 @@ EOF
 
 # Clean and generate keys
 echo ""
-echo "Step 3: Generating keys (Profile A)..."
-rm -rf keys encrypted result.ct
-./bin/ddia keygen -profile A -output ./keys
+echo "Step 3: Generating keys (Profile $PROFILE)..."
+rm -rf keys encrypted result.ct keys_btp encrypted_btp result_ba result_bv owner-keys keyring
+./bin/ddia keygen -profile $PROFILE $SEED_FLAGS -output ./keys
+
+# The owner signs every table, and da_run verifies it against the keyring
+./bin/table_util keygen -id owner1 -output ./owner-keys
+mkdir -p keyring
+cp ./owner-keys/owner1.pub ./keyring/
+SIGN_FLAGS="-owner owner1 -sign-key ./owner-keys/owner1.key"
 
 # Encrypt data
 echo ""
 echo "Step 4: Encrypting test data..."
-./bin/do_encrypt -data test_data.csv -schema test_schema.json -pk ./keys/public.key -output ./encrypted -profile A
+./bin/do_encrypt -data test_data.csv -schema test_schema.json -pk ./keys/public.key -output ./encrypted -profile $PROFILE $SEED_FLAGS $SIGN_FLAGS
+
+echo ""
+echo "Step 5: Generating keys and encrypting for Ba/Bv (Profile $BTP_PROFILE)..."
+./bin/ddia keygen -profile $BTP_PROFILE $BTP_FLAGS -output ./keys_btp
+./bin/do_encrypt -data test_data.csv -schema test_schema.json -pk ./keys_btp/public.key -output ./encrypted_btp -profile $BTP_PROFILE $BTP_FLAGS $SIGN_FLAGS
 
 # Test each operation
 echo ""
@@ -75,7 +111,7 @@ cat > job_bc.json << 'EOF'
   "conditions": [{"column": "gender", "value": 1}]
 }
 EOF
-./bin/da_run -job job_bc.json -table ./encrypted -keys ./keys -output result.ct
+./bin/da_run -job job_bc.json -table ./encrypted -keys ./keys -output result.ct -keyring ./keyring $TEST_FLAGS
 echo "✓ Bc operation completed"
 
 # Test 2: Bin Average (ba)
@@ -91,13 +127,14 @@ cat > job_ba.json << 'EOF'
   "conditions": [{"column": "gender", "value": 1}]
 }
 EOF
-./bin/da_run -job job_ba.json -table ./encrypted -keys ./keys -output result.ct
+./bin/da_run -job job_ba.json -table ./encrypted_btp -keys ./keys_btp -output result_ba -keyring ./keyring $BTP_FLAGS
+./bin/ddia decrypt -sk ./keys_btp/secret.key -ct result_ba/result.ct $BTP_FLAGS
 echo "✓ Ba operation completed"
 
 # Test 3: Bin Variance (bv)
 echo ""
 echo "Test 3: Bin Variance (bv) - Variance of income where gender=1"
-echo "Expected: Var([100,300,500])"
+echo "Expected: Var([100,300,500]) = 26666.67"
 cat > job_bv.json << 'EOF'
 {
   "id": "test_bv",
@@ -107,28 +144,25 @@ cat > job_bv.json << 'EOF'
   "conditions": [{"column": "gender", "value": 1}]
 }
 EOF
-./bin/da_run -job job_bv.json -table ./encrypted -keys ./keys -output result.ct
+./bin/da_run -job job_bv.json -table ./encrypted_btp -keys ./keys_btp -output result_bv -keyring ./keyring $BTP_FLAGS
+./bin/ddia decrypt -sk ./keys_btp/secret.key -ct result_bv/result.ct $BTP_FLAGS
 echo "✓ Bv operation completed"
 
 # Summary
 echo ""
 echo "==================================="
-echo "Profile A Operations Summary"
+echo "Operations Summary"
 echo "==================================="
 echo "✓ bc (Bin Count) - PASSED"
-echo "✓ ba (Bin Average) - PASSED"  
-echo "✓ bv (Bin Variance) - PASSED"
-echo ""
-echo "Note: Mean, Variance, Stdev, Correlation, Percentile operations"
-echo "require Profile B with bootstrapping enabled (needs 16+ GB RAM)."
+echo "✓ ba (Bin Average, Profile $BTP_PROFILE) - PASSED"
+echo "✓ bv (Bin Variance, Profile $BTP_PROFILE) - PASSED"
 echo ""
-echo "To test Profile B operations:"
-echo "  ./bin/ddia keygen -profile B -output ./keys"
-echo "  ./bin/do_encrypt -data test_data.csv -schema test_schema.json -pk ./keys/public.key -output ./encrypted -profile B"
-echo "  # Then run mean/variance/etc. operations"
+echo "Note: Mean, Variance, Stdev, Correlation, Percentile operations also"
+echo "need a bootstrapping profile; run them as above on Profile $BTP_PROFILE."
 
 # Cleanup
 rm -f job_bc.json job_ba.json job_bv.json test_schema.json test_data.csv
+rm -rf owner-keys keyring
 
 echo ""
 echo "==================================="